@@ -0,0 +1,178 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// accountProperties are storage account properties which are not supported by account options of cloud provider,
+// they are only set in create parameters of storage accounts created by driver, existing storage accounts are
+// never updated and they are only matched if they already have the same properties
+type accountProperties struct {
+	// minimum TLS version of storage account, any version is matched if it's empty
+	minimumTLSVersion string
+	// default action of network rule set, any default action is matched if it's empty
+	networkDefaultAction string
+	// IP addresses or CIDR ranges allowed by network rule set
+	allowedIPs []string
+	// key expiration period(days) of key policy, any key policy is matched if it's 0
+	keyExpirationDays int32
+}
+
+// accountPropertiesKey is the context key of accountProperties required by storage account search
+type accountPropertiesKey struct{}
+
+// withAccountProperties returns context in which listed storage accounts are filtered by properties
+// and new storage account is created with properties
+func withAccountProperties(ctx context.Context, properties *accountProperties) context.Context {
+	return context.WithValue(ctx, accountPropertiesKey{}, properties)
+}
+
+func getAccountProperties(ctx context.Context) *accountProperties {
+	properties, _ := ctx.Value(accountPropertiesKey{}).(*accountProperties)
+	return properties
+}
+
+// matches returns true if storage account has the same properties
+func (p *accountProperties) matches(account storage.Account) bool {
+	if p.minimumTLSVersion != "" {
+		// minimum TLS version of storage account is TLS1_0 if it's not set
+		version := string(storage.MinimumTLSVersionTLS10)
+		if account.AccountProperties != nil && account.AccountProperties.MinimumTLSVersion != "" {
+			version = string(account.AccountProperties.MinimumTLSVersion)
+		}
+		if !strings.EqualFold(version, p.minimumTLSVersion) {
+			return false
+		}
+	}
+	var networkRuleSet *storage.NetworkRuleSet
+	if account.AccountProperties != nil {
+		networkRuleSet = account.AccountProperties.NetworkRuleSet
+	}
+	if p.networkDefaultAction != "" {
+		// network access is allowed if there is no network rule set
+		defaultAction := storage.DefaultActionAllow
+		if networkRuleSet != nil && networkRuleSet.DefaultAction != "" {
+			defaultAction = networkRuleSet.DefaultAction
+		}
+		if !strings.EqualFold(string(defaultAction), p.networkDefaultAction) {
+			return false
+		}
+	}
+	if p.keyExpirationDays > 0 {
+		if account.AccountProperties == nil || account.AccountProperties.KeyPolicy == nil ||
+			account.AccountProperties.KeyPolicy.KeyExpirationPeriodInDays == nil ||
+			*account.AccountProperties.KeyPolicy.KeyExpirationPeriodInDays != p.keyExpirationDays {
+			return false
+		}
+	}
+	for _, ip := range p.allowedIPs {
+		if networkRuleSet == nil || networkRuleSet.IPRules == nil {
+			return false
+		}
+		found := false
+		for _, rule := range *networkRuleSet.IPRules {
+			if rule.IPAddressOrRange != nil && *rule.IPAddressOrRange == ip && rule.Action == storage.ActionAllow {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// apply sets properties in create parameters of storage account
+func (p *accountProperties) apply(parameters *storage.AccountCreateParameters) {
+	if parameters.AccountPropertiesCreateParameters == nil {
+		parameters.AccountPropertiesCreateParameters = &storage.AccountPropertiesCreateParameters{}
+	}
+	if p.minimumTLSVersion != "" {
+		parameters.AccountPropertiesCreateParameters.MinimumTLSVersion = storage.MinimumTLSVersion(p.minimumTLSVersion)
+	}
+	if p.keyExpirationDays > 0 {
+		keyExpirationDays := p.keyExpirationDays
+		parameters.AccountPropertiesCreateParameters.KeyPolicy = &storage.KeyPolicy{KeyExpirationPeriodInDays: &keyExpirationDays}
+	}
+	if p.networkDefaultAction != "" || len(p.allowedIPs) > 0 {
+		// virtual network rules are set by cloud provider, ip rules are not supported by cloud provider
+		var vnetResourceIDs []string
+		if ruleSet := parameters.AccountPropertiesCreateParameters.NetworkRuleSet; ruleSet != nil && ruleSet.VirtualNetworkRules != nil {
+			for _, rule := range *ruleSet.VirtualNetworkRules {
+				if rule.VirtualNetworkResourceID != nil {
+					vnetResourceIDs = append(vnetResourceIDs, *rule.VirtualNetworkResourceID)
+				}
+			}
+		}
+		networkRuleSet := buildNetworkRuleSet(vnetResourceIDs, p.allowedIPs)
+		if strings.EqualFold(p.networkDefaultAction, string(storage.DefaultActionAllow)) {
+			networkRuleSet.DefaultAction = storage.DefaultActionAllow
+		}
+		parameters.AccountPropertiesCreateParameters.NetworkRuleSet = networkRuleSet
+	}
+}
+
+// accountPropertiesStorageAccountClient filters storage accounts listed in resource group by account properties in context,
+// and creates new storage account with account properties in context
+type accountPropertiesStorageAccountClient struct {
+	storageaccountclient.Interface
+}
+
+var _ storageaccountclient.Interface = &accountPropertiesStorageAccountClient{}
+
+func (c *accountPropertiesStorageAccountClient) ListByResourceGroup(ctx context.Context, subsID, resourceGroupName string) ([]storage.Account, *retry.Error) {
+	accounts, rerr := c.Interface.ListByResourceGroup(ctx, subsID, resourceGroupName)
+	properties := getAccountProperties(ctx)
+	if rerr != nil || properties == nil {
+		return accounts, rerr
+	}
+	var matched []storage.Account
+	for _, account := range accounts {
+		if properties.matches(account) {
+			matched = append(matched, account)
+		} else {
+			klog.V(4).Infof("storage account(%s) does not match account properties(%+v)", pointer.StringDeref(account.Name, ""), *properties)
+		}
+	}
+	return matched, nil
+}
+
+func (c *accountPropertiesStorageAccountClient) Create(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+	if properties := getAccountProperties(ctx); properties != nil {
+		properties.apply(&parameters)
+	}
+	return c.Interface.Create(ctx, subsID, resourceGroupName, accountName, parameters)
+}
+
+// setAccountPropertiesClient wraps storage account client of cloud provider so that account properties
+// which are not supported by cloud provider are applied in storage account search and creation
+func (d *Driver) setAccountPropertiesClient() {
+	if d.cloud.StorageAccountClient == nil {
+		return
+	}
+	d.cloud.StorageAccountClient = &accountPropertiesStorageAccountClient{Interface: d.cloud.StorageAccountClient}
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+)
+
+func TestAccountPropertiesMatches(t *testing.T) {
+	tests := []struct {
+		desc       string
+		properties accountProperties
+		account    storage.Account
+		expected   bool
+	}{
+		{
+			desc:     "empty properties match any account",
+			account:  storage.Account{AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS10}},
+			expected: true,
+		},
+		{
+			desc:       "minimum TLS version matches",
+			properties: accountProperties{minimumTLSVersion: "TLS1_1"},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS11}},
+			expected:   true,
+		},
+		{
+			desc:       "higher minimum TLS version does not match",
+			properties: accountProperties{minimumTLSVersion: "TLS1_0"},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS12}},
+		},
+		{
+			desc:       "lower minimum TLS version does not match",
+			properties: accountProperties{minimumTLSVersion: "TLS1_2"},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS10}},
+		},
+		{
+			desc:       "minimum TLS version of account is TLS1_0 if it's not set",
+			properties: accountProperties{minimumTLSVersion: "TLS1_0"},
+			account:    storage.Account{},
+			expected:   true,
+		},
+		{
+			desc:       "network access is allowed if there is no network rule set",
+			properties: accountProperties{networkDefaultAction: "Allow"},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{}},
+			expected:   true,
+		},
+		{
+			desc:       "account which denies network access does not match default action Allow",
+			properties: accountProperties{networkDefaultAction: "Allow"},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{NetworkRuleSet: buildNetworkRuleSet(nil, []string{"10.0.0.1"})}},
+		},
+		{
+			desc:       "public account does not match allowedIPs",
+			properties: accountProperties{networkDefaultAction: "Deny", allowedIPs: []string{"10.0.0.1"}},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{NetworkRuleSet: &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionAllow}}},
+		},
+		{
+			desc:       "account without all allowedIPs does not match",
+			properties: accountProperties{networkDefaultAction: "Deny", allowedIPs: []string{"10.0.0.1", "20.0.0.0/24"}},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{NetworkRuleSet: buildNetworkRuleSet(nil, []string{"10.0.0.1"})}},
+		},
+		{
+			desc:       "account with all allowedIPs matches",
+			properties: accountProperties{networkDefaultAction: "deny", allowedIPs: []string{"10.0.0.1"}},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{NetworkRuleSet: buildNetworkRuleSet(nil, []string{"20.0.0.0/24", "10.0.0.1"})}},
+			expected:   true,
+		},
+		{
+			desc:       "account without key policy does not match keyExpirationDays",
+			properties: accountProperties{keyExpirationDays: 90},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{}},
+		},
+		{
+			desc:       "account with different key expiration period does not match",
+			properties: accountProperties{keyExpirationDays: 90},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{KeyPolicy: &storage.KeyPolicy{KeyExpirationPeriodInDays: pointer.Int32(30)}}},
+		},
+		{
+			desc:       "account with the same key expiration period matches",
+			properties: accountProperties{keyExpirationDays: 90},
+			account:    storage.Account{AccountProperties: &storage.AccountProperties{KeyPolicy: &storage.KeyPolicy{KeyExpirationPeriodInDays: pointer.Int32(90)}}},
+			expected:   true,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.properties.matches(test.account), test.desc)
+	}
+}
+
+func TestAccountPropertiesApply(t *testing.T) {
+	subnetID := "/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+	tests := []struct {
+		desc               string
+		properties         accountProperties
+		networkRuleSet     *storage.NetworkRuleSet
+		expectedRuleSet    *storage.NetworkRuleSet
+		expectedTLSVersion storage.MinimumTLSVersion
+	}{
+		{
+			desc:               "create parameters are not changed by empty properties",
+			networkRuleSet:     buildNetworkRuleSet([]string{subnetID}, nil),
+			expectedRuleSet:    buildNetworkRuleSet([]string{subnetID}, nil),
+			expectedTLSVersion: storage.MinimumTLSVersionTLS12,
+		},
+		{
+			desc:               "ip rules are added to virtual network rules set by cloud provider",
+			properties:         accountProperties{minimumTLSVersion: "TLS1_1", networkDefaultAction: "Deny", allowedIPs: []string{"10.0.0.1"}},
+			networkRuleSet:     buildNetworkRuleSet([]string{subnetID}, nil),
+			expectedRuleSet:    buildNetworkRuleSet([]string{subnetID}, []string{"10.0.0.1"}),
+			expectedTLSVersion: storage.MinimumTLSVersionTLS11,
+		},
+		{
+			desc:               "network default action Allow is honored",
+			properties:         accountProperties{networkDefaultAction: "Allow"},
+			networkRuleSet:     buildNetworkRuleSet([]string{subnetID}, nil),
+			expectedRuleSet:    &storage.NetworkRuleSet{VirtualNetworkRules: buildNetworkRuleSet([]string{subnetID}, nil).VirtualNetworkRules, IPRules: &[]storage.IPRule{}, DefaultAction: storage.DefaultActionAllow},
+			expectedTLSVersion: storage.MinimumTLSVersionTLS12,
+		},
+	}
+
+	parameters := storage.AccountCreateParameters{}
+	(&accountProperties{keyExpirationDays: 90}).apply(&parameters)
+	assert.Equal(t, &storage.KeyPolicy{KeyExpirationPeriodInDays: pointer.Int32(90)}, parameters.KeyPolicy)
+
+	for _, test := range tests {
+		parameters := storage.AccountCreateParameters{
+			AccountPropertiesCreateParameters: &storage.AccountPropertiesCreateParameters{
+				MinimumTLSVersion: storage.MinimumTLSVersionTLS12,
+				NetworkRuleSet:    test.networkRuleSet,
+			},
+		}
+		test.properties.apply(&parameters)
+		assert.Equal(t, test.expectedRuleSet, parameters.NetworkRuleSet, test.desc)
+		assert.Nil(t, parameters.KeyPolicy, test.desc)
+		assert.Equal(t, test.expectedTLSVersion, parameters.MinimumTLSVersion, test.desc)
+	}
+}
+
+func TestAccountPropertiesStorageAccountClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d := NewFakeDriver()
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.setAccountPropertiesClient()
+	client := d.cloud.StorageAccountClient
+
+	accounts := []storage.Account{
+		{Name: pointer.String("accounta"), AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS12}},
+		{Name: pointer.String("accountb"), AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS10}},
+	}
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(accounts, nil).Times(2)
+
+	// accounts are not filtered without account properties
+	result, rerr := client.ListByResourceGroup(context.Background(), "subsID", "rg")
+	assert.Nil(t, rerr)
+	assert.Equal(t, accounts, result)
+
+	ctx := withAccountProperties(context.Background(), &accountProperties{minimumTLSVersion: "TLS1_0"})
+	result, rerr = client.ListByResourceGroup(ctx, "subsID", "rg")
+	assert.Nil(t, rerr)
+	assert.Equal(t, []storage.Account{accounts[1]}, result)
+
+	// account properties are set in create parameters
+	parameters := storage.AccountCreateParameters{
+		AccountPropertiesCreateParameters: &storage.AccountPropertiesCreateParameters{MinimumTLSVersion: storage.MinimumTLSVersionTLS12},
+	}
+	expected := storage.AccountCreateParameters{
+		AccountPropertiesCreateParameters: &storage.AccountPropertiesCreateParameters{MinimumTLSVersion: storage.MinimumTLSVersionTLS10},
+	}
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), "subsID", "rg", "account", expected).Return(nil).Times(1)
+	assert.Nil(t, client.Create(ctx, "subsID", "rg", "account", parameters))
+
+	parameters = storage.AccountCreateParameters{
+		AccountPropertiesCreateParameters: &storage.AccountPropertiesCreateParameters{MinimumTLSVersion: storage.MinimumTLSVersionTLS12},
+	}
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), "subsID", "rg", "account", parameters).Return(nil).Times(1)
+	assert.Nil(t, client.Create(context.Background(), "subsID", "rg", "account", parameters))
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	// the first matching storage account listed in resource group is used
+	accountSelectionFirstMatch = "first-match"
+	// matching storage accounts are used in turn
+	accountSelectionRoundRobin = "round-robin"
+	// matching storage account with the fewest file shares is used
+	accountSelectionLeastUsed = "least-used"
+	// matching storage account with the most file shares is used until it's full
+	accountSelectionBinPacking = "bin-packing"
+)
+
+var supportedAccountSelectionStrategies = []string{accountSelectionFirstMatch, accountSelectionRoundRobin, accountSelectionLeastUsed, accountSelectionBinPacking}
+
+// number of file shares in storage account is cached for shareCountCacheTTL so that file shares of every account
+// are not listed on every account selection
+const shareCountCacheTTL = time.Minute
+
+// accountSelectionKey is the context key of account options of storage accounts listed for account selection
+type accountSelectionKey struct{}
+
+// withAccountSelection returns context in which listed storage accounts are filtered by account options and ordered by
+// account selector, storage accounts listed in other operations(e.g. orphaned resource garbage collection) are not changed
+func withAccountSelection(ctx context.Context, accountOptions *azure.AccountOptions) context.Context {
+	return context.WithValue(ctx, accountSelectionKey{}, accountOptions)
+}
+
+// matchesAccountOptions returns false if storage account does not match account options in properties which are matched by
+// cloud provider without extra requests, so that accounts which could never be selected are not counted or rotated over,
+// other properties(e.g. multichannel) are still matched by cloud provider
+func matchesAccountOptions(account storage.Account, options *azure.AccountOptions) bool {
+	if account.Name == nil || account.Location == nil || account.Sku == nil {
+		return false
+	}
+	if options.Type != "" && !strings.EqualFold(options.Type, string(account.Sku.Name)) {
+		return false
+	}
+	if options.Kind != "" && !strings.EqualFold(options.Kind, string(account.Kind)) {
+		return false
+	}
+	if options.Location != "" && !strings.EqualFold(options.Location, *account.Location) {
+		return false
+	}
+	if _, ok := account.Tags[azure.SkipMatchingTag]; ok {
+		return false
+	}
+	if options.MatchTags {
+		for k, v := range account.Tags {
+			if options.Tags[k] != pointer.StringDeref(v, "") {
+				return false
+			}
+		}
+	}
+	props := account.AccountProperties
+	if props == nil {
+		props = &storage.AccountProperties{}
+	}
+	if options.EnableLargeFileShare != nil {
+		enabled := props.LargeFileSharesState == storage.LargeFileSharesStateEnabled
+		if *options.EnableLargeFileShare != enabled {
+			return false
+		}
+	}
+	if options.AccessTier != "" && options.AccessTier != string(props.AccessTier) {
+		return false
+	}
+	hasPrivateEndpoint := props.PrivateEndpointConnections != nil && len(*props.PrivateEndpointConnections) > 0
+	if options.CreatePrivateEndpoint != hasPrivateEndpoint {
+		return false
+	}
+	return pointer.BoolDeref(options.IsHnsEnabled, false) == pointer.BoolDeref(props.IsHnsEnabled, false) &&
+		pointer.BoolDeref(options.EnableNfsV3, false) == pointer.BoolDeref(props.EnableNfsV3, false) &&
+		pointer.BoolDeref(options.AllowBlobPublicAccess, false) == pointer.BoolDeref(props.AllowBlobPublicAccess, false) &&
+		pointer.BoolDeref(options.AllowSharedKeyAccess, false) == pointer.BoolDeref(props.AllowSharedKeyAccess, false)
+}
+
+// AccountSelector orders storage accounts of a resource group by preference when a storage account is selected for new file share,
+// cloud provider uses the first account in the order which matches storage class parameters
+type AccountSelector interface {
+	Order(ctx context.Context, subsID, resourceGroup string, accounts []storage.Account) []storage.Account
+}
+
+// shareCounter returns number of file shares in storage account
+type shareCounter func(ctx context.Context, subsID, resourceGroup, accountName string) (int, error)
+
+// firstMatchSelector keeps the order of listed storage accounts
+type firstMatchSelector struct{}
+
+func (s *firstMatchSelector) Order(_ context.Context, _, _ string, accounts []storage.Account) []storage.Account {
+	return accounts
+}
+
+// roundRobinSelector rotates storage accounts sorted by name on every selection
+type roundRobinSelector struct {
+	next uint64
+}
+
+func (s *roundRobinSelector) Order(_ context.Context, _, _ string, accounts []storage.Account) []storage.Account {
+	if len(accounts) == 0 {
+		return accounts
+	}
+	sorted := make([]storage.Account, len(accounts))
+	copy(sorted, accounts)
+	sort.SliceStable(sorted, func(i, j int) bool { return getAccountName(sorted[i]) < getAccountName(sorted[j]) })
+	start := int((atomic.AddUint64(&s.next, 1) - 1) % uint64(len(sorted)))
+	return append(sorted[start:], sorted[:start]...)
+}
+
+// usageSelector sorts storage accounts by number of file shares, accounts with fewer file shares come first if leastUsed is true,
+// otherwise accounts with more file shares come first, accounts whose file shares could not be counted come last
+type usageSelector struct {
+	leastUsed   bool
+	countShares shareCounter
+	// number of file shares in storage account <subsID/resourceGroup/accountName, int>
+	shareCounts *azcache.TimedCache
+}
+
+func (s *usageSelector) Order(ctx context.Context, subsID, resourceGroup string, accounts []storage.Account) []storage.Account {
+	counts := make(map[string]int, len(accounts))
+	for _, account := range accounts {
+		name := getAccountName(account)
+		counts[name] = s.getShareCount(ctx, subsID, resourceGroup, name)
+	}
+	// usage of account is the rank in the order, unknown usage is always ranked last
+	rank := func(account storage.Account) int {
+		count := counts[getAccountName(account)]
+		switch {
+		case count < 0:
+			return math.MaxInt
+		case s.leastUsed:
+			return count
+		default:
+			return math.MaxInt - 1 - count
+		}
+	}
+	sorted := make([]storage.Account, len(accounts))
+	copy(sorted, accounts)
+	sort.SliceStable(sorted, func(i, j int) bool { return rank(sorted[i]) < rank(sorted[j]) })
+	return sorted
+}
+
+// getShareCount returns cached number of file shares in storage account, -1 is returned if it could not be counted
+func (s *usageSelector) getShareCount(ctx context.Context, subsID, resourceGroup, accountName string) int {
+	key := subsID + "/" + resourceGroup + "/" + accountName
+	if cache, err := s.shareCounts.Get(key, azcache.CacheReadTypeDefault); err == nil && cache != nil {
+		return cache.(int)
+	}
+	count, err := s.countShares(ctx, subsID, resourceGroup, accountName)
+	if err != nil {
+		klog.Warningf("failed to count file shares of storage account(%s) in resource group(%s): %v", accountName, resourceGroup, err)
+		return -1
+	}
+	s.shareCounts.Set(key, count)
+	return count
+}
+
+// newAccountSelector returns account selector of the strategy, empty strategy is first-match
+func newAccountSelector(strategy string, countShares shareCounter) (AccountSelector, error) {
+	switch strategy {
+	case "", accountSelectionFirstMatch:
+		return &firstMatchSelector{}, nil
+	case accountSelectionRoundRobin:
+		return &roundRobinSelector{}, nil
+	case accountSelectionLeastUsed, accountSelectionBinPacking:
+		getter := func(key string) (interface{}, error) { return nil, nil }
+		shareCounts, err := azcache.NewTimedcache(shareCountCacheTTL, getter)
+		if err != nil {
+			return nil, err
+		}
+		return &usageSelector{leastUsed: strategy == accountSelectionLeastUsed, countShares: countShares, shareCounts: shareCounts}, nil
+	}
+	return nil, fmt.Errorf("account selection strategy(%s) is not supported, supported strategies: %v", strategy, supportedAccountSelectionStrategies)
+}
+
+func getAccountName(account storage.Account) string {
+	if account.Name == nil {
+		return ""
+	}
+	return *account.Name
+}
+
+// accountSelectingStorageAccountClient filters storage accounts listed in resource group for account selection by account options
+// and orders matching accounts by account selector so that account selection of cloud provider follows the strategy
+type accountSelectingStorageAccountClient struct {
+	storageaccountclient.Interface
+	selector AccountSelector
+}
+
+var _ storageaccountclient.Interface = &accountSelectingStorageAccountClient{}
+
+func (c *accountSelectingStorageAccountClient) ListByResourceGroup(ctx context.Context, subsID, resourceGroupName string) ([]storage.Account, *retry.Error) {
+	accounts, rerr := c.Interface.ListByResourceGroup(ctx, subsID, resourceGroupName)
+	options, ok := ctx.Value(accountSelectionKey{}).(*azure.AccountOptions)
+	if rerr != nil || !ok || options == nil {
+		return accounts, rerr
+	}
+	var matched []storage.Account
+	for _, account := range accounts {
+		if matchesAccountOptions(account, options) {
+			matched = append(matched, account)
+		}
+	}
+	return c.selector.Order(ctx, subsID, resourceGroupName, matched), nil
+}
+
+// countFileShares returns number of file shares in storage account by listing file shares
+func (d *Driver) countFileShares(ctx context.Context, subsID, resourceGroup, accountName string) (int, error) {
+	shares, err := d.cloud.FileClient.WithSubscriptionID(subsID).ListFileShare(ctx, resourceGroup, accountName, "", "")
+	if err != nil {
+		return 0, err
+	}
+	return len(shares), nil
+}
+
+// setAccountSelector wraps storage account client of cloud provider so that storage accounts are selected by the strategy,
+// storage account client is not changed for first-match strategy
+func (d *Driver) setAccountSelector(strategy string) error {
+	if strategy == "" || strategy == accountSelectionFirstMatch || d.cloud.StorageAccountClient == nil {
+		return nil
+	}
+	selector, err := newAccountSelector(strategy, d.countFileShares)
+	if err != nil {
+		return err
+	}
+	d.cloud.StorageAccountClient = &accountSelectingStorageAccountClient{
+		Interface: d.cloud.StorageAccountClient,
+		selector:  selector,
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func getAccountNames(accounts []storage.Account) []string {
+	var names []string
+	for _, account := range accounts {
+		names = append(names, getAccountName(account))
+	}
+	return names
+}
+
+func TestAccountSelectors(t *testing.T) {
+	// the same account inventory is used by every strategy
+	accounts := []storage.Account{
+		{Name: pointer.String("accountc")},
+		{Name: pointer.String("accounta")},
+		{Name: pointer.String("accountd")},
+		{Name: pointer.String("accountb")},
+	}
+	shareCounts := map[string]int{"accounta": 5, "accountb": 1, "accountc": 3}
+	countShares := func(_ context.Context, _, _, accountName string) (int, error) {
+		if count, ok := shareCounts[accountName]; ok {
+			return count, nil
+		}
+		return 0, fmt.Errorf("account(%s) not found", accountName)
+	}
+
+	tests := []struct {
+		strategy         string
+		expectedAccounts [][]string
+	}{
+		{
+			strategy: accountSelectionFirstMatch,
+			expectedAccounts: [][]string{
+				{"accountc", "accounta", "accountd", "accountb"},
+				{"accountc", "accounta", "accountd", "accountb"},
+			},
+		},
+		{
+			strategy: accountSelectionRoundRobin,
+			expectedAccounts: [][]string{
+				{"accounta", "accountb", "accountc", "accountd"},
+				{"accountb", "accountc", "accountd", "accounta"},
+				{"accountc", "accountd", "accounta", "accountb"},
+				{"accountd", "accounta", "accountb", "accountc"},
+				{"accounta", "accountb", "accountc", "accountd"},
+			},
+		},
+		{
+			strategy: accountSelectionLeastUsed,
+			expectedAccounts: [][]string{
+				{"accountb", "accountc", "accounta", "accountd"},
+			},
+		},
+		{
+			strategy: accountSelectionBinPacking,
+			expectedAccounts: [][]string{
+				{"accounta", "accountc", "accountb", "accountd"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		selector, err := newAccountSelector(test.strategy, countShares)
+		assert.NoError(t, err, test.strategy)
+		for i, expected := range test.expectedAccounts {
+			ordered := selector.Order(context.Background(), "subsID", "rg", accounts)
+			assert.Equal(t, expected, getAccountNames(ordered), "strategy(%s) selection(%d)", test.strategy, i)
+		}
+	}
+	assert.Equal(t, []string{"accountc", "accounta", "accountd", "accountb"}, getAccountNames(accounts), "listed accounts should not be changed")
+
+	_, err := newAccountSelector("random", countShares)
+	assert.Equal(t, fmt.Errorf("account selection strategy(random) is not supported, supported strategies: [first-match round-robin least-used bin-packing]"), err)
+}
+
+func TestMatchesAccountOptions(t *testing.T) {
+	account := storage.Account{
+		Name:     pointer.String("account"),
+		Location: pointer.String("westus"),
+		Sku:      &storage.Sku{Name: storage.SkuNamePremiumLRS},
+		Kind:     storage.KindFileStorage,
+		Tags:     map[string]*string{"key": pointer.String("value")},
+	}
+	tests := []struct {
+		desc     string
+		account  storage.Account
+		options  azure.AccountOptions
+		expected bool
+	}{
+		{
+			desc:     "empty options",
+			account:  account,
+			expected: true,
+		},
+		{
+			desc:     "same sku kind and location",
+			account:  account,
+			options:  azure.AccountOptions{Type: "premium_lrs", Kind: "FileStorage", Location: "westus"},
+			expected: true,
+		},
+		{
+			desc:    "different sku",
+			account: account,
+			options: azure.AccountOptions{Type: "Standard_LRS"},
+		},
+		{
+			desc:    "different location",
+			account: account,
+			options: azure.AccountOptions{Location: "eastus"},
+		},
+		{
+			desc:    "different tags",
+			account: account,
+			options: azure.AccountOptions{MatchTags: true, Tags: map[string]string{"key": "other"}},
+		},
+		{
+			desc:    "account without sku",
+			account: storage.Account{Name: pointer.String("account"), Location: pointer.String("westus")},
+		},
+		{
+			desc: "account tagged with skip matching",
+			account: storage.Account{Name: pointer.String("account"), Location: pointer.String("westus"), Sku: &storage.Sku{Name: storage.SkuNamePremiumLRS},
+				Tags: map[string]*string{azure.SkipMatchingTag: pointer.String("")}},
+		},
+		{
+			desc:    "account without private endpoint",
+			account: account,
+			options: azure.AccountOptions{CreatePrivateEndpoint: true},
+		},
+	}
+
+	for _, test := range tests {
+		options := test.options
+		assert.Equal(t, test.expected, matchesAccountOptions(test.account, &options), test.desc)
+	}
+}
+
+func TestNewAccountSelectorError(t *testing.T) {
+	_, err := newAccountSelector("random", nil)
+	assert.Equal(t, fmt.Errorf("account selection strategy(random) is not supported, supported strategies: [first-match round-robin least-used bin-packing]"), err)
+}
+
+func TestSetAccountSelector(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.cloud.FileClient = mockFileClient
+
+	// storage account client is not wrapped by first-match strategy
+	assert.NoError(t, d.setAccountSelector(accountSelectionFirstMatch))
+	assert.Equal(t, mockStorageAccountsClient, d.cloud.StorageAccountClient)
+	assert.Error(t, d.setAccountSelector("random"))
+
+	assert.NoError(t, d.setAccountSelector(accountSelectionLeastUsed))
+	newAccount := func(name, sku string) storage.Account {
+		return storage.Account{Name: pointer.String(name), Location: pointer.String("westus"), Sku: &storage.Sku{Name: storage.SkuName(sku)}}
+	}
+	accounts := []storage.Account{newAccount("accounta", "Standard_LRS"), newAccount("accountb", "Standard_LRS"), newAccount("accountc", "Premium_LRS")}
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(accounts, nil).Times(3)
+	// file shares of every matching account are only counted once since counts are cached, non-matching account is never counted
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).Times(2)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "accounta", "", "").Return([]storage.FileShareItem{{}, {}}, nil).Times(1)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "accountb", "", "").Return([]storage.FileShareItem{{}}, nil).Times(1)
+
+	// accounts are only filtered and ordered when they are listed for account selection
+	result, rerr := d.cloud.StorageAccountClient.ListByResourceGroup(context.Background(), "subsID", "rg")
+	assert.Nil(t, rerr)
+	assert.Equal(t, []string{"accounta", "accountb", "accountc"}, getAccountNames(result))
+
+	options := &azure.AccountOptions{Type: "Standard_LRS"}
+	for i := 0; i < 2; i++ {
+		result, rerr = d.cloud.StorageAccountClient.ListByResourceGroup(withAccountSelection(context.Background(), options), "subsID", "rg")
+		assert.Nil(t, rerr)
+		assert.Equal(t, []string{"accountb", "accounta"}, getAccountNames(result))
+	}
+
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, AccountSelectionStrategy: "random"}))
+	assert.Equal(t, accountSelectionFirstMatch, NewFakeDriver().accountSelectionStrategy)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const defaultARMBurst = 10
+
+// subscriptionRateLimiter is a token bucket rate limiter of ARM calls,
+// ARM throttling is per subscription, so every subscription has its own token bucket
+type subscriptionRateLimiter struct {
+	sync.Mutex
+	qps      rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+}
+
+func newSubscriptionRateLimiter(qps float64, burst int) *subscriptionRateLimiter {
+	if burst <= 0 {
+		burst = defaultARMBurst
+	}
+	return &subscriptionRateLimiter{
+		qps:      rate.Limit(qps),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// wait blocks until a token of the subscription is available or ctx is done
+func (l *subscriptionRateLimiter) wait(ctx context.Context, subsID string) error {
+	l.Lock()
+	key := strings.ToLower(subsID)
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(l.qps, l.burst)
+		l.limiters[key] = limiter
+	}
+	l.Unlock()
+	if err := limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("ARM rate limiter of subscription(%s) failed with error: %v", subsID, err)
+	}
+	return nil
+}
+
+// rateLimitedStorageAccountClient wraps storage account client with subscription rate limiter,
+// empty subsID means the default subscription of the client
+type rateLimitedStorageAccountClient struct {
+	client        storageaccountclient.Interface
+	limiter       *subscriptionRateLimiter
+	defaultSubsID string
+}
+
+var _ storageaccountclient.Interface = &rateLimitedStorageAccountClient{}
+
+func (c *rateLimitedStorageAccountClient) wait(ctx context.Context, subsID string) *retry.Error {
+	if subsID == "" {
+		subsID = c.defaultSubsID
+	}
+	if err := c.limiter.wait(ctx, subsID); err != nil {
+		return retry.NewError(false, err)
+	}
+	return nil
+}
+
+func (c *rateLimitedStorageAccountClient) Create(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+	if rerr := c.wait(ctx, subsID); rerr != nil {
+		return rerr
+	}
+	return c.client.Create(ctx, subsID, resourceGroupName, accountName, parameters)
+}
+
+func (c *rateLimitedStorageAccountClient) Update(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountUpdateParameters) *retry.Error {
+	if rerr := c.wait(ctx, subsID); rerr != nil {
+		return rerr
+	}
+	return c.client.Update(ctx, subsID, resourceGroupName, accountName, parameters)
+}
+
+func (c *rateLimitedStorageAccountClient) Delete(ctx context.Context, subsID, resourceGroupName, accountName string) *retry.Error {
+	if rerr := c.wait(ctx, subsID); rerr != nil {
+		return rerr
+	}
+	return c.client.Delete(ctx, subsID, resourceGroupName, accountName)
+}
+
+func (c *rateLimitedStorageAccountClient) ListKeys(ctx context.Context, subsID, resourceGroupName, accountName string) (storage.AccountListKeysResult, *retry.Error) {
+	if rerr := c.wait(ctx, subsID); rerr != nil {
+		return storage.AccountListKeysResult{}, rerr
+	}
+	return c.client.ListKeys(ctx, subsID, resourceGroupName, accountName)
+}
+
+func (c *rateLimitedStorageAccountClient) ListByResourceGroup(ctx context.Context, subsID, resourceGroupName string) ([]storage.Account, *retry.Error) {
+	if rerr := c.wait(ctx, subsID); rerr != nil {
+		return nil, rerr
+	}
+	return c.client.ListByResourceGroup(ctx, subsID, resourceGroupName)
+}
+
+func (c *rateLimitedStorageAccountClient) GetProperties(ctx context.Context, subsID, resourceGroupName, accountName string) (storage.Account, *retry.Error) {
+	if rerr := c.wait(ctx, subsID); rerr != nil {
+		return storage.Account{}, rerr
+	}
+	return c.client.GetProperties(ctx, subsID, resourceGroupName, accountName)
+}
+
+// rateLimitedFileClient wraps file client with subscription rate limiter, subsID is the subscription of the client
+type rateLimitedFileClient struct {
+	client  fileclient.Interface
+	limiter *subscriptionRateLimiter
+	subsID  string
+}
+
+var _ fileclient.Interface = &rateLimitedFileClient{}
+
+func (c *rateLimitedFileClient) CreateFileShare(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+	if err := c.limiter.wait(ctx, c.subsID); err != nil {
+		return storage.FileShare{}, err
+	}
+	return c.client.CreateFileShare(ctx, resourceGroupName, accountName, shareOptions, expand)
+}
+
+func (c *rateLimitedFileClient) DeleteFileShare(ctx context.Context, resourceGroupName, accountName, name, xMsSnapshot string) error {
+	if err := c.limiter.wait(ctx, c.subsID); err != nil {
+		return err
+	}
+	return c.client.DeleteFileShare(ctx, resourceGroupName, accountName, name, xMsSnapshot)
+}
+
+func (c *rateLimitedFileClient) ResizeFileShare(ctx context.Context, resourceGroupName, accountName, name string, sizeGiB int) error {
+	if err := c.limiter.wait(ctx, c.subsID); err != nil {
+		return err
+	}
+	return c.client.ResizeFileShare(ctx, resourceGroupName, accountName, name, sizeGiB)
+}
+
+func (c *rateLimitedFileClient) GetFileShare(ctx context.Context, resourceGroupName, accountName, name, xMsSnapshot string) (storage.FileShare, error) {
+	if err := c.limiter.wait(ctx, c.subsID); err != nil {
+		return storage.FileShare{}, err
+	}
+	return c.client.GetFileShare(ctx, resourceGroupName, accountName, name, xMsSnapshot)
+}
+
+func (c *rateLimitedFileClient) ListFileShare(ctx context.Context, resourceGroupName, accountName, filter, expand string) ([]storage.FileShareItem, error) {
+	if err := c.limiter.wait(ctx, c.subsID); err != nil {
+		return nil, err
+	}
+	return c.client.ListFileShare(ctx, resourceGroupName, accountName, filter, expand)
+}
+
+func (c *rateLimitedFileClient) GetServiceProperties(ctx context.Context, resourceGroupName, accountName string) (storage.FileServiceProperties, error) {
+	if err := c.limiter.wait(ctx, c.subsID); err != nil {
+		return storage.FileServiceProperties{}, err
+	}
+	return c.client.GetServiceProperties(ctx, resourceGroupName, accountName)
+}
+
+func (c *rateLimitedFileClient) SetServiceProperties(ctx context.Context, resourceGroupName, accountName string, parameters storage.FileServiceProperties) (storage.FileServiceProperties, error) {
+	if err := c.limiter.wait(ctx, c.subsID); err != nil {
+		return storage.FileServiceProperties{}, err
+	}
+	return c.client.SetServiceProperties(ctx, resourceGroupName, accountName, parameters)
+}
+
+// WithSubscriptionID returns file client of the subscription which shares the same rate limiter
+func (c *rateLimitedFileClient) WithSubscriptionID(subscriptionID string) fileclient.Interface {
+	if subscriptionID == "" || strings.EqualFold(subscriptionID, c.subsID) {
+		return c
+	}
+	return &rateLimitedFileClient{
+		client:  c.client.WithSubscriptionID(subscriptionID),
+		limiter: c.limiter,
+		subsID:  subscriptionID,
+	}
+}
+
+// setARMRateLimiter wraps storage account client and file client of cloud provider with subscription rate limiter
+func (d *Driver) setARMRateLimiter(qps float64, burst int) {
+	limiter := newSubscriptionRateLimiter(qps, burst)
+	if d.cloud.StorageAccountClient != nil {
+		d.cloud.StorageAccountClient = &rateLimitedStorageAccountClient{
+			client:        d.cloud.StorageAccountClient,
+			limiter:       limiter,
+			defaultSubsID: d.cloud.SubscriptionID,
+		}
+	}
+	if d.cloud.FileClient != nil {
+		d.cloud.FileClient = &rateLimitedFileClient{
+			client:  d.cloud.FileClient,
+			limiter: limiter,
+			subsID:  d.cloud.SubscriptionID,
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+)
+
+func TestSubscriptionRateLimiter(t *testing.T) {
+	// one token per subscription, no token would be refilled during the test
+	limiter := newSubscriptionRateLimiter(0.001, 1)
+
+	assert.NoError(t, limiter.wait(context.Background(), "subs1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, limiter.wait(ctx, "subs1"), "subs1 should be throttled")
+	assert.Error(t, limiter.wait(ctx, "SUBS1"), "subscription ID should be case insensitive")
+
+	// calls of other subscriptions are not throttled by subs1
+	assert.NoError(t, limiter.wait(context.Background(), "subs2"))
+	assert.NoError(t, limiter.wait(context.Background(), ""))
+	assert.Equal(t, 3, len(limiter.limiters))
+
+	limiter = newSubscriptionRateLimiter(1, 0)
+	assert.Equal(t, defaultARMBurst, limiter.burst)
+}
+
+func TestSetARMRateLimiter(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	mockOtherSubsFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.cloud.FileClient = mockFileClient
+	d.cloud.SubscriptionID = "subsID"
+
+	d.setARMRateLimiter(0.001, 1)
+
+	// first calls of every subscription go through
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "", "rg", "account").Return(storage.Account{}, nil).Times(1)
+	_, rerr := d.cloud.StorageAccountClient.GetProperties(context.Background(), "", "rg", "account")
+	assert.Nil(t, rerr)
+	mockFileClient.EXPECT().WithSubscriptionID("otherSubsID").Return(mockOtherSubsFileClient).Times(1)
+	mockOtherSubsFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(storage.FileShare{}, nil).Times(1)
+	_, err := d.cloud.FileClient.WithSubscriptionID("otherSubsID").GetFileShare(context.Background(), "rg", "account", "share", "")
+	assert.NoError(t, err)
+
+	// storage account client and file client share the same token bucket of the default subscription
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = d.cloud.FileClient.WithSubscriptionID("subsID").GetFileShare(ctx, "rg", "account", "share", "")
+	assert.Error(t, err)
+	rerr = d.cloud.StorageAccountClient.Delete(ctx, "subsID", "rg", "account")
+	assert.NotNil(t, rerr)
+	assert.False(t, rerr.Retriable)
+}
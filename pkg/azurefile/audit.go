@@ -0,0 +1,136 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+const (
+	auditCreateVolume = "CreateVolume"
+	auditDeleteVolume = "DeleteVolume"
+	auditExpandVolume = "ControllerExpandVolume"
+
+	redactedValue = "***"
+)
+
+// sensitiveParameterKeywords are parameter key keywords whose values are never written into audit events
+var sensitiveParameterKeywords = []string{"secret", "key", "password", "token"}
+
+// initAuditEventRecorder initializes the event recorder used for audit events
+func (d *Driver) initAuditEventRecorder() {
+	if !d.emitAuditEvents {
+		return
+	}
+	if d.cloud == nil || d.cloud.KubeClient == nil {
+		klog.Warningf("audit events are disabled since KubeClient is nil")
+		return
+	}
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: d.cloud.KubeClient.CoreV1().Events("")})
+	d.eventRecorder = eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: d.Name})
+}
+
+// emitAuditEvent records a volume operation as a Kubernetes event on the PVC,
+// the event is recorded on the CSIDriver object if PVC could not be resolved
+func (d *Driver) emitAuditEvent(operation, volumeID, pvcNamespace, pvcName string, parameters map[string]string, succeeded bool) {
+	if !d.emitAuditEvents || d.eventRecorder == nil {
+		return
+	}
+	eventType := v1.EventTypeNormal
+	reason := operation + "Succeeded"
+	if !succeeded {
+		eventType = v1.EventTypeWarning
+		reason = operation + "Failed"
+	}
+	d.eventRecorder.Event(d.getAuditEventObject(pvcNamespace, pvcName), eventType, reason, buildAuditEventMessage(operation, volumeID, parameters, succeeded))
+}
+
+// getAuditEventObject returns reference of PVC with its UID so that the event is listed in events of the PVC,
+// UID is empty if PVC could not be read
+func (d *Driver) getAuditEventObject(pvcNamespace, pvcName string) *v1.ObjectReference {
+	if pvcNamespace != "" && pvcName != "" {
+		ref := &v1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  pvcNamespace,
+			Name:       pvcName,
+		}
+		if d.cloud != nil && d.cloud.KubeClient != nil {
+			pvc, err := d.cloud.KubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
+			if err != nil {
+				klog.Warningf("failed to get PVC(%s/%s) for audit event: %v", pvcNamespace, pvcName, err)
+			} else {
+				ref.UID = pvc.UID
+				ref.ResourceVersion = pvc.ResourceVersion
+			}
+		}
+		return ref
+	}
+	return &v1.ObjectReference{
+		Kind:       "CSIDriver",
+		APIVersion: "storage.k8s.io/v1",
+		Name:       d.Name,
+	}
+}
+
+// buildAuditEventMessage returns the audit event message, values of sensitive parameters are redacted
+func buildAuditEventMessage(operation, volumeID string, parameters map[string]string, succeeded bool) string {
+	result := "succeeded"
+	if !succeeded {
+		result = "failed"
+	}
+	msg := fmt.Sprintf("%s(%s) %s", operation, volumeID, result)
+	if len(parameters) == 0 {
+		return msg
+	}
+
+	keys := make([]string, 0, len(parameters))
+	for k := range parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := parameters[k]
+		if isSensitiveParameter(k) {
+			v = redactedValue
+		}
+		params = append(params, fmt.Sprintf("%s=%s", k, v))
+	}
+	return fmt.Sprintf("%s, parameters: %s", msg, strings.Join(params, ","))
+}
+
+func isSensitiveParameter(key string) bool {
+	key = strings.ToLower(key)
+	for _, keyword := range sensitiveParameterKeywords {
+		if strings.Contains(key, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+)
+
+func TestBuildAuditEventMessage(t *testing.T) {
+	tests := []struct {
+		desc       string
+		operation  string
+		volumeID   string
+		parameters map[string]string
+		succeeded  bool
+		expected   string
+	}{
+		{
+			desc:      "no parameters",
+			operation: auditDeleteVolume,
+			volumeID:  "rg#account#share#",
+			succeeded: true,
+			expected:  "DeleteVolume(rg#account#share#) succeeded",
+		},
+		{
+			desc:      "failed operation",
+			operation: auditExpandVolume,
+			volumeID:  "rg#account#share#",
+			succeeded: false,
+			expected:  "ControllerExpandVolume(rg#account#share#) failed",
+		},
+		{
+			desc:      "sensitive parameters are redacted",
+			operation: auditCreateVolume,
+			volumeID:  "pvc-123",
+			parameters: map[string]string{
+				skuNameField:         "Premium_LRS",
+				"secretName":         "azure-secret",
+				"storeAccountKey":    "false",
+				"azurestoragetoken":  "token",
+				pvcNameKey:           "pvc-name",
+				"csi.storage.k8s.io": "value",
+			},
+			succeeded: true,
+			expected:  "CreateVolume(pvc-123) succeeded, parameters: azurestoragetoken=***,csi.storage.k8s.io=value,csi.storage.k8s.io/pvc/name=pvc-name,secretName=***,skuname=Premium_LRS,storeAccountKey=***",
+		},
+	}
+
+	for _, test := range tests {
+		result := buildAuditEventMessage(test.operation, test.volumeID, test.parameters, test.succeeded)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestGetAuditEventObject(t *testing.T) {
+	d := NewFakeDriver()
+
+	obj := d.getAuditEventObject("ns", "pvc-name")
+	assert.Equal(t, &v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: "ns", Name: "pvc-name"}, obj)
+
+	// UID of PVC is set if PVC could be read
+	d.cloud.KubeClient = fake.NewSimpleClientset(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pvc-name", UID: "pvc-uid", ResourceVersion: "1"},
+	})
+	obj = d.getAuditEventObject("ns", "pvc-name")
+	assert.Equal(t, &v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: "ns", Name: "pvc-name", UID: "pvc-uid", ResourceVersion: "1"}, obj)
+	obj = d.getAuditEventObject("ns", "not-exist")
+	assert.Equal(t, &v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: "ns", Name: "not-exist"}, obj)
+
+	obj = d.getAuditEventObject("", "")
+	assert.Equal(t, &v1.ObjectReference{Kind: "CSIDriver", APIVersion: "storage.k8s.io/v1", Name: fakeDriverName}, obj)
+}
+
+func TestEmitAuditEvent(t *testing.T) {
+	d := NewFakeDriver()
+	recorder := record.NewFakeRecorder(10)
+	recorder.IncludeObject = true
+	d.eventRecorder = recorder
+
+	// audit events are disabled by default
+	d.emitAuditEvent(auditDeleteVolume, "vol_1", "", "", nil, true)
+	assert.Equal(t, 0, len(recorder.Events))
+
+	d.emitAuditEvents = true
+	d.emitAuditEvent(auditCreateVolume, "pvc-123", "ns", "pvc-name", map[string]string{"secretName": "azure-secret"}, true)
+	assert.Equal(t, "Normal CreateVolumeSucceeded CreateVolume(pvc-123) succeeded, parameters: secretName=*** involvedObject{kind=PersistentVolumeClaim,apiVersion=v1}", <-recorder.Events)
+
+	d.emitAuditEvent(auditDeleteVolume, "vol_1", "", "", nil, false)
+	assert.Equal(t, "Warning DeleteVolumeFailed DeleteVolume(vol_1) failed involvedObject{kind=CSIDriver,apiVersion=storage.k8s.io/v1}", <-recorder.Events)
+
+	// requests failed in validation are audited
+	_, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{Name: "pvc-456", Parameters: map[string]string{pvcNamespaceKey: "ns", pvcNameKey: "pvc-name"}})
+	assert.Error(t, err)
+	assert.Equal(t, "Warning CreateVolumeFailed CreateVolume(pvc-456) failed, parameters: csi.storage.k8s.io/pvc/name=pvc-name,csi.storage.k8s.io/pvc/namespace=ns involvedObject{kind=PersistentVolumeClaim,apiVersion=v1}", <-recorder.Events)
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{})
+	assert.Error(t, err)
+	assert.Equal(t, "Warning DeleteVolumeFailed DeleteVolume() failed involvedObject{kind=CSIDriver,apiVersion=storage.k8s.io/v1}", <-recorder.Events)
+}
+
+func TestCreateVolumeAuditEventAfterAccountLimitExceeded(t *testing.T) {
+	d := NewFakeDriver()
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	d.emitAuditEvents = true
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+	created := false
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, resourceGroupName, accountName, name, expand string) (storage.FileShare, error) {
+			if !created {
+				return storage.FileShare{}, fmt.Errorf("ShareNotFound")
+			}
+			return storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}}, nil
+		}).AnyTimes()
+	// file share creation fails with account limit error first and succeeds in the retry
+	gomock.InOrder(
+		mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).Return(storage.FileShare{}, fmt.Errorf(accountLimitExceedManagementAPI)).Times(1),
+		mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+				created = true
+				return storage.FileShare{}, nil
+			}).Times(1),
+	)
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-123",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 100 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}, AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}}},
+		Parameters: map[string]string{
+			storageAccountField:  "stoacc",
+			resourceGroupField:   "rg",
+			shareNameField:       "share",
+			storeAccountKeyField: "false",
+		},
+	}
+	_, err := d.CreateVolume(context.Background(), req)
+	assert.NoError(t, err)
+
+	// only the retried request is audited
+	assert.Equal(t, 1, len(recorder.Events))
+	assert.Contains(t, <-recorder.Events, "Normal CreateVolumeSucceeded CreateVolume(pvc-123) succeeded")
+}
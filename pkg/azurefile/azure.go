@@ -25,6 +25,9 @@ import (
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2022-07-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	azure2 "github.com/Azure/go-autorest/autorest/azure"
 
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -32,18 +35,25 @@ import (
 	"k8s.io/klog/v2"
 
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	azureconfig "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
 )
 
 const (
 	DefaultAzureCredentialFileEnv = "AZURE_CREDENTIAL_FILE"
 	DefaultCredFilePathLinux      = "/etc/kubernetes/azure.json"
 	DefaultCredFilePathWindows    = "C:\\k\\azure.json"
+	azureStackCloud               = "AzureStackCloud"
 )
 
 var (
 	storageService = "Microsoft.Storage"
 )
 
+// fileShareRestorer restores soft deleted file shares, which is not supported by cloud provider file client
+type fileShareRestorer interface {
+	Restore(ctx context.Context, resourceGroupName, accountName, shareName string, deletedShare storage.DeletedShare) (autorest.Response, error)
+}
+
 // getCloudProvider get Azure Cloud Provider
 func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent string, allowEmptyCloudConfig bool, kubeAPIQPS float64, kubeAPIBurst int) (*azure.Cloud, error) {
 	var (
@@ -121,10 +131,22 @@ func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent
 			return az, fmt.Errorf("no cloud config provided, error: %v", err)
 		}
 	} else {
+		var azureStackEnv *azure2.Environment
+		if strings.EqualFold(config.Cloud, azureStackCloud) {
+			envFile := os.Getenv(azure2.EnvironmentFilepathName)
+			klog.V(2).Infof("%s env var set as %v", azure2.EnvironmentFilepathName, envFile)
+			if azureStackEnv, err = getAzureStackEnvironment(envFile); err != nil {
+				return az, fmt.Errorf("invalid %s environment: %v", config.Cloud, err)
+			}
+		}
 		config.UserAgent = userAgent
 		if err = az.InitializeCloudFromConfig(context.TODO(), config, fromSecret, false); err != nil {
 			klog.Warningf("InitializeCloudFromConfig failed with error: %v", err)
 		}
+		if azureStackEnv != nil && az.Environment.StorageEndpointSuffix == "" {
+			klog.V(2).Infof("use storage endpoint suffix(%s) from %s environment file", azureStackEnv.StorageEndpointSuffix, config.Cloud)
+			az.Environment = *azureStackEnv
+		}
 	}
 
 	// reassign kubeClient
@@ -148,6 +170,44 @@ func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent
 	return az, nil
 }
 
+// getAzureStackEnvironment loads Azure Stack Hub environment from envFile and
+// validates that endpoints used by the driver are set
+func getAzureStackEnvironment(envFile string) (*azure2.Environment, error) {
+	if strings.TrimSpace(envFile) == "" {
+		return nil, fmt.Errorf("%s env var is not set", azure2.EnvironmentFilepathName)
+	}
+	env, err := azure2.EnvironmentFromFile(envFile)
+	if err != nil {
+		return nil, fmt.Errorf("load environment file(%s) failed with error: %v", envFile, err)
+	}
+	if env.ResourceManagerEndpoint == "" {
+		return nil, fmt.Errorf("resourceManagerEndpoint is empty in environment file(%s)", envFile)
+	}
+	if env.StorageEndpointSuffix == "" {
+		return nil, fmt.Errorf("storageEndpointSuffix is empty in environment file(%s)", envFile)
+	}
+	return &env, nil
+}
+
+// getStorageEndpointSuffix returns storage endpoint suffix of the cloud environment, e.g.
+// core.windows.net (AzurePublicCloud), core.usgovcloudapi.net (AzureUSGovernmentCloud), core.chinacloudapi.cn (AzureChinaCloud)
+func getStorageEndpointSuffix(cloud *azure.Cloud) string {
+	if cloud == nil {
+		return defaultStorageEndPointSuffix
+	}
+	if cloud.Environment.StorageEndpointSuffix != "" {
+		return cloud.Environment.StorageEndpointSuffix
+	}
+	if cloud.Config.Cloud != "" {
+		env, err := azure2.EnvironmentFromName(cloud.Config.Cloud)
+		if err == nil && env.StorageEndpointSuffix != "" {
+			return env.StorageEndpointSuffix
+		}
+		klog.Warningf("could not get storage endpoint suffix of cloud(%s), use %s instead", cloud.Config.Cloud, defaultStorageEndPointSuffix)
+	}
+	return defaultStorageEndPointSuffix
+}
+
 func getKubeConfig(kubeconfig string) (config *rest.Config, err error) {
 	if kubeconfig != "" {
 		if config, err = clientcmd.BuildConfigFromFlags("", kubeconfig); err != nil {
@@ -227,3 +287,62 @@ func (d *Driver) updateSubnetServiceEndpoints(ctx context.Context, vnetResourceG
 
 	return nil
 }
+
+// getNFSDeniedSubnets returns subnets in subnetIDs which are not allowed to access storage account by its network rules,
+// NFS mount is only authorized by network since there is no credential
+func (d *Driver) getNFSDeniedSubnets(ctx context.Context, subsID, resourceGroup, accountName string, subnetIDs []string) ([]string, error) {
+	if d.cloud.StorageAccountClient == nil {
+		return nil, fmt.Errorf("StorageAccountClient is nil")
+	}
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return nil, rerr.Error()
+	}
+	if account.AccountProperties == nil || account.AccountProperties.NetworkRuleSet == nil ||
+		account.AccountProperties.NetworkRuleSet.DefaultAction == storage.DefaultActionAllow {
+		return nil, nil
+	}
+	if account.AccountProperties.PrivateEndpointConnections != nil && len(*account.AccountProperties.PrivateEndpointConnections) > 0 {
+		klog.V(2).Infof("storage account(%s) has private endpoint connections, skip checking virtual network rules", accountName)
+		return nil, nil
+	}
+
+	var deniedSubnets []string
+	for _, subnetID := range subnetIDs {
+		allowed := false
+		if rules := account.AccountProperties.NetworkRuleSet.VirtualNetworkRules; rules != nil {
+			for _, rule := range *rules {
+				if rule.VirtualNetworkResourceID != nil && strings.EqualFold(*rule.VirtualNetworkResourceID, subnetID) &&
+					(rule.Action == "" || rule.Action == storage.ActionAllow) {
+					allowed = true
+					break
+				}
+			}
+		}
+		if !allowed {
+			deniedSubnets = append(deniedSubnets, subnetID)
+		}
+	}
+	return deniedSubnets, nil
+}
+
+// newFileShareRestorer creates file shares client in subscription with the credential of cloud provider
+func newFileShareRestorer(cloud *azure.Cloud, subsID string) (fileShareRestorer, error) {
+	if cloud == nil {
+		return nil, fmt.Errorf("cloud provider is nil")
+	}
+	token, err := azureconfig.GetServicePrincipalToken(&cloud.Config.AzureAuthConfig, &cloud.Environment, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service principal token: %w", err)
+	}
+	client := storage.NewFileSharesClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	client.Authorizer = autorest.NewBearerAuthorizer(token)
+	return client, nil
+}
@@ -20,10 +20,14 @@ limitations under the License.
 package azurefile
 
 import (
+	"context"
+	"fmt"
+	"runtime"
+
 	mount "k8s.io/mount-utils"
 )
 
-func SMBMount(m *mount.SafeFormatAndMount, source, target, fsType string, options, sensitiveMountOptions []string) error {
+func SMBMount(ctx context.Context, m *mount.SafeFormatAndMount, source, target, fsType string, options, sensitiveMountOptions []string) error {
 	return nil
 }
 
@@ -31,6 +35,18 @@ func CleanupMountPoint(m *mount.SafeFormatAndMount, target string, extensiveMoun
 	return nil
 }
 
+func lazyUnmount(target string) error {
+	return fmt.Errorf("lazy unmount is not supported on darwin")
+}
+
+func getDirACL(path string) (string, error) {
+	return "", fmt.Errorf("getDirACL is not supported on %s", runtime.GOOS)
+}
+
+func setDirACL(path, acl string) error {
+	return fmt.Errorf("setDirACL is not supported on %s", runtime.GOOS)
+}
+
 func preparePublishPath(path string, m *mount.SafeFormatAndMount) error {
 	return nil
 }
@@ -20,17 +20,50 @@ limitations under the License.
 package azurefile
 
 import (
+	"context"
+	"fmt"
+	"runtime"
+	"syscall"
+
+	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 )
 
-func SMBMount(m *mount.SafeFormatAndMount, source, target, fsType string, options, sensitiveMountOptions []string) error {
-	return m.MountSensitive(source, target, fsType, options, sensitiveMountOptions)
+// SMBMount mounts source on target, if ctx could be done (e.g. it has a deadline), mount command is run directly
+// and it's killed once ctx is done
+func SMBMount(ctx context.Context, m *mount.SafeFormatAndMount, source, target, fsType string, options, sensitiveMountOptions []string) error {
+	if ctx.Done() == nil {
+		return m.MountSensitive(source, target, fsType, options, sensitiveMountOptions)
+	}
+	mountArgs, mountArgsLogStr := mount.MakeMountArgsSensitive(source, target, fsType, options, sensitiveMountOptions)
+	klog.V(4).Infof("Mounting cmd (mount) with arguments (%s)", mountArgsLogStr)
+	output, err := m.Exec.CommandContext(ctx, "mount", mountArgs...).CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("mount is killed: %v", ctx.Err())
+		}
+		return fmt.Errorf("mount failed: %v\nMounting command: mount\nMounting arguments: %s\nOutput: %s", err, mountArgsLogStr, string(output))
+	}
+	return nil
 }
 
 func CleanupMountPoint(m *mount.SafeFormatAndMount, target string, extensiveMountCheck bool) error {
 	return mount.CleanupMountPoint(target, m.Interface, true /*extensiveMountPointCheck*/)
 }
 
+// lazyUnmount detaches mount point from file system hierarchy immediately, mount is cleaned up when it's not busy anymore
+func lazyUnmount(target string) error {
+	return syscall.Unmount(target, syscall.MNT_DETACH)
+}
+
+func getDirACL(path string) (string, error) {
+	return "", fmt.Errorf("getDirACL is not supported on %s", runtime.GOOS)
+}
+
+func setDirACL(path, acl string) error {
+	return fmt.Errorf("setDirACL is not supported on %s", runtime.GOOS)
+}
+
 func preparePublishPath(path string, m *mount.SafeFormatAndMount) error {
 	return nil
 }
@@ -20,14 +20,19 @@ limitations under the License.
 package azurefile
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/exec"
+	"strings"
 
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 	"sigs.k8s.io/azurefile-csi-driver/pkg/mounter"
 )
 
-func SMBMount(m *mount.SafeFormatAndMount, source, target, fsType string, mountOptions, sensitiveMountOptions []string) error {
+// SMBMount mounts source on target by csi proxy, ctx is not respected since mount could not be killed
+func SMBMount(ctx context.Context, m *mount.SafeFormatAndMount, source, target, fsType string, mountOptions, sensitiveMountOptions []string) error {
 	if proxy, ok := m.Interface.(mounter.CSIProxyMounter); ok {
 		return proxy.SMBMount(source, target, fsType, mountOptions, sensitiveMountOptions)
 	}
@@ -41,6 +46,10 @@ func CleanupMountPoint(m *mount.SafeFormatAndMount, target string, extensiveMoun
 	return fmt.Errorf("could not cast to csi proxy class")
 }
 
+func lazyUnmount(target string) error {
+	return fmt.Errorf("lazy unmount is not supported on windows")
+}
+
 func removeDir(path string, m *mount.SafeFormatAndMount) error {
 	if proxy, ok := m.Interface.(mounter.CSIProxyMounter); ok {
 		isExists, err := proxy.ExistsPath(path)
@@ -59,6 +68,29 @@ func removeDir(path string, m *mount.SafeFormatAndMount) error {
 	return fmt.Errorf("could not cast to csi proxy class")
 }
 
+// getDirACL returns ACL of directory in SDDL format, it only works when driver is running as host process
+func getDirACL(path string) (string, error) {
+	cmd := exec.Command("powershell", "/c", `(Get-Acl -Path $Env:aclpath).Sddl`)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("aclpath=%s", path))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to get ACL of %s, output: %s, error: %v", path, string(output), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// setDirACL sets components of ACL in SDDL format on directory, components not in acl are not changed,
+// it only works when driver is running as host process
+func setDirACL(path, acl string) error {
+	cmdLine := `$acl = Get-Acl -Path $Env:aclpath; $acl.SetSecurityDescriptorSddlForm($Env:sddl); Set-Acl -Path $Env:aclpath -AclObject $acl`
+	cmd := exec.Command("powershell", "/c", cmdLine)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("aclpath=%s", path), fmt.Sprintf("sddl=%s", acl))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set ACL(%s) on %s, output: %s, error: %v", acl, path, string(output), err)
+	}
+	return nil
+}
+
 // preparePublishPath - In case of windows, the publish code path creates a soft link
 // from global stage path to the publish path. But kubelet creates the directory in advance.
 // We work around this issue by deleting the publish path then recreating the link.
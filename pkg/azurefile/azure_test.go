@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"reflect"
 	"runtime"
@@ -27,14 +28,18 @@ import (
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2022-07-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	azure2 "github.com/Azure/go-autorest/autorest/azure"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
 	"sigs.k8s.io/azurefile-csi-driver/test/utils/testutil"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/subnetclient/mocksubnetclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
 
 	azureprovider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	azureconfig "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
 )
 
 func skipIfTestingOnWindows(t *testing.T) {
@@ -185,6 +190,125 @@ users:
 	}
 }
 
+func TestGetCloudProviderWithAzureStackCloud(t *testing.T) {
+	skipIfTestingOnWindows(t)
+	var (
+		fakeCredFile = testutil.GetWorkDirPath("fake-azurestack-cred-file.json", t)
+		fakeEnvFile  = testutil.GetWorkDirPath("fake-azurestack-env-file.json", t)
+	)
+	if err := ioutil.WriteFile(fakeCredFile, []byte(`{"cloud": "AzureStackCloud", "location": "local"}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fakeCredFile)
+	if err := ioutil.WriteFile(fakeEnvFile, []byte(`{"name": "AzureStackCloud", "resourceManagerEndpoint": "https://management.local.azurestack.external/", "storageEndpointSuffix": "local.azurestack.external"}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fakeEnvFile)
+
+	for _, env := range []string{DefaultAzureCredentialFileEnv, azure2.EnvironmentFilepathName} {
+		if original, ok := os.LookupEnv(env); ok {
+			defer os.Setenv(env, original)
+		} else {
+			defer os.Unsetenv(env)
+		}
+	}
+	os.Setenv(DefaultAzureCredentialFileEnv, fakeCredFile)
+
+	tests := []struct {
+		desc                          string
+		envFile                       string
+		expectedErr                   error
+		expectedStorageEndpointSuffix string
+	}{
+		{
+			desc:        "[failure] environment file path not set",
+			envFile:     "",
+			expectedErr: fmt.Errorf("invalid AzureStackCloud environment: %s env var is not set", azure2.EnvironmentFilepathName),
+		},
+		{
+			desc:                          "[success] valid environment file",
+			envFile:                       fakeEnvFile,
+			expectedStorageEndpointSuffix: "local.azurestack.external",
+		},
+	}
+
+	for _, test := range tests {
+		os.Setenv(azure2.EnvironmentFilepathName, test.envFile)
+		cloud, err := getCloudProvider("", "", "", "", "", true, 5, 10)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("desc: %s, getCloudProvider err: %v, expectedErr: %v", test.desc, err, test.expectedErr)
+		}
+		if err == nil {
+			assert.Equal(t, test.expectedStorageEndpointSuffix, cloud.Environment.StorageEndpointSuffix, test.desc)
+		}
+	}
+}
+
+func TestGetAzureStackEnvironment(t *testing.T) {
+	validEnvFile := testutil.GetWorkDirPath("valid-azurestack-env.json", t)
+	invalidEnvFile := testutil.GetWorkDirPath("invalid-azurestack-env.json", t)
+	noStorageSuffixEnvFile := testutil.GetWorkDirPath("no-storage-suffix-azurestack-env.json", t)
+	noResourceManagerEnvFile := testutil.GetWorkDirPath("no-resource-manager-azurestack-env.json", t)
+	files := map[string]string{
+		validEnvFile:             `{"name": "AzureStackCloud", "resourceManagerEndpoint": "https://management.local.azurestack.external/", "storageEndpointSuffix": "local.azurestack.external"}`,
+		invalidEnvFile:           `invalid`,
+		noStorageSuffixEnvFile:   `{"name": "AzureStackCloud", "resourceManagerEndpoint": "https://management.local.azurestack.external/"}`,
+		noResourceManagerEnvFile: `{"name": "AzureStackCloud", "storageEndpointSuffix": "local.azurestack.external"}`,
+	}
+	for file, content := range files {
+		if err := ioutil.WriteFile(file, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file)
+	}
+
+	tests := []struct {
+		desc                          string
+		envFile                       string
+		expectedErr                   error
+		expectedStorageEndpointSuffix string
+		expectedResourceManager       string
+	}{
+		{
+			desc:        "empty env file path",
+			envFile:     "",
+			expectedErr: fmt.Errorf("%s env var is not set", azure2.EnvironmentFilepathName),
+		},
+		{
+			desc:        "invalid env file content",
+			envFile:     invalidEnvFile,
+			expectedErr: fmt.Errorf("load environment file(%s) failed with error: invalid character 'i' looking for beginning of value", invalidEnvFile),
+		},
+		{
+			desc:        "resourceManagerEndpoint is empty",
+			envFile:     noResourceManagerEnvFile,
+			expectedErr: fmt.Errorf("resourceManagerEndpoint is empty in environment file(%s)", noResourceManagerEnvFile),
+		},
+		{
+			desc:        "storageEndpointSuffix is empty",
+			envFile:     noStorageSuffixEnvFile,
+			expectedErr: fmt.Errorf("storageEndpointSuffix is empty in environment file(%s)", noStorageSuffixEnvFile),
+		},
+		{
+			desc:                          "valid env file",
+			envFile:                       validEnvFile,
+			expectedStorageEndpointSuffix: "local.azurestack.external",
+			expectedResourceManager:       "https://management.local.azurestack.external/",
+		},
+	}
+
+	for _, test := range tests {
+		env, err := getAzureStackEnvironment(test.envFile)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("desc: %s, unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+		if err == nil {
+			assert.Equal(t, test.expectedStorageEndpointSuffix, env.StorageEndpointSuffix, test.desc)
+			assert.Equal(t, test.expectedResourceManager, env.ResourceManagerEndpoint, test.desc)
+		}
+	}
+}
+
 func createTestFile(path string) error {
 	f, err := os.Create(path)
 	if err != nil {
@@ -315,6 +439,132 @@ func TestUpdateSubnetServiceEndpoints(t *testing.T) {
 	}
 }
 
+func TestGetNFSDeniedSubnets(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	_, err := d.getNFSDeniedSubnets(context.TODO(), "", "", "account", nil)
+	assert.Equal(t, fmt.Errorf("StorageAccountClient is nil"), err)
+
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.cloud.ResourceGroup = "rg"
+
+	subnet1 := "/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet1"
+	subnet2 := "/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet2"
+	tests := []struct {
+		desc                  string
+		account               storage.Account
+		rerr                  *retry.Error
+		expectedDeniedSubnets []string
+		expectedErr           error
+	}{
+		{
+			desc:        "GetProperties returns error",
+			rerr:        &retry.Error{HTTPStatusCode: http.StatusInternalServerError, RawError: fmt.Errorf("test error")},
+			expectedErr: (&retry.Error{HTTPStatusCode: http.StatusInternalServerError, RawError: fmt.Errorf("test error")}).Error(),
+		},
+		{
+			desc: "network access is allowed by default",
+			account: storage.Account{AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionAllow},
+			}},
+		},
+		{
+			desc: "all subnets are allowed by virtual network rules",
+			account: storage.Account{AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: buildNetworkRuleSet([]string{strings.ToUpper(subnet1), subnet2}, nil),
+			}},
+		},
+		{
+			desc: "subnet is not in virtual network rules",
+			account: storage.Account{AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: buildNetworkRuleSet([]string{subnet1}, []string{"10.0.0.1"}),
+			}},
+			expectedDeniedSubnets: []string{subnet2},
+		},
+		{
+			desc: "no virtual network rules",
+			account: storage.Account{AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionDeny},
+			}},
+			expectedDeniedSubnets: []string{subnet1, subnet2},
+		},
+		{
+			desc: "account with private endpoint",
+			account: storage.Account{AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet:             &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionDeny},
+				PrivateEndpointConnections: &[]storage.PrivateEndpointConnection{{}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subscriptionID", "rg", "account").Return(test.account, test.rerr).Times(1)
+		deniedSubnets, err := d.getNFSDeniedSubnets(context.TODO(), "", "", "account", []string{subnet1, subnet2})
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedDeniedSubnets, deniedSubnets, test.desc)
+	}
+}
+
+func TestGetStorageEndpointSuffix(t *testing.T) {
+	tests := []struct {
+		desc     string
+		cloud    *azureprovider.Cloud
+		expected string
+	}{
+		{
+			desc:     "nil cloud",
+			cloud:    nil,
+			expected: "core.windows.net",
+		},
+		{
+			desc:     "empty cloud config",
+			cloud:    &azureprovider.Cloud{},
+			expected: "core.windows.net",
+		},
+		{
+			desc:     "AzurePublicCloud",
+			cloud:    &azureprovider.Cloud{Config: azureprovider.Config{AzureAuthConfig: azureconfig.AzureAuthConfig{Cloud: "AzurePublicCloud"}}},
+			expected: "core.windows.net",
+		},
+		{
+			desc:     "AzureUSGovernmentCloud",
+			cloud:    &azureprovider.Cloud{Config: azureprovider.Config{AzureAuthConfig: azureconfig.AzureAuthConfig{Cloud: "AzureUSGovernmentCloud"}}},
+			expected: "core.usgovcloudapi.net",
+		},
+		{
+			desc:     "AzureChinaCloud",
+			cloud:    &azureprovider.Cloud{Config: azureprovider.Config{AzureAuthConfig: azureconfig.AzureAuthConfig{Cloud: "AzureChinaCloud"}}},
+			expected: "core.chinacloudapi.cn",
+		},
+		{
+			desc:     "cloud name is case insensitive",
+			cloud:    &azureprovider.Cloud{Config: azureprovider.Config{AzureAuthConfig: azureconfig.AzureAuthConfig{Cloud: "azurechinacloud"}}},
+			expected: "core.chinacloudapi.cn",
+		},
+		{
+			desc:     "unknown cloud",
+			cloud:    &azureprovider.Cloud{Config: azureprovider.Config{AzureAuthConfig: azureconfig.AzureAuthConfig{Cloud: "unknown"}}},
+			expected: "core.windows.net",
+		},
+		{
+			desc: "storage endpoint suffix in environment takes precedence",
+			cloud: &azureprovider.Cloud{
+				Config:      azureprovider.Config{AzureAuthConfig: azureconfig.AzureAuthConfig{Cloud: "AzureChinaCloud"}},
+				Environment: azure2.Environment{StorageEndpointSuffix: "core.azurestack.local"},
+			},
+			expected: "core.azurestack.local",
+		},
+	}
+
+	for _, test := range tests {
+		result := getStorageEndpointSuffix(test.cloud)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
 func TestGetKubeConfig(t *testing.T) {
 	// skip for now as this is very flaky on Windows
 	skipIfTestingOnWindows(t)
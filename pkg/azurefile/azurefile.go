@@ -19,11 +19,18 @@ package azurefile
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
@@ -35,10 +42,14 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume/util"
 	mount "k8s.io/mount-utils"
+	"k8s.io/utils/pointer"
 
 	csicommon "sigs.k8s.io/azurefile-csi-driver/pkg/csi-common"
 	"sigs.k8s.io/azurefile-csi-driver/pkg/mounter"
@@ -51,18 +62,32 @@ import (
 const (
 	DefaultDriverName  = "file.csi.azure.com"
 	separator          = "#"
-	volumeIDTemplate   = "%s#%s#%s#%s#%s#%s"
 	secretNameTemplate = "azure-storage-account-%s-secret"
-	serviceURLTemplate = "https://%s.file.%s"
-	fileURLTemplate    = "https://%s.file.%s/%s/%s"
-	subnetTemplate     = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s/subnets/%s"
-	fileMode           = "file_mode"
-	dirMode            = "dir_mode"
-	actimeo            = "actimeo"
-	mfsymlinks         = "mfsymlinks"
-	defaultFileMode    = "0777"
-	defaultDirMode     = "0777"
-	defaultActimeo     = "30"
+	// secret storing the key of storage account specified by useKey
+	keySecretNameTemplate = "azure-storage-account-%s-%s-secret"
+	serviceURLTemplate    = "https://%s.file.%s"
+	fileURLTemplate       = "https://%s.file.%s/%s/%s"
+	subnetTemplate        = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s/subnets/%s"
+	fileMode              = "file_mode"
+	dirMode               = "dir_mode"
+	actimeo               = "actimeo"
+	mfsymlinks            = "mfsymlinks"
+	softMountOption       = "soft"
+	hardMountOption       = "hard"
+	timeoMountOption      = "timeo"
+	retransMountOption    = "retrans"
+	defaultFileMode       = "0777"
+	defaultDirMode        = "0777"
+	defaultActimeo        = "30"
+	// default nfs mount options, NFSv4 ACL also requires nfs v4.1 with sys security flavor,
+	// nfs v4.1 only connects to port 2049 of server so that rpcbind(portmapper) is not required on node
+	defaultNFSMountOptions = "vers=4,minorversion=1,sec=sys"
+	// default nfs v3 mount options, locking is disabled since NLM requires rpcbind and rpc.statd on node
+	defaultNFSv3MountOptions = "vers=3,proto=tcp,nolock,sec=sys"
+	nfsVersion41             = "4.1"
+	nfsVersion3              = "3"
+	// cifs mount option of SMB protocol version
+	smbVersionMountOption = "vers"
 
 	// See https://docs.microsoft.com/en-us/rest/api/storageservices/naming-and-referencing-shares--directories--files--and-metadata#share-names
 	fileShareNameMinLength = 3
@@ -72,9 +97,33 @@ const (
 	// Minimum size of Azure Premium Files is 100GiB
 	// See https://docs.microsoft.com/en-us/azure/storage/files/storage-files-planning#provisioned-shares
 	defaultAzureFileQuota = 100
+	// maximum size of file share with large file shares enabled
+	maximumShareSize = 102400 // GiB
+	// default max value of operationTimeout parameter in CreateVolume
+	defaultMaxOperationTimeout = 30 * time.Minute
+	// max value of mountTimeout parameter in NodeStageVolume
+	maxMountTimeout = 10 * time.Minute
+	// default timeout of waiting for file share deletion in DeleteVolume
+	defaultWaitForShareDeleteTimeout = 5 * time.Minute
 
 	// key of snapshot name in metadata
 	snapshotNameKey = "initiator"
+	// key of deleted volume ID in metadata of the snapshot created before volume deletion
+	deletedVolumeIDKey = "deletedvolumeid"
+	// key of parent snapshot ID in metadata of the snapshot, used by backup tools to reconstruct incremental backup chain
+	parentSnapshotKey = "parentsnapshot"
+	// snapshot name of the snapshot created before volume deletion
+	snapshotBeforeDeleteName = "snapshot-before-delete"
+
+	// keys of provisioned performance of premium file share in volume context
+	provisionedIopsKey           = "provisionedIops"
+	provisionedBandwidthMiBpsKey = "provisionedBandwidthMiBps"
+	// key of secret name which stores SAS token of file share in volume context
+	sasTokenSecretNameKey = "sasTokenSecretName"
+	// max provisioned performance of premium file share
+	// See https://learn.microsoft.com/en-us/azure/storage/files/understanding-billing#provisioned-model
+	maxPremiumFileShareIops           = 100000
+	maxPremiumFileShareBandwidthMiBps = 10340
 
 	shareNameField                    = "sharename"
 	accessTierField                   = "accesstier"
@@ -96,6 +145,13 @@ const (
 	resourceGroupField                = "resourcegroup"
 	locationField                     = "location"
 	secretNamespaceField              = "secretnamespace"
+	secretNamespacesField             = "secretnamespaces"
+	keyVaultURLField                  = "keyvaulturl"
+	keyVaultSecretNameField           = "keyvaultsecretname"
+	nfsVersionField                   = "nfsversion"
+	keyExpirationDaysField            = "keyexpirationdays"
+	useKeyField                       = "usekey"
+	profileField                      = "profile"
 	secretNameField                   = "secretname"
 	createAccountField                = "createaccount"
 	useDataPlaneAPIField              = "usedataplaneapi"
@@ -109,15 +165,21 @@ const (
 	ephemeralField                    = "csi.storage.k8s.io/ephemeral"
 	podNamespaceField                 = "csi.storage.k8s.io/pod.namespace"
 	mountOptionsField                 = "mountoptions"
+	mountOptionsConfigMapField        = "mountoptionsconfigmap"
+	windowsMountOptionsField          = "windowsmountoptions"
+	linuxMountOptionsField            = "linuxmountoptions"
 	mountPermissionsField             = "mountpermissions"
 	falseValue                        = "false"
 	trueValue                         = "true"
 	defaultSecretAccountName          = "azurestorageaccountname"
 	defaultSecretAccountKey           = "azurestorageaccountkey"
+	defaultSecretConnectionString     = "azurestorageconnectionstring"
 	proxyMount                        = "proxy-mount"
+	credentialFile                    = "smb-credentials"
 	cifs                              = "cifs"
 	smb                               = "smb"
 	nfs                               = "nfs"
+	auto                              = "auto"
 	ext4                              = "ext4"
 	ext3                              = "ext3"
 	ext2                              = "ext2"
@@ -131,6 +193,27 @@ const (
 	shareNamePrefixField              = "sharenameprefix"
 	requireInfraEncryptionField       = "requireinfraencryption"
 	enableMultichannelField           = "enablemultichannel"
+	minimumTLSVersionField            = "minimumtlsversion"
+	networkDefaultActionField         = "networkdefaultaction"
+	allowedIPsField                   = "allowedips"
+	shareMetadataField                = "sharemetadata"
+	dataPlaneAuthField                = "dataplaneauth"
+	restoreFromSoftDeleteField        = "restorefromsoftdelete"
+	useExistingShareField             = "useexistingshare"
+	operationTimeoutField             = "operationtimeout"
+	mountTimeoutField                 = "mounttimeout"
+	mountServerAddressField           = "mountserveraddress"
+	nfsExportPathTemplateField        = "nfsexportpathtemplate"
+	dnsSearchDomainField              = "dnssearchdomain"
+	enableNFSACLField                 = "enablenfsacl"
+	useCredentialFileField            = "usecredentialfile"
+	rootDirACLField                   = "rootdiracl"
+	parentSnapshotField               = "parentsnapshot"
+	generateSASTokenField             = "generatesastoken"
+	sasTokenPermissionsField          = "sastokenpermissions"
+	sasTokenExpiryField               = "sastokenexpiry"
+	disableAccountKeyAuthField        = "disableaccountkeyauth"
+	initialDirectoriesField           = "initialdirectories"
 	premium                           = "premium"
 
 	accountNotProvisioned = "StorageAccountIsNotProvisioned"
@@ -138,9 +221,28 @@ const (
 	tooManyRequests   = "TooManyRequests"
 	shareBeingDeleted = "The specified share is being deleted"
 	clientThrottled   = "client throttled"
+	// returned with 403 by storage account firewall when network rules deny the request, which also happens
+	// before newly added network rules are propagated. It's different from AuthorizationFailed of RBAC
+	authorizationFailure = "AuthorizationFailure"
 	// accountLimitExceed returned by different API
 	accountLimitExceedManagementAPI = "TotalSharesProvisionedCapacityExceedsAccountLimit"
 	accountLimitExceedDataPlaneAPI  = "specified share does not exist"
+	// returned when storage account name is already used by another subscription or resource group
+	accountNameAlreadyTaken  = "StorageAccountAlreadyTaken"
+	accountNameAlreadyExists = "StorageAccountAlreadyExists"
+	// provisioning state of storage account which is being deleted
+	accountProvisioningStateDeleting = "Deleting"
+	// max number of storage account name regeneration when generated name is unavailable
+	maxAccountNameRetries = 3
+
+	// data plane auth types
+	dataPlaneAuthKey              = "key"
+	dataPlaneAuthConnectionString = "connectionString"
+	dataPlaneAuthAAD              = "aad"
+
+	// identity based auth methods of mount when account key auth is disabled
+	identityAuthNetwork  = "network"
+	identityAuthKerberos = "kerberos"
 
 	fileShareNotFound  = "ErrorCode=ShareNotFound"
 	statusCodeNotFound = "StatusCode=404"
@@ -161,6 +263,19 @@ const (
 	pvcNamespaceMetadata = "${pvc.metadata.namespace}"
 	pvNameMetadata       = "${pv.metadata.name}"
 
+	// placeholders in nfsExportPathTemplate, the standard NFS export path of file share is /accountname/sharename
+	nfsExportPathAccountName     = "${account}"
+	nfsExportPathShareName       = "${share}"
+	defaultNFSExportPathTemplate = "/" + nfsExportPathAccountName + "/" + nfsExportPathShareName
+
+	// parameters in allowlist could be overridden by PVC annotations with this prefix, e.g. file.csi.azure.com/skuName
+	pvcAnnotationPrefix = DefaultDriverName + "/"
+
+	// topology key of node region, storage account in the region of node is preferred if regional account affinity is enabled
+	topologyRegionKey = "topology.kubernetes.io/region"
+	// topology key of node OS, protocol(auto) is resolved by node OS in requested topology if node OS topology is enabled
+	topologyOSKey = "kubernetes.io/os"
+
 	defaultStorageEndPointSuffix = "core.windows.net"
 
 	VolumeID         = "volumeid"
@@ -171,6 +286,35 @@ const (
 	FSGroupChangeNone = "None"
 )
 
+const (
+	// volume handle versions, segments of v1 handle are joined by # directly, segments of v2 handle are escaped
+	volumeHandleVersionV1 = "v1"
+	volumeHandleVersionV2 = "v2"
+	volumeIDV2Prefix      = "v2:"
+	// prefix of volume name segment in volume handle of existing file share adopted by useExistingShare,
+	// file share of such volume handle is never deleted in DeleteVolume
+	existingShareVolumePrefix = "existing-"
+
+	// share name longer than fileShareNameMaxLength is truncated directly by default,
+	// a hash suffix of the full name is appended to truncated share name with hash strategy
+	shareNameCollisionTruncate = "truncate"
+	shareNameCollisionHash     = "hash"
+	shareNameHashSuffixLength  = 8
+
+	// range of key expiration period of storage account in days
+	minKeyExpirationDays = 1
+	maxKeyExpirationDays = 3650
+
+	// names of storage account keys which could be specified by useKey parameter
+	accountKey1 = "key1"
+	accountKey2 = "key2"
+
+	// contradictory access modes in volume capabilities of CreateVolume are rejected by default,
+	// they are accepted as before with allow policy
+	accessModeConflictReject = "reject"
+	accessModeConflictAllow  = "allow"
+)
+
 var (
 	supportedFsTypeList              = []string{cifs, smb, nfs, ext4, ext3, ext2, xfs}
 	supportedProtocolList            = []string{smb, nfs}
@@ -178,6 +322,37 @@ var (
 	supportedFSGroupChangePolicyList = []string{FSGroupChangeNone, string(v1.FSGroupChangeAlways), string(v1.FSGroupChangeOnRootMismatch)}
 
 	retriableErrors = []string{accountNotProvisioned, tooManyRequests, shareBeingDeleted, clientThrottled}
+
+	accountNameUnavailableErrors = []string{accountNameAlreadyTaken, accountNameAlreadyExists}
+
+	// storage account creation errors caused by capacity or quota of region, storage account could be created in other regions
+	regionCapacityErrors = []string{"SkuNotAvailable", "LocationNotAvailableForResourceType", "AllocationFailed", "QuotaExceeded"}
+
+	// SMB versions to fall back in order when server rejects SMB protocol negotiation
+	smbVersionFallbackList = []string{"3.1.1", "3.0", "2.1"}
+	// mount.cifs errors on SMB protocol negotiation failure: EOPNOTSUPP, EHOSTDOWN
+	smbNegotiationErrors = []string{"mount error(95)", "mount error(112)"}
+	// mount.cifs errors on authentication or permission failure: EPERM, EACCES, ENOKEY
+	smbAuthErrors = []string{"mount error(1)", "mount error(13)", "mount error(126)"}
+
+	supportedDataPlaneAuthList = []string{dataPlaneAuthKey, dataPlaneAuthConnectionString, dataPlaneAuthAAD}
+
+	// default nfs mount options of supported nfs versions
+	nfsVersionMountOptions = map[string]string{
+		nfsVersion41: defaultNFSMountOptions,
+		nfsVersion3:  defaultNFSv3MountOptions,
+	}
+
+	// mount propagation flags in mount flags and the propagation set on bind mount of NodePublishVolume,
+	// empty propagation means private which is the default behavior of CSI spec
+	mountPropagationMap = map[string]string{
+		"shared":   "rshared",
+		"rshared":  "rshared",
+		"slave":    "rslave",
+		"rslave":   "rslave",
+		"private":  "",
+		"rprivate": "",
+	}
 )
 
 // DriverOptions defines driver parameters specified in driver deployment
@@ -198,6 +373,62 @@ type DriverOptions struct {
 	KubeAPIQPS                             float64
 	KubeAPIBurst                           int
 	EnableWindowsHostProcess               bool
+	MinimumTLSVersion                      string
+	EmitAuditEvents                        bool
+	SnapshotBeforeDelete                   bool
+	MaxVolumesPerNode                      int64
+	EnableOrphanGC                         bool
+	OrphanGCInterval                       time.Duration
+	OrphanGCThreshold                      time.Duration
+	MaxOperationTimeout                    time.Duration
+	EnableTracing                          bool
+	OTLPEndpoint                           string
+	EnableSMBVersionFallback               bool
+	NodeCredentialCacheTTL                 time.Duration
+	EnableDebugEndpoint                    bool
+	DebugEndpointAddress                   string
+	RedactSubscriptionID                   bool
+	ARMQPS                                 float64
+	ARMBurst                               int
+	DefaultShareQuotaGiB                   int
+	ApplyDefaultMountOptions               bool
+	PVCAnnotationAllowlist                 string
+	CheckShareCapacityOnMount              bool
+	FailOnFullShare                        bool
+	ShutdownTimeout                        time.Duration
+	AllowedSKUs                            string
+	AllowMismatchedStagingMount            bool
+	EnableRegionalAccountAffinity          bool
+	MountHealthCheckInterval               time.Duration
+	SingleAccountName                      string
+	WaitForShareDelete                     bool
+	WaitForShareDeleteTimeout              time.Duration
+	PreferFlagResourceGroup                bool
+	CSISocketPermissions                   uint64
+	SecretLabelSelector                    string
+	NodeMountRetries                       int
+	NodeMountRetryInterval                 time.Duration
+	ProvisionRetries                       int
+	ProvisionRetryInterval                 time.Duration
+	EnableNodeOSTopology                   bool
+	AccountSelectionStrategy               string
+	LazyUnmountOnBusy                      bool
+	RequiredTags                           []string
+	MinShareQuotaGiB                       int
+	EnableFailoverAwareness                bool
+	VolumeHandleVersion                    string
+	VolumeFullThresholdPercent             int
+	MaxConcurrentFormat                    int
+	CABundlePath                           string
+	ShareNameCollisionStrategy             string
+	FailOnLockedResourceGroup              bool
+	AccessModeConflictPolicy               string
+	ProfileConfigMap                       string
+	NetworkRulePropagationRetries          int
+	NetworkRulePropagationRetryInterval    time.Duration
+	CreateSecretNamespace                  bool
+	MaxShareQuotaGiB                       int
+	MaxGRPCMessageSize                     int
 }
 
 // Driver implements all interfaces of CSI drivers
@@ -218,8 +449,114 @@ type Driver struct {
 	kubeAPIQPS                             float64
 	kubeAPIBurst                           int
 	enableWindowsHostProcess               bool
+	minimumTLSVersion                      string
+	emitAuditEvents                        bool
+	snapshotBeforeDelete                   bool
+	maxVolumesPerNode                      int64
+	enableOrphanGC                         bool
+	orphanGCInterval                       time.Duration
+	orphanGCThreshold                      time.Duration
+	maxOperationTimeout                    time.Duration
+	enableTracing                          bool
+	otlpEndpoint                           string
+	enableSMBVersionFallback               bool
+	eventRecorder                          record.EventRecorder
 	fileClient                             *azureFileClient
 	mounter                                *mount.SafeFormatAndMount
+	// debug endpoint is disabled if address is empty
+	debugEndpointAddress string
+	redactSubscriptionID bool
+	// ARM call rate limiter per subscription is disabled if armQPS is 0
+	armQPS   float64
+	armBurst int
+	// quota of file share created without requested capacity
+	defaultShareQuotaGiB int
+	// apply protocol specific default mount options in NodeStageVolume
+	enableDefaultMountOptions bool
+	// lower case names of parameters which could be overridden by PVC annotations <parameter name, "">
+	pvcAnnotationAllowlist map[string]string
+	// check whether file share is full before mount in NodeStageVolume, fail the mount if failOnFullShare is set
+	checkShareCapacityOnMount bool
+	failOnFullShare           bool
+	// max time to wait for in-flight operations on SIGTERM, exit immediately if it's 0
+	shutdownTimeout time.Duration
+	// skuName values allowed in CreateVolume, all values are allowed if it's empty
+	allowedSKUs []string
+	// treat existing mount on staging target as success in NodeStageVolume even if its source is different
+	allowMismatchedStagingMount bool
+	// report node region in topology and prefer storage account in the region of requested topology in CreateVolume
+	enableRegionalAccountAffinity bool
+	// interval of probing mounts of staged volumes, mount health checker is disabled if it's 0
+	mountHealthCheckInterval time.Duration
+	// all file shares are created in this storage account if it's set, singleAccountEnsured, singleAccountSKU
+	// and singleAccountSupportsNFS are protected by singleAccountLock
+	singleAccountName        string
+	singleAccountLock        sync.Mutex
+	singleAccountEnsured     bool
+	singleAccountSKU         string
+	singleAccountSupportsNFS bool
+	// DeleteVolume returns only after file share is not found if waitForShareDelete is set
+	waitForShareDelete        bool
+	waitForShareDeleteTimeout time.Duration
+	// use resource group of driver config instead of the one in volume handle in management operations
+	preferFlagResourceGroup bool
+	// file mode of CSI unix socket, it's not changed if 0
+	csiSocketPermissions uint64
+	// max size(bytes) of received and sent gRPC messages, default limits of gRPC are used if it's 0
+	maxGRPCMessageSize int
+	// only secrets matching secretLabelSelector are read by driver, all secrets are read if it's nil
+	secretLabelSelector labels.Selector
+	// labels set on secrets created by driver so that they match secretLabelSelector
+	secretLabels map[string]string
+	// retries of transient mount errors in NodeStageVolume, mount is only tried once if nodeMountRetries is 0
+	nodeMountRetries       int
+	nodeMountRetryInterval time.Duration
+	// retries of retriable errors in file share operations of controller, backoff in cloud config is used if provisionRetries is 0
+	provisionRetries       int
+	provisionRetryInterval time.Duration
+	// report node OS in topology so that protocol(auto) is resolved by node OS in requested topology in CreateVolume
+	enableNodeOSTopology bool
+	// strategy of selecting matching storage account for new file share, e.g. first-match, round-robin
+	accountSelectionStrategy string
+	// lazily unmount target in NodeUnpublishVolume if it's still busy after retries
+	lazyUnmountOnBusy bool
+	// tag keys which must be set in tags parameter of storage class in CreateVolume
+	requiredTags []string
+	// requested quota of new file share smaller than minShareQuotaGiB is rounded up to it, disabled if it's 0
+	minShareQuotaGiB int
+	// requested quota larger than maxShareQuotaGiB is rejected in CreateVolume and ControllerExpandVolume, disabled if it's 0
+	maxShareQuotaGiB int
+	// check failover state of storage account and retry mount against new primary endpoint on persistent SMB mount failures
+	enableFailoverAwareness bool
+	// format of volume handles of new volumes, volume handles of both versions are always parsed
+	volumeHandleVersion string
+	// volume condition is abnormal in NodeGetVolumeStats if usage percentage reaches it, disabled if it's 0
+	volumeFullThresholdPercent int
+	// max number of vhd disks formatted concurrently in NodeStageVolume, unlimited if it's 0
+	maxConcurrentFormat int
+	// semaphore which bounds concurrent format operations, nil if it's unlimited
+	formatSemaphore chan struct{}
+	// PEM encoded CA bundle file whose certificates are trusted in addition to system root CAs
+	caBundlePath string
+	// http client of storage data plane and key vault requests
+	httpClient *http.Client
+	// how auto-generated share name longer than max length is shortened, truncate or hash
+	shareNameCollisionStrategy string
+	// fail CreateVolume instead of logging a warning if storage account would be created in resource group with management locks
+	failOnLockedResourceGroup bool
+	// a timed cache storing management locks of resource groups, <subsID/resourceGroup, []managementLock>
+	resourceGroupLockCache *azcache.TimedCache
+	// lists management locks of resource group, only set in unit tests
+	resourceGroupLockClient resourceGroupLockLister
+	// how contradictory access modes in volume capabilities of CreateVolume are handled, reject or allow
+	accessModeConflictPolicy string
+	// configmap(namespace/name) providing custom provisioning profiles
+	profileConfigMap string
+	// retries with exponential backoff when file share creation is denied by network rules which are not propagated yet
+	networkRulePropagationRetries       int
+	networkRulePropagationRetryInterval time.Duration
+	// create namespace of account key secret if it does not exist
+	createSecretNamespace bool
 	// lock per volume attach (only for vhd disk feature)
 	volLockMap *lockMap
 	// only for nfs feature
@@ -235,12 +572,37 @@ type Driver struct {
 	secretCacheMap *azcache.TimedCache
 	// a map storing all volumes using data plane API <volumeID, "">
 	dataPlaneAPIVolMap sync.Map
+	// a map storing all volumes staged on this node <volumeID, *stagedVolume>
+	stagedVolumes sync.Map
+	// a map storing staging paths whose mount health probe is still in flight <stagingPath, "">
+	mountProbes sync.Map
 	// a timed cache storing all storage accounts that are using data plane API temporarily
 	dataPlaneAPIAccountCache *azcache.TimedCache
 	// a timed cache storing account search history (solve account list throttling issue)
 	accountSearchCache *azcache.TimedCache
 	// a timed cache storing tag removing history (solve account update throttling issue)
 	removeTagCache *azcache.TimedCache
+	// a timed cache storing data of mount options and profile configmaps <namespace/name, configmap data>
+	mountOptionsConfigMapCache *azcache.TimedCache
+	// a timed cache storing credentials read from account key secrets on this node <namespace/name, *secretCredential>,
+	// it's only kept in memory and nil if disabled
+	nodeCredentialCache *azcache.TimedCache
+	// a timed cache storing account keys read from key vault <secret id, *secretCredential>
+	keyVaultSecretCache *azcache.TimedCache
+	// get account key from key vault, only set in unit tests
+	keyVaultClient keyVaultSecretGetter
+	// retry schedule of orphaned resource deletion <resource key, retry entry>
+	orphanGCRetryScheduler *orphanGCRetryScheduler
+	// restore soft deleted file shares, only set in unit tests
+	fileShareRestorer fileShareRestorer
+	// backoff of verifying file share existence and quota after creation or expansion
+	fileShareVerifyBackoff wait.Backoff
+	// backoff of waiting for file share deletion, it's also bounded by waitForShareDeleteTimeout
+	fileShareDeleteBackoff wait.Backoff
+	// backoff of retrying unmount of busy target before lazy unmount
+	busyUnmountBackoff wait.Backoff
+	// detach busy mount point lazily, only replaced in unit tests
+	lazyUnmounter func(target string) error
 }
 
 // NewDriver Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
@@ -264,6 +626,203 @@ func NewDriver(options *DriverOptions) *Driver {
 	driver.kubeAPIQPS = options.KubeAPIQPS
 	driver.kubeAPIBurst = options.KubeAPIBurst
 	driver.enableWindowsHostProcess = options.EnableWindowsHostProcess
+	driver.minimumTLSVersion = options.MinimumTLSVersion
+	driver.emitAuditEvents = options.EmitAuditEvents
+	driver.snapshotBeforeDelete = options.SnapshotBeforeDelete
+	driver.maxVolumesPerNode = options.MaxVolumesPerNode
+	driver.enableOrphanGC = options.EnableOrphanGC
+	driver.orphanGCInterval = options.OrphanGCInterval
+	driver.orphanGCThreshold = options.OrphanGCThreshold
+	driver.maxOperationTimeout = options.MaxOperationTimeout
+	driver.enableTracing = options.EnableTracing
+	driver.otlpEndpoint = options.OTLPEndpoint
+	driver.enableSMBVersionFallback = options.EnableSMBVersionFallback
+	driver.redactSubscriptionID = options.RedactSubscriptionID
+	driver.armQPS = options.ARMQPS
+	driver.armBurst = options.ARMBurst
+	if options.EnableDebugEndpoint {
+		driver.debugEndpointAddress = options.DebugEndpointAddress
+		if driver.debugEndpointAddress == "" {
+			driver.debugEndpointAddress = DefaultDebugEndpointAddress
+		}
+	}
+	if driver.minimumTLSVersion == "" {
+		driver.minimumTLSVersion = string(storage.MinimumTLSVersionTLS12)
+	}
+	if !isSupportedMinimumTLSVersion(driver.minimumTLSVersion) {
+		klog.Errorf("minimum TLS version(%s) is not supported, supported MinimumTLSVersion list: %v", driver.minimumTLSVersion, storage.PossibleMinimumTLSVersionValues())
+		return nil
+	}
+	if driver.orphanGCInterval <= 0 {
+		driver.orphanGCInterval = defaultOrphanGCInterval
+	}
+	if driver.orphanGCThreshold <= 0 {
+		driver.orphanGCThreshold = defaultOrphanGCThreshold
+	}
+	if driver.maxOperationTimeout <= 0 {
+		driver.maxOperationTimeout = defaultMaxOperationTimeout
+	}
+	driver.enableDefaultMountOptions = options.ApplyDefaultMountOptions
+	driver.pvcAnnotationAllowlist = parsePVCAnnotationAllowlist(options.PVCAnnotationAllowlist)
+	driver.checkShareCapacityOnMount = options.CheckShareCapacityOnMount
+	driver.failOnFullShare = options.FailOnFullShare
+	driver.shutdownTimeout = options.ShutdownTimeout
+	driver.allowMismatchedStagingMount = options.AllowMismatchedStagingMount
+	driver.enableRegionalAccountAffinity = options.EnableRegionalAccountAffinity
+	driver.mountHealthCheckInterval = options.MountHealthCheckInterval
+	if options.SingleAccountName != "" {
+		if err := validateStorageAccountName(options.SingleAccountName); err != nil {
+			klog.Errorf("invalid single account name: %v", err)
+			return nil
+		}
+		driver.singleAccountName = options.SingleAccountName
+	}
+	driver.waitForShareDelete = options.WaitForShareDelete
+	driver.waitForShareDeleteTimeout = options.WaitForShareDeleteTimeout
+	if driver.waitForShareDeleteTimeout <= 0 {
+		driver.waitForShareDeleteTimeout = defaultWaitForShareDeleteTimeout
+	}
+	driver.preferFlagResourceGroup = options.PreferFlagResourceGroup
+	if options.CSISocketPermissions > 0777 {
+		klog.Errorf("invalid CSI socket permissions(%#o), should be in range [0, 0777]", options.CSISocketPermissions)
+		return nil
+	}
+	driver.csiSocketPermissions = options.CSISocketPermissions
+	if options.MaxGRPCMessageSize < 0 {
+		klog.Errorf("invalid max gRPC message size(%d), should not be negative", options.MaxGRPCMessageSize)
+		return nil
+	}
+	driver.maxGRPCMessageSize = options.MaxGRPCMessageSize
+	if options.SecretLabelSelector != "" {
+		selector, err := labels.Parse(options.SecretLabelSelector)
+		if err != nil {
+			klog.Errorf("invalid secret label selector(%s): %v", options.SecretLabelSelector, err)
+			return nil
+		}
+		driver.secretLabelSelector = selector
+		if driver.secretLabels, err = labels.ConvertSelectorToLabelsMap(options.SecretLabelSelector); err != nil {
+			driver.secretLabels = nil
+			klog.Warningf("secret label selector(%s) is not equality based, secrets created by driver would not be labeled and could not be read by driver: %v", options.SecretLabelSelector, err)
+		}
+	}
+	if options.NodeMountRetries < 0 || options.ProvisionRetries < 0 {
+		klog.Errorf("invalid node mount retries(%d) or provision retries(%d), should not be negative", options.NodeMountRetries, options.ProvisionRetries)
+		return nil
+	}
+	driver.nodeMountRetries = options.NodeMountRetries
+	driver.nodeMountRetryInterval = options.NodeMountRetryInterval
+	driver.provisionRetries = options.ProvisionRetries
+	driver.provisionRetryInterval = options.ProvisionRetryInterval
+	driver.enableNodeOSTopology = options.EnableNodeOSTopology
+	driver.accountSelectionStrategy = options.AccountSelectionStrategy
+	driver.lazyUnmountOnBusy = options.LazyUnmountOnBusy
+	if driver.accountSelectionStrategy == "" {
+		driver.accountSelectionStrategy = accountSelectionFirstMatch
+	}
+	if _, err := newAccountSelector(driver.accountSelectionStrategy, nil); err != nil {
+		klog.Errorf("%v", err)
+		return nil
+	}
+	if options.MinShareQuotaGiB < 0 || options.MinShareQuotaGiB > maximumShareSize {
+		klog.Errorf("invalid minimum share quota(%d GiB), should be in range [0, %d]", options.MinShareQuotaGiB, maximumShareSize)
+		return nil
+	}
+	driver.minShareQuotaGiB = options.MinShareQuotaGiB
+	if options.MaxShareQuotaGiB < 0 || options.MaxShareQuotaGiB > maximumShareSize || (options.MaxShareQuotaGiB > 0 && options.MaxShareQuotaGiB < options.MinShareQuotaGiB) {
+		klog.Errorf("invalid maximum share quota(%d GiB), should be in range [0, %d] and not smaller than minimum share quota(%d GiB)", options.MaxShareQuotaGiB, maximumShareSize, options.MinShareQuotaGiB)
+		return nil
+	}
+	driver.maxShareQuotaGiB = options.MaxShareQuotaGiB
+	driver.enableFailoverAwareness = options.EnableFailoverAwareness
+	switch options.VolumeHandleVersion {
+	case "", volumeHandleVersionV1:
+		driver.volumeHandleVersion = volumeHandleVersionV1
+	case volumeHandleVersionV2:
+		driver.volumeHandleVersion = volumeHandleVersionV2
+	default:
+		klog.Errorf("volume handle version(%s) is not supported, supported versions: [%s %s]", options.VolumeHandleVersion, volumeHandleVersionV1, volumeHandleVersionV2)
+		return nil
+	}
+	if options.VolumeFullThresholdPercent < 0 || options.VolumeFullThresholdPercent > 100 {
+		klog.Errorf("invalid volume full threshold percent(%d), should be in range [0, 100]", options.VolumeFullThresholdPercent)
+		return nil
+	}
+	driver.volumeFullThresholdPercent = options.VolumeFullThresholdPercent
+	if options.MaxConcurrentFormat < 0 {
+		klog.Errorf("invalid max concurrent format(%d), should not be negative", options.MaxConcurrentFormat)
+		return nil
+	}
+	driver.maxConcurrentFormat = options.MaxConcurrentFormat
+	if driver.maxConcurrentFormat > 0 {
+		driver.formatSemaphore = make(chan struct{}, driver.maxConcurrentFormat)
+	}
+	driver.caBundlePath = options.CABundlePath
+	driver.httpClient = http.DefaultClient
+	if driver.caBundlePath != "" {
+		rootCAs, err := loadCABundle(driver.caBundlePath)
+		if err != nil {
+			klog.Errorf("invalid CA bundle: %v", err)
+			return nil
+		}
+		driver.httpClient = newHTTPClient(rootCAs)
+		registerCABundleTracer(rootCAs)
+	}
+	switch options.ShareNameCollisionStrategy {
+	case "", shareNameCollisionTruncate:
+		driver.shareNameCollisionStrategy = shareNameCollisionTruncate
+	case shareNameCollisionHash:
+		driver.shareNameCollisionStrategy = shareNameCollisionHash
+	default:
+		klog.Errorf("share name collision strategy(%s) is not supported, supported strategies: [%s %s]", options.ShareNameCollisionStrategy, shareNameCollisionTruncate, shareNameCollisionHash)
+		return nil
+	}
+	driver.failOnLockedResourceGroup = options.FailOnLockedResourceGroup
+	switch options.AccessModeConflictPolicy {
+	case "", accessModeConflictReject:
+		driver.accessModeConflictPolicy = accessModeConflictReject
+	case accessModeConflictAllow:
+		driver.accessModeConflictPolicy = accessModeConflictAllow
+	default:
+		klog.Errorf("access mode conflict policy(%s) is not supported, supported policies: [%s %s]", options.AccessModeConflictPolicy, accessModeConflictReject, accessModeConflictAllow)
+		return nil
+	}
+	if options.ProfileConfigMap != "" {
+		if _, _, err := parseConfigMapRef(options.ProfileConfigMap); err != nil {
+			klog.Errorf("invalid profile configmap: %v", err)
+			return nil
+		}
+		driver.profileConfigMap = options.ProfileConfigMap
+	}
+	if options.NetworkRulePropagationRetries < 0 {
+		klog.Errorf("invalid network rule propagation retries(%d), should not be negative", options.NetworkRulePropagationRetries)
+		return nil
+	}
+	driver.networkRulePropagationRetries = options.NetworkRulePropagationRetries
+	driver.networkRulePropagationRetryInterval = options.NetworkRulePropagationRetryInterval
+	driver.createSecretNamespace = options.CreateSecretNamespace
+	for _, tag := range options.RequiredTags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			driver.requiredTags = append(driver.requiredTags, tag)
+		}
+	}
+	for _, sku := range strings.Split(options.AllowedSKUs, ",") {
+		if sku = strings.TrimSpace(sku); sku != "" {
+			driver.allowedSKUs = append(driver.allowedSKUs, sku)
+		}
+	}
+	driver.defaultShareQuotaGiB = options.DefaultShareQuotaGiB
+	if driver.defaultShareQuotaGiB <= 0 {
+		driver.defaultShareQuotaGiB = defaultAzureFileQuota
+	}
+	if driver.defaultShareQuotaGiB > maximumShareSize {
+		klog.Warningf("default share quota(%d GiB) is larger than maximum share size, set as %d GiB", driver.defaultShareQuotaGiB, maximumShareSize)
+		driver.defaultShareQuotaGiB = maximumShareSize
+	}
+	driver.orphanGCRetryScheduler = newOrphanGCRetryScheduler(driver.orphanGCInterval, maxOrphanGCRetryDelay)
+	driver.fileShareVerifyBackoff = wait.Backoff{Duration: time.Second, Factor: 2.0, Steps: 5}
+	driver.fileShareDeleteBackoff = wait.Backoff{Duration: time.Second, Factor: 2.0, Steps: 8}
+	driver.busyUnmountBackoff = wait.Backoff{Duration: time.Second, Factor: 2.0, Steps: 3}
+	driver.lazyUnmounter = lazyUnmount
 	driver.volLockMap = newLockMap()
 	driver.subnetLockMap = newLockMap()
 	driver.volumeLocks = newVolumeLocks()
@@ -291,6 +850,24 @@ func NewDriver(options *DriverOptions) *Driver {
 		klog.Fatalf("%v", err)
 	}
 
+	if driver.mountOptionsConfigMapCache, err = azcache.NewTimedcache(time.Minute, driver.getConfigMapData); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	if driver.keyVaultSecretCache, err = azcache.NewTimedcache(keyVaultSecretCacheTTL, getter); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	if driver.resourceGroupLockCache, err = azcache.NewTimedcache(resourceGroupLockCacheTTL, getter); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	if options.NodeCredentialCacheTTL > 0 {
+		if driver.nodeCredentialCache, err = azcache.NewTimedcache(options.NodeCredentialCacheTTL, driver.getSecretCredential); err != nil {
+			klog.Fatalf("%v", err)
+		}
+	}
+
 	return &driver
 }
 
@@ -310,8 +887,49 @@ func (d *Driver) Run(endpoint, kubeconfig string, testBool bool) {
 	}
 	klog.V(2).Infof("cloud: %s, location: %s, rg: %s, VnetName: %s, VnetResourceGroup: %s, SubnetName: %s", d.cloud.Cloud, d.cloud.Location, d.cloud.ResourceGroup, d.cloud.VnetName, d.cloud.VnetResourceGroup, d.cloud.SubnetName)
 
+	if d.armQPS > 0 {
+		d.setARMRateLimiter(d.armQPS, d.armBurst)
+		klog.V(2).Infof("ARM calls are rate limited per subscription, qps: %v, burst: %d", d.armQPS, d.armBurst)
+	}
+	d.setAccountPropertiesClient()
+	d.setResourceLockClient()
+	if err := d.setAccountSelector(d.accountSelectionStrategy); err != nil {
+		klog.Fatalf("failed to set account selector, error: %v", err)
+	}
+
+	d.initAuditEventRecorder()
+
+	if d.enableTracing {
+		shutdown, err := initTracerProvider(context.Background(), d.Name, d.otlpEndpoint)
+		if err != nil {
+			klog.Fatalf("failed to initialize tracer provider with OTLP endpoint(%s), error: %v", d.otlpEndpoint, err)
+		}
+		klog.V(2).Infof("export traces to OTLP endpoint(%s)", d.otlpEndpoint)
+		defer func() {
+			if err := shutdown(context.Background()); err != nil {
+				klog.Warningf("failed to shutdown tracer provider, error: %v", err)
+			}
+		}()
+	}
+
+	if d.enableOrphanGC {
+		go d.runOrphanGC(wait.NeverStop)
+	}
+
+	if d.mountHealthCheckInterval > 0 {
+		go d.runMountHealthChecker(wait.NeverStop)
+	}
+
+	if d.debugEndpointAddress != "" {
+		if err := d.runDebugEndpoint(); err != nil {
+			klog.Fatalf("failed to run debug endpoint, error: %v", err)
+		}
+	}
+
 	// todo: set backoff from cloud provider config
 	d.fileClient = newAzureFileClient(&d.cloud.Environment, &retry.Backoff{Steps: 1})
+	d.fileClient.StorageEndpointSuffix = getStorageEndpointSuffix(d.cloud)
+	d.fileClient.httpClient = d.httpClient
 
 	d.mounter, err = mounter.NewSafeMounter(d.enableWindowsHostProcess)
 	if err != nil {
@@ -345,56 +963,159 @@ func (d *Driver) Run(endpoint, kubeconfig string, testBool bool) {
 	}
 	if d.enableGetVolumeStats {
 		nodeCap = append(nodeCap, csi.NodeServiceCapability_RPC_GET_VOLUME_STATS)
+		if d.volumeFullThresholdPercent > 0 {
+			nodeCap = append(nodeCap, csi.NodeServiceCapability_RPC_VOLUME_CONDITION)
+		}
 	}
 	d.AddNodeServiceCapabilities(nodeCap)
 
-	s := csicommon.NewNonBlockingGRPCServer()
+	s := csicommon.NewNonBlockingGRPCServerWithOptions(os.FileMode(d.csiSocketPermissions), d.maxGRPCMessageSize)
 	// Driver d act as IdentityServer, ControllerServer and NodeServer
 	s.Start(endpoint, d, d, d, testBool)
+	// gRPC server is created when Start returns, signal handler could stop it at any time from now on
+	if !testBool && d.shutdownTimeout > 0 {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+		go d.gracefulShutdownOnSignal(s, sigCh)
+	}
 	s.Wait()
 }
 
+// gracefulShutdownOnSignal stops accepting new calls on signal and waits at most shutdownTimeout for in-flight operations,
+// so that operations like CreateVolume and NodeStageVolume would not be aborted with partial state left
+func (d *Driver) gracefulShutdownOnSignal(s csicommon.NonBlockingGRPCServer, sigCh <-chan os.Signal) {
+	sig := <-sigCh
+	klog.Infof("received signal(%v), stop accepting new calls and wait at most %v for in-flight operations", sig, d.shutdownTimeout)
+	if s.GracefulStopWithTimeout(d.shutdownTimeout) {
+		klog.Infof("all in-flight operations completed, driver is stopped")
+	} else {
+		klog.Warningf("in-flight operations did not complete within %v, driver is stopped forcefully", d.shutdownTimeout)
+	}
+}
+
 // getFileShareQuota return (-1, nil) means file share does not exist
 func (d *Driver) getFileShareQuota(ctx context.Context, subsID, resourceGroupName, accountName, fileShareName string, secrets map[string]string) (int, error) {
+	quota, _, err := d.getFileShareQuotaAndProtocol(ctx, subsID, resourceGroupName, accountName, fileShareName, secrets)
+	return quota, err
+}
+
+// isShareSoftDeleteEnabled returns true if file share soft delete is enabled on storage account
+func (d *Driver) isShareSoftDeleteEnabled(ctx context.Context, subsID, resourceGroupName, accountName string) (bool, error) {
+	if d.cloud.FileClient == nil {
+		return false, fmt.Errorf("FileClient is nil")
+	}
+	properties, err := d.cloud.FileClient.WithSubscriptionID(subsID).GetServiceProperties(ctx, resourceGroupName, accountName)
+	if err != nil {
+		return false, err
+	}
+	if properties.FileServicePropertiesProperties == nil || properties.FileServicePropertiesProperties.ShareDeleteRetentionPolicy == nil {
+		return false, nil
+	}
+	return pointer.BoolDeref(properties.FileServicePropertiesProperties.ShareDeleteRetentionPolicy.Enabled, false), nil
+}
+
+// restoreSoftDeletedFileShare restores the latest soft deleted version of file share
+// return (-1, nil) means there is no soft deleted file share
+func (d *Driver) restoreSoftDeletedFileShare(ctx context.Context, subsID, resourceGroupName, accountName, fileShareName string) (int, error) {
+	if d.cloud.FileClient == nil {
+		return -1, fmt.Errorf("FileClient is nil")
+	}
+	items, err := d.cloud.FileClient.WithSubscriptionID(subsID).ListFileShare(ctx, resourceGroupName, accountName, "", "deleted")
+	if err != nil {
+		return -1, err
+	}
+
+	var deletedShare *storage.FileShareItem
+	for i := range items {
+		item := &items[i]
+		if item.Name == nil || !strings.EqualFold(*item.Name, fileShareName) || item.FileShareProperties == nil ||
+			!pointer.BoolDeref(item.FileShareProperties.Deleted, false) || item.FileShareProperties.Version == nil {
+			continue
+		}
+		if deletedShare == nil || (item.FileShareProperties.DeletedTime != nil && deletedShare.FileShareProperties.DeletedTime != nil &&
+			item.FileShareProperties.DeletedTime.After(deletedShare.FileShareProperties.DeletedTime.Time)) {
+			deletedShare = item
+		}
+	}
+	if deletedShare == nil {
+		return -1, nil
+	}
+
+	restorer, err := d.getFileShareRestorer(subsID)
+	if err != nil {
+		return -1, err
+	}
+	version := *deletedShare.FileShareProperties.Version
+	klog.V(2).Infof("restore soft deleted file share(%s) version(%s) on account(%s) subsID(%s) rg(%s)", fileShareName, version, accountName, subsID, resourceGroupName)
+	if _, err := restorer.Restore(ctx, resourceGroupName, accountName, fileShareName, storage.DeletedShare{
+		DeletedShareName:    pointer.String(fileShareName),
+		DeletedShareVersion: pointer.String(version),
+	}); err != nil {
+		return -1, err
+	}
+	return int(pointer.Int32Deref(deletedShare.FileShareProperties.ShareQuota, 0)), nil
+}
+
+// getFileShareRestorer returns the client to restore soft deleted file shares in subscription
+func (d *Driver) getFileShareRestorer(subsID string) (fileShareRestorer, error) {
+	if d.fileShareRestorer != nil {
+		return d.fileShareRestorer, nil
+	}
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	return newFileShareRestorer(d.cloud, subsID)
+}
+
+// getFileShareQuotaAndProtocol return (-1, "", nil) means file share does not exist
+func (d *Driver) getFileShareQuotaAndProtocol(ctx context.Context, subsID, resourceGroupName, accountName, fileShareName string, secrets map[string]string) (int, storage.EnabledProtocols, error) {
 	if len(secrets) > 0 {
 		accountName, accountKey, err := getStorageAccount(secrets)
 		if err != nil {
-			return -1, err
+			return -1, "", err
 		}
 		fileClient, err := d.fileClient.getFileSvcClient(accountName, accountKey)
 		if err != nil {
-			return -1, err
+			return -1, "", err
 		}
 		share := fileClient.GetShareReference(fileShareName)
 		exists, err := share.Exists()
 		if err != nil {
-			return -1, err
+			return -1, "", err
 		}
 		if !exists {
-			return -1, nil
+			return -1, "", nil
 		}
-		return share.Properties.Quota, nil
+		// data plane API only supports SMB file share
+		return share.Properties.Quota, storage.EnabledProtocolsSMB, nil
 	}
 
 	fileShare, err := d.cloud.GetFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName)
 	if err != nil {
 		if strings.Contains(err.Error(), "ShareNotFound") {
-			return -1, nil
+			return -1, "", nil
 		}
-		return -1, err
+		return -1, "", err
 	}
 
 	if fileShare.FileShareProperties == nil || fileShare.FileShareProperties.ShareQuota == nil {
-		return -1, fmt.Errorf("FileShareProperties or FileShareProperties.ShareQuota is nil")
+		return -1, "", fmt.Errorf("FileShareProperties or FileShareProperties.ShareQuota is nil")
+	}
+	protocol := fileShare.FileShareProperties.EnabledProtocols
+	if protocol == "" {
+		protocol = storage.EnabledProtocolsSMB
 	}
-	return int(*fileShare.FileShareProperties.ShareQuota), nil
+	return int(*fileShare.FileShareProperties.ShareQuota), protocol, nil
 }
 
 // get file share info according to volume id, e.g.
 // input: "rg#f5713de20cde511e8ba4900#fileShareName#diskname.vhd#uuid#namespace#subsID"
 // output: rg, f5713de20cde511e8ba4900, fileShareName, diskname.vhd, namespace, subsID
 func GetFileShareInfo(id string) (string, string, string, string, string, string, error) {
-	segments := strings.Split(id, separator)
+	segments, err := splitVolumeID(id)
+	if err != nil {
+		return "", "", "", "", "", "", err
+	}
 	if len(segments) < 3 {
 		return "", "", "", "", "", "", fmt.Errorf("error parsing volume id: %q, should at least contain two #", id)
 	}
@@ -420,6 +1141,68 @@ func GetFileShareInfo(id string) (string, string, string, string, string, string
 	return rg, segments[1], segments[2], diskName, namespace, subsID, nil
 }
 
+// isExistingShareVolume returns true if volume handle is created with useExistingShare, e.g.
+// rg#f5713de20cde511e8ba4900#fileShareName##existing-pvc-xxx#namespace
+func isExistingShareVolume(id string) bool {
+	segments, err := splitVolumeID(id)
+	if err != nil || len(segments) < 5 {
+		return false
+	}
+	return strings.HasPrefix(segments[4], existingShareVolumePrefix)
+}
+
+// formatVolumeID returns volume handle of segments(e.g. rg#accountName#fileShareName#diskName#uuid#secretNamespace#subsID),
+// segments are joined by # in v1 format, in v2 format every segment is escaped so that it could contain # or other special characters,
+// e.g. v2:rg#accountName#file%23share
+func formatVolumeID(version string, segments ...string) string {
+	if version != volumeHandleVersionV2 {
+		return strings.Join(segments, separator)
+	}
+	escaped := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		escaped = append(escaped, url.PathEscape(segment))
+	}
+	return volumeIDV2Prefix + strings.Join(escaped, separator)
+}
+
+// splitVolumeID returns segments of volume handle in v1 or v2 format, v2 handle is prefixed by "v2:" which could never be
+// the beginning of v1 handle since colon is not allowed in resource group name
+func splitVolumeID(id string) ([]string, error) {
+	if !strings.HasPrefix(id, volumeIDV2Prefix) {
+		return strings.Split(id, separator), nil
+	}
+	segments := strings.Split(strings.TrimPrefix(id, volumeIDV2Prefix), separator)
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing volume id: %q, invalid segment(%s): %v", id, segment, err)
+		}
+		segments[i] = unescaped
+	}
+	return segments, nil
+}
+
+// getResourceGroupOfVolume returns resource group of management operations on volume, resource group parsed from volume handle
+// is used by default, while resource group of driver config is preferred if preferFlagResourceGroup is set since old volume handles
+// could encode a stale resource group in migration scenarios, volume handle of other subscription is not affected.
+// substituted is true if resource group of driver config is used instead of the one in volume handle
+func (d *Driver) getResourceGroupOfVolume(volumeID, resourceGroup, subsID string) (rg string, substituted bool) {
+	if resourceGroup == "" {
+		return d.cloud.ResourceGroup, true
+	}
+	if !d.preferFlagResourceGroup || d.cloud.ResourceGroup == "" {
+		return resourceGroup, false
+	}
+	if subsID != "" && !strings.EqualFold(subsID, d.cloud.SubscriptionID) {
+		return resourceGroup, false
+	}
+	if strings.EqualFold(resourceGroup, d.cloud.ResourceGroup) {
+		return d.cloud.ResourceGroup, false
+	}
+	klog.Warningf("resource group(%s) in volume handle(%s) is different from resource group(%s) of driver, use %s", resourceGroup, volumeID, d.cloud.ResourceGroup, d.cloud.ResourceGroup)
+	return d.cloud.ResourceGroup, true
+}
+
 // check whether mountOptions contains file_mode, dir_mode, vers, if not, append default mode
 func appendDefaultMountOptions(mountOptions []string) []string {
 	var defaultMountOptions = map[string]string{
@@ -460,13 +1243,208 @@ func appendDefaultMountOptions(mountOptions []string) []string {
 	return allMountOptions
 }
 
+// defaultNFSMountOption is a default mount option of nfs protocol,
+// it's not applied if any of keys is already set in mount options
+type defaultNFSMountOption struct {
+	option string
+	keys   []string
+}
+
+// defaultNFSMountOptionList are recommended mount options of nfs protocol,
+// see https://learn.microsoft.com/en-us/azure/storage/files/storage-files-how-to-mount-nfs-shares
+var defaultNFSMountOptionList = []defaultNFSMountOption{
+	{option: "nconnect=4", keys: []string{"nconnect"}},
+	{option: "noresvport", keys: []string{"noresvport", "resvport"}},
+	{option: "actimeo=30", keys: []string{actimeo, "noac", "acregmin", "acregmax", "acdirmin", "acdirmax"}},
+}
+
+// appendDefaultNFSMountOptions appends default mount options of nfs protocol which are not set in mountOptions
+func appendDefaultNFSMountOptions(mountOptions []string) []string {
+	included := make(map[string]bool)
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			key, _, _ := strings.Cut(strings.TrimSpace(option), "=")
+			included[key] = true
+		}
+	}
+
+	allMountOptions := mountOptions
+	for _, defaultOption := range defaultNFSMountOptionList {
+		isIncluded := false
+		for _, key := range defaultOption.keys {
+			if included[key] {
+				isIncluded = true
+				break
+			}
+		}
+		if !isIncluded {
+			allMountOptions = append(allMountOptions, defaultOption.option)
+		}
+	}
+	return allMountOptions
+}
+
+// applyDefaultMountOptions appends default mount options of protocol which are not set in mountOptions,
+// mountOptions is returned as it is if default mount options are disabled
+func (d *Driver) applyDefaultMountOptions(mountOptions []string, protocol string) []string {
+	if !d.enableDefaultMountOptions {
+		return mountOptions
+	}
+	if protocol == nfs {
+		return appendDefaultNFSMountOptions(mountOptions)
+	}
+	return appendDefaultMountOptions(mountOptions)
+}
+
+// mergeMountOptions appends extraMountOptions to mountOptions, options already set in mountOptions take precedence
+func mergeMountOptions(mountOptions, extraMountOptions []string) []string {
+	included := make(map[string]bool)
+	for _, mountOption := range mountOptions {
+		included[strings.SplitN(mountOption, "=", 2)[0]] = true
+	}
+
+	allMountOptions := mountOptions
+	for _, mountOption := range extraMountOptions {
+		if key := strings.SplitN(mountOption, "=", 2)[0]; !included[key] {
+			allMountOptions = append(allMountOptions, mountOption)
+			included[key] = true
+		}
+	}
+	return allMountOptions
+}
+
+// parseConfigMapRef parses configmap reference in "namespace/name" format
+func parseConfigMapRef(configMapRef string) (string, string, error) {
+	parts := strings.Split(configMapRef, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid configmap reference(%s), the format should be namespace/name", configMapRef)
+	}
+	return parts[0], parts[1], nil
+}
+
+// getConfigMapData gets data of configmap(namespace/name), used as getter of mountOptionsConfigMapCache
+// which also caches data of profile configmap
+func (d *Driver) getConfigMapData(configMapRef string) (interface{}, error) {
+	namespace, name, err := parseConfigMapRef(configMapRef)
+	if err != nil {
+		return nil, err
+	}
+	if d.cloud == nil || d.cloud.KubeClient == nil {
+		return nil, fmt.Errorf("could not get configmap(%s): KubeClient is nil", configMapRef)
+	}
+	configMap, err := d.cloud.KubeClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("configmap(%s) not found", configMapRef)
+		}
+		return nil, fmt.Errorf("could not get configmap(%s): %v", configMapRef, err)
+	}
+	data := configMap.Data
+	if data == nil {
+		data = map[string]string{}
+	}
+	return data, nil
+}
+
+// parsePVCAnnotationAllowlist parses comma separated parameter names, e.g. "skuName,enableLargeFileShares"
+func parsePVCAnnotationAllowlist(allowlist string) map[string]string {
+	m := map[string]string{}
+	for _, name := range strings.Split(allowlist, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			m[strings.ToLower(name)] = ""
+		}
+	}
+	return m
+}
+
+// applyPVCAnnotationOverrides returns parameters overridden by annotations(with pvcAnnotationPrefix) of the PVC,
+// only parameters in pvcAnnotationAllowlist would be overridden, other annotations are ignored.
+// PVC name and namespace are passed in parameters by external-provisioner with --extra-create-metadata
+func (d *Driver) applyPVCAnnotationOverrides(ctx context.Context, parameters map[string]string) (map[string]string, error) {
+	if len(d.pvcAnnotationAllowlist) == 0 {
+		return parameters, nil
+	}
+	pvcName, pvcNamespace := parameters[pvcNameKey], parameters[pvcNamespaceKey]
+	if pvcName == "" || pvcNamespace == "" {
+		klog.V(2).Infof("skip PVC annotation overrides since PVC name or namespace is not provided in parameters")
+		return parameters, nil
+	}
+	if d.cloud == nil || d.cloud.KubeClient == nil {
+		klog.Warningf("skip PVC annotation overrides of PVC(%s/%s) since KubeClient is nil", pvcNamespace, pvcName)
+		return parameters, nil
+	}
+	pvc, err := d.cloud.KubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get PVC(%s/%s): %v", pvcNamespace, pvcName, err)
+	}
+
+	result := make(map[string]string, len(parameters))
+	for k, v := range parameters {
+		result[k] = v
+	}
+	for k, v := range pvc.Annotations {
+		if !strings.HasPrefix(k, pvcAnnotationPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, pvcAnnotationPrefix)
+		if _, ok := d.pvcAnnotationAllowlist[strings.ToLower(name)]; !ok {
+			klog.V(2).Infof("ignore annotation(%s) of PVC(%s/%s) since %s is not in allowlist", k, pvcNamespace, pvcName, name)
+			continue
+		}
+		// parameter names are case insensitive, remove the original parameter (or its deprecated alias) set in storage class
+		canonicalName, _ := getCanonicalParameterName(name)
+		for param := range result {
+			if canonicalParam, _ := getCanonicalParameterName(param); canonicalParam == canonicalName {
+				delete(result, param)
+			}
+		}
+		klog.V(2).Infof("override parameter %s with annotation(%s: %s) of PVC(%s/%s)", name, k, v, pvcNamespace, pvcName)
+		result[name] = v
+	}
+	return result, nil
+}
+
+// getMountOptionsFromConfigMap returns mount options of the protocol(smb or nfs) from configmap(namespace/name),
+// e.g. "smb: nobrl,cache=none" in configmap data
+func (d *Driver) getMountOptionsFromConfigMap(configMapRef, protocol string) ([]string, error) {
+	if protocol == "" {
+		protocol = smb
+	}
+	cache, err := d.mountOptionsConfigMapCache.Get(configMapRef, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cache.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("invalid data of configmap(%s)", configMapRef)
+	}
+
+	var mountOptions []string
+	for _, mountOption := range strings.Split(data[protocol], ",") {
+		mountOption = strings.TrimSpace(mountOption)
+		if mountOption == "" {
+			continue
+		}
+		if strings.ContainsAny(mountOption, " \t\n") {
+			return nil, fmt.Errorf("invalid mount option(%s) in configmap(%s)", mountOption, configMapRef)
+		}
+		key := strings.ToLower(strings.SplitN(mountOption, "=", 2)[0])
+		if protocol == smb && (key == "username" || key == "password" || key == "credentials") {
+			return nil, fmt.Errorf("mount option(%s) is not allowed in configmap(%s)", key, configMapRef)
+		}
+		mountOptions = append(mountOptions, mountOption)
+	}
+	return mountOptions, nil
+}
+
 // get storage account from secrets map
 func getStorageAccount(secrets map[string]string) (string, string, error) {
 	if secrets == nil {
 		return "", "", fmt.Errorf("unexpected: getStorageAccount secrets is nil")
 	}
 
-	var accountName, accountKey string
+	var accountName, accountKey, connectionString string
 	for k, v := range secrets {
 		v = strings.TrimSpace(v)
 		switch strings.ToLower(k) {
@@ -478,7 +1456,22 @@ func getStorageAccount(secrets map[string]string) (string, string, error) {
 			accountKey = v
 		case defaultSecretAccountKey: // for compatibility with built-in azurefile plugin
 			accountKey = v
+		case "connectionstring":
+			connectionString = v
+		case defaultSecretConnectionString:
+			connectionString = v
+		}
+	}
+
+	if accountKey == "" && connectionString != "" {
+		name, key, err := parseConnectionString(connectionString)
+		if err != nil {
+			return "", "", err
 		}
+		if accountName == "" {
+			accountName = name
+		}
+		accountKey = key
 	}
 
 	if accountName == "" {
@@ -499,10 +1492,14 @@ func getStorageAccount(secrets map[string]string) (string, string, error) {
 // The name cannot contain two consecutive hyphens.
 //
 // See https://docs.microsoft.com/en-us/rest/api/storageservices/naming-and-referencing-shares--directories--files--and-metadata#share-names
-func getValidFileShareName(volumeName string) string {
+func getValidFileShareName(volumeName, collisionStrategy string) string {
 	fileShareName := strings.ToLower(volumeName)
 	if len(fileShareName) > fileShareNameMaxLength {
-		fileShareName = fileShareName[0:fileShareNameMaxLength]
+		if collisionStrategy == shareNameCollisionHash {
+			fileShareName = truncateWithHashSuffix(fileShareName, fileShareNameMaxLength)
+		} else {
+			fileShareName = fileShareName[0:fileShareNameMaxLength]
+		}
 	}
 	if !checkShareNameBeginAndEnd(fileShareName) || len(fileShareName) < fileShareNameMinLength {
 		fileShareName = util.GenerateVolumeName("pvc-file", uuid.NewUUID().String(), fileShareNameMaxLength)
@@ -513,6 +1510,18 @@ func getValidFileShareName(volumeName string) string {
 	return strings.ToLower(fileShareName)
 }
 
+// truncateWithHashSuffix truncates name to maxLength and appends hash of the full name as suffix,
+// so that long names with the same prefix are not truncated to the same name
+func truncateWithHashSuffix(name string, maxLength int) string {
+	if len(name) <= maxLength {
+		return name
+	}
+	hash := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(hash[:])[:shareNameHashSuffixLength]
+	prefix := strings.TrimRight(name[:maxLength-len(suffix)-1], "-")
+	return prefix + "-" + suffix
+}
+
 func checkShareNameBeginAndEnd(fileShareName string) bool {
 	length := len(fileShareName)
 	if (('a' <= fileShareName[0] && fileShareName[0] <= 'z') ||
@@ -529,14 +1538,17 @@ func checkShareNameBeginAndEnd(fileShareName string) bool {
 // input: "rg#f5713de20cde511e8ba4900#csivolumename#diskname#2019-08-22T07:17:53.0000000Z"
 // output: 2019-08-22T07:17:53.0000000Z (last element)
 func getSnapshot(id string) (string, error) {
-	segments := strings.Split(id, separator)
+	segments, err := splitVolumeID(id)
+	if err != nil {
+		return "", err
+	}
 	if len(segments) < 5 {
 		return "", fmt.Errorf("error parsing volume id: %q, should at least contain four #", id)
 	}
 	return segments[len(segments)-1], nil
 }
 
-func getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName string) (*azfile.FileURL, error) {
+func getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName string, httpClient *http.Client) (*azfile.FileURL, error) {
 	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
 	if err != nil {
 		return nil, fmt.Errorf("NewSharedKeyCredential(%s) failed with error: %v", accountName, err)
@@ -558,11 +1570,11 @@ func getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, d
 			MaxRetryDelay: time.Second * 3,               // Max delay between retries
 		},
 	}
-	fileURL := azfile.NewFileURL(*u, azfile.NewPipeline(credential, po))
+	fileURL := azfile.NewFileURL(*u, newFilePipeline(credential, po, httpClient))
 	return &fileURL, nil
 }
 
-func createDisk(ctx context.Context, accountName, accountKey, storageEndpointSuffix, fileShareName, diskName string, diskSizeBytes int64) error {
+func createDisk(ctx context.Context, accountName, accountKey, storageEndpointSuffix, fileShareName, diskName string, diskSizeBytes int64, httpClient *http.Client) error {
 	vhdHeader := vhd.CreateFixedHeader(uint64(diskSizeBytes), &vhd.VHDOptions{})
 	buf := new(bytes.Buffer)
 	if err := binary.Write(buf, binary.BigEndian, vhdHeader); nil != err {
@@ -572,7 +1584,7 @@ func createDisk(ctx context.Context, accountName, accountKey, storageEndpointSuf
 	start := diskSizeBytes - int64(len(headerBytes))
 	end := diskSizeBytes - 1
 
-	fileURL, err := getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName)
+	fileURL, err := getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName, httpClient)
 	if err != nil {
 		return err
 	}
@@ -603,9 +1615,11 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 		err = nil
 	}
 
-	var protocol, accountKey, secretName, pvcNamespace string
+	var protocol, accountKey, secretName, pvcNamespace, keyVaultURL, keyVaultSecretName, useKey string
+	var secretNamespaces []string
 	// indicates whether get account key only from k8s secret
 	getAccountKeyFromSecret := false
+	var disableAccountKeyAuth bool
 
 	for k, v := range reqContext {
 		switch strings.ToLower(k) {
@@ -629,8 +1643,27 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 			secretName = v
 		case secretNamespaceField:
 			secretNamespace = v
+		case secretNamespacesField:
+			secretNamespaces = parseSecretNamespaces(v)
+		case keyVaultURLField:
+			keyVaultURL = v
+		case keyVaultSecretNameField:
+			keyVaultSecretName = v
+		case useKeyField:
+			useKey = strings.ToLower(v)
+			// the specified key is only stored in k8s secret by controller, first key of storage account should not be used instead
+			getAccountKeyFromSecret = true
 		case pvcNamespaceKey:
 			pvcNamespace = v
+		case disableAccountKeyAuthField:
+			if strings.EqualFold(v, trueValue) {
+				disableAccountKeyAuth = true
+			}
+		case dataPlaneAuthField:
+			// aad does not use account key at all
+			if strings.EqualFold(v, dataPlaneAuthAAD) {
+				disableAccountKeyAuth = true
+			}
 		}
 	}
 
@@ -644,6 +1677,11 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 		// nfs protocol does not need account key, return directly
 		return rgName, accountName, accountKey, fileShareName, diskName, subsID, err
 	}
+	if disableAccountKeyAuth {
+		// account key should never be fetched or used, mount uses identity based auth
+		klog.V(2).Infof("account key auth is disabled on volume(%s), skip getting account key", volumeID)
+		return rgName, accountName, accountKey, fileShareName, diskName, subsID, err
+	}
 
 	if secretNamespace == "" {
 		if pvcNamespace == "" {
@@ -652,27 +1690,39 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 			secretNamespace = pvcNamespace
 		}
 	}
+	if len(secretNamespaces) == 0 {
+		secretNamespaces = []string{secretNamespace}
+	}
 
 	if len(secrets) == 0 {
 		// read account key from cache first
-		cache, errCache := d.accountCacheMap.Get(accountName, azcache.CacheReadTypeDefault)
+		cache, errCache := d.accountCacheMap.Get(getAccountKeyCacheKey(accountName, useKey), azcache.CacheReadTypeDefault)
 		if errCache != nil {
 			return rgName, accountName, accountKey, fileShareName, diskName, subsID, errCache
 		}
 		if cache != nil {
 			accountKey = cache.(string)
+		} else if keyVaultURL != "" || keyVaultSecretName != "" {
+			// account key is stored in key vault instead of k8s secret
+			if accountKey, err = d.getAccountKeyFromKeyVault(ctx, accountName, keyVaultURL, keyVaultSecretName); err != nil {
+				klog.Errorf("getAccountKeyFromKeyVault(%s, %s, %s) failed with error: %v", accountName, keyVaultURL, keyVaultSecretName, err)
+			}
 		} else {
 			if secretName == "" && accountName != "" {
-				secretName = fmt.Sprintf(secretNameTemplate, accountName)
+				if useKey != "" {
+					secretName = fmt.Sprintf(keySecretNameTemplate, accountName, useKey)
+				} else {
+					secretName = fmt.Sprintf(secretNameTemplate, accountName)
+				}
 			}
 			if secretName != "" {
 				var name string
-				name, accountKey, err = d.GetStorageAccountFromSecret(ctx, secretName, secretNamespace)
+				name, accountKey, err = d.getStorageAccountFromSecretInNamespaces(ctx, secretName, secretNamespaces)
 				if name != "" {
 					accountName = name
 				}
 				if err != nil {
-					klog.Warningf("GetStorageAccountFromSecret(%s, %s) failed with error: %v", secretName, secretNamespace, err)
+					klog.Warningf("GetStorageAccountFromSecret(%s, %v) failed with error: %v", secretName, secretNamespaces, err)
 					if !getAccountKeyFromSecret && d.cloud.StorageAccountClient != nil && accountName != "" {
 						klog.V(2).Infof("use cluster identity to get account key from (%s, %s, %s)", subsID, rgName, accountName)
 						accountKey, err = d.cloud.GetStorageAccesskey(ctx, subsID, accountName, rgName)
@@ -695,7 +1745,7 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 	}
 
 	if err == nil && accountKey != "" {
-		d.accountCacheMap.Set(accountName, accountKey)
+		d.accountCacheMap.Set(getAccountKeyCacheKey(accountName, useKey), accountKey)
 	}
 	return rgName, accountName, accountKey, fileShareName, diskName, subsID, err
 }
@@ -736,6 +1786,18 @@ func isSupportedAccountAccessTier(accessTier string) bool {
 	return false
 }
 
+func isSupportedMinimumTLSVersion(minimumTLSVersion string) bool {
+	if minimumTLSVersion == "" {
+		return true
+	}
+	for _, version := range storage.PossibleMinimumTLSVersionValues() {
+		if minimumTLSVersion == string(version) {
+			return true
+		}
+	}
+	return false
+}
+
 func isSupportedRootSquashType(rootSquashType string) bool {
 	if rootSquashType == "" {
 		return true
@@ -760,9 +1822,22 @@ func isSupportedFSGroupChangePolicy(policy string) bool {
 	return false
 }
 
+// getProvisionBackoff returns backoff of file share operations in controller, backoff in cloud config is used if provisionRetries is not set
+func (d *Driver) getProvisionBackoff() wait.Backoff {
+	if d.provisionRetries > 0 {
+		return wait.Backoff{Duration: d.provisionRetryInterval, Factor: 1.0, Steps: d.provisionRetries + 1}
+	}
+	return d.cloud.RequestBackoff()
+}
+
+// getNodeMountBackoff returns backoff of mount in NodeStageVolume, mount is tried once if nodeMountRetries is not set
+func (d *Driver) getNodeMountBackoff() wait.Backoff {
+	return wait.Backoff{Duration: d.nodeMountRetryInterval, Factor: 1.0, Steps: d.nodeMountRetries + 1}
+}
+
 // CreateFileShare creates a file share
 func (d *Driver) CreateFileShare(ctx context.Context, accountOptions *azure.AccountOptions, shareOptions *fileclient.ShareOptions, secrets map[string]string) error {
-	return wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+	return wait.ExponentialBackoff(d.getProvisionBackoff(), func() (bool, error) {
 		var err error
 		if len(secrets) > 0 {
 			accountName, accountKey, rerr := getStorageAccount(secrets)
@@ -782,9 +1857,89 @@ func (d *Driver) CreateFileShare(ctx context.Context, accountOptions *azure.Acco
 	})
 }
 
+// createFileShareWithNetworkRuleRetry creates file share, creation denied by network rules is retried with exponential backoff
+// since network rules set on storage account take a while to propagate, other errors are returned directly
+func (d *Driver) createFileShareWithNetworkRuleRetry(ctx context.Context, accountOptions *azure.AccountOptions, shareOptions *fileclient.ShareOptions, secrets map[string]string) error {
+	if d.networkRulePropagationRetries == 0 {
+		return d.CreateFileShare(ctx, accountOptions, shareOptions, secrets)
+	}
+	backoff := wait.Backoff{Duration: d.networkRulePropagationRetryInterval, Factor: 2.0, Steps: d.networkRulePropagationRetries + 1}
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, backoff, func() (bool, error) {
+		err := d.CreateFileShare(ctx, accountOptions, shareOptions, secrets)
+		if isNetworkRulePropagationError(err) {
+			klog.Warningf("CreateFileShare(%s) on account(%s) is denied by network rules which may not be propagated yet, error(%v), waiting for retrying", shareOptions.Name, accountOptions.Name, err)
+			lastErr = err
+			return false, nil
+		}
+		return true, err
+	})
+	if err == wait.ErrWaitTimeout && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
+
+// verifyFileShareExists gets file share after creation or expansion and confirms its quota is not smaller than requestGiB,
+// it retries when file share is not found or quota is not updated since they may be visible after a while
+func (d *Driver) verifyFileShareExists(ctx context.Context, subsID, resourceGroup, accountName, shareName string, requestGiB int, secrets map[string]string) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(d.fileShareVerifyBackoff, func() (bool, error) {
+		quota, err := d.getFileShareQuota(ctx, subsID, resourceGroup, accountName, shareName, secrets)
+		if err != nil {
+			if isRetriableError(err) {
+				lastErr = err
+				return false, nil
+			}
+			return true, err
+		}
+		if quota == -1 {
+			lastErr = fmt.Errorf("file share(%s) on account(%s) is not found", shareName, accountName)
+		} else if quota < requestGiB {
+			lastErr = fmt.Errorf("quota(%d) of file share(%s) on account(%s) is smaller than %d", quota, shareName, accountName, requestGiB)
+		} else {
+			return true, nil
+		}
+		klog.Warningf("verify file share(%s) on account(%s) failed with error(%v), waiting for retrying", shareName, accountName, lastErr)
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
+
+// waitForFileShareDeleted polls file share after deletion until it's not found, since deleting a large file share
+// could take a while and the share name could not be reused before that
+func (d *Driver) waitForFileShareDeleted(ctx context.Context, subsID, resourceGroup, accountName, shareName string, secrets map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.waitForShareDeleteTimeout)
+	defer cancel()
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, d.fileShareDeleteBackoff, func() (bool, error) {
+		quota, err := d.getFileShareQuota(ctx, subsID, resourceGroup, accountName, shareName, secrets)
+		if err != nil {
+			if isRetriableError(err) {
+				lastErr = err
+				return false, nil
+			}
+			return true, err
+		}
+		if quota == -1 {
+			return true, nil
+		}
+		lastErr = fmt.Errorf("file share(%s) on account(%s) is still being deleted", shareName, accountName)
+		klog.V(2).Infof("%v, waiting for retrying", lastErr)
+		return false, nil
+	})
+	if (err == wait.ErrWaitTimeout || err == context.DeadlineExceeded) && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
+
 // DeleteFileShare deletes a file share using storage account name and key
 func (d *Driver) DeleteFileShare(ctx context.Context, subsID, resourceGroup, accountName, shareName string, secrets map[string]string) error {
-	return wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+	return wait.ExponentialBackoff(d.getProvisionBackoff(), func() (bool, error) {
 		var err error
 		if len(secrets) > 0 {
 			accountName, accountKey, rerr := getStorageAccount(secrets)
@@ -821,7 +1976,7 @@ func (d *Driver) DeleteFileShare(ctx context.Context, subsID, resourceGroup, acc
 
 // ResizeFileShare resizes a file share
 func (d *Driver) ResizeFileShare(ctx context.Context, subsID, resourceGroup, accountName, shareName string, sizeGiB int, secrets map[string]string) error {
-	return wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+	return wait.ExponentialBackoff(d.getProvisionBackoff(), func() (bool, error) {
 		var err error
 		if len(secrets) > 0 {
 			accountName, accountKey, rerr := getStorageAccount(secrets)
@@ -866,6 +2021,11 @@ func (d *Driver) RemoveStorageAccountTag(ctx context.Context, subsID, resourceGr
 //  2. use k8s client identity to read from k8s secret
 //  3. use cluster identity to get from storage account directly
 func (d *Driver) GetStorageAccesskey(ctx context.Context, accountOptions *azure.AccountOptions, secrets map[string]string, secretName, secretNamespace string) (string, error) {
+	return d.getStorageAccesskey(ctx, accountOptions, secrets, secretName, []string{secretNamespace})
+}
+
+// getStorageAccesskey is the same as GetStorageAccesskey except that k8s secret is read from secretNamespaces in order
+func (d *Driver) getStorageAccesskey(ctx context.Context, accountOptions *azure.AccountOptions, secrets map[string]string, secretName string, secretNamespaces []string) (string, error) {
 	if len(secrets) > 0 {
 		_, accountKey, err := getStorageAccount(secrets)
 		return accountKey, err
@@ -885,7 +2045,7 @@ func (d *Driver) GetStorageAccesskey(ctx context.Context, accountOptions *azure.
 	if secretName == "" {
 		secretName = fmt.Sprintf(secretNameTemplate, accountName)
 	}
-	_, accountKey, err := d.GetStorageAccountFromSecret(ctx, secretName, secretNamespace)
+	_, accountKey, err := d.getStorageAccountFromSecretInNamespaces(ctx, secretName, secretNamespaces)
 	if err != nil {
 		klog.V(2).Infof("could not get account(%s) key from secret(%s), error: %v, use cluster identity to get account key instead", accountOptions.Name, secretName, err)
 		accountKey, err = d.cloud.GetStorageAccesskey(ctx, accountOptions.SubscriptionID, accountName, accountOptions.ResourceGroup)
@@ -897,6 +2057,33 @@ func (d *Driver) GetStorageAccesskey(ctx context.Context, accountOptions *azure.
 	return accountKey, err
 }
 
+// getStorageAccountKeyByName gets the storage account key with keyName(key1 or key2) with cluster identity
+func (d *Driver) getStorageAccountKeyByName(ctx context.Context, subsID, resourceGroup, accountName, keyName string) (string, error) {
+	if d.cloud.StorageAccountClient == nil {
+		return "", fmt.Errorf("StorageAccountClient is nil")
+	}
+	result, rerr := d.cloud.StorageAccountClient.ListKeys(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return "", rerr.Error()
+	}
+	if result.Keys != nil {
+		for _, k := range *result.Keys {
+			if k.KeyName != nil && strings.EqualFold(*k.KeyName, keyName) && k.Value != nil && *k.Value != "" {
+				return *k.Value, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("key(%s) of storage account(%s) is not found", keyName, accountName)
+}
+
+// getAccountKeyCacheKey returns key of account key in accountCacheMap, the key specified by useKey is cached separately
+func getAccountKeyCacheKey(accountName, useKey string) string {
+	if useKey == "" {
+		return accountName
+	}
+	return accountName + "#" + useKey
+}
+
 // GetStorageAccountFromSecret get storage account key from k8s secret
 // return <accountName, accountKey, error>
 func (d *Driver) GetStorageAccountFromSecret(ctx context.Context, secretName, secretNamespace string) (string, string, error) {
@@ -908,12 +2095,134 @@ func (d *Driver) GetStorageAccountFromSecret(ctx context.Context, secretName, se
 	if err != nil {
 		return "", "", fmt.Errorf("could not get secret(%v): %v", secretName, err)
 	}
+	if d.secretLabelSelector != nil && !d.secretLabelSelector.Matches(labels.Set(secret.Labels)) {
+		return "", "", fmt.Errorf("secret(%s/%s) does not match secret label selector(%s)", secretNamespace, secretName, d.secretLabelSelector.String())
+	}
 
 	accountName := strings.TrimSpace(string(secret.Data[defaultSecretAccountName][:]))
 	accountKey := strings.TrimSpace(string(secret.Data[defaultSecretAccountKey][:]))
+	if connectionString := strings.TrimSpace(string(secret.Data[defaultSecretConnectionString][:])); accountKey == "" && connectionString != "" {
+		name, key, err := parseConnectionString(connectionString)
+		if err != nil {
+			return "", "", fmt.Errorf("could not parse connection string in secret(%v): %v", secretName, err)
+		}
+		if accountName == "" {
+			accountName = name
+		}
+		accountKey = key
+	}
 	return accountName, accountKey, nil
 }
 
+// secretCredential is the storage account credential read from k8s secret or key vault
+type secretCredential struct {
+	accountName string
+	accountKey  string
+}
+
+// getSecretCredential gets credential from k8s secret(namespace/name), used as getter of nodeCredentialCache
+func (d *Driver) getSecretCredential(secretRef string) (interface{}, error) {
+	namespace, name, found := strings.Cut(secretRef, "/")
+	if !found || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid secret reference(%s), the format should be namespace/name", secretRef)
+	}
+	accountName, accountKey, err := d.GetStorageAccountFromSecret(context.TODO(), name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &secretCredential{accountName: accountName, accountKey: accountKey}, nil
+}
+
+// getStorageAccountFromSecretWithCache get storage account key from k8s secret, secret is read from
+// nodeCredentialCache first if it's enabled
+// return <accountName, accountKey, error>
+func (d *Driver) getStorageAccountFromSecretWithCache(ctx context.Context, secretName, secretNamespace string) (string, string, error) {
+	if d.nodeCredentialCache == nil {
+		return d.GetStorageAccountFromSecret(ctx, secretName, secretNamespace)
+	}
+	cache, err := d.nodeCredentialCache.Get(secretNamespace+"/"+secretName, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return "", "", err
+	}
+	credential, ok := cache.(*secretCredential)
+	if !ok {
+		return "", "", fmt.Errorf("invalid credential of secret(%s/%s)", secretNamespace, secretName)
+	}
+	return credential.accountName, credential.accountKey, nil
+}
+
+// getStorageAccountFromSecretInNamespaces get storage account key from k8s secret in secretNamespaces in order,
+// the first secret which could be read is used, error of every namespace is returned if secret could not be read in any namespace
+// return <accountName, accountKey, error>
+func (d *Driver) getStorageAccountFromSecretInNamespaces(ctx context.Context, secretName string, secretNamespaces []string) (string, string, error) {
+	if len(secretNamespaces) == 1 {
+		return d.getStorageAccountFromSecretWithCache(ctx, secretName, secretNamespaces[0])
+	}
+	errs := make([]string, 0, len(secretNamespaces))
+	for _, secretNamespace := range secretNamespaces {
+		accountName, accountKey, err := d.getStorageAccountFromSecretWithCache(ctx, secretName, secretNamespace)
+		if err == nil {
+			klog.V(2).Infof("read secret(%s) in namespace(%s) of %s%v", secretName, secretNamespace, secretNamespacesField, secretNamespaces)
+			return accountName, accountKey, nil
+		}
+		klog.V(4).Infof("could not read secret(%s) in namespace(%s), try next namespace: %v", secretName, secretNamespace, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", secretNamespace, err))
+	}
+	return "", "", fmt.Errorf("could not get secret(%s) in any of namespaces%v: %s", secretName, secretNamespaces, strings.Join(errs, "; "))
+}
+
+// invalidateAccountCredential removes cached credentials of the storage account,
+// it's called when mount fails with authentication error so that the credential would be read again
+func (d *Driver) invalidateAccountCredential(accountName string) {
+	for _, cacheKey := range []string{accountName, getAccountKeyCacheKey(accountName, accountKey1), getAccountKeyCacheKey(accountName, accountKey2)} {
+		if err := d.accountCacheMap.Delete(cacheKey); err != nil {
+			klog.Warningf("delete account(%s) from accountCacheMap failed with error: %v", cacheKey, err)
+		}
+	}
+	invalidateCachedCredentials(d.nodeCredentialCache, "nodeCredentialCache", accountName)
+	invalidateCachedCredentials(d.keyVaultSecretCache, "keyVaultSecretCache", accountName)
+}
+
+// invalidateCachedCredentials deletes credentials of account from cache storing *secretCredential
+func invalidateCachedCredentials(cache *azcache.TimedCache, cacheName, accountName string) {
+	if cache == nil {
+		return
+	}
+	for _, obj := range cache.Store.List() {
+		entry, ok := obj.(*azcache.AzureCacheEntry)
+		if !ok {
+			continue
+		}
+		entry.Lock.Lock()
+		credential, ok := entry.Data.(*secretCredential)
+		entry.Lock.Unlock()
+		if ok && credential.accountName == accountName {
+			klog.V(2).Infof("invalidate cached credential of secret(%s) for account(%s)", entry.Key, accountName)
+			if err := cache.Delete(entry.Key); err != nil {
+				klog.Warningf("delete secret(%s) from %s failed with error: %v", entry.Key, cacheName, err)
+			}
+		}
+	}
+}
+
+// isStorageAccountDeleted returns true if storage account does not exist or is being deleted
+func (d *Driver) isStorageAccountDeleted(ctx context.Context, subsID, resourceGroup, accountName string) (bool, error) {
+	if d.cloud.StorageAccountClient == nil {
+		return false, fmt.Errorf("StorageAccountClient is nil")
+	}
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		if rerr.IsNotFound() {
+			return true, nil
+		}
+		return false, rerr.Error()
+	}
+	if account.AccountProperties == nil {
+		return false, nil
+	}
+	return strings.EqualFold(string(account.AccountProperties.ProvisioningState), accountProvisioningStateDeleting), nil
+}
+
 // getSubnetResourceID get default subnet resource ID from cloud provider config
 func (d *Driver) getSubnetResourceID(vnetResourceGroup, vnetName, subnetName string) string {
 	subsID := d.cloud.SubscriptionID
@@ -938,6 +2247,46 @@ func (d *Driver) getSubnetResourceID(vnetResourceGroup, vnetName, subnetName str
 	return fmt.Sprintf(subnetTemplate, subsID, vnetResourceGroup, vnetName, subnetName)
 }
 
+// buildNetworkRuleSet returns a default deny network rule set which only allows access from vnetResourceIDs and allowedIPs
+func buildNetworkRuleSet(vnetResourceIDs, allowedIPs []string) *storage.NetworkRuleSet {
+	virtualNetworkRules := []storage.VirtualNetworkRule{}
+	for i := range vnetResourceIDs {
+		virtualNetworkRules = append(virtualNetworkRules, storage.VirtualNetworkRule{
+			VirtualNetworkResourceID: &vnetResourceIDs[i],
+			Action:                   storage.ActionAllow,
+		})
+	}
+	ipRules := []storage.IPRule{}
+	for i := range allowedIPs {
+		ipRules = append(ipRules, storage.IPRule{
+			IPAddressOrRange: &allowedIPs[i],
+			Action:           storage.ActionAllow,
+		})
+	}
+	return &storage.NetworkRuleSet{
+		VirtualNetworkRules: &virtualNetworkRules,
+		IPRules:             &ipRules,
+		DefaultAction:       storage.DefaultActionDeny,
+	}
+}
+
+// stagedVolume is a volume staged on this node, sensitive values in mount options are redacted
+type stagedVolume struct {
+	VolumeID     string   `json:"volumeID"`
+	StagingPath  string   `json:"stagingPath"`
+	MountOptions []string `json:"mountOptions"`
+}
+
+// getStagedVolumeCount returns the number of volumes staged on this node
+func (d *Driver) getStagedVolumeCount() int64 {
+	var count int64
+	d.stagedVolumes.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
 func (d *Driver) useDataPlaneAPI(volumeID, accountName string) bool {
 	_, useDataPlaneAPI := d.dataPlaneAPIVolMap.Load(volumeID)
 	if useDataPlaneAPI {
@@ -955,33 +2304,100 @@ func (d *Driver) useDataPlaneAPI(volumeID, accountName string) bool {
 }
 
 func (d *Driver) SetAzureCredentials(ctx context.Context, accountName, accountKey, secretName, secretNamespace string) (string, error) {
+	return d.setAzureCredentials(ctx, accountName, accountKey, "", secretName, secretNamespace)
+}
+
+// setAzureCredentials stores account key in k8s secret, connection string is stored instead of account key if it's not empty
+func (d *Driver) setAzureCredentials(ctx context.Context, accountName, accountKey, connectionString, secretName, secretNamespace string) (string, error) {
 	if d.cloud.KubeClient == nil {
 		klog.Warningf("could not create secret: kubeClient is nil")
 		return "", nil
 	}
-	if accountName == "" || accountKey == "" {
+	if accountName == "" || (accountKey == "" && connectionString == "") {
 		return "", fmt.Errorf("the account info is not enough, accountName(%v), accountKey(%v)", accountName, accountKey)
 	}
 	if secretName == "" {
 		secretName = fmt.Sprintf(secretNameTemplate, accountName)
 	}
+	data := map[string][]byte{
+		defaultSecretAccountName: []byte(accountName),
+	}
+	if connectionString != "" {
+		data[defaultSecretConnectionString] = []byte(connectionString)
+	} else {
+		data[defaultSecretAccountKey] = []byte(accountKey)
+	}
 	secret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: secretNamespace,
 			Name:      secretName,
+			Labels:    d.secretLabels,
 		},
-		Data: map[string][]byte{
-			defaultSecretAccountName: []byte(accountName),
-			defaultSecretAccountKey:  []byte(accountKey),
-		},
+		Data: data,
 		Type: "Opaque",
 	}
-	_, err := d.cloud.KubeClient.CoreV1().Secrets(secretNamespace).Create(ctx, secret, metav1.CreateOptions{})
+	err := d.createSecret(ctx, secret)
 	if errors.IsAlreadyExists(err) {
-		err = nil
+		// secret created before secretLabelSelector is set could not be read by driver without labels
+		err = d.ensureSecretLabels(ctx, accountName, secretName, secretNamespace)
 	}
 	if err != nil {
 		return "", fmt.Errorf("couldn't create secret %v", err)
 	}
 	return secretName, err
 }
+
+// ensureSecretLabels patches secretLabels onto existing secret created by driver, secret of other storage account is not changed
+func (d *Driver) ensureSecretLabels(ctx context.Context, accountName, secretName, secretNamespace string) error {
+	if len(d.secretLabels) == 0 {
+		return nil
+	}
+	secret, err := d.cloud.KubeClient.CoreV1().Secrets(secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if string(secret.Data[defaultSecretAccountName]) != accountName {
+		klog.Warningf("secret(%s/%s) is not created for account(%s), skip setting labels(%v)", secretNamespace, secretName, accountName, d.secretLabels)
+		return nil
+	}
+	if labels.SelectorFromSet(d.secretLabels).Matches(labels.Set(secret.Labels)) {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"labels": d.secretLabels}})
+	if err != nil {
+		return err
+	}
+	if _, err := d.cloud.KubeClient.CoreV1().Secrets(secretNamespace).Patch(ctx, secretName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	klog.V(2).Infof("labels(%v) are set on existing secret(%s/%s)", d.secretLabels, secretNamespace, secretName)
+	return nil
+}
+
+// createSecret creates k8s secret, namespace of the secret is created if it's not found and createSecretNamespace is set
+func (d *Driver) createSecret(ctx context.Context, secret *v1.Secret) error {
+	_, err := d.cloud.KubeClient.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if errors.IsNotFound(err) && d.createSecretNamespace {
+		if nsErr := d.ensureSecretNamespace(ctx, secret.Namespace); nsErr != nil {
+			return nsErr
+		}
+		_, err = d.cloud.KubeClient.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+	}
+	return err
+}
+
+// ensureSecretNamespace creates namespace of secret, namespace created concurrently is regarded as success
+func (d *Driver) ensureSecretNamespace(ctx context.Context, namespace string) error {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+	_, err := d.cloud.KubeClient.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+	switch {
+	case err == nil:
+		klog.V(2).Infof("secret namespace(%s) is created", namespace)
+		return nil
+	case errors.IsAlreadyExists(err):
+		return nil
+	case errors.IsForbidden(err):
+		return fmt.Errorf("could not create secret namespace(%s), create permission of namespaces should be granted to controller: %v", namespace, err)
+	}
+	return fmt.Errorf("could not create secret namespace(%s): %v", namespace, err)
+}
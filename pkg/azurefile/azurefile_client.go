@@ -48,6 +48,8 @@ type azureFileClient struct {
 	backoff *retry.Backoff
 	// if storageEndpointSuffix is empty, will default to cloud.env.StorageEndpointSuffix
 	StorageEndpointSuffix string
+	// http client of file service requests, default http client is used if it's nil
+	httpClient *http.Client
 }
 
 func newAzureFileClient(env *azure.Environment, backoff *retry.Backoff) *azureFileClient {
@@ -61,10 +63,16 @@ func (f *azureFileClient) CreateFileShare(accountName, accountKey string, shareO
 	if shareOptions == nil {
 		return fmt.Errorf("shareOptions of account(%s) is nil", accountName)
 	}
-	return f.createFileShare(accountName, accountKey, shareOptions.Name, shareOptions.RequestGiB)
+	metadata := map[string]string{}
+	for k, v := range shareOptions.Metadata {
+		if v != nil {
+			metadata[k] = *v
+		}
+	}
+	return f.createFileShare(accountName, accountKey, shareOptions.Name, shareOptions.RequestGiB, metadata)
 }
 
-func (f *azureFileClient) createFileShare(accountName, accountKey, name string, sizeGiB int) error {
+func (f *azureFileClient) createFileShare(accountName, accountKey, name string, sizeGiB int, metadata map[string]string) error {
 	fileClient, err := f.getFileSvcClient(accountName, accountKey)
 	if err != nil {
 		return err
@@ -77,6 +85,14 @@ func (f *azureFileClient) createFileShare(accountName, accountKey, name string,
 	}
 	if !newlyCreated {
 		klog.V(2).Infof("file share(%s) under account(%s) already exists", name, accountName)
+		return nil
+	}
+	if len(metadata) > 0 {
+		// CreateIfNotExists does not set metadata, set it separately
+		share.Metadata = metadata
+		if err := share.SetMetadata(nil); err != nil {
+			return fmt.Errorf("failed to set metadata on file share %s, err: %v", name, err)
+		}
 	}
 	return nil
 }
@@ -123,6 +139,9 @@ func (f *azureFileClient) getFileSvcClient(accountName, accountKey string) (*azs
 		return nil, fmt.Errorf("error creating azure client: %v", err)
 	}
 
+	if f.httpClient != nil {
+		fileClient.HTTPClient = f.httpClient
+	}
 	if f.backoff != nil {
 		fileClient.Sender = &azs.DefaultSender{
 			RetryAttempts:    f.backoff.Steps,
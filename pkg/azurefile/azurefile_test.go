@@ -17,27 +17,43 @@ limitations under the License.
 package azurefile
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest"
 	azure2 "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
 
+	csicommon "sigs.k8s.io/azurefile-csi-driver/pkg/csi-common"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 	auth "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
 )
 
 const (
@@ -91,6 +107,29 @@ func TestNewFakeDriver(t *testing.T) {
 	assert.NotNil(t, d)
 }
 
+func TestNewDriverWithCSISocketPermissions(t *testing.T) {
+	d := NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, CSISocketPermissions: 0660})
+	assert.NotNil(t, d)
+	assert.Equal(t, uint64(0660), d.csiSocketPermissions)
+
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, CSISocketPermissions: 01777}))
+}
+
+func TestNewDriverWithMaxGRPCMessageSize(t *testing.T) {
+	d := NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MaxGRPCMessageSize: 16 * 1024 * 1024})
+	assert.NotNil(t, d)
+	assert.Equal(t, 16*1024*1024, d.maxGRPCMessageSize)
+	assert.Equal(t, 0, NewFakeDriver().maxGRPCMessageSize)
+
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MaxGRPCMessageSize: -1}))
+}
+
+func TestNewDriverWithVolumeHandleVersion(t *testing.T) {
+	assert.Equal(t, volumeHandleVersionV1, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName}).volumeHandleVersion)
+	assert.Equal(t, volumeHandleVersionV2, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, VolumeHandleVersion: "v2"}).volumeHandleVersion)
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, VolumeHandleVersion: "v3"}))
+}
+
 func TestAppendDefaultMountOptions(t *testing.T) {
 	tests := []struct {
 		options  []string
@@ -284,6 +323,33 @@ func TestGetFileShareInfo(t *testing.T) {
 			subsID:            "",
 			expectedError:     nil,
 		},
+		{
+			id:                "v2:rg#f5713de20cde511e8ba4900#file%23share#diskname.vhd#uuid#namespace#subsID",
+			resourceGroupName: "rg",
+			accountName:       "f5713de20cde511e8ba4900",
+			fileShareName:     "file#share",
+			diskName:          "diskname.vhd",
+			namespace:         "namespace",
+			subsID:            "subsID",
+			expectedError:     nil,
+		},
+		{
+			id:                "v2:#f5713de20cde511e8ba4900#fileShareName#diskname.vhd#namespace",
+			resourceGroupName: "",
+			accountName:       "f5713de20cde511e8ba4900",
+			fileShareName:     "fileShareName",
+			diskName:          "diskname.vhd",
+			namespace:         "namespace",
+			expectedError:     nil,
+		},
+		{
+			id:            "v2:rg#f5713de20cde511e8ba4900",
+			expectedError: fmt.Errorf("error parsing volume id: \"v2:rg#f5713de20cde511e8ba4900\", should at least contain two #"),
+		},
+		{
+			id:            "v2:rg#f5713de20cde511e8ba4900#share%zz",
+			expectedError: fmt.Errorf("error parsing volume id: \"v2:rg#f5713de20cde511e8ba4900#share%%zz\", invalid segment(share%%zz): invalid URL escape \"%%zz\""),
+		},
 	}
 
 	for _, test := range tests {
@@ -312,6 +378,41 @@ func TestGetFileShareInfo(t *testing.T) {
 	}
 }
 
+func TestIsExistingShareVolume(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected bool
+	}{
+		{
+			id:       "rg#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+			expected: false,
+		},
+		{
+			id:       "rg#f5713de20cde511e8ba4900#fileshare##pvc-xxx#namespace",
+			expected: false,
+		},
+		{
+			id:       "rg#f5713de20cde511e8ba4900#fileshare##existing-pvc-xxx#namespace",
+			expected: true,
+		},
+		{
+			id:       "v2:rg#f5713de20cde511e8ba4900#file%23share##existing-pvc-xxx#namespace#subsID",
+			expected: true,
+		},
+		{
+			id:       "rg#f5713de20cde511e8ba4900",
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isExistingShareVolume(test.id)
+		if result != test.expected {
+			t.Errorf("isExistingShareVolume(%s) returned with %v, expected %v", test.id, result, test.expected)
+		}
+	}
+}
+
 func TestGetStorageAccount(t *testing.T) {
 	emptyAccountKeyMap := map[string]string{
 		"accountname": "testaccount",
@@ -396,6 +497,23 @@ func TestGetStorageAccount(t *testing.T) {
 			expected2: "",
 			expected3: fmt.Errorf("unexpected: getStorageAccount secrets is nil"),
 		},
+		{
+			options: map[string]string{
+				defaultSecretConnectionString: "DefaultEndpointsProtocol=https;AccountName=testaccount;AccountKey=testkey==;EndpointSuffix=core.windows.net",
+			},
+			expected1: "testaccount",
+			expected2: "testkey==",
+			expected3: nil,
+		},
+		{
+			options: map[string]string{
+				defaultSecretAccountName:      "testaccount",
+				defaultSecretConnectionString: "AccountKey=testkey",
+			},
+			expected1: "",
+			expected2: "",
+			expected3: fmt.Errorf("could not find AccountName or AccountKey in connection string"),
+		},
 	}
 
 	for _, test := range tests {
@@ -443,7 +561,7 @@ func TestGetValidFileShareName(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := getValidFileShareName(test.volumeName)
+		result := getValidFileShareName(test.volumeName, shareNameCollisionTruncate)
 		if test.volumeName == "aq" {
 			assert.Contains(t, result, test.expected)
 		} else if !reflect.DeepEqual(result, test.expected) {
@@ -452,6 +570,60 @@ func TestGetValidFileShareName(t *testing.T) {
 	}
 }
 
+func TestGetValidFileShareNameWithHashStrategy(t *testing.T) {
+	longName := "pvc-" + strings.Repeat("a", 60)
+	tests := []struct {
+		desc       string
+		volumeName string
+		expected   string
+	}{
+		{
+			desc:       "short name is not changed",
+			volumeName: "pvc-3e2a4f1b",
+			expected:   "pvc-3e2a4f1b",
+		},
+		{
+			desc:       "name with max length is not changed",
+			volumeName: longName[:fileShareNameMaxLength],
+			expected:   longName[:fileShareNameMaxLength],
+		},
+		{
+			desc:       "long name is truncated with hash suffix",
+			volumeName: longName + "x",
+			expected:   longName[:54] + "-" + "3c1eb5af",
+		},
+		{
+			desc:       "dash before hash suffix is not duplicated",
+			volumeName: strings.Repeat("a", 53) + "--" + strings.Repeat("b", 20),
+			expected:   strings.Repeat("a", 53) + "-" + "51118f2b",
+		},
+	}
+
+	for _, test := range tests {
+		result := getValidFileShareName(test.volumeName, shareNameCollisionHash)
+		if result != test.expected {
+			t.Errorf("test[%s]: getValidFileShareName result: %q, expected: %q", test.desc, result, test.expected)
+		}
+	}
+
+	// long names with the same prefix are truncated to the same share name only with truncate strategy
+	name1, name2 := longName+"-volume1", longName+"-volume2"
+	if getValidFileShareName(name1, shareNameCollisionTruncate) != getValidFileShareName(name2, shareNameCollisionTruncate) {
+		t.Errorf("expected collision of %q and %q with truncate strategy", name1, name2)
+	}
+	hashName1, hashName2 := getValidFileShareName(name1, shareNameCollisionHash), getValidFileShareName(name2, shareNameCollisionHash)
+	if hashName1 == hashName2 || len(hashName1) != fileShareNameMaxLength || len(hashName2) != fileShareNameMaxLength {
+		t.Errorf("unexpected share names with hash strategy: %q, %q", hashName1, hashName2)
+	}
+
+	if d := NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName}); d.shareNameCollisionStrategy != shareNameCollisionTruncate {
+		t.Errorf("unexpected default share name collision strategy: %q", d.shareNameCollisionStrategy)
+	}
+	if d := NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, ShareNameCollisionStrategy: "random"}); d != nil {
+		t.Errorf("expected nil driver with invalid share name collision strategy")
+	}
+}
+
 func TestCheckShareNameBeginAndEnd(t *testing.T) {
 	tests := []struct {
 		fileShareName string
@@ -491,6 +663,51 @@ func TestCheckShareNameBeginAndEnd(t *testing.T) {
 	}
 }
 
+func TestFormatVolumeID(t *testing.T) {
+	tests := []struct {
+		version    string
+		segments   []string
+		expectedID string
+	}{
+		{
+			version:    volumeHandleVersionV1,
+			segments:   []string{"rg", "account", "share", "", "", "default"},
+			expectedID: "rg#account#share###default",
+		},
+		{
+			version:    volumeHandleVersionV2,
+			segments:   []string{"rg", "account", "share", "", "", "default"},
+			expectedID: "v2:rg#account#share###default",
+		},
+		{
+			version:    volumeHandleVersionV2,
+			segments:   []string{"rg#1", "account", "share#1", "disk name.vhd", "pvc-uuid", "default", "subsID"},
+			expectedID: "v2:rg%231#account#share%231#disk%20name.vhd#pvc-uuid#default#subsID",
+		},
+		{
+			version:    volumeHandleVersionV2,
+			segments:   []string{"rg(1)", "account", "share%1", "dir/disk.vhd", "", "default"},
+			expectedID: "v2:rg%281%29#account#share%251#dir%2Fdisk.vhd##default",
+		},
+		{
+			version:    volumeHandleVersionV2,
+			segments:   []string{"", "account", "share", "disk.vhd", "default"},
+			expectedID: "v2:#account#share#disk.vhd#default",
+		},
+	}
+
+	for _, test := range tests {
+		id := formatVolumeID(test.version, test.segments...)
+		if id != test.expectedID {
+			t.Errorf("formatVolumeID(%s, %v) returned with: %q, expected: %q", test.version, test.segments, id, test.expectedID)
+		}
+		segments, err := splitVolumeID(id)
+		if err != nil || !reflect.DeepEqual(segments, test.segments) {
+			t.Errorf("splitVolumeID(%q) returned with: %q, %v, expected: %q", id, segments, err, test.segments)
+		}
+	}
+}
+
 func TestGetSnapshot(t *testing.T) {
 	tests := []struct {
 		options   string
@@ -532,6 +749,11 @@ func TestGetSnapshot(t *testing.T) {
 			expected1: "",
 			expected2: fmt.Errorf("error parsing volume id: \"\", should at least contain four #"),
 		},
+		{
+			options:   "v2:rg#f123#csi%23volumename#diskname#uuid#default#2021-08-22T07:17:53.0000000Z",
+			expected1: "2021-08-22T07:17:53.0000000Z",
+			expected2: nil,
+		},
 	}
 
 	for _, test := range tests {
@@ -578,6 +800,83 @@ func TestIsCorruptedDir(t *testing.T) {
 	}
 }
 
+func TestAppendDefaultNFSMountOptions(t *testing.T) {
+	tests := []struct {
+		options  []string
+		expected []string
+	}{
+		{
+			options:  []string{},
+			expected: []string{"nconnect=4", "noresvport", "actimeo=30"},
+		},
+		{
+			options:  []string{"nconnect=8", "resvport"},
+			expected: []string{"nconnect=8", "resvport", "actimeo=30"},
+		},
+		{
+			options:  []string{"hard,noac"},
+			expected: []string{"hard,noac", "nconnect=4", "noresvport"},
+		},
+		{
+			options:  []string{"nconnect=2", "noresvport", "actimeo=60"},
+			expected: []string{"nconnect=2", "noresvport", "actimeo=60"},
+		},
+	}
+
+	for _, test := range tests {
+		result := appendDefaultNFSMountOptions(test.options)
+		assert.Equal(t, test.expected, result, "input: %q", test.options)
+	}
+}
+
+func TestApplyDefaultMountOptions(t *testing.T) {
+	tests := []struct {
+		desc                      string
+		enableDefaultMountOptions bool
+		protocol                  string
+		options                   []string
+		expected                  []string
+	}{
+		{
+			desc:                      "default mount options are disabled",
+			enableDefaultMountOptions: false,
+			protocol:                  smb,
+			options:                   []string{"vers=3.1.1"},
+			expected:                  []string{"vers=3.1.1"},
+		},
+		{
+			desc:                      "default mount options of nfs are disabled",
+			enableDefaultMountOptions: false,
+			protocol:                  nfs,
+			options:                   []string{"nconnect=8"},
+			expected:                  []string{"nconnect=8"},
+		},
+		{
+			desc:                      "default mount options of smb",
+			enableDefaultMountOptions: true,
+			protocol:                  smb,
+			options:                   []string{"vers=3.1.1", "file_mode=0755"},
+			expected:                  []string{"vers=3.1.1", "file_mode=0755", "dir_mode=0777", "actimeo=30", mfsymlinks},
+		},
+		{
+			desc:                      "default mount options of nfs",
+			enableDefaultMountOptions: true,
+			protocol:                  nfs,
+			options:                   []string{"nconnect=8"},
+			expected:                  []string{"nconnect=8", "noresvport", "actimeo=30"},
+		},
+	}
+
+	d := NewFakeDriver()
+	for _, test := range tests {
+		d.enableDefaultMountOptions = test.enableDefaultMountOptions
+		result := d.applyDefaultMountOptions(test.options, test.protocol)
+		sort.Strings(result)
+		sort.Strings(test.expected)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
 func TestNewDriver(t *testing.T) {
 	tests := []struct {
 		nodeID string
@@ -598,6 +897,22 @@ func TestNewDriver(t *testing.T) {
 		result := NewDriver(&driverOptions)
 		assert.NotNil(t, result)
 		assert.Equal(t, result.NodeID, test.nodeID)
+		assert.Equal(t, result.minimumTLSVersion, string(storage.MinimumTLSVersionTLS12))
+	}
+
+	driverOptions := DriverOptions{
+		NodeID:            fakeNodeID,
+		DriverName:        DefaultDriverName,
+		MinimumTLSVersion: string(storage.MinimumTLSVersionTLS11),
+	}
+	result := NewDriver(&driverOptions)
+	assert.Equal(t, result.minimumTLSVersion, string(storage.MinimumTLSVersionTLS11))
+	assert.Equal(t, defaultAzureFileQuota, result.defaultShareQuotaGiB)
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MinimumTLSVersion: "TLS1_3"}))
+
+	for quota, expected := range map[int]int{-1: defaultAzureFileQuota, 50: 50, maximumShareSize + 1: maximumShareSize} {
+		result = NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, DefaultShareQuotaGiB: quota})
+		assert.Equal(t, expected, result.defaultShareQuotaGiB, "DefaultShareQuotaGiB: %d", quota)
 	}
 }
 
@@ -652,7 +967,7 @@ func TestGetFileURL(t *testing.T) {
 		},
 	}
 	for _, test := range tests {
-		_, err := getFileURL(test.accountName, test.accountKey, test.storageEndpointSuffix, test.fileShareName, test.diskName)
+		_, err := getFileURL(test.accountName, test.accountKey, test.storageEndpointSuffix, test.fileShareName, test.diskName, nil)
 		if !reflect.DeepEqual(err, test.expectedError) {
 			t.Errorf("accountName: %v accountKey: %v storageEndpointSuffix: %v fileShareName: %v diskName: %v Error: %v",
 				test.accountName, test.accountKey, test.storageEndpointSuffix, test.fileShareName, test.diskName, err)
@@ -777,6 +1092,226 @@ func TestGetAccountInfo(t *testing.T) {
 	}
 }
 
+func TestGetAccountInfoWithAccountKeyAuthDisabled(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// account key should never be fetched by cluster identity
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.cloud.KubeClient = fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf(secretNameTemplate, "accountname"), Namespace: defaultNamespace},
+		Data: map[string][]byte{
+			defaultSecretAccountName: []byte("accountname"),
+			defaultSecretAccountKey:  []byte("testkey"),
+		},
+	})
+
+	reqContext := map[string]string{
+		disableAccountKeyAuthField: "true",
+	}
+	rgName, accountName, accountKey, fileShareName, _, _, err := d.GetAccountInfo(context.Background(), "rg#accountname#sharename", nil, reqContext)
+	assert.NoError(t, err)
+	assert.Equal(t, "rg", rgName)
+	assert.Equal(t, "accountname", accountName)
+	assert.Equal(t, "sharename", fileShareName)
+	assert.Empty(t, accountKey)
+
+	// account key is not used with aad auth either
+	_, _, accountKey, _, _, _, err = d.GetAccountInfo(context.Background(), "rg#accountname#sharename", nil, map[string]string{dataPlaneAuthField: "aad"})
+	assert.NoError(t, err)
+	assert.Empty(t, accountKey)
+
+	// account key is read from secret if account key auth is not disabled
+	reqContext[disableAccountKeyAuthField] = "false"
+	_, _, accountKey, _, _, _, err = d.GetAccountInfo(context.Background(), "rg#accountname#sharename", nil, reqContext)
+	assert.NoError(t, err)
+	assert.Equal(t, "testkey", accountKey)
+}
+
+func TestGetAccountInfoWithUseKey(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	// key specified by useKey is stored in a separate k8s secret by controller
+	d.cloud.KubeClient = fake.NewSimpleClientset(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf(secretNameTemplate, "accountname"), Namespace: defaultNamespace},
+			Data: map[string][]byte{
+				defaultSecretAccountName: []byte("accountname"),
+				defaultSecretAccountKey:  []byte("secretkey"),
+			},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf(keySecretNameTemplate, "accountname", "key2"), Namespace: defaultNamespace},
+			Data: map[string][]byte{
+				defaultSecretAccountName: []byte("accountname"),
+				defaultSecretAccountKey:  []byte("value2"),
+			},
+		},
+	)
+	// account keys are never listed with cluster identity on node
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	tests := []struct {
+		useKey      string
+		secretName  string
+		expectedKey string
+		expectErr   bool
+	}{
+		{
+			expectedKey: "secretkey",
+		},
+		{
+			useKey:      "key2",
+			secretName:  fmt.Sprintf(keySecretNameTemplate, "accountname", "key2"),
+			expectedKey: "value2",
+		},
+		{
+			// secret name is derived from useKey if it's not set
+			useKey:      "KEY2",
+			expectedKey: "value2",
+		},
+		{
+			// secret of key1 does not exist
+			useKey:    "key1",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		d.invalidateAccountCredential("accountname")
+		reqContext := map[string]string{}
+		if test.useKey != "" {
+			reqContext[useKeyField] = test.useKey
+		}
+		if test.secretName != "" {
+			reqContext[secretNameField] = test.secretName
+		}
+		_, _, accountKey, _, _, _, err := d.GetAccountInfo(context.Background(), "rg#accountname#sharename", nil, reqContext)
+		if test.expectErr {
+			assert.Error(t, err, test.useKey)
+			assert.Empty(t, accountKey, test.useKey)
+			continue
+		}
+		assert.NoError(t, err, test.useKey)
+		assert.Equal(t, test.expectedKey, accountKey, test.useKey)
+	}
+
+	// cached keys are invalidated together
+	for _, useKey := range []string{"", "key2"} {
+		reqContext := map[string]string{}
+		if useKey != "" {
+			reqContext[useKeyField] = useKey
+		}
+		_, _, _, _, _, _, err := d.GetAccountInfo(context.Background(), "rg#accountname#sharename", nil, reqContext)
+		assert.NoError(t, err, useKey)
+	}
+	d.invalidateAccountCredential("accountname")
+	for _, cacheKey := range []string{"accountname", "accountname#key1", "accountname#key2"} {
+		cache, err := d.accountCacheMap.Get(cacheKey, azcache.CacheReadTypeDefault)
+		assert.NoError(t, err)
+		assert.Nil(t, cache, cacheKey)
+	}
+}
+
+func TestGetStorageAccountKeyByName(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	_, err := d.getStorageAccountKeyByName(context.Background(), "subsID", "rg", "accountname", "key1")
+	assert.Equal(t, fmt.Errorf("StorageAccountClient is nil"), err)
+
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	keys := storage.AccountListKeysResult{
+		Keys: &[]storage.AccountKey{
+			{KeyName: pointer.String("key1"), Value: pointer.String("value1")},
+			{KeyName: pointer.String("key2"), Value: pointer.String("")},
+		},
+	}
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), "subsID", "rg", "accountname").Return(keys, nil).Times(2)
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), "subsID", "rg", "notfound").Return(storage.AccountListKeysResult{}, &retry.Error{RawError: fmt.Errorf("not found")}).Times(1)
+
+	key, err := d.getStorageAccountKeyByName(context.Background(), "subsID", "rg", "accountname", "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", key)
+	_, err = d.getStorageAccountKeyByName(context.Background(), "subsID", "rg", "accountname", "key2")
+	assert.Equal(t, fmt.Errorf("key(key2) of storage account(accountname) is not found"), err)
+	_, err = d.getStorageAccountKeyByName(context.Background(), "subsID", "rg", "notfound", "key2")
+	assert.Error(t, err)
+}
+
+func TestGetAccountInfoWithSecretNamespaces(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// account key is only read from secret
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	secretName := fmt.Sprintf(secretNameTemplate, "accountname")
+	d.cloud.KubeClient = fake.NewSimpleClientset(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "team-b"},
+			Data:       map[string][]byte{defaultSecretAccountName: []byte("accountname"), defaultSecretAccountKey: []byte("keyb")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "team-c"},
+			Data:       map[string][]byte{defaultSecretAccountName: []byte("accountname"), defaultSecretAccountKey: []byte("keyc")},
+		},
+	)
+
+	tests := []struct {
+		desc             string
+		secretNamespaces []string
+		expectedKey      string
+		expectedErr      error
+	}{
+		{
+			desc:             "secret in the first namespace is used",
+			secretNamespaces: []string{"team-c", "team-b"},
+			expectedKey:      "keyc",
+		},
+		{
+			desc:             "fall back to next namespace if secret is not found",
+			secretNamespaces: []string{"team-a", "team-b", "team-c"},
+			expectedKey:      "keyb",
+		},
+		{
+			desc:             "secret is not found in any namespace",
+			secretNamespaces: []string{"team-a", "default"},
+			expectedErr: fmt.Errorf("could not get secret(%s) in any of namespaces[team-a default]: "+
+				"team-a: could not get secret(%s): secrets \"%s\" not found; default: could not get secret(%s): secrets \"%s\" not found",
+				secretName, secretName, secretName, secretName, secretName),
+		},
+		{
+			desc:             "error of single namespace is not changed",
+			secretNamespaces: []string{"team-a"},
+			expectedErr:      fmt.Errorf("could not get secret(%s): secrets \"%s\" not found", secretName, secretName),
+		},
+	}
+
+	for _, test := range tests {
+		_, accountKey, err := d.getStorageAccountFromSecretInNamespaces(context.Background(), secretName, test.secretNamespaces)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedKey, accountKey, test.desc)
+	}
+
+	reqContext := map[string]string{
+		getAccountKeyFromSecretField: "true",
+		secretNamespacesField:        "team-a, team-b",
+	}
+	_, accountName, accountKey, _, _, _, err := d.GetAccountInfo(context.Background(), "rg#accountname#sharename", nil, reqContext)
+	assert.NoError(t, err)
+	assert.Equal(t, "accountname", accountName)
+	assert.Equal(t, "keyb", accountKey)
+}
+
 func TestCreateDisk(t *testing.T) {
 	skipIfTestingOnWindows(t)
 	d := NewFakeDriver()
@@ -809,7 +1344,7 @@ func TestCreateDisk(t *testing.T) {
 
 	for _, test := range tests {
 		_ = createDisk(context.Background(), test.accountName, test.accountKey, test.storageEndpointSuffix,
-			test.fileShareName, test.diskName, 20)
+			test.fileShareName, test.diskName, 20, nil)
 	}
 }
 
@@ -1128,33 +1663,650 @@ func TestIsSupportedRootSquashType(t *testing.T) {
 	}
 }
 
-func TestGetSubnetResourceID(t *testing.T) {
-	testCases := []struct {
-		name     string
-		testFunc func(t *testing.T)
+func TestCreateFileShareWithMetadata(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+
+	value := "value"
+	shareOptions := &fileclient.ShareOptions{
+		Name:       "share",
+		RequestGiB: 10,
+		Metadata:   map[string]*string{"key": &value},
+	}
+	accountOptions := &azure.AccountOptions{
+		Name:           "account",
+		ResourceGroup:  "rg",
+		SubscriptionID: "subsID",
+	}
+	expectedShareOptions := &fileclient.ShareOptions{
+		Name:       "share",
+		RequestGiB: 10,
+		Metadata:   map[string]*string{"key": &value},
+	}
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).Times(1)
+	mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "account", expectedShareOptions, "").Return(storage.FileShare{}, nil).Times(1)
+
+	err := d.CreateFileShare(context.Background(), accountOptions, shareOptions, nil)
+	assert.NoError(t, err)
+}
+
+func TestCreateFileShareWithProvisionRetries(t *testing.T) {
+	shareOptions := &fileclient.ShareOptions{Name: "share", RequestGiB: 10}
+	accountOptions := &azure.AccountOptions{Name: "account", ResourceGroup: "rg"}
+	retriableErr := fmt.Errorf("%s", accountNotProvisioned)
+
+	tests := []struct {
+		desc                string
+		provisionRetries    int
+		nodeMountRetries    int
+		failures            int
+		expectedCreateCalls int
+		expectedErr         error
 	}{
 		{
-			name: "NetworkResourceSubscriptionID is Empty",
-			testFunc: func(t *testing.T) {
-				d := NewFakeDriver()
-				d.cloud = &azure.Cloud{}
-				d.cloud.SubscriptionID = "fakeSubID"
-				d.cloud.NetworkResourceSubscriptionID = ""
-				d.cloud.ResourceGroup = "foo"
-				d.cloud.VnetResourceGroup = "foo"
-				actualOutput := d.getSubnetResourceID("", "", "")
-				expectedOutput := fmt.Sprintf(subnetTemplate, d.cloud.SubscriptionID, "foo", d.cloud.VnetName, d.cloud.SubnetName)
-				assert.Equal(t, actualOutput, expectedOutput, "cloud.SubscriptionID should be used as the SubID")
-			},
+			desc:                "backoff in cloud config is used by default",
+			failures:            1,
+			expectedCreateCalls: 1,
+			expectedErr:         wait.ErrWaitTimeout,
 		},
 		{
-			name: "NetworkResourceSubscriptionID is not Empty",
-			testFunc: func(t *testing.T) {
-				d := NewFakeDriver()
-				d.cloud = &azure.Cloud{}
-				d.cloud.SubscriptionID = "fakeSubID"
-				d.cloud.NetworkResourceSubscriptionID = "fakeNetSubID"
-				d.cloud.ResourceGroup = "foo"
+			desc:                "node mount retries are not used in provisioning",
+			nodeMountRetries:    3,
+			failures:            1,
+			expectedCreateCalls: 1,
+			expectedErr:         wait.ErrWaitTimeout,
+		},
+		{
+			desc:                "succeed after retries",
+			provisionRetries:    2,
+			failures:            2,
+			expectedCreateCalls: 3,
+		},
+		{
+			desc:                "retries are exhausted",
+			provisionRetries:    2,
+			failures:            3,
+			expectedCreateCalls: 3,
+			expectedErr:         wait.ErrWaitTimeout,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{
+			NodeID:                 fakeNodeID,
+			DriverName:             DefaultDriverName,
+			ProvisionRetries:       test.provisionRetries,
+			ProvisionRetryInterval: time.Millisecond,
+			NodeMountRetries:       test.nodeMountRetries,
+			NodeMountRetryInterval: time.Millisecond,
+		})
+		ctrl := gomock.NewController(t)
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+
+		calls := 0
+		mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+		mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "account", shareOptions, "").DoAndReturn(
+			func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+				calls++
+				if calls <= test.failures {
+					return storage.FileShare{}, retriableErr
+				}
+				return storage.FileShare{}, nil
+			}).AnyTimes()
+
+		err := d.CreateFileShare(context.Background(), accountOptions, shareOptions, nil)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedCreateCalls, calls, test.desc)
+		ctrl.Finish()
+	}
+
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, ProvisionRetries: -1}))
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, NodeMountRetries: -1}))
+}
+
+func TestCreateFileShareWithNetworkRuleRetry(t *testing.T) {
+	shareOptions := &fileclient.ShareOptions{Name: "share", RequestGiB: 10}
+	accountOptions := &azure.AccountOptions{Name: "account", ResourceGroup: "rg"}
+	deniedErr := fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 403, RawError: %s", authorizationFailure)
+	otherErr := fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 403, RawError: AuthorizationFailed")
+
+	tests := []struct {
+		desc                string
+		retries             int
+		failures            int
+		failureErr          error
+		expectedCreateCalls int
+		expectedErr         error
+	}{
+		{
+			desc:                "no retry if disabled",
+			failures:            1,
+			failureErr:          deniedErr,
+			expectedCreateCalls: 1,
+			expectedErr:         deniedErr,
+		},
+		{
+			desc:                "succeed after network rules are propagated",
+			retries:             3,
+			failures:            2,
+			failureErr:          deniedErr,
+			expectedCreateCalls: 3,
+		},
+		{
+			desc:                "last error is returned when retries are exhausted",
+			retries:             2,
+			failures:            3,
+			failureErr:          deniedErr,
+			expectedCreateCalls: 3,
+			expectedErr:         deniedErr,
+		},
+		{
+			desc:                "other errors are not retried",
+			retries:             3,
+			failures:            1,
+			failureErr:          otherErr,
+			expectedCreateCalls: 1,
+			expectedErr:         otherErr,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{
+			NodeID:                              fakeNodeID,
+			DriverName:                          DefaultDriverName,
+			NetworkRulePropagationRetries:       test.retries,
+			NetworkRulePropagationRetryInterval: time.Millisecond,
+		})
+		ctrl := gomock.NewController(t)
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+
+		calls := 0
+		mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+		mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "account", shareOptions, "").DoAndReturn(
+			func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+				calls++
+				if calls <= test.failures {
+					return storage.FileShare{}, test.failureErr
+				}
+				return storage.FileShare{}, nil
+			}).AnyTimes()
+
+		err := d.createFileShareWithNetworkRuleRetry(context.Background(), accountOptions, shareOptions, nil)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedCreateCalls, calls, test.desc)
+		ctrl.Finish()
+	}
+
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, NetworkRulePropagationRetries: -1}))
+}
+
+func TestMergeMountOptions(t *testing.T) {
+	tests := []struct {
+		desc              string
+		mountOptions      []string
+		extraMountOptions []string
+		expected          []string
+	}{
+		{
+			desc:              "no extra mount options",
+			mountOptions:      []string{"nobrl"},
+			extraMountOptions: nil,
+			expected:          []string{"nobrl"},
+		},
+		{
+			desc:              "extra mount options are appended",
+			mountOptions:      []string{"nobrl"},
+			extraMountOptions: []string{"cache=none", "mfsymlinks"},
+			expected:          []string{"nobrl", "cache=none", "mfsymlinks"},
+		},
+		{
+			desc:              "mount options take precedence over extra mount options",
+			mountOptions:      []string{"cache=strict", "nobrl"},
+			extraMountOptions: []string{"cache=none", "nobrl", "actimeo=30", "actimeo=60"},
+			expected:          []string{"cache=strict", "nobrl", "actimeo=30"},
+		},
+	}
+
+	for _, test := range tests {
+		result := mergeMountOptions(test.mountOptions, test.extraMountOptions)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestParseConfigMapRef(t *testing.T) {
+	tests := []struct {
+		configMapRef      string
+		expectedNamespace string
+		expectedName      string
+		expectedErr       error
+	}{
+		{
+			configMapRef:      "kube-system/mount-options",
+			expectedNamespace: "kube-system",
+			expectedName:      "mount-options",
+		},
+		{
+			configMapRef: "mount-options",
+			expectedErr:  fmt.Errorf("invalid configmap reference(mount-options), the format should be namespace/name"),
+		},
+		{
+			configMapRef: "kube-system/",
+			expectedErr:  fmt.Errorf("invalid configmap reference(kube-system/), the format should be namespace/name"),
+		},
+		{
+			configMapRef: "a/b/c",
+			expectedErr:  fmt.Errorf("invalid configmap reference(a/b/c), the format should be namespace/name"),
+		},
+	}
+
+	for _, test := range tests {
+		namespace, name, err := parseConfigMapRef(test.configMapRef)
+		assert.Equal(t, test.expectedErr, err)
+		assert.Equal(t, test.expectedNamespace, namespace)
+		assert.Equal(t, test.expectedName, name)
+	}
+}
+
+func TestGetStorageAccountFromSecretWithCache(t *testing.T) {
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeCredentialCacheTTL: time.Minute})
+	clientSet := fake.NewSimpleClientset()
+	d.cloud.KubeClient = clientSet
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"},
+		Data: map[string][]byte{
+			defaultSecretAccountName: []byte("account"),
+			defaultSecretAccountKey:  []byte("key"),
+		},
+	}
+	_, err := clientSet.CoreV1().Secrets("default").Create(context.TODO(), secret, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// cache miss, read from secret
+	accountName, accountKey, err := d.getStorageAccountFromSecretWithCache(context.TODO(), "secret", "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "account", accountName)
+	assert.Equal(t, "key", accountKey)
+
+	// cache hit, rotated key is not read
+	secret.Data[defaultSecretAccountKey] = []byte("newkey")
+	_, err = clientSet.CoreV1().Secrets("default").Update(context.TODO(), secret, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	_, accountKey, err = d.getStorageAccountFromSecretWithCache(context.TODO(), "secret", "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "key", accountKey)
+
+	// invalidate credential of other account
+	d.invalidateAccountCredential("otheraccount")
+	_, accountKey, err = d.getStorageAccountFromSecretWithCache(context.TODO(), "secret", "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "key", accountKey)
+
+	// invalidate credential, rotated key is read
+	d.accountCacheMap.Set("account", "key")
+	d.invalidateAccountCredential("account")
+	cache, err := d.accountCacheMap.Get("account", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Nil(t, cache)
+	_, accountKey, err = d.getStorageAccountFromSecretWithCache(context.TODO(), "secret", "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "newkey", accountKey)
+
+	// secret not found is not cached
+	_, _, err = d.getStorageAccountFromSecretWithCache(context.TODO(), "notexists", "default")
+	assert.Error(t, err)
+
+	// cache is disabled
+	d.nodeCredentialCache = nil
+	secret.Data[defaultSecretAccountKey] = []byte("latestkey")
+	_, err = clientSet.CoreV1().Secrets("default").Update(context.TODO(), secret, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	_, accountKey, err = d.getStorageAccountFromSecretWithCache(context.TODO(), "secret", "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "latestkey", accountKey)
+	d.invalidateAccountCredential("account")
+}
+
+func TestGetSecretCredential(t *testing.T) {
+	d := NewFakeDriver()
+	_, err := d.getSecretCredential("invalid")
+	assert.Equal(t, fmt.Errorf("invalid secret reference(invalid), the format should be namespace/name"), err)
+	_, err = d.getSecretCredential("default/secret")
+	assert.Equal(t, fmt.Errorf("could not get account key from secret(secret): KubeClient is nil"), err)
+}
+
+func TestGetStorageAccountFromSecretWithLabelSelector(t *testing.T) {
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, SecretLabelSelector: "a in (b"}))
+
+	clientSet := fake.NewSimpleClientset()
+	for name, secretLabels := range map[string]map[string]string{
+		"labeled":       {"azurefile.csi.azure.com/allowed": "true"},
+		"wrong-label":   {"azurefile.csi.azure.com/allowed": "false"},
+		"without-label": nil,
+	} {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: secretLabels},
+			Data: map[string][]byte{
+				defaultSecretAccountName: []byte("account"),
+				defaultSecretAccountKey:  []byte("key"),
+			},
+		}
+		_, err := clientSet.CoreV1().Secrets("default").Create(context.TODO(), secret, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+
+	tests := []struct {
+		desc          string
+		selector      string
+		secretName    string
+		expectedKey   string
+		expectedErr   error
+		expectedLabel map[string]string
+	}{
+		{
+			desc:        "secret without label is read when selector is not set",
+			secretName:  "without-label",
+			expectedKey: "key",
+		},
+		{
+			desc:          "secret matching selector is read",
+			selector:      "azurefile.csi.azure.com/allowed=true",
+			secretName:    "labeled",
+			expectedKey:   "key",
+			expectedLabel: map[string]string{"azurefile.csi.azure.com/allowed": "true"},
+		},
+		{
+			desc:          "secret with different label value is refused",
+			selector:      "azurefile.csi.azure.com/allowed=true",
+			secretName:    "wrong-label",
+			expectedErr:   fmt.Errorf("secret(default/wrong-label) does not match secret label selector(azurefile.csi.azure.com/allowed=true)"),
+			expectedLabel: map[string]string{"azurefile.csi.azure.com/allowed": "true"},
+		},
+		{
+			desc:        "secret without label is refused",
+			selector:    "azurefile.csi.azure.com/allowed",
+			secretName:  "without-label",
+			expectedErr: fmt.Errorf("secret(default/without-label) does not match secret label selector(azurefile.csi.azure.com/allowed)"),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, SecretLabelSelector: test.selector})
+		assert.NotNil(t, d, test.desc)
+		d.cloud = &azure.Cloud{}
+		d.cloud.KubeClient = clientSet
+
+		_, accountKey, err := d.GetStorageAccountFromSecret(context.TODO(), test.secretName, "default")
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedKey, accountKey, test.desc)
+		assert.Equal(t, test.expectedLabel, d.secretLabels, test.desc)
+	}
+
+	// secret created by driver is labeled and could be read by driver
+	d := NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, SecretLabelSelector: "azurefile.csi.azure.com/allowed=true"})
+	d.cloud = &azure.Cloud{}
+	d.cloud.KubeClient = clientSet
+	secretName, err := d.setAzureCredentials(context.TODO(), "newaccount", "newkey", "", "", "default")
+	assert.NoError(t, err)
+	_, accountKey, err := d.GetStorageAccountFromSecret(context.TODO(), secretName, "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "newkey", accountKey)
+
+	// labels are set on existing secret created by driver before selector is set
+	_, err = clientSet.CoreV1().Secrets("default").Create(context.TODO(), &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "azure-storage-account-oldaccount-secret", Namespace: "default"},
+		Data: map[string][]byte{
+			defaultSecretAccountName: []byte("oldaccount"),
+			defaultSecretAccountKey:  []byte("oldkey"),
+		},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	secretName, err = d.setAzureCredentials(context.TODO(), "oldaccount", "oldkey", "", "", "default")
+	assert.NoError(t, err)
+	_, accountKey, err = d.GetStorageAccountFromSecret(context.TODO(), secretName, "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "oldkey", accountKey)
+
+	// existing secret of other account is not labeled
+	_, err = d.setAzureCredentials(context.TODO(), "otheraccount", "otherkey", "", "without-label", "default")
+	assert.NoError(t, err)
+	secret, err := clientSet.CoreV1().Secrets("default").Get(context.TODO(), "without-label", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, secret.Labels)
+}
+
+func TestIsStorageAccountDeleted(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	_, err := d.isStorageAccountDeleted(context.Background(), "subsID", "rg", "account")
+	assert.Equal(t, fmt.Errorf("StorageAccountClient is nil"), err)
+
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	tests := []struct {
+		desc        string
+		account     storage.Account
+		rerr        *retry.Error
+		expected    bool
+		expectedErr error
+	}{
+		{
+			desc:     "account does not exist",
+			rerr:     &retry.Error{HTTPStatusCode: http.StatusNotFound},
+			expected: true,
+		},
+		{
+			desc:        "GetProperties returns error",
+			rerr:        &retry.Error{HTTPStatusCode: http.StatusInternalServerError, RawError: fmt.Errorf("test error")},
+			expected:    false,
+			expectedErr: (&retry.Error{HTTPStatusCode: http.StatusInternalServerError, RawError: fmt.Errorf("test error")}).Error(),
+		},
+		{
+			desc:     "account is being deleted",
+			account:  storage.Account{AccountProperties: &storage.AccountProperties{ProvisioningState: "deleting"}},
+			expected: true,
+		},
+		{
+			desc:     "account exists",
+			account:  storage.Account{AccountProperties: &storage.AccountProperties{ProvisioningState: storage.ProvisioningStateSucceeded}},
+			expected: false,
+		},
+		{
+			desc:     "account without properties",
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(test.account, test.rerr).Times(1)
+		result, err := d.isStorageAccountDeleted(context.Background(), "subsID", "rg", "account")
+		assert.Equal(t, test.expected, result, test.desc)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+func TestGetMountOptionsFromConfigMap(t *testing.T) {
+	d := NewFakeDriver()
+
+	// KubeClient is nil
+	_, err := d.getMountOptionsFromConfigMap("default/mount-options", smb)
+	assert.Equal(t, fmt.Errorf("could not get configmap(default/mount-options): KubeClient is nil"), err)
+
+	clientSet := fake.NewSimpleClientset()
+	d.cloud.KubeClient = clientSet
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "mount-options", Namespace: "default"},
+		Data: map[string]string{
+			smb: "nobrl, cache=none,",
+			nfs: "nconnect=4",
+		},
+	}
+	_, err = clientSet.CoreV1().ConfigMaps("default").Create(context.TODO(), configMap, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	invalidConfigMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid-mount-options", Namespace: "default"},
+		Data: map[string]string{
+			smb: "password=xxx",
+			nfs: "vers=4 ,nconnect 4",
+		},
+	}
+	_, err = clientSet.CoreV1().ConfigMaps("default").Create(context.TODO(), invalidConfigMap, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		desc         string
+		configMapRef string
+		protocol     string
+		expected     []string
+		expectedErr  error
+	}{
+		{
+			desc:         "smb mount options",
+			configMapRef: "default/mount-options",
+			protocol:     smb,
+			expected:     []string{"nobrl", "cache=none"},
+		},
+		{
+			desc:         "empty protocol is smb",
+			configMapRef: "default/mount-options",
+			expected:     []string{"nobrl", "cache=none"},
+		},
+		{
+			desc:         "nfs mount options",
+			configMapRef: "default/mount-options",
+			protocol:     nfs,
+			expected:     []string{"nconnect=4"},
+		},
+		{
+			desc:         "configmap not found",
+			configMapRef: "default/not-exists",
+			protocol:     smb,
+			expectedErr:  fmt.Errorf("configmap(default/not-exists) not found"),
+		},
+		{
+			desc:         "sensitive smb mount option is not allowed",
+			configMapRef: "default/invalid-mount-options",
+			protocol:     smb,
+			expectedErr:  fmt.Errorf("mount option(password) is not allowed in configmap(default/invalid-mount-options)"),
+		},
+		{
+			desc:         "invalid nfs mount option",
+			configMapRef: "default/invalid-mount-options",
+			protocol:     nfs,
+			expectedErr:  fmt.Errorf("invalid mount option(nconnect 4) in configmap(default/invalid-mount-options)"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := d.getMountOptionsFromConfigMap(test.configMapRef, test.protocol)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+
+	// configmap data is cached
+	err = clientSet.CoreV1().ConfigMaps("default").Delete(context.TODO(), "mount-options", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	result, err := d.getMountOptionsFromConfigMap("default/mount-options", nfs)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nconnect=4"}, result)
+}
+
+func TestIsSupportedMinimumTLSVersion(t *testing.T) {
+	tests := []struct {
+		minimumTLSVersion string
+		expectedResult    bool
+	}{
+		{
+			minimumTLSVersion: "",
+			expectedResult:    true,
+		},
+		{
+			minimumTLSVersion: "TLS1_0",
+			expectedResult:    true,
+		},
+		{
+			minimumTLSVersion: "TLS1_1",
+			expectedResult:    true,
+		},
+		{
+			minimumTLSVersion: "TLS1_2",
+			expectedResult:    true,
+		},
+		{
+			minimumTLSVersion: "TLS1_3",
+			expectedResult:    false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isSupportedMinimumTLSVersion(test.minimumTLSVersion)
+		if result != test.expectedResult {
+			t.Errorf("isSupportedMinimumTLSVersion(%s) returned with %v, not equal to %v", test.minimumTLSVersion, result, test.expectedResult)
+		}
+	}
+}
+
+func TestBuildNetworkRuleSet(t *testing.T) {
+	subnetID := "/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+	ip := "10.0.0.1"
+	cidr := "20.0.0.0/24"
+
+	networkRuleSet := buildNetworkRuleSet([]string{subnetID}, []string{ip, cidr})
+	expected := &storage.NetworkRuleSet{
+		VirtualNetworkRules: &[]storage.VirtualNetworkRule{
+			{VirtualNetworkResourceID: &subnetID, Action: storage.ActionAllow},
+		},
+		IPRules: &[]storage.IPRule{
+			{IPAddressOrRange: &ip, Action: storage.ActionAllow},
+			{IPAddressOrRange: &cidr, Action: storage.ActionAllow},
+		},
+		DefaultAction: storage.DefaultActionDeny,
+	}
+	assert.Equal(t, expected, networkRuleSet)
+
+	networkRuleSet = buildNetworkRuleSet(nil, nil)
+	expected = &storage.NetworkRuleSet{
+		VirtualNetworkRules: &[]storage.VirtualNetworkRule{},
+		IPRules:             &[]storage.IPRule{},
+		DefaultAction:       storage.DefaultActionDeny,
+	}
+	assert.Equal(t, expected, networkRuleSet)
+}
+
+func TestGetSubnetResourceID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "NetworkResourceSubscriptionID is Empty",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				d.cloud.SubscriptionID = "fakeSubID"
+				d.cloud.NetworkResourceSubscriptionID = ""
+				d.cloud.ResourceGroup = "foo"
+				d.cloud.VnetResourceGroup = "foo"
+				actualOutput := d.getSubnetResourceID("", "", "")
+				expectedOutput := fmt.Sprintf(subnetTemplate, d.cloud.SubscriptionID, "foo", d.cloud.VnetName, d.cloud.SubnetName)
+				assert.Equal(t, actualOutput, expectedOutput, "cloud.SubscriptionID should be used as the SubID")
+			},
+		},
+		{
+			name: "NetworkResourceSubscriptionID is not Empty",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				d.cloud.SubscriptionID = "fakeSubID"
+				d.cloud.NetworkResourceSubscriptionID = "fakeNetSubID"
+				d.cloud.ResourceGroup = "foo"
 				d.cloud.VnetResourceGroup = "foo"
 				actualOutput := d.getSubnetResourceID("", "", "")
 				expectedOutput := fmt.Sprintf(subnetTemplate, d.cloud.NetworkResourceSubscriptionID, "foo", d.cloud.VnetName, d.cloud.SubnetName)
@@ -1208,3 +2360,443 @@ func TestGetSubnetResourceID(t *testing.T) {
 		t.Run(tc.name, tc.testFunc)
 	}
 }
+
+type fakeFileShareRestorer struct {
+	deletedShares []storage.DeletedShare
+	err           error
+}
+
+func (r *fakeFileShareRestorer) Restore(_ context.Context, _, _, _ string, deletedShare storage.DeletedShare) (autorest.Response, error) {
+	r.deletedShares = append(r.deletedShares, deletedShare)
+	return autorest.Response{}, r.err
+}
+
+func TestIsShareSoftDeleteEnabled(t *testing.T) {
+	tests := []struct {
+		desc        string
+		properties  storage.FileServiceProperties
+		err         error
+		expected    bool
+		expectedErr error
+	}{
+		{
+			desc:     "empty properties",
+			expected: false,
+		},
+		{
+			desc: "soft delete disabled",
+			properties: storage.FileServiceProperties{
+				FileServicePropertiesProperties: &storage.FileServicePropertiesProperties{
+					ShareDeleteRetentionPolicy: &storage.DeleteRetentionPolicy{Enabled: pointer.Bool(false)},
+				},
+			},
+			expected: false,
+		},
+		{
+			desc: "soft delete enabled",
+			properties: storage.FileServiceProperties{
+				FileServicePropertiesProperties: &storage.FileServicePropertiesProperties{
+					ShareDeleteRetentionPolicy: &storage.DeleteRetentionPolicy{Enabled: pointer.Bool(true)},
+				},
+			},
+			expected: true,
+		},
+		{
+			desc:        "GetServiceProperties returns error",
+			err:         fmt.Errorf("test error"),
+			expected:    false,
+			expectedErr: fmt.Errorf("test error"),
+		},
+	}
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).Times(1)
+		mockFileClient.EXPECT().GetServiceProperties(gomock.Any(), "rg", "account").Return(test.properties, test.err).Times(1)
+
+		result, err := d.isShareSoftDeleteEnabled(context.Background(), "subsID", "rg", "account")
+		assert.Equal(t, test.expected, result, test.desc)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		ctrl.Finish()
+	}
+}
+
+func TestRestoreSoftDeletedFileShare(t *testing.T) {
+	deletedItem := func(name, version string, quota int32, deletedTime time.Time) storage.FileShareItem {
+		return storage.FileShareItem{
+			Name: pointer.String(name),
+			FileShareProperties: &storage.FileShareProperties{
+				Deleted:     pointer.Bool(true),
+				Version:     pointer.String(version),
+				ShareQuota:  pointer.Int32(quota),
+				DeletedTime: &date.Time{Time: deletedTime},
+			},
+		}
+	}
+	now := time.Now()
+
+	tests := []struct {
+		desc                  string
+		items                 []storage.FileShareItem
+		listErr               error
+		restoreErr            error
+		expectedQuota         int
+		expectedErr           error
+		expectedDeletedShares []storage.DeletedShare
+	}{
+		{
+			desc:          "ListFileShare returns error",
+			listErr:       fmt.Errorf("list error"),
+			expectedQuota: -1,
+			expectedErr:   fmt.Errorf("list error"),
+		},
+		{
+			desc: "no soft deleted file share",
+			items: []storage.FileShareItem{
+				{Name: pointer.String("share"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}},
+				deletedItem("othershare", "1", 100, now),
+			},
+			expectedQuota: -1,
+		},
+		{
+			desc: "latest soft deleted version is restored",
+			items: []storage.FileShareItem{
+				deletedItem("share", "1", 100, now.Add(-time.Hour)),
+				deletedItem("share", "2", 200, now),
+				deletedItem("othershare", "3", 300, now),
+			},
+			expectedQuota:         200,
+			expectedDeletedShares: []storage.DeletedShare{{DeletedShareName: pointer.String("share"), DeletedShareVersion: pointer.String("2")}},
+		},
+		{
+			desc:                  "Restore returns error",
+			items:                 []storage.FileShareItem{deletedItem("share", "1", 100, now)},
+			restoreErr:            fmt.Errorf("restore error"),
+			expectedQuota:         -1,
+			expectedErr:           fmt.Errorf("restore error"),
+			expectedDeletedShares: []storage.DeletedShare{{DeletedShareName: pointer.String("share"), DeletedShareVersion: pointer.String("1")}},
+		},
+	}
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		restorer := &fakeFileShareRestorer{err: test.restoreErr}
+		d.fileShareRestorer = restorer
+		mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).Times(1)
+		mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", "deleted").Return(test.items, test.listErr).Times(1)
+
+		quota, err := d.restoreSoftDeletedFileShare(context.Background(), "subsID", "rg", "account", "share")
+		assert.Equal(t, test.expectedQuota, quota, test.desc)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedDeletedShares, restorer.deletedShares, test.desc)
+		ctrl.Finish()
+	}
+}
+
+func TestVerifyFileShareExists(t *testing.T) {
+	notFoundErr := fmt.Errorf("ShareNotFound")
+	quota := func(q int32) storage.FileShare {
+		return storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(q)}}
+	}
+	type getResult struct {
+		share storage.FileShare
+		err   error
+	}
+
+	tests := []struct {
+		desc        string
+		results     []getResult
+		expectedErr error
+	}{
+		{
+			desc:    "file share exists",
+			results: []getResult{{share: quota(100)}},
+		},
+		{
+			desc: "file share exists after retries",
+			results: []getResult{
+				{err: notFoundErr},
+				{err: fmt.Errorf("TooManyRequests")},
+				{share: quota(10)},
+				{share: quota(100)},
+			},
+		},
+		{
+			desc:        "file share is never found",
+			results:     []getResult{{err: notFoundErr}, {err: notFoundErr}, {err: notFoundErr}, {err: notFoundErr}},
+			expectedErr: fmt.Errorf("file share(share) on account(account) is not found"),
+		},
+		{
+			desc:        "quota is never updated",
+			results:     []getResult{{share: quota(10)}, {share: quota(10)}, {share: quota(10)}, {share: quota(10)}},
+			expectedErr: fmt.Errorf("quota(10) of file share(share) on account(account) is smaller than 100"),
+		},
+		{
+			desc:        "non-retriable error",
+			results:     []getResult{{err: fmt.Errorf("test error")}},
+			expectedErr: fmt.Errorf("test error"),
+		},
+	}
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		d.fileShareVerifyBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 4}
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+		for _, result := range test.results {
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(result.share, result.err).Times(1)
+		}
+
+		err := d.verifyFileShareExists(context.Background(), "subsID", "rg", "account", "share", 100, nil)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		ctrl.Finish()
+	}
+}
+
+func TestWaitForFileShareDeleted(t *testing.T) {
+	notFoundErr := fmt.Errorf("ShareNotFound")
+	deleting := storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}}
+	type getResult struct {
+		share storage.FileShare
+		err   error
+	}
+
+	tests := []struct {
+		desc        string
+		results     []getResult
+		timeout     time.Duration
+		expectedErr error
+	}{
+		{
+			desc:    "file share is already deleted",
+			results: []getResult{{err: notFoundErr}},
+		},
+		{
+			desc: "file share is deleted after retries",
+			results: []getResult{
+				{share: deleting},
+				{err: fmt.Errorf("TooManyRequests")},
+				{share: deleting},
+				{err: notFoundErr},
+			},
+		},
+		{
+			desc:        "file share is never deleted",
+			results:     []getResult{{share: deleting}, {share: deleting}, {share: deleting}, {share: deleting}},
+			expectedErr: fmt.Errorf("file share(share) on account(account) is still being deleted"),
+		},
+		{
+			desc:        "non-retriable error",
+			results:     []getResult{{share: deleting}, {err: fmt.Errorf("test error")}},
+			expectedErr: fmt.Errorf("test error"),
+		},
+	}
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		d.fileShareDeleteBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 4}
+		d.waitForShareDeleteTimeout = time.Minute
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+		for _, result := range test.results {
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(result.share, result.err).Times(1)
+		}
+
+		err := d.waitForFileShareDeleted(context.Background(), "subsID", "rg", "account", "share", nil)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		ctrl.Finish()
+	}
+
+	// wait is bounded by waitForShareDeleteTimeout
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := NewFakeDriver()
+	d.fileShareDeleteBackoff = wait.Backoff{Duration: 10 * time.Millisecond, Steps: 1000}
+	d.waitForShareDeleteTimeout = 100 * time.Millisecond
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(deleting, nil).MinTimes(1)
+	err := d.waitForFileShareDeleted(context.Background(), "subsID", "rg", "account", "share", nil)
+	assert.Equal(t, fmt.Errorf("file share(share) on account(account) is still being deleted"), err)
+}
+
+func TestGetResourceGroupOfVolume(t *testing.T) {
+	tests := []struct {
+		desc                    string
+		preferFlagResourceGroup bool
+		resourceGroup           string
+		subsID                  string
+		expectedResourceGroup   string
+		expectedSubstituted     bool
+		expectedWarning         bool
+	}{
+		{
+			desc:                  "empty resource group in volume handle",
+			expectedResourceGroup: "flagRG",
+			expectedSubstituted:   true,
+		},
+		{
+			desc:                  "resource group in volume handle is used by default",
+			resourceGroup:         "handleRG",
+			expectedResourceGroup: "handleRG",
+		},
+		{
+			desc:                    "resource group of driver is preferred",
+			preferFlagResourceGroup: true,
+			resourceGroup:           "handleRG",
+			subsID:                  "subsID",
+			expectedResourceGroup:   "flagRG",
+			expectedSubstituted:     true,
+			expectedWarning:         true,
+		},
+		{
+			desc:                    "same resource group in different case",
+			preferFlagResourceGroup: true,
+			resourceGroup:           "FLAGRG",
+			expectedResourceGroup:   "flagRG",
+		},
+		{
+			desc:                    "volume handle of other subscription",
+			preferFlagResourceGroup: true,
+			resourceGroup:           "handleRG",
+			subsID:                  "otherSubsID",
+			expectedResourceGroup:   "handleRG",
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	klog.LogToStderr(false)
+	klog.SetOutput(buf)
+	defer klog.LogToStderr(true)
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.cloud.ResourceGroup = "flagRG"
+		d.cloud.SubscriptionID = "subsID"
+		d.preferFlagResourceGroup = test.preferFlagResourceGroup
+		buf.Reset()
+
+		resourceGroup, substituted := d.getResourceGroupOfVolume("volumeID", test.resourceGroup, test.subsID)
+		assert.Equal(t, test.expectedResourceGroup, resourceGroup, test.desc)
+		assert.Equal(t, test.expectedSubstituted, substituted, test.desc)
+		klog.Flush()
+		warning := "resource group(handleRG) in volume handle(volumeID) is different from resource group(flagRG) of driver, use flagRG"
+		assert.Equal(t, test.expectedWarning, bytes.Contains(buf.Bytes(), []byte(warning)), test.desc)
+	}
+}
+
+func TestApplyPVCAnnotationOverrides(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pvc",
+			Namespace: "ns",
+			Annotations: map[string]string{
+				pvcAnnotationPrefix + "skuName":    "Premium_LRS",
+				pvcAnnotationPrefix + "shareName":  "share",
+				pvcAnnotationPrefix + "accessTier": "Hot",
+				"skuname":                          "Standard_GRS",
+			},
+		},
+	}
+
+	tests := []struct {
+		desc               string
+		allowlist          string
+		parameters         map[string]string
+		expectedParameters map[string]string
+		expectedErr        error
+	}{
+		{
+			desc:      "PVC annotation overrides are disabled",
+			allowlist: "",
+			parameters: map[string]string{
+				skuNameField:    "Standard_LRS",
+				pvcNameKey:      "pvc",
+				pvcNamespaceKey: "ns",
+			},
+			expectedParameters: map[string]string{
+				skuNameField:    "Standard_LRS",
+				pvcNameKey:      "pvc",
+				pvcNamespaceKey: "ns",
+			},
+		},
+		{
+			desc:      "PVC name is not provided",
+			allowlist: "skuName",
+			parameters: map[string]string{
+				skuNameField: "Standard_LRS",
+			},
+			expectedParameters: map[string]string{
+				skuNameField: "Standard_LRS",
+			},
+		},
+		{
+			desc:      "allowed annotations override parameters of storage class",
+			allowlist: " SKUName, accessTier",
+			parameters: map[string]string{
+				"SkuName":       "Standard_LRS",
+				shareNameField:  "scShare",
+				pvcNameKey:      "pvc",
+				pvcNamespaceKey: "ns",
+			},
+			expectedParameters: map[string]string{
+				"skuName":       "Premium_LRS",
+				"accessTier":    "Hot",
+				shareNameField:  "scShare",
+				pvcNameKey:      "pvc",
+				pvcNamespaceKey: "ns",
+			},
+		},
+		{
+			desc:      "PVC not found",
+			allowlist: "skuName",
+			parameters: map[string]string{
+				pvcNameKey:      "notfound",
+				pvcNamespaceKey: "ns",
+			},
+			expectedErr: fmt.Errorf("could not get PVC(ns/notfound): persistentvolumeclaims \"notfound\" not found"),
+		},
+	}
+
+	d := NewFakeDriver()
+	d.cloud.KubeClient = fake.NewSimpleClientset(pvc)
+	for _, test := range tests {
+		d.pvcAnnotationAllowlist = parsePVCAnnotationAllowlist(test.allowlist)
+		result, err := d.applyPVCAnnotationOverrides(context.Background(), test.parameters)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		if test.expectedErr == nil {
+			assert.Equal(t, test.expectedParameters, result, test.desc)
+		}
+	}
+}
+
+type fakeGRPCServer struct {
+	csicommon.NonBlockingGRPCServer
+	stopTimeout time.Duration
+}
+
+func (s *fakeGRPCServer) GracefulStopWithTimeout(timeout time.Duration) bool {
+	s.stopTimeout = timeout
+	return true
+}
+
+func TestGracefulShutdownOnSignal(t *testing.T) {
+	d := NewFakeDriver()
+	d.shutdownTimeout = 10 * time.Second
+	s := &fakeGRPCServer{}
+	sigCh := make(chan os.Signal, 1)
+	sigCh <- syscall.SIGTERM
+	d.gracefulShutdownOnSignal(s, sigCh)
+	assert.Equal(t, d.shutdownTimeout, s.stopTimeout)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/Azure/go-autorest/tracing"
+	"k8s.io/klog/v2"
+)
+
+// loadCABundle returns system root CAs with additional CA certificates in PEM encoded CA bundle file,
+// it fails if the file could not be read or there is no valid certificate in it
+func loadCABundle(caBundlePath string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caBundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle(%s): %w", caBundlePath, err)
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil || rootCAs == nil {
+		klog.Warningf("failed to load system root CAs, only CA bundle(%s) is trusted: %v", caBundlePath, err)
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no valid PEM encoded certificate found in CA bundle(%s)", caBundlePath)
+	}
+	return rootCAs, nil
+}
+
+// newHTTPClient returns http client whose transport is the same as http.DefaultTransport except that rootCAs are trusted,
+// http.DefaultClient is returned if rootCAs is nil
+func newHTTPClient(rootCAs *x509.CertPool) *http.Client {
+	if rootCAs == nil {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    rootCAs,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// newFilePipeline returns the same pipeline as azfile.NewPipeline except that requests are sent by httpClient,
+// pipeline of azfile is returned if httpClient is nil
+func newFilePipeline(credential azfile.Credential, o azfile.PipelineOptions, httpClient *http.Client) pipeline.Pipeline {
+	if httpClient == nil {
+		return azfile.NewPipeline(credential, o)
+	}
+	f := []pipeline.Factory{
+		azfile.NewTelemetryPolicyFactory(o.Telemetry),
+		azfile.NewUniqueRequestIDPolicyFactory(),
+		azfile.NewRetryPolicyFactory(o.Retry),
+		credential,
+		azfile.NewRequestLogPolicyFactory(o.RequestLog),
+		pipeline.MethodFactoryMarker(),
+	}
+	sender := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			r, err := httpClient.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+			return pipeline.NewHTTPResponse(r), err
+		}
+	})
+	return pipeline.NewPipeline(f, pipeline.Options{HTTPSender: sender, Log: o.Log})
+}
+
+// caBundleTracer trusts CA bundle in transports of ARM clients of cloud provider and their token requests,
+// go-autorest creates these transports by tracing.NewTransport once a tracer is registered
+type caBundleTracer struct {
+	rootCAs *x509.CertPool
+}
+
+var _ tracing.Tracer = &caBundleTracer{}
+
+func (t *caBundleTracer) NewTransport(base *http.Transport) http.RoundTripper {
+	if base.TLSClientConfig == nil {
+		base.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	base.TLSClientConfig.RootCAs = t.rootCAs
+	return base
+}
+
+func (t *caBundleTracer) StartSpan(ctx context.Context, _ string) context.Context {
+	return ctx
+}
+
+func (t *caBundleTracer) EndSpan(_ context.Context, _ int, _ error) {}
+
+// registerCABundleTracer makes cloud provider trust rootCAs, it must be called before cloud provider sends any request
+// since transports of go-autorest are only created once
+func registerCABundleTracer(rootCAs *x509.CertPool) {
+	tracing.Register(&caBundleTracer{rootCAs: rootCAs})
+}
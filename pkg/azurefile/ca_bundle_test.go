@@ -0,0 +1,122 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/Azure/go-autorest/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caBundlePath := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, os.WriteFile(caBundlePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	invalidCABundlePath := filepath.Join(dir, "invalid.crt")
+	assert.NoError(t, os.WriteFile(invalidCABundlePath, []byte("invalid"), 0600))
+
+	_, err := loadCABundle(filepath.Join(dir, "notfound.crt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = loadCABundle(invalidCABundlePath)
+	assert.EqualError(t, err, "no valid PEM encoded certificate found in CA bundle("+invalidCABundlePath+")")
+
+	rootCAs, err := loadCABundle(caBundlePath)
+	assert.NoError(t, err)
+	client := newHTTPClient(rootCAs)
+	transport, ok := client.Transport.(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, rootCAs, transport.TLSClientConfig.RootCAs)
+
+	// server certificate is only trusted with CA bundle
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	_, err = http.DefaultClient.Get(server.URL)
+	assert.Error(t, err)
+
+	assert.Equal(t, http.DefaultClient, newHTTPClient(nil))
+}
+
+func TestNewDriverWithCABundle(t *testing.T) {
+	dir := t.TempDir()
+	invalidCABundlePath := filepath.Join(dir, "invalid.crt")
+	assert.NoError(t, os.WriteFile(invalidCABundlePath, []byte("invalid"), 0600))
+
+	assert.Equal(t, http.DefaultClient, NewFakeDriver().httpClient)
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, CABundlePath: invalidCABundlePath}))
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, CABundlePath: filepath.Join(dir, "notfound.crt")}))
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+	caBundlePath := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, os.WriteFile(caBundlePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	defer tracing.Register(nil)
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, CABundlePath: caBundlePath})
+	assert.NotEqual(t, http.DefaultClient, d.httpClient)
+	// transports of cloud provider trust CA bundle
+	assert.True(t, tracing.IsEnabled())
+	transport, ok := tracing.NewTransport(&http.Transport{}).(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, d.httpClient.Transport.(*http.Transport).TLSClientConfig.RootCAs, transport.TLSClientConfig.RootCAs)
+	// key vault requests use http client with CA bundle
+	keyVaultClient, ok := d.getKeyVaultSecretGetter().(*keyVaultSecretClient)
+	assert.True(t, ok)
+	assert.Equal(t, d.httpClient, keyVaultClient.httpClient)
+}
+
+func TestNewFilePipeline(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	credential, err := azfile.NewSharedKeyCredential("account", "YWNjb3VudGtleQ==")
+	assert.NoError(t, err)
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	po := azfile.PipelineOptions{Retry: azfile.RetryOptions{MaxTries: 1}}
+
+	// server certificate is only trusted by pipeline with CA bundle
+	shareURL := azfile.NewServiceURL(*u, newFilePipeline(credential, po, nil)).NewShareURL("share")
+	_, err = shareURL.GetProperties(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0, requests)
+
+	shareURL = azfile.NewServiceURL(*u, newFilePipeline(credential, po, newHTTPClient(rootCAs))).NewShareURL("share")
+	_, err = shareURL.GetProperties(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+}
@@ -81,12 +81,23 @@ var (
 
 // CreateVolume provisions an azure file
 func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	volName := req.GetName()
+	// requests failed in validation are audited as well, parameters are replaced by effective ones once they are resolved
+	auditParameters := req.GetParameters()
+	isOperationSucceeded := false
+	// request retried by CreateVolume itself is audited by the retry
+	skipAudit := false
+	defer func() {
+		if !skipAudit {
+			d.emitAuditEvent(auditCreateVolume, volName, auditParameters[pvcNamespaceKey], auditParameters[pvcNameKey], auditParameters, isOperationSucceeded)
+		}
+	}()
+
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
 		klog.Errorf("invalid create volume req: %v", req)
 		return nil, err
 	}
 
-	volName := req.GetName()
 	if len(volName) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "CreateVolume Name must be provided")
 	}
@@ -94,12 +105,33 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if err := isValidVolumeCapabilities(volumeCapabilities); err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("CreateVolume Volume capabilities not valid: %v", err))
 	}
+	if d.accessModeConflictPolicy != accessModeConflictAllow {
+		if err := checkAccessModeConflicts(volumeCapabilities); err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("CreateVolume Volume capabilities not valid: %v", err))
+		}
+	}
 
-	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
+	capacityBytes, err := validateCapacityRange(req.GetCapacityRange())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	requestGiB := volumehelper.RoundUpGiB(capacityBytes)
-	if requestGiB == 0 {
-		requestGiB = defaultAzureFileQuota
-		klog.Warningf("no quota specified, set as default value(%d GiB)", defaultAzureFileQuota)
+	isDefaultQuota := requestGiB == 0
+	if isDefaultQuota {
+		requestGiB = int64(d.defaultShareQuotaGiB)
+		klog.Warningf("no quota specified, set as default value(%d GiB)", requestGiB)
+	}
+	if requestGiB < int64(d.minShareQuotaGiB) {
+		minBytes := volumehelper.GiBToBytes(int64(d.minShareQuotaGiB))
+		if limitBytes := req.GetCapacityRange().GetLimitBytes(); limitBytes > 0 && limitBytes < minBytes {
+			return nil, status.Errorf(codes.OutOfRange, "LimitBytes(%d) in capacity range is smaller than minimum share quota(%d GiB)", limitBytes, d.minShareQuotaGiB)
+		}
+		klog.V(2).Infof("requested quota(%d GiB) is smaller than minimum share quota, set as %d GiB", requestGiB, d.minShareQuotaGiB)
+		requestGiB = int64(d.minShareQuotaGiB)
+		capacityBytes = minBytes
+	}
+	if err := d.validateMaxShareQuota(requestGiB); err != nil {
+		return nil, err
 	}
 
 	if acquired := d.volumeLocks.TryAcquire(volName); !acquired {
@@ -111,186 +143,89 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if parameters == nil {
 		parameters = make(map[string]string)
 	}
-	var sku, subsID, resourceGroup, location, account, fileShareName, diskName, fsType, secretName string
-	var secretNamespace, pvcNamespace, protocol, customTags, storageEndpointSuffix, networkEndpointType, shareAccessTier, accountAccessTier, rootSquashType string
-	var createAccount, useDataPlaneAPI, useSeretCache, matchTags bool
-	var vnetResourceGroup, vnetName, subnetName, shareNamePrefix, fsGroupChangePolicy string
-	var requireInfraEncryption, disableDeleteRetentionPolicy, enableLFS, isMultichannelEnabled *bool
-	// set allowBlobPublicAccess as false by default
-	allowBlobPublicAccess := pointer.Bool(false)
-
-	fileShareNameReplaceMap := map[string]string{}
-	// store account key to k8s secret by default
-	storeAccountKey := true
-
-	// Apply ProvisionerParameters (case-insensitive). We leave validation of
-	// the values to the cloud provider.
-	for k, v := range parameters {
-		switch strings.ToLower(k) {
-		case skuNameField:
-			sku = v
-		case storageAccountTypeField:
-			sku = v
-		case locationField:
-			location = v
-		case storageAccountField:
-			account = v
-		case subscriptionIDField:
-			subsID = v
-		case resourceGroupField:
-			resourceGroup = v
-		case shareNameField:
-			fileShareName = v
-		case diskNameField:
-			diskName = v
-		case fsTypeField:
-			fsType = v
-		case storeAccountKeyField:
-			if strings.EqualFold(v, falseValue) {
-				storeAccountKey = false
-			}
-		case secretNameField:
-			secretName = v
-		case secretNamespaceField:
-			secretNamespace = v
-		case protocolField:
-			protocol = v
-		case matchTagsField:
-			matchTags = strings.EqualFold(v, trueValue)
-		case tagsField:
-			customTags = v
-		case createAccountField:
-			createAccount = strings.EqualFold(v, trueValue)
-		case useSecretCacheField:
-			useSeretCache = strings.EqualFold(v, trueValue)
-		case enableLargeFileSharesField:
-			value, err := strconv.ParseBool(v)
-			if err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", enableLargeFileSharesField, v))
-			}
-			enableLFS = &value
-		case useDataPlaneAPIField:
-			useDataPlaneAPI = strings.EqualFold(v, trueValue)
-		case disableDeleteRetentionPolicyField:
-			value, err := strconv.ParseBool(v)
-			if err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", disableDeleteRetentionPolicyField, v))
-			}
-			disableDeleteRetentionPolicy = &value
-		case pvcNamespaceKey:
-			pvcNamespace = v
-			fileShareNameReplaceMap[pvcNamespaceMetadata] = v
-		case storageEndpointSuffixField:
-			storageEndpointSuffix = v
-		case networkEndpointTypeField:
-			networkEndpointType = v
-		case accessTierField:
-			shareAccessTier = v
-		case shareAccessTierField:
-			shareAccessTier = v
-		case accountAccessTierField:
-			accountAccessTier = v
-		case rootSquashTypeField:
-			rootSquashType = v
-		case allowBlobPublicAccessField:
-			value, err := strconv.ParseBool(v)
-			if err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", allowBlobPublicAccessField, v))
-			}
-			allowBlobPublicAccess = &value
-		case pvcNameKey:
-			fileShareNameReplaceMap[pvcNameMetadata] = v
-		case pvNameKey:
-			fileShareNameReplaceMap[pvNameMetadata] = v
-		case serverNameField:
-			// no op, only used in NodeStageVolume
-		case folderNameField:
-			// no op, only used in NodeStageVolume
-		case fsGroupChangePolicyField:
-			fsGroupChangePolicy = v
-		case mountPermissionsField:
-			// only do validations here, used in NodeStageVolume, NodePublishVolume
-			if _, err := strconv.ParseUint(v, 8, 32); err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid mountPermissions %s in storage class", v))
-			}
-		case vnetResourceGroupField:
-			vnetResourceGroup = v
-		case vnetNameField:
-			vnetName = v
-		case subnetNameField:
-			subnetName = v
-		case shareNamePrefixField:
-			shareNamePrefix = v
-		case requireInfraEncryptionField:
-			value, err := strconv.ParseBool(v)
-			if err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", requireInfraEncryptionField, v))
-			}
-			requireInfraEncryption = &value
-		case enableMultichannelField:
-			value, err := strconv.ParseBool(v)
-			if err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", enableMultichannelField, v))
-			}
-			isMultichannelEnabled = &value
-		default:
-			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", k))
-		}
+	if parameters, err = d.applyPVCAnnotationOverrides(ctx, parameters); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-
-	if matchTags && account != "" {
-		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("matchTags must set as false when storageAccount(%s) is provided", account))
+	if parameters, err = d.expandProfile(parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-
-	if subsID != "" && subsID != d.cloud.SubscriptionID {
-		if resourceGroup == "" {
-			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("resourceGroup must be provided in cross subscription(%s)", subsID))
-		}
+	if parameters, _, err = canonicalizeParameters(parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-
-	if secretNamespace == "" {
-		if pvcNamespace == "" {
-			secretNamespace = defaultNamespace
-		} else {
-			secretNamespace = pvcNamespace
-		}
+	auditParameters = parameters
+	p, err := d.parseCreateVolumeParameters(parameters)
+	if err != nil {
+		return nil, err
 	}
-
-	if !d.enableVHDDiskFeature && fsType != "" {
-		return nil, status.Errorf(codes.InvalidArgument, "fsType storage class parameter enables experimental VDH disk feature which is currently disabled, use --enable-vhd driver option to enable it")
+	if _, err := d.validateCreateVolumeParameters(p); err != nil {
+		return nil, err
 	}
-
-	if !isSupportedFsType(fsType) {
-		return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported, supported fsType list: %v", fsType, supportedFsTypeList)
+	sku, subsID, resourceGroup, location, account := p.sku, p.subsID, p.resourceGroup, p.location, p.account
+	fileShareName, diskName, fsType, protocol, customTags := p.fileShareName, p.diskName, p.fsType, p.protocol, p.customTags
+	secretName, secretNamespace, pvcNamespace := p.secretName, p.secretNamespace, p.pvcNamespace
+	storageEndpointSuffix, networkEndpointType, dataPlaneAuth := p.storageEndpointSuffix, p.networkEndpointType, p.dataPlaneAuth
+	shareAccessTier, accountAccessTier, rootSquashType := p.shareAccessTier, p.accountAccessTier, p.rootSquashType
+	vnetResourceGroup, vnetName, subnetName, shareNamePrefix := p.vnetResourceGroup, p.vnetName, p.subnetName, p.shareNamePrefix
+	minimumTLSVersion, networkDefaultAction, allowedIPs, keyExpirationDays := p.minimumTLSVersion, p.networkDefaultAction, p.allowedIPs, p.keyExpirationDays
+	createAccount, useDataPlaneAPI, useSeretCache, matchTags := p.createAccount, p.useDataPlaneAPI, p.useSecretCache, p.matchTags
+	restoreFromSoftDelete, useExistingShare, storeAccountKey := p.restoreFromSoftDelete, p.useExistingShare, p.storeAccountKey
+	requireInfraEncryption, disableDeleteRetentionPolicy, enableLFS := p.requireInfraEncryption, p.disableDeleteRetentionPolicy, p.enableLFS
+	isMultichannelEnabled, allowBlobPublicAccess, shareMetadata := p.isMultichannelEnabled, p.allowBlobPublicAccess, p.shareMetadata
+	generateSASToken, sasTokenPermissions, sasTokenExpiry := p.generateSASToken, p.sasTokenPermissions, p.sasTokenExpiry
+	disableAccountKeyAuth, initialDirectories, operationTimeout := p.disableAccountKeyAuth, p.initialDirectories, p.operationTimeout
+	secretNamespaces := parseSecretNamespaces(p.secretNamespaces)
+	keyVaultURL, keyVaultSecretName, useKey := p.keyVaultURL, p.keyVaultSecretName, strings.ToLower(p.useKey)
+
+	// pv/pvc name namespace metadata which could be referenced in shareName
+	fileShareNameReplaceMap := map[string]string{}
+	if p.pvcNamespace != "" {
+		fileShareNameReplaceMap[pvcNamespaceMetadata] = p.pvcNamespace
 	}
-
-	if !isSupportedProtocol(protocol) {
-		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", protocol, supportedProtocolList)
+	if p.pvcName != "" {
+		fileShareNameReplaceMap[pvcNameMetadata] = p.pvcName
 	}
-
-	if !isSupportedShareAccessTier(shareAccessTier) {
-		return nil, status.Errorf(codes.InvalidArgument, "shareAccessTier(%s) is not supported, supported ShareAccessTier list: %v", shareAccessTier, storage.PossibleShareAccessTierValues())
+	if p.pvName != "" {
+		fileShareNameReplaceMap[pvNameMetadata] = p.pvName
 	}
 
-	if !isSupportedAccountAccessTier(accountAccessTier) {
-		return nil, status.Errorf(codes.InvalidArgument, "accountAccessTier(%s) is not supported, supported AccountAccessTier list: %v", accountAccessTier, storage.PossibleAccessTierValues())
+	if operationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = extendContextTimeout(ctx, operationTimeout)
+		defer cancel()
 	}
 
-	if !isSupportedRootSquashType(rootSquashType) {
-		return nil, status.Errorf(codes.InvalidArgument, "rootSquashType(%s) is not supported, supported RootSquashType list: %v", rootSquashType, storage.PossibleRootSquashTypeValues())
+	if secretNamespace == "" {
+		if len(secretNamespaces) > 0 {
+			// account key is stored in the first namespace
+			secretNamespace = secretNamespaces[0]
+		} else if pvcNamespace == "" {
+			secretNamespace = defaultNamespace
+		} else {
+			secretNamespace = pvcNamespace
+		}
 	}
-
-	if !isSupportedFSGroupChangePolicy(fsGroupChangePolicy) {
-		return nil, status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", fsGroupChangePolicy, supportedFSGroupChangePolicyList)
+	if len(secretNamespaces) == 0 {
+		secretNamespaces = []string{secretNamespace}
 	}
 
-	if !isSupportedShareNamePrefix(shareNamePrefix) {
-		return nil, status.Errorf(codes.InvalidArgument, "shareNamePrefix(%s) can only contain lowercase letters, numbers, hyphens, and length should be less than 21", shareNamePrefix)
+	// properties which are not supported by account options of cloud provider, they are only set on storage account created by driver
+	accountProps := &accountProperties{}
+	if minimumTLSVersion != "" {
+		accountProps.minimumTLSVersion = minimumTLSVersion
+	} else {
+		minimumTLSVersion = d.minimumTLSVersion
+		// storage account is created with TLS1_2 by default, existing storage accounts are matched regardless of their minimum TLS version
+		if minimumTLSVersion != string(storage.MinimumTLSVersionTLS12) {
+			accountProps.minimumTLSVersion = minimumTLSVersion
+		}
 	}
-
-	if protocol == nfs && fsType != "" && fsType != nfs {
-		return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported with protocol(%s)", fsType, protocol)
+	if len(allowedIPs) > 0 {
+		networkDefaultAction = string(storage.DefaultActionDeny)
 	}
+	accountProps.networkDefaultAction = networkDefaultAction
+	accountProps.allowedIPs = allowedIPs
+	accountProps.keyExpirationDays = keyExpirationDays
+	denyNetworkAccess := strings.EqualFold(networkDefaultAction, string(storage.DefaultActionDeny))
 
 	enableHTTPSTrafficOnly := true
 	shareProtocol := storage.EnabledProtocolsSMB
@@ -299,6 +234,31 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		createPrivateEndpoint = true
 	}
 	var vnetResourceIDs []string
+	var accessibleTopology []*csi.Topology
+	if protocol == auto {
+		// file share only supports one protocol, protocol is resolved by OS of the node which volume is provisioned for
+		nodeOS := getPreferredNodeOS(req.GetAccessibilityRequirements())
+		if nodeOS == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) requires %s in requested topology, enable node OS topology in driver and use WaitForFirstConsumer volume binding mode", auto, topologyOSKey)
+		}
+		if account != "" {
+			// volumes of the storage class could be provisioned for nodes of any OS, so existing account must support both protocols
+			if err := d.checkAccountSupportsNFS(ctx, subsID, resourceGroup, account); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) requires storage account supporting both smb and nfs protocols: %v", auto, err)
+			}
+		}
+		protocol = getProtocolByNodeOS(protocol, nodeOS)
+		if sku == "" {
+			// premium account supports both smb and nfs protocols
+			sku = string(storage.SkuNamePremiumLRS)
+		}
+		if protocol == nfs {
+			// nfs file share could not be mounted on windows node
+			accessibleTopology = []*csi.Topology{{Segments: map[string]string{topologyOSKey: nodeOS}}}
+		}
+		klog.V(2).Infof("protocol(%s) is resolved to %s by node OS(%s)", auto, protocol, nodeOS)
+		setKeyValueInMap(parameters, protocolField, protocol)
+	}
 	if fsType == nfs || protocol == nfs {
 		protocol = nfs
 		enableHTTPSTrafficOnly = false
@@ -323,13 +283,39 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		}
 	}
 
-	if pointer.BoolDeref(isMultichannelEnabled, false) {
-		if sku != "" && !strings.HasPrefix(strings.ToLower(sku), premium) {
-			return nil, status.Errorf(codes.InvalidArgument, "smb multichannel is only supported with premium account, current account type: %s", sku)
+	if dataPlaneAuth, err = getDataPlaneAuth(dataPlaneAuth, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if keyAuthDisabledBy := getAccountKeyAuthDisabledBy(disableAccountKeyAuth, dataPlaneAuth); keyAuthDisabledBy != "" {
+		var mountFlags []string
+		for _, c := range req.GetVolumeCapabilities() {
+			mountFlags = append(mountFlags, c.GetMount().GetMountFlags()...)
 		}
-		if fsType == nfs || protocol == nfs {
-			return nil, status.Errorf(codes.InvalidArgument, "smb multichannel is only supported with smb protocol, current protocol: %s", protocol)
+		if _, err := getIdentityAuthMethod(keyAuthDisabledBy, protocol, mountFlags); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
+		// account key is never stored since it should not be used in mount
+		storeAccountKey = false
+	}
+	if keyVaultURL != "" {
+		// account key is read from key vault in mount, it's not stored in k8s secret
+		storeAccountKey = false
+	}
+
+	if denyNetworkAccess && !createPrivateEndpoint && len(vnetResourceIDs) == 0 && (subnetName != "" || d.cloud.SubnetName != "") {
+		// set VirtualNetworkResourceIDs for storage account firewall setting
+		vnetResourceID := d.getSubnetResourceID(vnetResourceGroup, vnetName, subnetName)
+		klog.V(2).Infof("set vnetResourceID(%s) for default deny network rule set", vnetResourceID)
+		vnetResourceIDs = []string{vnetResourceID}
+		if err := d.updateSubnetServiceEndpoints(ctx, vnetResourceGroup, vnetName, subnetName); err != nil {
+			return nil, status.Errorf(codes.Internal, "update service endpoints failed with error: %v", err)
+		}
+	}
+
+	if sku == "" && len(d.allowedSKUs) > 0 {
+		// empty sku matches storage account of any sku, only use default sku which is validated against allowed skus
+		sku = string(storage.SkuNameStandardLRS)
 	}
 
 	fileShareSize := int(requestGiB)
@@ -344,6 +330,10 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 
 	// replace pv/pvc name namespace metadata in fileShareName
 	validFileShareName := replaceWithMap(fileShareName, fileShareNameReplaceMap)
+	if validFileShareName != "" && !isSupportedShareName(validFileShareName) {
+		// only generated share names are normalized, user specified share name is used as is
+		return nil, status.Errorf(codes.InvalidArgument, "shareName(%s) is invalid, it can only contain lowercase letters, numbers, single hyphens, must begin and end with a letter or a number, and length should be between %d and %d", validFileShareName, fileShareNameMinLength, fileShareNameMaxLength)
+	}
 	if validFileShareName == "" {
 		name := volName
 		if shareNamePrefix != "" {
@@ -357,7 +347,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 				name = strings.Replace(name, "pvc", "pvcd", 1)
 			}
 		}
-		validFileShareName = getValidFileShareName(name)
+		validFileShareName = getValidFileShareName(name, d.shareNameCollisionStrategy)
 	}
 
 	if resourceGroup == "" {
@@ -368,18 +358,34 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, err.Error())
 	}
+	provenanceTags := getProvenanceTags(time.Now())
+	if !matchTags {
+		// provenance tags are not set with matchTags since creation time would prevent matching storage accounts
+		tags = mergeProvenanceTags(tags, provenanceTags)
+	}
 
 	if strings.TrimSpace(storageEndpointSuffix) == "" {
-		if d.cloud.Environment.StorageEndpointSuffix != "" {
-			storageEndpointSuffix = d.cloud.Environment.StorageEndpointSuffix
-		} else {
-			storageEndpointSuffix = defaultStorageEndPointSuffix
-		}
+		storageEndpointSuffix = getStorageEndpointSuffix(d.cloud)
 	}
 	if d.fileClient != nil {
 		d.fileClient.StorageEndpointSuffix = storageEndpointSuffix
 	}
 
+	useSingleAccount := false
+	if d.singleAccountName != "" && account == "" && len(req.GetSecrets()) == 0 {
+		// all file shares are created in the single storage account, account selection is skipped
+		account = d.singleAccountName
+		useSingleAccount = true
+	}
+
+	var regions []string
+	if d.enableRegionalAccountAffinity && location == "" && account == "" {
+		// storage account in the region of requested topology is preferred
+		if regions = getPreferredRegions(req.GetAccessibilityRequirements()); len(regions) > 0 {
+			location = regions[0]
+		}
+	}
+
 	accountOptions := &azure.AccountOptions{
 		Name:                                    account,
 		Type:                                    sku,
@@ -408,36 +414,51 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 
 	var accountKey, lockKey string
 	accountName := account
+	if useSingleAccount {
+		if accountKey, err = d.ensureSingleAccount(withAccountProperties(ctx, accountProps), accountOptions); err != nil {
+			if isResourceGroupLockedError(err) {
+				return nil, status.Errorf(codes.FailedPrecondition, "failed to ensure single storage account(%s): %v", accountName, err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to ensure single storage account(%s): %v", accountName, err)
+		}
+		requestedSKU := ""
+		if p.sku != "" {
+			// sku is replaced by premium sku for nfs protocol
+			requestedSKU = sku
+		}
+		if err := d.checkSingleAccount(requestedSKU, protocol); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
 	if len(req.GetSecrets()) == 0 && accountName == "" {
 		if v, ok := d.volMap.Load(volName); ok {
 			accountName = v.(string)
 		} else {
-			lockKey = fmt.Sprintf("%s%s%s%s%s%s%s%v%v%v%v%v", sku, accountKind, resourceGroup, location, protocol, subsID, accountAccessTier,
-				createPrivateEndpoint, pointer.BoolDeref(allowBlobPublicAccess, false), pointer.BoolDeref(requireInfraEncryption, false),
-				pointer.BoolDeref(enableLFS, false), pointer.BoolDeref(disableDeleteRetentionPolicy, false))
-			// search in cache first
-			cache, err := d.accountSearchCache.Get(lockKey, azcache.CacheReadTypeDefault)
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, err.Error())
+			lockKey = fmt.Sprintf("%s%s%s%s%s%s%s%s%s%v%v%v%v%v%v%v", sku, accountKind, resourceGroup, location, protocol, subsID, accountAccessTier, minimumTLSVersion,
+				strings.ToLower(networkDefaultAction), allowedIPs, createPrivateEndpoint, pointer.BoolDeref(allowBlobPublicAccess, false), pointer.BoolDeref(requireInfraEncryption, false),
+				pointer.BoolDeref(enableLFS, false), pointer.BoolDeref(disableDeleteRetentionPolicy, false), keyExpirationDays)
+			var cache interface{}
+			if d.accountSelectionStrategy == accountSelectionFirstMatch {
+				// search in cache first, account is selected on every request by other strategies
+				if cache, err = d.accountSearchCache.Get(lockKey, azcache.CacheReadTypeDefault); err != nil {
+					return nil, status.Errorf(codes.Internal, err.Error())
+				}
 			}
 			if cache != nil {
 				accountName = cache.(string)
 			} else {
 				d.volLockMap.LockEntry(lockKey)
-				err = wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
-					var retErr error
-					accountName, accountKey, retErr = d.cloud.EnsureStorageAccount(ctx, accountOptions, defaultAccountNamePrefix)
-					if isRetriableError(retErr) {
-						klog.Warningf("EnsureStorageAccount(%s) failed with error(%v), waiting for retrying", account, retErr)
-						sleepIfThrottled(retErr, accountOpThrottlingSleepSec)
-						return false, nil
-					}
-					return true, retErr
-				})
+				spanCtx, span := startSpan(ctx, "ensureStorageAccount", protocolAttribute.String(protocol))
+				accountName, accountKey, err = d.ensureStorageAccountInRegions(withAccountProperties(spanCtx, accountProps), accountOptions, regions)
+				endSpan(span, err)
 				d.volLockMap.UnlockEntry(lockKey)
 				if err != nil {
+					if isResourceGroupLockedError(err) {
+						return nil, status.Errorf(codes.FailedPrecondition, "failed to ensure storage account: %v", err)
+					}
 					return nil, status.Errorf(codes.Internal, "failed to ensure storage account: %v", err)
 				}
+				location = accountOptions.Location
 				d.accountSearchCache.Set(lockKey, accountName)
 				d.volMap.Store(volName, accountName)
 				if accountKey != "" {
@@ -447,148 +468,797 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		}
 	}
 
-	if createPrivateEndpoint {
-		setKeyValueInMap(parameters, serverNameField, fmt.Sprintf("%s.privatelink.file.%s", accountName, storageEndpointSuffix))
+	if createPrivateEndpoint {
+		setKeyValueInMap(parameters, serverNameField, fmt.Sprintf("%s.privatelink.file.%s", accountName, storageEndpointSuffix))
+	}
+
+	accountOptions.Name = accountName
+	if keyVaultURL != "" && accountKey == "" && len(req.GetSecrets()) == 0 {
+		// account key in key vault is used in data plane operations
+		if accountKey, err = d.getAccountKeyFromKeyVault(ctx, accountName, keyVaultURL, keyVaultSecretName); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get account(%s) key from key vault(%s): %v", accountName, keyVaultURL, err)
+		}
+	}
+	if useKey != "" && len(req.GetSecrets()) == 0 {
+		// account key returned by account search is the first valid key, the specified key is used in data plane operations
+		// and stored in k8s secret instead, so that node reads it from secret
+		if accountKey, err = d.getStorageAccountKeyByName(ctx, subsID, resourceGroup, accountName, useKey); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get %s of account(%s): %v", useKey, accountName, err)
+		}
+		if secretName == "" {
+			// secret of the first key is not overridden
+			secretName = fmt.Sprintf(keySecretNameTemplate, accountName, useKey)
+			setKeyValueInMap(parameters, secretNameField, secretName)
+		}
+	}
+	// -1 means no soft deleted file share is restored
+	restoredQuota := -1
+	shareExists := false
+	secret := req.GetSecrets()
+	if len(secret) == 0 && useDataPlaneAPI {
+		if accountKey == "" {
+			if accountKey, err = d.getStorageAccesskey(ctx, accountOptions, secret, secretName, secretNamespaces); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
+			}
+		}
+		if dataPlaneAuth == dataPlaneAuthConnectionString {
+			secret = map[string]string{defaultSecretConnectionString: buildConnectionString(accountName, accountKey, storageEndpointSuffix)}
+		} else {
+			secret = createStorageAccountSecret(accountName, accountKey)
+		}
+		// skip validating file share quota if useDataPlaneAPI
+	} else {
+		quota, err := d.getFileShareQuota(ctx, subsID, resourceGroup, accountName, validFileShareName, secret)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+		if quota == -1 && restoreFromSoftDelete {
+			enabled, err := d.isShareSoftDeleteEnabled(ctx, subsID, resourceGroup, accountName)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to get file service properties of account(%s): %v", accountName, err)
+			}
+			if !enabled {
+				return nil, status.Errorf(codes.FailedPrecondition, "file share soft delete is not enabled on account(%s), could not restore file share(%s)", accountName, validFileShareName)
+			}
+			if quota, err = d.restoreSoftDeletedFileShare(ctx, subsID, resourceGroup, accountName, validFileShareName); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to restore soft deleted file share(%s) on account(%s): %v", validFileShareName, accountName, err)
+			}
+			restoredQuota = quota
+		}
+		shareExists = quota != -1
+		if quota == -1 && useExistingShare {
+			return nil, status.Errorf(codes.NotFound, "file share(%s) does not exist on account(%s), could not use existing file share", validFileShareName, accountName)
+		}
+		if quota != -1 && quota < fileShareSize {
+			return nil, status.Errorf(codes.AlreadyExists, "request file share(%s) already exists, but its capacity %d is smaller than %d", validFileShareName, quota, fileShareSize)
+		}
+		if useExistingShare {
+			// keep the capacity of existing file share
+			fileShareSize = quota
+			capacityBytes = volumehelper.GiBToBytes(int64(quota))
+		}
+	}
+
+	if useExistingShare && protocol == nfs && !createPrivateEndpoint {
+		if deniedSubnets, err := d.getNFSDeniedSubnets(ctx, subsID, resourceGroup, accountName, vnetResourceIDs); err != nil {
+			klog.Warningf("failed to check network rules of storage account(%s) for existing file share(%s): %v", accountName, validFileShareName, err)
+		} else if len(deniedSubnets) > 0 {
+			klog.Warningf("network rules of storage account(%s) do not allow access from subnet(%s), NFS mount of existing file share(%s) would fail on nodes in the subnet, "+
+				"add the subnet to virtual network rules of the storage account or access the account by private endpoint", accountName, strings.Join(deniedSubnets, ","), validFileShareName)
+		}
+	}
+
+	if !shareExists {
+		// provenance metadata is only set on new file share
+		shareMetadata = mergeProvenanceMetadata(shareMetadata, provenanceTags)
+	}
+	shareOptions := &fileclient.ShareOptions{
+		Name:       validFileShareName,
+		Protocol:   shareProtocol,
+		RequestGiB: fileShareSize,
+		AccessTier: shareAccessTier,
+		RootSquash: rootSquashType,
+		Metadata:   shareMetadata,
+	}
+	if restoredQuota != -1 {
+		// keep the capacity of restored file share
+		shareOptions.RequestGiB = restoredQuota
+		capacityBytes = volumehelper.GiBToBytes(int64(restoredQuota))
+	} else if isDefaultQuota {
+		// default quota may be raised to minimum share size of the sku
+		capacityBytes = volumehelper.GiBToBytes(int64(fileShareSize))
+	}
+
+	var volumeID string
+	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_create_volume", d.cloud.ResourceGroup, subsID, d.Name)
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded, VolumeID, volumeID)
+	}()
+
+	if useExistingShare {
+		klog.V(2).Infof("use existing file share(%s) on account(%s) subID(%s) rg(%s) size(%d) protocol(%s)", validFileShareName, accountName, subsID, resourceGroup, fileShareSize, shareProtocol)
+	} else {
+		klog.V(2).Infof("begin to create file share(%s) on account(%s) type(%s) subID(%s) rg(%s) location(%s) size(%d) protocol(%s)", validFileShareName, accountName, sku, subsID, resourceGroup, location, fileShareSize, shareProtocol)
+		spanCtx, span := startSpan(ctx, "CreateFileShare", accountNameAttribute.String(accountName), shareNameAttribute.String(validFileShareName), protocolAttribute.String(string(shareProtocol)))
+		err = d.createFileShareWithNetworkRuleRetry(spanCtx, accountOptions, shareOptions, secret)
+		endSpan(span, err)
+		if err != nil {
+			if strings.Contains(err.Error(), accountLimitExceedManagementAPI) || strings.Contains(err.Error(), accountLimitExceedDataPlaneAPI) {
+				klog.Warningf("create file share(%s) on account(%s) type(%s) subID(%s) rg(%s) location(%s) size(%d), error: %v, skip matching current account", validFileShareName, accountName, sku, subsID, resourceGroup, location, fileShareSize, err)
+				tags := map[string]*string{
+					azure.SkipMatchingTag: pointer.String(""),
+				}
+				if rerr := d.cloud.AddStorageAccountTags(ctx, subsID, resourceGroup, accountName, tags); rerr != nil {
+					klog.Warningf("AddStorageAccountTags(%v) on account(%s) subsID(%s) rg(%s) failed with error: %v", tags, accountName, subsID, resourceGroup, rerr.Error())
+				}
+				// release volume lock first to prevent deadlock
+				d.volumeLocks.Release(volName)
+				// clean search cache
+				if err := d.accountSearchCache.Delete(lockKey); err != nil {
+					return nil, status.Errorf(codes.Internal, err.Error())
+				}
+				// remove the volName from the volMap to stop it matching the same storage account
+				d.volMap.Delete(volName)
+				skipAudit = true
+				return d.CreateVolume(ctx, req)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to create file share(%s) on account(%s) type(%s) subsID(%s) rg(%s) location(%s) size(%d), error: %v", validFileShareName, account, sku, subsID, resourceGroup, location, fileShareSize, err)
+		}
+		if err := d.verifyFileShareExists(ctx, subsID, resourceGroup, accountName, validFileShareName, shareOptions.RequestGiB, secret); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to verify file share(%s) on account(%s) after creation: %v", validFileShareName, accountName, err)
+		}
+		klog.V(2).Infof("create file share %s on storage account %s successfully", validFileShareName, accountName)
+	}
+
+	if strings.HasPrefix(strings.ToLower(sku), premium) {
+		iops, bandwidth := getPremiumFileShareProvisionedPerformance(shareOptions.RequestGiB)
+		setKeyValueInMap(parameters, provisionedIopsKey, strconv.Itoa(iops))
+		setKeyValueInMap(parameters, provisionedBandwidthMiBpsKey, strconv.Itoa(bandwidth))
+	}
+
+	if isDiskFsType(fsType) && !strings.HasSuffix(diskName, vhdSuffix) {
+		if accountKey == "" {
+			if accountKey, err = d.getStorageAccesskey(ctx, accountOptions, req.GetSecrets(), secretName, secretNamespaces); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
+			}
+		}
+		if fileShareName == "" {
+			// use pvc name as vhd disk name if file share not specified
+			diskName = validFileShareName + vhdSuffix
+		} else {
+			// use uuid as vhd disk name if file share specified
+			diskName = uuid.NewUUID().String() + vhdSuffix
+		}
+		diskSizeBytes := volumehelper.GiBToBytes(requestGiB)
+		klog.V(2).Infof("begin to create vhd file(%s) size(%d) on share(%s) on account(%s) type(%s) rg(%s) location(%s)",
+			diskName, diskSizeBytes, validFileShareName, account, sku, resourceGroup, location)
+		if err := createDisk(ctx, accountName, accountKey, storageEndpointSuffix, validFileShareName, diskName, diskSizeBytes, d.httpClient); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create VHD disk: %v", err)
+		}
+		klog.V(2).Infof("create vhd file(%s) size(%d) on share(%s) on account(%s) type(%s) rg(%s) location(%s) successfully",
+			diskName, diskSizeBytes, validFileShareName, account, sku, resourceGroup, location)
+		setKeyValueInMap(parameters, diskNameField, diskName)
+	}
+
+	if len(initialDirectories) > 0 {
+		if accountKey == "" {
+			if accountKey, err = d.getStorageAccesskey(ctx, accountOptions, req.GetSecrets(), secretName, secretNamespaces); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
+			}
+		}
+		if err := createInitialDirectories(ctx, accountName, accountKey, storageEndpointSuffix, validFileShareName, initialDirectories, d.httpClient); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create initial directories on file share(%s) on account(%s): %v", validFileShareName, accountName, err)
+		}
+	}
+
+	if storeAccountKey && len(req.GetSecrets()) == 0 {
+		secretCacheKey := accountName + secretName + secretNamespace
+		if useSeretCache {
+			cache, err := d.secretCacheMap.Get(secretCacheKey, azcache.CacheReadTypeDefault)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "get cache key(%s) failed with %v", secretCacheKey, err)
+			}
+			useSeretCache = (cache != nil)
+		}
+		if !useSeretCache {
+			if accountKey == "" {
+				if accountKey, err = d.getStorageAccesskey(ctx, accountOptions, req.GetSecrets(), secretName, secretNamespaces); err != nil {
+					return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
+				}
+			}
+			var connectionString string
+			if dataPlaneAuth == dataPlaneAuthConnectionString {
+				connectionString = buildConnectionString(accountName, accountKey, storageEndpointSuffix)
+			}
+			storeSecretName, err := d.setAzureCredentials(ctx, accountName, accountKey, connectionString, secretName, secretNamespace)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to store storage account key: %v", err)
+			}
+			if storeSecretName != "" {
+				klog.V(2).Infof("store account key to k8s secret(%v) in %s namespace", storeSecretName, secretNamespace)
+			}
+			d.secretCacheMap.Set(secretCacheKey, "")
+		}
+	}
+
+	var uuid string
+	if fileShareName != "" {
+		// add volume name as suffix to differentiate volumeID since "shareName" is specified
+		// not necessary for dynamic file share name creation since volumeID already contains volume name
+		uuid = volName
+	}
+	if useExistingShare {
+		// existing file share is not deleted in DeleteVolume
+		uuid = existingShareVolumePrefix + volName
+	}
+	volumeIDSegments := []string{resourceGroup, accountName, validFileShareName, diskName, uuid, secretNamespace}
+	if subsID != "" && subsID != d.cloud.SubscriptionID {
+		volumeIDSegments = append(volumeIDSegments, subsID)
+	}
+	volumeID = formatVolumeID(d.volumeHandleVersion, volumeIDSegments...)
+
+	if generateSASToken {
+		if accountKey == "" {
+			if accountKey, err = d.getStorageAccesskey(ctx, accountOptions, req.GetSecrets(), secretName, secretNamespaces); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
+			}
+		}
+		sasToken, err := createShareSASToken(ctx, accountName, accountKey, storageEndpointSuffix, validFileShareName, getStoredAccessPolicyID(volName), sasTokenPermissions, sasTokenExpiry, d.httpClient)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to generate SAS token of file share(%s) on account(%s): %v", validFileShareName, accountName, err)
+		}
+		sasSecretName := fmt.Sprintf(sasSecretNameTemplate, volName)
+		// secret is labeled with volumeID so that it's deleted in DeleteVolume
+		if err := d.setSASTokenSecret(ctx, accountName, sasToken, sasSecretName, secretNamespace, volumeID); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to store SAS token: %v", err)
+		}
+		setKeyValueInMap(parameters, sasTokenSecretNameKey, sasSecretName)
+	}
+
+	if useDataPlaneAPI {
+		d.dataPlaneAPIVolMap.Store(volumeID, "")
+	}
+
+	setSpanAttributes(ctx, volumeIDAttribute.String(volumeID), protocolAttribute.String(string(shareProtocol)))
+	isOperationSucceeded = true
+
+	// reset secretNamespace field in VolumeContext
+	setKeyValueInMap(parameters, secretNamespaceField, secretNamespace)
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:           volumeID,
+			CapacityBytes:      capacityBytes,
+			VolumeContext:      parameters,
+			AccessibleTopology: accessibleTopology,
+		},
+	}, nil
+}
+
+// ValidateCreateVolumeParameters validates storage class parameters in the same way as CreateVolume
+// without creating any resource, returns warnings of parameters which could not be fully validated
+// or would be changed by driver
+func (d *Driver) ValidateCreateVolumeParameters(parameters map[string]string) ([]string, error) {
+	parameters, err := d.expandProfile(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	parameters, warnings, err := canonicalizeParameters(parameters)
+	if err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+	p, err := d.parseCreateVolumeParameters(parameters)
+	if err != nil {
+		return warnings, err
+	}
+	validationWarnings, err := d.validateCreateVolumeParameters(p)
+	return append(warnings, validationWarnings...), err
+}
+
+// createVolumeParameters are storage class parameters of CreateVolume, node parameters are only kept for validation
+type createVolumeParameters struct {
+	sku                          string
+	location                     string
+	subsID                       string
+	resourceGroup                string
+	account                      string
+	fileShareName                string
+	diskName                     string
+	fsType                       string
+	protocol                     string
+	secretName                   string
+	secretNamespace              string
+	secretNamespaces             string
+	keyVaultURL                  string
+	keyVaultSecretName           string
+	useKey                       string
+	customTags                   string
+	storageEndpointSuffix        string
+	dataPlaneAuth                string
+	networkEndpointType          string
+	shareAccessTier              string
+	accountAccessTier            string
+	rootSquashType               string
+	minimumTLSVersion            string
+	vnetResourceGroup            string
+	vnetName                     string
+	subnetName                   string
+	shareNamePrefix              string
+	networkDefaultAction         string
+	pvcNamespace                 string
+	pvcName                      string
+	pvName                       string
+	storeAccountKey              bool
+	matchTags                    bool
+	createAccount                bool
+	useSecretCache               bool
+	useDataPlaneAPI              bool
+	restoreFromSoftDelete        bool
+	useExistingShare             bool
+	generateSASToken             bool
+	disableAccountKeyAuth        bool
+	enableLFS                    *bool
+	disableDeleteRetentionPolicy *bool
+	allowBlobPublicAccess        *bool
+	requireInfraEncryption       *bool
+	isMultichannelEnabled        *bool
+	shareMetadata                map[string]*string
+	operationTimeout             time.Duration
+	sasTokenExpiry               time.Duration
+	keyExpirationDays            int32
+	sasTokenPermissions          string
+	allowedIPs                   []string
+	initialDirectories           []string
+	// parameters only used in NodeStageVolume and NodePublishVolume
+	fsGroupChangePolicy   string
+	mountServerAddress    string
+	nfsExportPathTemplate string
+	dnsSearchDomain       string
+	useCredentialFile     bool
+	rootDirACL            string
+	nfsVersion            string
+	enableNFSACL          bool
+}
+
+// parseCreateVolumeParameters parses canonical storage class parameters (case-insensitive),
+// values which could not be parsed are returned as InvalidArgument errors
+func (d *Driver) parseCreateVolumeParameters(parameters map[string]string) (*createVolumeParameters, error) {
+	p := &createVolumeParameters{
+		// store account key to k8s secret by default
+		storeAccountKey: true,
+		// set allowBlobPublicAccess as false by default
+		allowBlobPublicAccess: pointer.Bool(false),
+		sasTokenPermissions:   defaultSASTokenPermissions,
+		sasTokenExpiry:        defaultSASTokenExpiry,
+	}
+	parseBool := func(field, v string) (*bool, error) {
+		value, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", field, v))
+		}
+		return &value, nil
+	}
+	var value *bool
+	var err error
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case skuNameField:
+			p.sku = v
+		case locationField:
+			p.location = v
+		case storageAccountField:
+			p.account = v
+		case subscriptionIDField:
+			p.subsID = v
+		case resourceGroupField:
+			p.resourceGroup = v
+		case shareNameField:
+			p.fileShareName = v
+		case diskNameField:
+			p.diskName = v
+		case fsTypeField:
+			p.fsType = v
+		case storeAccountKeyField:
+			if strings.EqualFold(v, falseValue) {
+				p.storeAccountKey = false
+			}
+		case secretNameField:
+			p.secretName = v
+		case secretNamespaceField:
+			p.secretNamespace = v
+		case secretNamespacesField:
+			p.secretNamespaces = v
+		case keyVaultURLField:
+			p.keyVaultURL = v
+		case keyVaultSecretNameField:
+			p.keyVaultSecretName = v
+		case useKeyField:
+			p.useKey = v
+		case protocolField:
+			p.protocol = v
+		case matchTagsField:
+			p.matchTags = strings.EqualFold(v, trueValue)
+		case tagsField:
+			p.customTags = v
+		case createAccountField:
+			p.createAccount = strings.EqualFold(v, trueValue)
+		case useSecretCacheField:
+			p.useSecretCache = strings.EqualFold(v, trueValue)
+		case enableLargeFileSharesField:
+			if p.enableLFS, err = parseBool(enableLargeFileSharesField, v); err != nil {
+				return nil, err
+			}
+		case useDataPlaneAPIField:
+			p.useDataPlaneAPI = strings.EqualFold(v, trueValue)
+		case disableDeleteRetentionPolicyField:
+			if p.disableDeleteRetentionPolicy, err = parseBool(disableDeleteRetentionPolicyField, v); err != nil {
+				return nil, err
+			}
+		case pvcNamespaceKey:
+			p.pvcNamespace = v
+		case pvcNameKey:
+			p.pvcName = v
+		case pvNameKey:
+			p.pvName = v
+		case storageEndpointSuffixField:
+			p.storageEndpointSuffix = v
+		case dataPlaneAuthField:
+			p.dataPlaneAuth = v
+		case networkEndpointTypeField:
+			p.networkEndpointType = v
+		case shareAccessTierField:
+			p.shareAccessTier = v
+		case accountAccessTierField:
+			p.accountAccessTier = v
+		case rootSquashTypeField:
+			p.rootSquashType = v
+		case allowBlobPublicAccessField:
+			if p.allowBlobPublicAccess, err = parseBool(allowBlobPublicAccessField, v); err != nil {
+				return nil, err
+			}
+		case serverNameField, folderNameField:
+			// no op, only used in NodeStageVolume
+		case mountServerAddressField:
+			p.mountServerAddress = v
+		case nfsExportPathTemplateField:
+			p.nfsExportPathTemplate = v
+		case dnsSearchDomainField:
+			p.dnsSearchDomain = v
+		case useCredentialFileField:
+			if value, err = parseBool(useCredentialFileField, v); err != nil {
+				return nil, err
+			}
+			p.useCredentialFile = *value
+		case rootDirACLField:
+			p.rootDirACL = v
+		case nfsVersionField:
+			p.nfsVersion = v
+		case enableNFSACLField:
+			if value, err = parseBool(enableNFSACLField, v); err != nil {
+				return nil, err
+			}
+			p.enableNFSACL = *value
+		case fsGroupChangePolicyField:
+			p.fsGroupChangePolicy = v
+		case mountOptionsConfigMapField:
+			if _, _, err := parseConfigMapRef(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", mountOptionsConfigMapField, err)
+			}
+		case windowsMountOptionsField, linuxMountOptionsField:
+			if _, err := parseOSMountOptions(strings.ToLower(k), v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		case mountTimeoutField:
+			if _, err := parseMountTimeout(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		case mountPermissionsField:
+			if _, err := strconv.ParseUint(v, 8, 32); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid mountPermissions %s in storage class", v))
+			}
+		case vnetResourceGroupField:
+			p.vnetResourceGroup = v
+		case vnetNameField:
+			p.vnetName = v
+		case subnetNameField:
+			p.subnetName = v
+		case shareNamePrefixField:
+			p.shareNamePrefix = v
+		case requireInfraEncryptionField:
+			if p.requireInfraEncryption, err = parseBool(requireInfraEncryptionField, v); err != nil {
+				return nil, err
+			}
+		case enableMultichannelField:
+			if p.isMultichannelEnabled, err = parseBool(enableMultichannelField, v); err != nil {
+				return nil, err
+			}
+		case minimumTLSVersionField:
+			p.minimumTLSVersion = v
+		case networkDefaultActionField:
+			p.networkDefaultAction = v
+		case shareMetadataField:
+			if p.shareMetadata, err = parseShareMetadata(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		case restoreFromSoftDeleteField:
+			if value, err = parseBool(restoreFromSoftDeleteField, v); err != nil {
+				return nil, err
+			}
+			p.restoreFromSoftDelete = *value
+		case useExistingShareField:
+			if value, err = parseBool(useExistingShareField, v); err != nil {
+				return nil, err
+			}
+			p.useExistingShare = *value
+		case operationTimeoutField:
+			if p.operationTimeout, err = parseOperationTimeout(v, d.maxOperationTimeout); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		case keyExpirationDaysField:
+			if p.keyExpirationDays, err = parseKeyExpirationDays(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		case allowedIPsField:
+			if p.allowedIPs, err = parseAllowedIPs(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		case generateSASTokenField:
+			if value, err = parseBool(generateSASTokenField, v); err != nil {
+				return nil, err
+			}
+			p.generateSASToken = *value
+		case sasTokenPermissionsField:
+			if p.sasTokenPermissions, err = parseSASTokenPermissions(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		case sasTokenExpiryField:
+			if p.sasTokenExpiry, err = parseSASTokenExpiry(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		case disableAccountKeyAuthField:
+			if value, err = parseBool(disableAccountKeyAuthField, v); err != nil {
+				return nil, err
+			}
+			p.disableAccountKeyAuth = *value
+		case initialDirectoriesField:
+			if p.initialDirectories, err = parseInitialDirectories(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", k))
+		}
+	}
+	return p, nil
+}
+
+// validateCreateVolumeParameters validates parsed storage class parameters, returns warnings of parameters
+// which could not be fully validated or would be changed by driver
+func (d *Driver) validateCreateVolumeParameters(p *createVolumeParameters) ([]string, error) {
+	var warnings []string
+	sku, fsType, protocol := p.sku, p.fsType, p.protocol
+
+	if p.restoreFromSoftDelete {
+		if p.fileShareName == "" {
+			return warnings, status.Errorf(codes.InvalidArgument, "shareName must be provided when restoreFromSoftDelete is true")
+		}
+		if p.useDataPlaneAPI {
+			return warnings, status.Errorf(codes.InvalidArgument, "restoreFromSoftDelete is not supported with useDataPlaneAPI")
+		}
+		if isDiskFsType(fsType) {
+			return warnings, status.Errorf(codes.InvalidArgument, "restoreFromSoftDelete is not supported with fsType(%s)", fsType)
+		}
+	}
+
+	if p.useExistingShare {
+		if p.fileShareName == "" || p.account == "" {
+			return warnings, status.Errorf(codes.InvalidArgument, "storageAccount and shareName must be provided when useExistingShare is true")
+		}
+		if p.restoreFromSoftDelete {
+			return warnings, status.Errorf(codes.InvalidArgument, "useExistingShare is not supported with restoreFromSoftDelete")
+		}
+		if p.useDataPlaneAPI {
+			return warnings, status.Errorf(codes.InvalidArgument, "useExistingShare is not supported with useDataPlaneAPI")
+		}
+		if isDiskFsType(fsType) {
+			return warnings, status.Errorf(codes.InvalidArgument, "useExistingShare is not supported with fsType(%s)", fsType)
+		}
+	}
+
+	if p.matchTags && p.account != "" {
+		return warnings, status.Errorf(codes.InvalidArgument, fmt.Sprintf("matchTags must set as false when storageAccount(%s) is provided", p.account))
+	}
+
+	if p.subsID != "" && p.resourceGroup == "" {
+		if d.cloud == nil {
+			warnings = append(warnings, fmt.Sprintf("resourceGroup must be provided if subscriptionID(%s) is not the subscription of the cluster", p.subsID))
+		} else if p.subsID != d.cloud.SubscriptionID {
+			return warnings, status.Errorf(codes.InvalidArgument, fmt.Sprintf("resourceGroup must be provided in cross subscription(%s)", p.subsID))
+		}
+	}
+
+	if !d.enableVHDDiskFeature && fsType != "" {
+		return warnings, status.Errorf(codes.InvalidArgument, "fsType storage class parameter enables experimental VDH disk feature which is currently disabled, use --enable-vhd driver option to enable it")
+	}
+
+	if !isSupportedFsType(fsType) {
+		return warnings, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported, supported fsType list: %v", fsType, supportedFsTypeList)
+	}
+
+	if protocol == auto {
+		// protocol is resolved by node OS in CreateVolume, so parameters must be valid with both smb and nfs protocols
+		if fsType != "" {
+			return warnings, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported with protocol(%s)", fsType, protocol)
+		}
+		if sku != "" && !strings.HasPrefix(strings.ToLower(sku), premium) {
+			return warnings, status.Errorf(codes.InvalidArgument, "skuName(%s) is not supported with protocol(%s) since nfs protocol only supports premium storage account", sku, protocol)
+		}
+		if p.useExistingShare {
+			return warnings, status.Errorf(codes.InvalidArgument, "%s is not supported with protocol(%s) since protocol of existing file share could not be changed", useExistingShareField, protocol)
+		}
+		for _, resolvedProtocol := range supportedProtocolList {
+			resolved := *p
+			resolved.protocol = resolvedProtocol
+			if _, err := d.validateCreateVolumeParameters(&resolved); err != nil {
+				return warnings, status.Errorf(codes.InvalidArgument, "protocol(%s) requires parameters valid with %s protocol: %s", protocol, resolvedProtocol, status.Convert(err).Message())
+			}
+		}
+		return warnings, nil
+	}
+
+	if !isSupportedProtocol(protocol) {
+		return warnings, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", protocol, supportedProtocolList)
+	}
+
+	if !isSupportedShareAccessTier(p.shareAccessTier) {
+		return warnings, status.Errorf(codes.InvalidArgument, "shareAccessTier(%s) is not supported, supported ShareAccessTier list: %v", p.shareAccessTier, storage.PossibleShareAccessTierValues())
+	}
+
+	if !isSupportedAccountAccessTier(p.accountAccessTier) {
+		return warnings, status.Errorf(codes.InvalidArgument, "accountAccessTier(%s) is not supported, supported AccountAccessTier list: %v", p.accountAccessTier, storage.PossibleAccessTierValues())
+	}
+
+	if !isSupportedRootSquashType(p.rootSquashType) {
+		return warnings, status.Errorf(codes.InvalidArgument, "rootSquashType(%s) is not supported, supported RootSquashType list: %v", p.rootSquashType, storage.PossibleRootSquashTypeValues())
+	}
+
+	if !isSupportedFSGroupChangePolicy(p.fsGroupChangePolicy) {
+		return warnings, status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", p.fsGroupChangePolicy, supportedFSGroupChangePolicyList)
+	}
+
+	if !isSupportedMinimumTLSVersion(p.minimumTLSVersion) {
+		return warnings, status.Errorf(codes.InvalidArgument, "minimumTlsVersion(%s) is not supported, supported MinimumTLSVersion list: %v", p.minimumTLSVersion, storage.PossibleMinimumTLSVersionValues())
+	}
+
+	if p.networkDefaultAction != "" && !strings.EqualFold(p.networkDefaultAction, string(storage.DefaultActionAllow)) && !strings.EqualFold(p.networkDefaultAction, string(storage.DefaultActionDeny)) {
+		return warnings, status.Errorf(codes.InvalidArgument, "networkDefaultAction(%s) is not supported, supported NetworkDefaultAction list: %v", p.networkDefaultAction, storage.PossibleDefaultActionValues())
+	}
+	if len(p.allowedIPs) > 0 && strings.EqualFold(p.networkDefaultAction, string(storage.DefaultActionAllow)) {
+		return warnings, status.Errorf(codes.InvalidArgument, "allowedIPs is only supported when networkDefaultAction is %s", storage.DefaultActionDeny)
+	}
+	if strings.EqualFold(p.networkDefaultAction, string(storage.DefaultActionDeny)) && len(p.allowedIPs) == 0 && p.subnetName == "" {
+		if d.cloud == nil {
+			warnings = append(warnings, fmt.Sprintf("subnetName in cloud config would be used when networkDefaultAction is %s, CreateVolume fails if it's empty", storage.DefaultActionDeny))
+		} else if d.cloud.SubnetName == "" {
+			return warnings, status.Errorf(codes.InvalidArgument, "subnetName or allowedIPs must be provided when networkDefaultAction is %s", storage.DefaultActionDeny)
+		}
+	}
+
+	if !isSupportedShareNamePrefix(p.shareNamePrefix) {
+		return warnings, status.Errorf(codes.InvalidArgument, "shareNamePrefix(%s) can only contain lowercase letters, numbers, hyphens, and length should be less than 21", p.shareNamePrefix)
+	}
+
+	if protocol == nfs && fsType != "" && fsType != nfs {
+		return warnings, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported with protocol(%s)", fsType, protocol)
+	}
+
+	if isDiskFsType(fsType) {
+		warnings = append(warnings, fmt.Sprintf("fsType(%s) enables experimental VHD disk feature", fsType))
+	}
+
+	if fsType == nfs || protocol == nfs {
+		protocol = nfs
+		if sku != "" && !strings.HasPrefix(strings.ToLower(sku), premium) {
+			warnings = append(warnings, fmt.Sprintf("skuName(%s) would be replaced by %s since nfs protocol only supports premium storage account", sku, storage.SkuNamePremiumLRS))
+		}
+	}
+
+	if err := d.validateSKU(sku, protocol); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := getDataPlaneAuth(p.dataPlaneAuth, protocol); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateMountServerAddress(p.mountServerAddress, protocol); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateNFSExportPathTemplate(p.nfsExportPathTemplate, protocol); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateDNSSearchDomain(p.dnsSearchDomain, protocol); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateNFSACL(p.enableNFSACL, protocol); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateNFSVersion(p.nfsVersion, protocol, p.enableNFSACL); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	accountOptions.Name = accountName
-	secret := req.GetSecrets()
-	if len(secret) == 0 && useDataPlaneAPI {
-		if accountKey == "" {
-			if accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, secret, secretName, secretNamespace); err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
-			}
-		}
-		secret = createStorageAccountSecret(accountName, accountKey)
-		// skip validating file share quota if useDataPlaneAPI
-	} else {
-		if quota, err := d.getFileShareQuota(ctx, subsID, resourceGroup, accountName, validFileShareName, secret); err != nil {
-			return nil, status.Errorf(codes.Internal, err.Error())
-		} else if quota != -1 && quota < fileShareSize {
-			return nil, status.Errorf(codes.AlreadyExists, "request file share(%s) already exists, but its capacity %d is smaller than %d", validFileShareName, quota, fileShareSize)
-		}
+	if err := validateUseCredentialFile(p.useCredentialFile, protocol); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	shareOptions := &fileclient.ShareOptions{
-		Name:       validFileShareName,
-		Protocol:   shareProtocol,
-		RequestGiB: fileShareSize,
-		AccessTier: shareAccessTier,
-		RootSquash: rootSquashType,
+	if err := validateRootDirACL(p.rootDirACL, protocol); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	var volumeID string
-	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_create_volume", d.cloud.ResourceGroup, subsID, d.Name)
-	isOperationSucceeded := false
-	defer func() {
-		mc.ObserveOperationWithResult(isOperationSucceeded, VolumeID, volumeID)
-	}()
+	if err := validateSecretNamespaces(p.secretNamespaces, p.secretNamespace); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
 
-	klog.V(2).Infof("begin to create file share(%s) on account(%s) type(%s) subID(%s) rg(%s) location(%s) size(%d) protocol(%s)", validFileShareName, accountName, sku, subsID, resourceGroup, location, fileShareSize, shareProtocol)
-	if err := d.CreateFileShare(ctx, accountOptions, shareOptions, secret); err != nil {
-		if strings.Contains(err.Error(), accountLimitExceedManagementAPI) || strings.Contains(err.Error(), accountLimitExceedDataPlaneAPI) {
-			klog.Warningf("create file share(%s) on account(%s) type(%s) subID(%s) rg(%s) location(%s) size(%d), error: %v, skip matching current account", validFileShareName, accountName, sku, subsID, resourceGroup, location, fileShareSize, err)
-			tags := map[string]*string{
-				azure.SkipMatchingTag: pointer.String(""),
-			}
-			if rerr := d.cloud.AddStorageAccountTags(ctx, subsID, resourceGroup, accountName, tags); rerr != nil {
-				klog.Warningf("AddStorageAccountTags(%v) on account(%s) subsID(%s) rg(%s) failed with error: %v", tags, accountName, subsID, resourceGroup, rerr.Error())
-			}
-			// release volume lock first to prevent deadlock
-			d.volumeLocks.Release(volName)
-			// clean search cache
-			if err := d.accountSearchCache.Delete(lockKey); err != nil {
-				return nil, status.Errorf(codes.Internal, err.Error())
-			}
-			// remove the volName from the volMap to stop it matching the same storage account
-			d.volMap.Delete(volName)
-			return d.CreateVolume(ctx, req)
+	if err := validateKeyVaultSecret(p.keyVaultURL, p.keyVaultSecretName, getKeyVaultEnvironment(d.cloud).KeyVaultDNSSuffix); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateUseKey(p.useKey, p.keyVaultURL, p.storeAccountKey); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if p.generateSASToken && protocol == nfs {
+		return warnings, status.Errorf(codes.InvalidArgument, "generateSasToken is only supported with smb protocol")
+	}
+
+	keyAuthDisabledBy := getAccountKeyAuthDisabledBy(p.disableAccountKeyAuth, p.dataPlaneAuth)
+	if keyAuthDisabledBy != "" {
+		if err := validateDisableAccountKeyAuth(keyAuthDisabledBy, p.dataPlaneAuth, fsType, p.useDataPlaneAPI, p.generateSASToken); err != nil {
+			return warnings, status.Error(codes.InvalidArgument, err.Error())
 		}
-		return nil, status.Errorf(codes.Internal, "failed to create file share(%s) on account(%s) type(%s) subsID(%s) rg(%s) location(%s) size(%d), error: %v", validFileShareName, account, sku, subsID, resourceGroup, location, fileShareSize, err)
 	}
-	klog.V(2).Infof("create file share %s on storage account %s successfully", validFileShareName, accountName)
 
-	if isDiskFsType(fsType) && !strings.HasSuffix(diskName, vhdSuffix) {
-		if accountKey == "" {
-			if accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, req.GetSecrets(), secretName, secretNamespace); err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
-			}
+	if len(p.initialDirectories) > 0 {
+		if protocol == nfs {
+			return warnings, status.Errorf(codes.InvalidArgument, "%s is only supported with smb protocol", initialDirectoriesField)
 		}
-		if fileShareName == "" {
-			// use pvc name as vhd disk name if file share not specified
-			diskName = validFileShareName + vhdSuffix
-		} else {
-			// use uuid as vhd disk name if file share specified
-			diskName = uuid.NewUUID().String() + vhdSuffix
+		if isDiskFsType(fsType) {
+			return warnings, status.Errorf(codes.InvalidArgument, "%s is not supported with vhd disk fsType(%s)", initialDirectoriesField, fsType)
 		}
-		diskSizeBytes := volumehelper.GiBToBytes(requestGiB)
-		klog.V(2).Infof("begin to create vhd file(%s) size(%d) on share(%s) on account(%s) type(%s) rg(%s) location(%s)",
-			diskName, diskSizeBytes, validFileShareName, account, sku, resourceGroup, location)
-		if err := createDisk(ctx, accountName, accountKey, d.cloud.Environment.StorageEndpointSuffix, validFileShareName, diskName, diskSizeBytes); err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to create VHD disk: %v", err)
+		if keyAuthDisabledBy != "" {
+			return warnings, status.Errorf(codes.InvalidArgument, "%s is not supported with %s since directories are created by account key", initialDirectoriesField, keyAuthDisabledBy)
 		}
-		klog.V(2).Infof("create vhd file(%s) size(%d) on share(%s) on account(%s) type(%s) rg(%s) location(%s) successfully",
-			diskName, diskSizeBytes, validFileShareName, account, sku, resourceGroup, location)
-		setKeyValueInMap(parameters, diskNameField, diskName)
 	}
 
-	if storeAccountKey && len(req.GetSecrets()) == 0 {
-		secretCacheKey := accountName + secretName + secretNamespace
-		if useSeretCache {
-			cache, err := d.secretCacheMap.Get(secretCacheKey, azcache.CacheReadTypeDefault)
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "get cache key(%s) failed with %v", secretCacheKey, err)
-			}
-			useSeretCache = (cache != nil)
+	if pointer.BoolDeref(p.isMultichannelEnabled, false) {
+		if sku != "" && !strings.HasPrefix(strings.ToLower(sku), premium) && protocol != nfs {
+			return warnings, status.Errorf(codes.InvalidArgument, "smb multichannel is only supported with premium account, current account type: %s", sku)
 		}
-		if !useSeretCache {
-			if accountKey == "" {
-				if accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, req.GetSecrets(), secretName, secretNamespace); err != nil {
-					return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
-				}
-			}
-			storeSecretName, err := d.SetAzureCredentials(ctx, accountName, accountKey, secretName, secretNamespace)
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to store storage account key: %v", err)
-			}
-			if storeSecretName != "" {
-				klog.V(2).Infof("store account key to k8s secret(%v) in %s namespace", storeSecretName, secretNamespace)
-			}
-			d.secretCacheMap.Set(secretCacheKey, "")
+		if protocol == nfs {
+			return warnings, status.Errorf(codes.InvalidArgument, "smb multichannel is only supported with smb protocol, current protocol: %s", protocol)
 		}
 	}
 
-	var uuid string
-	if fileShareName != "" {
-		// add volume name as suffix to differentiate volumeID since "shareName" is specified
-		// not necessary for dynamic file share name creation since volumeID already contains volume name
-		uuid = volName
-	}
-	volumeID = fmt.Sprintf(volumeIDTemplate, resourceGroup, accountName, validFileShareName, diskName, uuid, secretNamespace)
-	if subsID != "" && subsID != d.cloud.SubscriptionID {
-		volumeID = volumeID + "#" + subsID
+	tags, err := ConvertTagsToMap(p.customTags)
+	if err != nil {
+		return warnings, status.Errorf(codes.InvalidArgument, err.Error())
 	}
-
-	if useDataPlaneAPI {
-		d.dataPlaneAPIVolMap.Store(volumeID, "")
+	if err := d.validateRequiredTags(tags); err != nil {
+		return warnings, status.Error(codes.InvalidArgument, err.Error())
 	}
-
-	isOperationSucceeded = true
-
-	// reset secretNamespace field in VolumeContext
-	setKeyValueInMap(parameters, secretNamespaceField, secretNamespace)
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: capacityBytes,
-			VolumeContext: parameters,
-		},
-	}, nil
+	return warnings, nil
 }
 
 // DeleteVolume delete an azure file
 func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
+	// requests failed in validation are audited as well
+	isOperationSucceeded := false
+	defer func() {
+		d.emitAuditEvent(auditDeleteVolume, volumeID, "", "", nil, isOperationSucceeded)
+	}()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
@@ -609,9 +1279,16 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 		return &csi.DeleteVolumeResponse{}, nil
 	}
 
-	if resourceGroupName == "" {
-		resourceGroupName = d.cloud.ResourceGroup
+	if isExistingShareVolume(volumeID) {
+		klog.V(2).Infof("skip deleting existing file share(%s) under account(%s) rg(%s) of volume(%s)", fileShareName, accountName, resourceGroupName, volumeID)
+		if err := d.deleteSASTokenSecrets(ctx, volumeID, secretNamespace); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to delete SAS token secret of volume(%s): %v", volumeID, err)
+		}
+		isOperationSucceeded = true
+		return &csi.DeleteVolumeResponse{}, nil
 	}
+
+	resourceGroupName, rgSubstituted := d.getResourceGroupOfVolume(volumeID, resourceGroupName, subsID)
 	if subsID == "" {
 		subsID = d.cloud.SubscriptionID
 	}
@@ -632,15 +1309,59 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	}
 
 	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_delete_volume", resourceGroupName, subsID, d.Name)
-	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, VolumeID, volumeID)
 	}()
 
+	if rgSubstituted && len(secret) == 0 {
+		// file share not found in storage account of a resource group which is not encoded in volume handle
+		// should not be regarded as deleted silently, make sure storage account exists in that resource group first
+		deleted, err := d.isStorageAccountDeleted(ctx, subsID, resourceGroupName, accountName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check whether account(%s) rg(%s) exists: %v", accountName, resourceGroupName, err)
+		}
+		if deleted {
+			// volume is regarded as deleted as required by CSI, otherwise DeleteVolume would be retried forever
+			klog.Warningf("account(%s) of volume(%s) is not found in resource group(%s) of driver config, file share(%s) is regarded as deleted, it should be deleted manually if it still exists in another resource group", accountName, volumeID, resourceGroupName, fileShareName)
+			isOperationSucceeded = true
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+	}
+
+	if d.snapshotBeforeDelete {
+		if err := d.createSnapshotBeforeDelete(ctx, volumeID, subsID, resourceGroupName, accountName, fileShareName, secret); err != nil {
+			// snapshot before delete is best effort, should not block volume deletion
+			klog.Warningf("failed to create snapshot before deleting file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+		}
+	}
+
 	if err := d.DeleteFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName, secret); err != nil {
+		deleted, derr := d.isStorageAccountDeleted(ctx, subsID, resourceGroupName, accountName)
+		if derr != nil {
+			klog.Warningf("failed to check whether account(%s) rg(%s) is deleted: %v", accountName, resourceGroupName, derr)
+		}
+		if deleted {
+			// file share could not exist without storage account
+			klog.Warningf("DeleteFileShare %s under account(%s) rg(%s) failed with error(%v), account does not exist or is being deleted, return as success", fileShareName, accountName, resourceGroupName, err)
+			isOperationSucceeded = true
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		if d.enableOrphanGC {
+			if merr := d.markFileSharePendingDelete(ctx, subsID, resourceGroupName, accountName, fileShareName); merr != nil {
+				klog.Warningf("failed to mark file share(%s) under account(%s) rg(%s) as pending delete: %v", fileShareName, accountName, resourceGroupName, merr)
+			}
+		}
 		return nil, status.Errorf(codes.Internal, "DeleteFileShare %s under account(%s) rg(%s) failed with error: %v", fileShareName, accountName, resourceGroupName, err)
 	}
+	if d.waitForShareDelete {
+		if err := d.waitForFileShareDeleted(ctx, subsID, resourceGroupName, accountName, fileShareName, secret); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to wait for deletion of file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+		}
+	}
 	klog.V(2).Infof("azure file(%s) under subsID(%s) rg(%s) account(%s) volume(%s) is deleted successfully", fileShareName, subsID, resourceGroupName, accountName, volumeID)
+	if err := d.deleteSASTokenSecrets(ctx, volumeID, secretNamespace); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete SAS token secret of volume(%s): %v", volumeID, err)
+	}
 	if err := d.RemoveStorageAccountTag(ctx, subsID, resourceGroupName, accountName, azure.SkipMatchingTag); err != nil {
 		klog.Warningf("RemoveStorageAccountTag(%s) under rg(%s) account(%s) failed with %v", azure.SkipMatchingTag, resourceGroupName, accountName, err)
 	}
@@ -649,6 +1370,153 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+// ensureStorageAccount finds a matching storage account or creates a new one with generated account name,
+// account name is regenerated if it's already taken by another subscription or resource group
+func (d *Driver) ensureStorageAccount(ctx context.Context, accountOptions *azure.AccountOptions) (string, string, error) {
+	var accountName, accountKey string
+	var err error
+	for i := 0; i <= maxAccountNameRetries; i++ {
+		err = wait.ExponentialBackoff(d.getProvisionBackoff(), func() (bool, error) {
+			var retErr error
+			accountName, accountKey, retErr = d.cloud.EnsureStorageAccount(withAccountSelection(ctx, accountOptions), accountOptions, defaultAccountNamePrefix)
+			if isRetriableError(retErr) {
+				klog.Warningf("EnsureStorageAccount(%s) failed with error(%v), waiting for retrying", accountOptions.Name, retErr)
+				sleepIfThrottled(retErr, accountOpThrottlingSleepSec)
+				return false, nil
+			}
+			return true, retErr
+		})
+		if !isAccountNameUnavailableError(err) {
+			return accountName, accountKey, err
+		}
+		if i < maxAccountNameRetries {
+			klog.Warningf("generated storage account name is unavailable, error(%v), regenerating account name and retrying(%d/%d)", err, i+1, maxAccountNameRetries)
+		}
+	}
+	return "", "", fmt.Errorf("generated storage account names are unavailable after %d retries, last error: %w", maxAccountNameRetries, err)
+}
+
+// ensureSingleAccount ensures the single storage account exists, it's created with accountOptions if it does not exist,
+// account is only ensured once and concurrent calls wait for the first one, account key is returned if account is ensured in this call
+func (d *Driver) ensureSingleAccount(ctx context.Context, accountOptions *azure.AccountOptions) (string, error) {
+	d.singleAccountLock.Lock()
+	defer d.singleAccountLock.Unlock()
+	if d.singleAccountEnsured {
+		return "", nil
+	}
+	options := *accountOptions
+	options.Name = d.singleAccountName
+	options.CreateAccount = true
+	_, accountKey, err := d.ensureStorageAccount(ctx, &options)
+	if err != nil {
+		return "", err
+	}
+	// existing account is not updated by ensureStorageAccount, its properties are checked against later requests
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, options.SubscriptionID, options.ResourceGroup, d.singleAccountName)
+	if rerr != nil {
+		return "", fmt.Errorf("failed to get properties of storage account(%s): %v", d.singleAccountName, rerr.Error())
+	}
+	if account.Sku != nil {
+		d.singleAccountSKU = string(account.Sku.Name)
+	}
+	d.singleAccountSupportsNFS = accountSupportsNFS(account)
+	klog.V(2).Infof("single storage account(%s) sku(%s) is ensured in resource group(%s)", d.singleAccountName, d.singleAccountSKU, options.ResourceGroup)
+	d.singleAccountEnsured = true
+	return accountKey, nil
+}
+
+// accountSupportsNFS returns true if nfs file share could be created in storage account,
+// nfs file share is only supported by premium account with secure transfer disabled
+func accountSupportsNFS(account storage.Account) bool {
+	return account.Kind == storage.KindFileStorage && account.AccountProperties != nil &&
+		!pointer.BoolDeref(account.AccountProperties.EnableHTTPSTrafficOnly, true)
+}
+
+// checkAccountSupportsNFS checks that existing storage account supports nfs protocol, smb protocol is supported by any account
+func (d *Driver) checkAccountSupportsNFS(ctx context.Context, subsID, resourceGroup, accountName string) error {
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+	if d.cloud.StorageAccountClient == nil {
+		return fmt.Errorf("StorageAccountClient is nil")
+	}
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get properties of storage account(%s): %v", accountName, rerr.Error())
+	}
+	if !accountSupportsNFS(account) {
+		return fmt.Errorf("nfs protocol is not supported by storage account(%s), it requires premium account with secure transfer disabled", accountName)
+	}
+	return nil
+}
+
+// checkSingleAccount checks that sku and protocol of request do not conflict with the single storage account,
+// any sku is accepted if sku is empty
+func (d *Driver) checkSingleAccount(sku, protocol string) error {
+	d.singleAccountLock.Lock()
+	defer d.singleAccountLock.Unlock()
+	if sku != "" && !strings.EqualFold(sku, d.singleAccountSKU) {
+		return fmt.Errorf("skuName(%s) conflicts with skuName(%s) of single storage account(%s)", sku, d.singleAccountSKU, d.singleAccountName)
+	}
+	if protocol == nfs && !d.singleAccountSupportsNFS {
+		return fmt.Errorf("nfs protocol is not supported by single storage account(%s), it requires premium account with secure transfer disabled", d.singleAccountName)
+	}
+	return nil
+}
+
+// ensureStorageAccountInRegions ensures storage account in regions by order, storage account in next region is only tried
+// when it fails in previous region due to capacity or quota of region, other errors are returned directly,
+// accountOptions.Location is set as the region of returned storage account
+func (d *Driver) ensureStorageAccountInRegions(ctx context.Context, accountOptions *azure.AccountOptions, regions []string) (string, string, error) {
+	if len(regions) <= 1 {
+		return d.ensureStorageAccount(ctx, accountOptions)
+	}
+	var errs []string
+	for _, region := range regions {
+		accountOptions.Location = region
+		accountName, accountKey, err := d.ensureStorageAccount(ctx, accountOptions)
+		if err == nil {
+			return accountName, accountKey, nil
+		}
+		if !isRegionCapacityError(err) {
+			return "", "", err
+		}
+		klog.Warningf("failed to ensure storage account in region(%s): %v, try next region", region, err)
+		errs = append(errs, fmt.Sprintf("region(%s): %v", region, err))
+	}
+	return "", "", fmt.Errorf("failed to ensure storage account in regions(%v): %s", regions, strings.Join(errs, "; "))
+}
+
+// getPreferredRegions returns regions of requested topology without duplication,
+// regions of preferred topology come first and then regions of requisite topology
+func getPreferredRegions(requirement *csi.TopologyRequirement) []string {
+	var regions []string
+	exists := map[string]bool{}
+	for _, topology := range append(requirement.GetPreferred(), requirement.GetRequisite()...) {
+		region := topology.GetSegments()[topologyRegionKey]
+		if region == "" || exists[strings.ToLower(region)] {
+			continue
+		}
+		exists[strings.ToLower(region)] = true
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+// getPreferredNodeOS returns node OS of requested topology, node OS of preferred topology comes first,
+// empty string is returned if node OS is not in requested topology
+func getPreferredNodeOS(requirement *csi.TopologyRequirement) string {
+	for _, topology := range append(requirement.GetPreferred(), requirement.GetRequisite()...) {
+		if nodeOS := topology.GetSegments()[topologyOSKey]; nodeOS != "" {
+			return nodeOS
+		}
+	}
+	return ""
+}
+
 // ControllerGetVolume get volume
 func (d *Driver) ControllerGetVolume(context.Context, *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
@@ -760,8 +1628,8 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	storageEndpointSuffix := d.cloud.Environment.StorageEndpointSuffix
-	fileURL, err := getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName)
+	storageEndpointSuffix := getStorageEndpointSuffix(d.cloud)
+	fileURL, err := getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName, d.httpClient)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("getFileURL(%s,%s,%s,%s) returned with error: %v", accountName, storageEndpointSuffix, fileShareName, diskName, err))
 	}
@@ -812,8 +1680,8 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	storageEndpointSuffix := d.cloud.Environment.StorageEndpointSuffix
-	fileURL, err := getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName)
+	storageEndpointSuffix := getStorageEndpointSuffix(d.cloud)
+	fileURL, err := getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName, d.httpClient)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("getFileURL(%s,%s,%s,%s) returned with error: %v", accountName, storageEndpointSuffix, fileShareName, diskName, err))
 	}
@@ -843,18 +1711,19 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("GetFileShareInfo(%s) failed with error: %v", sourceVolumeID, err))
 	}
-	if rgName == "" {
-		rgName = d.cloud.ResourceGroup
-	}
+	rgName, _ = d.getResourceGroupOfVolume(sourceVolumeID, rgName, subsID)
 	if subsID == "" {
 		subsID = d.cloud.SubscriptionID
 	}
 
 	var useDataPlaneAPI bool
+	var parentSnapshotID string
 	for k, v := range req.GetParameters() {
 		switch strings.ToLower(k) {
 		case useDataPlaneAPIField:
 			useDataPlaneAPI = strings.EqualFold(v, trueValue)
+		case parentSnapshotField:
+			parentSnapshotID = v
 		default:
 			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", k))
 		}
@@ -866,6 +1735,23 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		mc.ObserveOperationWithResult(isOperationSucceeded, SourceResourceID, sourceVolumeID, SnapshotName, snapshotName)
 	}()
 
+	metadata := map[string]string{snapshotNameKey: snapshotName}
+	if parentSnapshotID != "" {
+		// parent snapshot must be an existing snapshot of the same file share
+		parentSnapshot := strings.TrimPrefix(parentSnapshotID, sourceVolumeID+separator)
+		if parentSnapshot == parentSnapshotID || parentSnapshot == "" || strings.Contains(parentSnapshot, separator) {
+			return nil, status.Errorf(codes.InvalidArgument, "parent snapshot(%s) is not a snapshot of source volume(%s)", parentSnapshotID, sourceVolumeID)
+		}
+		parentExists, err := d.shareSnapshotExists(ctx, sourceVolumeID, subsID, rgName, accountName, fileShareName, parentSnapshot, req.GetSecrets(), useDataPlaneAPI)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check if parent snapshot(%s) exists: %v", parentSnapshotID, err)
+		}
+		if !parentExists {
+			return nil, status.Errorf(codes.InvalidArgument, "parent snapshot(%s) does not exist", parentSnapshotID)
+		}
+		metadata[parentSnapshotKey] = parentSnapshotID
+	}
+
 	exists, itemSnapshot, itemSnapshotTime, itemSnapshotQuota, err := d.snapshotExists(ctx, sourceVolumeID, snapshotName, req.GetSecrets(), useDataPlaneAPI)
 	if err != nil {
 		if exists {
@@ -877,7 +1763,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		klog.V(2).Infof("snapshot(%s) already exists", snapshotName)
 		return &csi.CreateSnapshotResponse{
 			Snapshot: &csi.Snapshot{
-				SizeBytes:      volumehelper.GiBToBytes(int64(itemSnapshotQuota)),
+				SizeBytes:      d.getSnapshotSizeBytes(ctx, sourceVolumeID, subsID, rgName, accountName, fileShareName, itemSnapshotQuota, req.GetSecrets(), useDataPlaneAPI),
 				SnapshotId:     sourceVolumeID + "#" + itemSnapshot,
 				SourceVolumeId: sourceVolumeID,
 				CreationTime:   timestamppb.New(itemSnapshotTime),
@@ -893,7 +1779,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 			return nil, status.Errorf(codes.Internal, "failed to get share url with (%s): %v", sourceVolumeID, err)
 		}
 
-		snapshotShare, err := shareURL.CreateSnapshot(ctx, azfile.Metadata{snapshotNameKey: snapshotName})
+		snapshotShare, err := shareURL.CreateSnapshot(ctx, azfile.Metadata(metadata))
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "create snapshot from(%s) failed with %v, shareURL: %q", sourceVolumeID, err, shareURL)
 		}
@@ -907,7 +1793,11 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		itemSnapshotTime = properties.LastModified()
 		itemSnapshotQuota = properties.Quota()
 	} else {
-		snapshotShare, err := d.cloud.FileClient.WithSubscriptionID(subsID).CreateFileShare(ctx, rgName, accountName, &fileclient.ShareOptions{Name: fileShareName, RequestGiB: defaultAzureFileQuota, Metadata: map[string]*string{snapshotNameKey: &snapshotName}}, snapshotsExpand)
+		shareMetadata := map[string]*string{}
+		for k, v := range metadata {
+			shareMetadata[k] = pointer.String(v)
+		}
+		snapshotShare, err := d.cloud.FileClient.WithSubscriptionID(subsID).CreateFileShare(ctx, rgName, accountName, &fileclient.ShareOptions{Name: fileShareName, RequestGiB: defaultAzureFileQuota, Metadata: shareMetadata}, snapshotsExpand)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "create snapshot from(%s) failed with %v, accountName: %q", sourceVolumeID, err, accountName)
 		}
@@ -924,7 +1814,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	klog.V(2).Infof("Created share snapshot: %s", itemSnapshot)
 	createResp := &csi.CreateSnapshotResponse{
 		Snapshot: &csi.Snapshot{
-			SizeBytes:      volumehelper.GiBToBytes(int64(itemSnapshotQuota)),
+			SizeBytes:      d.getSnapshotSizeBytes(ctx, sourceVolumeID, subsID, rgName, accountName, fileShareName, itemSnapshotQuota, req.GetSecrets(), useDataPlaneAPI),
 			SnapshotId:     sourceVolumeID + "#" + itemSnapshot,
 			SourceVolumeId: sourceVolumeID,
 			CreationTime:   timestamppb.New(itemSnapshotTime),
@@ -953,9 +1843,7 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 		return nil, status.Errorf(codes.Internal, "failed to get snapshot name with (%s): %v", req.SnapshotId, err)
 	}
 
-	if rgName == "" {
-		rgName = d.cloud.ResourceGroup
-	}
+	rgName, _ = d.getResourceGroupOfVolume(req.SnapshotId, rgName, "")
 	subsID := d.cloud.SubscriptionID
 	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_delete_snapshot", rgName, subsID, d.Name)
 	isOperationSucceeded := false
@@ -998,10 +1886,18 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 // ControllerExpandVolume controller expand volume
 func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
+	// requests failed in validation are audited as well
+	isOperationSucceeded := false
+	defer func() {
+		d.emitAuditEvent(auditExpandVolume, volumeID, "", "", nil, isOperationSucceeded)
+	}()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
-	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
+	capacityBytes, err := validateCapacityRange(req.GetCapacityRange())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	if capacityBytes == 0 {
 		return nil, status.Error(codes.InvalidArgument, "volume capacity range missing in request")
 	}
@@ -1009,6 +1905,9 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid expand volume request: %v", req)
 	}
+	if err := d.validateMaxShareQuota(requestGiB); err != nil {
+		return nil, err
+	}
 
 	resourceGroupName, accountName, fileShareName, diskName, secretNamespace, subsID, err := GetFileShareInfo(volumeID)
 	if err != nil {
@@ -1018,15 +1917,12 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		// todo: figure out how to support vhd disk resize
 		return nil, status.Error(codes.Unimplemented, fmt.Sprintf("vhd disk volume(%s, diskName:%s) is not supported on ControllerExpandVolume", volumeID, diskName))
 	}
-	if resourceGroupName == "" {
-		resourceGroupName = d.cloud.ResourceGroup
-	}
+	resourceGroupName, _ = d.getResourceGroupOfVolume(volumeID, resourceGroupName, subsID)
 	if subsID == "" {
 		subsID = d.cloud.SubscriptionID
 	}
 
 	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_expand_volume", resourceGroupName, subsID, d.Name)
-	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, VolumeID, volumeID)
 	}()
@@ -1045,13 +1941,37 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		secrets = createStorageAccountSecret(accountName, accountKey)
 	}
 
+	currentQuota, shareProtocol, err := d.getFileShareQuotaAndProtocol(ctx, subsID, resourceGroupName, accountName, fileShareName, secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error checking quota of volume(%s): %v", volumeID, err)
+	}
+	if currentQuota == -1 {
+		return nil, status.Errorf(codes.NotFound, "the requested volume(%s) does not exist.", volumeID)
+	}
+	currentBytes := volumehelper.GiBToBytes(int64(currentQuota))
+	if limitBytes := req.GetCapacityRange().GetLimitBytes(); limitBytes > 0 && currentBytes > limitBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "shrinking volume(%s) from %d bytes to limit %d bytes is not supported", volumeID, currentBytes, limitBytes)
+	}
+	// only nfs file share requires node expansion
+	nodeExpansionRequired := shareProtocol == storage.EnabledProtocolsNFS
+
+	if int64(currentQuota) >= requestGiB {
+		isOperationSucceeded = true
+		klog.V(2).Infof("ControllerExpandVolume(%s) skipped since current quota(%d Gi) is already greater or equal than requested size(%d Gi)", volumeID, currentQuota, requestGiB)
+		return &csi.ControllerExpandVolumeResponse{CapacityBytes: currentBytes, NodeExpansionRequired: nodeExpansionRequired}, nil
+	}
+
 	if err = d.ResizeFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName, int(requestGiB), secrets); err != nil {
 		return nil, status.Errorf(codes.Internal, "expand volume error: %v", err)
 	}
+	// new quota may not be reported right after resize, confirm it before returning
+	if err = d.verifyFileShareExists(ctx, subsID, resourceGroupName, accountName, fileShareName, int(requestGiB), secrets); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to verify quota of volume(%s) after expansion: %v", volumeID, err)
+	}
 
 	isOperationSucceeded = true
 	klog.V(2).Infof("ControllerExpandVolume(%s) successfully, currentQuota: %d Gi", volumeID, int(requestGiB))
-	return &csi.ControllerExpandVolumeResponse{CapacityBytes: capacityBytes}, nil
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: capacityBytes, NodeExpansionRequired: nodeExpansionRequired}, nil
 }
 
 // getShareURL: sourceVolumeID is the id of source file share, returns a ShareURL of source file share.
@@ -1081,7 +2001,7 @@ func (d *Driver) getServiceURL(ctx context.Context, sourceVolumeID string, secre
 		return azfile.ServiceURL{}, "", err
 	}
 
-	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, accountName, d.cloud.Environment.StorageEndpointSuffix))
+	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, accountName, getStorageEndpointSuffix(d.cloud)))
 	if err != nil {
 		klog.Errorf("parse serviceURLTemplate error: %v", err)
 		return azfile.ServiceURL{}, "", err
@@ -1090,11 +2010,43 @@ func (d *Driver) getServiceURL(ctx context.Context, sourceVolumeID string, secre
 		return azfile.ServiceURL{}, "", fmt.Errorf("url is nil")
 	}
 
-	serviceURL := azfile.NewServiceURL(*u, azfile.NewPipeline(credential, azfile.PipelineOptions{}))
+	serviceURL := azfile.NewServiceURL(*u, newFilePipeline(credential, azfile.PipelineOptions{}, d.httpClient))
 
 	return serviceURL, fileShareName, nil
 }
 
+// createSnapshotBeforeDelete creates a snapshot of the file share before it's deleted, the snapshot is tagged with
+// the deleted volume ID in metadata for manual recovery. Snapshots are deleted together with the file share,
+// so the snapshot is only created if share soft delete is enabled, it could be recovered with the soft deleted share
+func (d *Driver) createSnapshotBeforeDelete(ctx context.Context, volumeID, subsID, resourceGroupName, accountName, fileShareName string, secrets map[string]string) error {
+	if len(secrets) > 0 {
+		// file share with snapshots could not be deleted by data plane API
+		return fmt.Errorf("snapshot before delete is not supported with data plane API")
+	}
+	enabled, err := d.isShareSoftDeleteEnabled(ctx, subsID, resourceGroupName, accountName)
+	if err != nil {
+		return fmt.Errorf("failed to check share soft delete: %v", err)
+	}
+	if !enabled {
+		return fmt.Errorf("share soft delete is not enabled on account(%s), snapshot would be deleted together with file share", accountName)
+	}
+
+	snapshotName := snapshotBeforeDeleteName
+	shareOptions := &fileclient.ShareOptions{
+		Name:       fileShareName,
+		RequestGiB: defaultAzureFileQuota,
+		Metadata:   map[string]*string{snapshotNameKey: &snapshotName, deletedVolumeIDKey: &volumeID},
+	}
+	snapshotShare, err := d.cloud.FileClient.WithSubscriptionID(subsID).CreateFileShare(ctx, resourceGroupName, accountName, shareOptions, snapshotsExpand)
+	if err != nil {
+		return err
+	}
+	if snapshotShare.FileShareProperties != nil && snapshotShare.SnapshotTime != nil {
+		klog.V(2).Infof("created snapshot(%s) of file share(%s) before deletion", snapshotShare.SnapshotTime.Format(snapshotTimeFormat), fileShareName)
+	}
+	return nil
+}
+
 // snapshotExists: sourceVolumeID is the id of source file share, returns the existence of snapshot and its detail info.
 // Since `ListSharesSegment` lists all file shares and snapshots, the process of checking existence is divided into two steps.
 // 1. Judge if the specify snapshot name already exists.
@@ -1136,6 +2088,9 @@ func (d *Driver) snapshotExists(ctx context.Context, sourceVolumeID, snapshotNam
 		if fileShareName == "" {
 			return false, "", time.Time{}, 0, fmt.Errorf("file share is empty after parsing sourceVolumeID: %s", sourceVolumeID)
 		}
+		if rgName != "" {
+			rgName, _ = d.getResourceGroupOfVolume(sourceVolumeID, rgName, subsID)
+		}
 
 		// List share snapshots.
 		listSnapshot, err := d.cloud.FileClient.WithSubscriptionID(subsID).ListFileShare(ctx, rgName, accountName, "", snapshotsExpand)
@@ -1167,6 +2122,111 @@ func (d *Driver) snapshotExists(ctx context.Context, sourceVolumeID, snapshotNam
 	return false, "", time.Time{}, 0, nil
 }
 
+// getSnapshotSizeBytes returns used capacity of source file share as snapshot size,
+// quota of the snapshot is returned if usage of source file share is not available
+func (d *Driver) getSnapshotSizeBytes(ctx context.Context, sourceVolumeID, subsID, rgName, accountName, fileShareName string, quotaGiB int32, secrets map[string]string, useDataPlaneAPI bool) int64 {
+	usageBytes, err := d.getFileShareUsageBytes(ctx, sourceVolumeID, subsID, rgName, accountName, fileShareName, secrets, useDataPlaneAPI)
+	if err != nil {
+		klog.Warningf("failed to get usage of file share(%s) on account(%s), use snapshot quota(%d GiB) as snapshot size: %v", fileShareName, accountName, quotaGiB, err)
+		return volumehelper.GiBToBytes(int64(quotaGiB))
+	}
+	return usageBytes
+}
+
+// getFileShareUsageBytes returns approximate size of the data stored on file share
+func (d *Driver) getFileShareUsageBytes(ctx context.Context, sourceVolumeID, subsID, rgName, accountName, fileShareName string, secrets map[string]string, useDataPlaneAPI bool) (int64, error) {
+	if len(secrets) > 0 || useDataPlaneAPI {
+		shareURL, err := d.getShareURL(ctx, sourceVolumeID, secrets)
+		if err != nil {
+			return 0, err
+		}
+		stats, err := shareURL.GetStatistics(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return int64(stats.ShareUsageBytes), nil
+	}
+	fileShare, err := d.cloud.GetFileShare(ctx, subsID, rgName, accountName, fileShareName)
+	if err != nil {
+		return 0, err
+	}
+	if fileShare.FileShareProperties == nil || fileShare.FileShareProperties.ShareUsageBytes == nil {
+		return 0, fmt.Errorf("usage of file share(%s) is not returned", fileShareName)
+	}
+	return *fileShare.FileShareProperties.ShareUsageBytes, nil
+}
+
+// shareSnapshotExists checks whether snapshot(x-ms-snapshot value) of the source file share exists
+func (d *Driver) shareSnapshotExists(ctx context.Context, sourceVolumeID, subsID, rgName, accountName, fileShareName, snapshot string, secrets map[string]string, useDataPlaneAPI bool) (bool, error) {
+	var err error
+	if len(secrets) > 0 || useDataPlaneAPI {
+		var shareURL azfile.ShareURL
+		if shareURL, err = d.getShareURL(ctx, sourceVolumeID, secrets); err != nil {
+			return false, err
+		}
+		_, err = shareURL.WithSnapshot(snapshot).GetProperties(ctx)
+	} else {
+		_, err = d.cloud.FileClient.WithSubscriptionID(subsID).GetFileShare(ctx, rgName, accountName, fileShareName, snapshot)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "ShareSnapshotNotFound") || strings.Contains(err.Error(), "ShareNotFound") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// validateSKU checks whether skuName of the storage account is in allowed skus,
+// empty sku would be Standard_LRS by default, and non-premium sku would be replaced by Premium_LRS for nfs protocol
+func (d *Driver) validateSKU(sku, protocol string) error {
+	if len(d.allowedSKUs) == 0 {
+		return nil
+	}
+	if protocol == nfs && !strings.HasPrefix(strings.ToLower(sku), premium) {
+		sku = string(storage.SkuNamePremiumLRS)
+	}
+	if sku == "" {
+		sku = string(storage.SkuNameStandardLRS)
+	}
+	for _, allowedSKU := range d.allowedSKUs {
+		if strings.EqualFold(sku, allowedSKU) {
+			return nil
+		}
+	}
+	return fmt.Errorf("skuName(%s) is not allowed, allowed skuName list: %v", sku, d.allowedSKUs)
+}
+
+// validateRequiredTags checks whether all required tag keys are set with non-empty values in tags parameter,
+// tag keys are case insensitive in Azure
+func (d *Driver) validateRequiredTags(tags map[string]string) error {
+	var missingTags []string
+	for _, requiredTag := range d.requiredTags {
+		found := false
+		for k, v := range tags {
+			if strings.EqualFold(k, requiredTag) && v != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missingTags = append(missingTags, requiredTag)
+		}
+	}
+	if len(missingTags) > 0 {
+		return fmt.Errorf("required tags%v are not set in tags parameter, the format should like: 'key1=value1,key2=value2'", missingTags)
+	}
+	return nil
+}
+
+// validateMaxShareQuota returns InvalidArgument error if requested quota is larger than maximum share quota
+func (d *Driver) validateMaxShareQuota(requestGiB int64) error {
+	if d.maxShareQuotaGiB > 0 && requestGiB > int64(d.maxShareQuotaGiB) {
+		return status.Errorf(codes.InvalidArgument, "requested quota(%d GiB) is larger than maximum share quota(%d GiB)", requestGiB, d.maxShareQuotaGiB)
+	}
+	return nil
+}
+
 // isValidVolumeCapabilities validates the given VolumeCapability array is valid
 func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) error {
 	if len(volCaps) == 0 {
@@ -1191,3 +2251,33 @@ func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) error {
 	}
 	return nil
 }
+
+// checkAccessModeConflicts validates access modes of the given VolumeCapability array are mutually compatible,
+// read only modes are compatible with any mode, while writer modes conflict if they are published on
+// a single node and multiple nodes, or they allow a single writer and multiple writers
+func checkAccessModeConflicts(volCaps []*csi.VolumeCapability) error {
+	// UNKNOWN means no such access mode is found
+	var singleNodeWriter, multiNodeWriter, singleWriter, multiWriter csi.VolumeCapability_AccessMode_Mode
+	for _, c := range volCaps {
+		mode := c.GetAccessMode().GetMode()
+		switch mode {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:
+			singleNodeWriter = mode
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER:
+			singleNodeWriter, singleWriter = mode, mode
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+			singleNodeWriter, multiWriter = mode, mode
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER:
+			multiNodeWriter, singleWriter = mode, mode
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+			multiNodeWriter, multiWriter = mode, mode
+		}
+		if singleNodeWriter != csi.VolumeCapability_AccessMode_UNKNOWN && multiNodeWriter != csi.VolumeCapability_AccessMode_UNKNOWN {
+			return fmt.Errorf("access mode %v conflicts with access mode %v", singleNodeWriter, multiNodeWriter)
+		}
+		if singleWriter != csi.VolumeCapability_AccessMode_UNKNOWN && multiWriter != csi.VolumeCapability_AccessMode_UNKNOWN {
+			return fmt.Errorf("access mode %v conflicts with access mode %v", singleWriter, multiWriter)
+		}
+	}
+	return nil
+}
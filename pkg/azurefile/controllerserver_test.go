@@ -25,6 +25,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -35,17 +36,25 @@ import (
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-03-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	azure2 "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/utils/pointer"
 
+	volumehelper "sigs.k8s.io/azurefile-csi-driver/pkg/util"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/vmclient/mockvmclient"
@@ -174,6 +183,47 @@ func TestCreateVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Contradictory access modes",
+			testFunc: func(t *testing.T) {
+				req := &csi.CreateVolumeRequest{
+					Name:          "random-vol-name-vol-cap-conflict",
+					CapacityRange: stdCapRange,
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+						},
+					},
+					Parameters: map[string]string{"unknown": "value"},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Error(codes.InvalidArgument, "CreateVolume Volume capabilities not valid: access mode SINGLE_NODE_WRITER conflicts with access mode MULTI_NODE_MULTI_WRITER")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+
+				// contradictory access modes are accepted with allow policy
+				d.accessModeConflictPolicy = accessModeConflictAllow
+				expectedErr = status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", "unknown"))
+				_, err = d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
 		{
 			name: "Volume lock already present",
 			testFunc: func(t *testing.T) {
@@ -391,6 +441,192 @@ func TestCreateVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Invalid minimumTlsVersion",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					minimumTLSVersionField: "TLS1_3",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "minimumTlsVersion(TLS1_3) is not supported, supported MinimumTLSVersion list: [TLS1_0 TLS1_1 TLS1_2]")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Invalid network rule set parameters",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params      map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{networkDefaultActionField: "Block"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "networkDefaultAction(Block) is not supported, supported NetworkDefaultAction list: [Allow Deny]"),
+					},
+					{
+						params:      map[string]string{networkDefaultActionField: "Deny"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "subnetName or allowedIPs must be provided when networkDefaultAction is Deny"),
+					},
+					{
+						params:      map[string]string{networkDefaultActionField: "Allow", allowedIPsField: "10.0.0.1"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "allowedIPs is only supported when networkDefaultAction is Deny"),
+					},
+					{
+						params:      map[string]string{allowedIPsField: "10.0.0.1,invalid"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "allowedIPs '10.0.0.1,invalid' is invalid, invalid is not a valid IP address or CIDR range"),
+					},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-vol-cap-invalid",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+					}
+					_, err := d.CreateVolume(ctx, req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("Unexpected error: %v, expected error: %v", err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "aad dataPlaneAuth without kerberos auth",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					dataPlaneAuthField: "aad",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "account key auth is disabled by dataPlaneAuth(aad) while no identity based auth is available, use nfs protocol or sec=krb5 mount option with smb protocol")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Invalid shareMetadata",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					shareMetadataField: "invalid-key=value",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "share metadata key(invalid-key) is invalid, it should start with a letter or underscore and only contain letters, numbers and underscores")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Negative capacity range",
+			testFunc: func(t *testing.T) {
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: -1},
+					VolumeCapabilities: stdVolCap,
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Error(codes.InvalidArgument, "RequiredBytes(-1) in capacity range must not be negative")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Invalid operationTimeout",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					operationTimeoutField: "-1m",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "invalid operationtimeout: -1m, it should be positive")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
 		{
 			name: "Invalid shareNamePrefix",
 			testFunc: func(t *testing.T) {
@@ -420,6 +656,35 @@ func TestCreateVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Invalid shareName",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					shareNameField: "Invalid--Share",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "shareName(Invalid--Share) is invalid, it can only contain lowercase letters, numbers, single hyphens, must begin and end with a letter or a number, and length should be between 3 and 63")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
 		{
 			name: "invalid tags format to convert to map",
 			testFunc: func(t *testing.T) {
@@ -995,44 +1260,373 @@ func TestCreateVolume(t *testing.T) {
 			},
 		},
 		{
-			name: "Create disk returns error",
+			name: "restoreFromSoftDelete without shareName",
 			testFunc: func(t *testing.T) {
-				skipIfTestingOnWindows(t)
-				name := "baz"
-				sku := "sku"
-				kind := "StorageV2"
-				location := "centralus"
-				value := "foo bar"
-				accounts := []storage.Account{
-					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				allParam := map[string]string{
+					restoreFromSoftDeleteField: "true",
 				}
-				keys := storage.AccountListKeysResult{
-					Keys: &[]storage.AccountKey{
-						{Value: &value},
-					},
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-restore",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
 				}
 
+				d := NewFakeDriver()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "shareName must be provided when restoreFromSoftDelete is true")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v, expected error: %v", err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "restore soft deleted file share",
+			testFunc: func(t *testing.T) {
 				allParam := map[string]string{
-					skuNameField:            "premium",
-					storageAccountTypeField: "stoacctype",
-					locationField:           "loc",
-					storageAccountField:     "stoacc",
-					resourceGroupField:      "rg",
-					fsTypeField:             "ext4",
-					storeAccountKeyField:    "storeaccountkey",
-					secretNamespaceField:    "default",
+					storageAccountField:        "stoacc",
+					resourceGroupField:         "rg",
+					shareNameField:             "share",
+					storeAccountKeyField:       "false",
+					restoreFromSoftDeleteField: "true",
 				}
 
 				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-create-disk-error",
+					Name:               "random-vol-name-restore",
+					CapacityRange:      stdCapRange,
 					VolumeCapabilities: stdVolCap,
-					CapacityRange:      lessThanPremCapRange,
 					Parameters:         allParam,
 				}
 
-				driverOptions := DriverOptions{
-					NodeID:               fakeNodeID,
-					DriverName:           DefaultDriverName,
+				d := NewFakeDriver()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+				restorer := &fakeFileShareRestorer{}
+				d.fileShareRestorer = restorer
+
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1)
+				// verify file share after restore
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(200)}}, nil).Times(1)
+				mockFileClient.EXPECT().GetServiceProperties(gomock.Any(), "rg", "stoacc").Return(storage.FileServiceProperties{
+					FileServicePropertiesProperties: &storage.FileServicePropertiesProperties{
+						ShareDeleteRetentionPolicy: &storage.DeleteRetentionPolicy{Enabled: pointer.Bool(true)},
+					},
+				}, nil).Times(1)
+				mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "stoacc", "", "deleted").Return([]storage.FileShareItem{
+					{
+						Name: pointer.String("share"),
+						FileShareProperties: &storage.FileShareProperties{
+							Deleted:    pointer.Bool(true),
+							Version:    pointer.String("version"),
+							ShareQuota: pointer.Int32(200),
+						},
+					},
+				}, nil).Times(1)
+				mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), "").DoAndReturn(
+					func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+						assert.Equal(t, 200, shareOptions.RequestGiB)
+						return storage.FileShare{}, nil
+					}).Times(1)
+
+				resp, err := d.CreateVolume(context.Background(), req)
+				assert.NoError(t, err)
+				assert.Equal(t, volumehelper.GiBToBytes(200), resp.Volume.CapacityBytes)
+				assert.Equal(t, []storage.DeletedShare{{DeletedShareName: pointer.String("share"), DeletedShareVersion: pointer.String("version")}}, restorer.deletedShares)
+			},
+		},
+		{
+			name: "default share quota when capacity range is absent",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					sku                  string
+					defaultShareQuotaGiB int
+					expectedQuota        int
+				}{
+					{
+						sku:                  "Standard_LRS",
+						defaultShareQuotaGiB: 50,
+						expectedQuota:        50,
+					},
+					{
+						sku:                  "Premium_LRS",
+						defaultShareQuotaGiB: 50,
+						expectedQuota:        minimumPremiumShareSize,
+					},
+					{
+						sku:                  "Premium_LRS",
+						defaultShareQuotaGiB: 200,
+						expectedQuota:        200,
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-default-quota",
+						VolumeCapabilities: stdVolCap,
+						Parameters: map[string]string{
+							storageAccountField:  "stoacc",
+							resourceGroupField:   "rg",
+							shareNameField:       "share",
+							skuNameField:         test.sku,
+							storeAccountKeyField: "false",
+						},
+					}
+
+					d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, DefaultShareQuotaGiB: test.defaultShareQuotaGiB})
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud.FileClient = mockFileClient
+
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1)
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), "").DoAndReturn(
+						func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+							assert.Equal(t, test.expectedQuota, shareOptions.RequestGiB, test.sku)
+							return storage.FileShare{}, nil
+						}).Times(1)
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(int32(test.expectedQuota))}}, nil).Times(1)
+
+					resp, err := d.CreateVolume(context.Background(), req)
+					assert.NoError(t, err, test.sku)
+					assert.Equal(t, volumehelper.GiBToBytes(int64(test.expectedQuota)), resp.Volume.CapacityBytes, test.sku)
+					ctrl.Finish()
+				}
+			},
+		},
+		{
+			name: "provenance metadata is set on new file share",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					desc             string
+					existingQuota    int32
+					expectedMetadata map[string]string
+				}{
+					{
+						desc: "new file share",
+						expectedMetadata: map[string]string{
+							"team":                    "storage",
+							"CreatedAt":               "2023-01-01T00:00:00Z",
+							createdByDriverVersionTag: driverVersion,
+						},
+					},
+					{
+						desc:          "existing file share",
+						existingQuota: 100,
+						expectedMetadata: map[string]string{
+							"team":      "storage",
+							"CreatedAt": "2023-01-01T00:00:00Z",
+						},
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-provenance",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters: map[string]string{
+							storageAccountField:  "stoacc",
+							resourceGroupField:   "rg",
+							shareNameField:       "share",
+							shareMetadataField:   "team=storage,CreatedAt=2023-01-01T00:00:00Z",
+							storeAccountKeyField: "false",
+						},
+					}
+
+					d := NewFakeDriver()
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud.FileClient = mockFileClient
+
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					if test.existingQuota > 0 {
+						mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(test.existingQuota)}}, nil).Times(1)
+					} else {
+						mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1)
+					}
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), "").DoAndReturn(
+						func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+							metadata := map[string]string{}
+							for k, v := range shareOptions.Metadata {
+								metadata[k] = *v
+							}
+							assert.Equal(t, test.expectedMetadata, metadata, test.desc)
+							return storage.FileShare{}, nil
+						}).Times(1)
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}}, nil).Times(1)
+
+					_, err := d.CreateVolume(context.Background(), req)
+					assert.NoError(t, err, test.desc)
+					ctrl.Finish()
+				}
+			},
+		},
+		{
+			name: "protocol(auto) is resolved by node OS in requested topology",
+			testFunc: func(t *testing.T) {
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-auto-protocol",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters: map[string]string{
+						storageAccountField:  "stoacc",
+						resourceGroupField:   "rg",
+						shareNameField:       "share",
+						protocolField:        auto,
+						storeAccountKeyField: "false",
+					},
+				}
+
+				d := NewFakeDriver()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "protocol(auto) requires kubernetes.io/os in requested topology, enable node OS topology in driver and use WaitForFirstConsumer volume binding mode")
+				_, err := d.CreateVolume(context.Background(), req)
+				assert.Equal(t, expectedErr, err)
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				req.AccessibilityRequirements = &csi.TopologyRequirement{
+					Preferred: []*csi.Topology{{Segments: map[string]string{topologyOSKey: "windows"}}},
+				}
+
+				// existing storage account must support nfs protocol as well
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "stoacc").Return(storage.Account{
+					Kind:              storage.KindStorageV2,
+					AccountProperties: &storage.AccountProperties{EnableHTTPSTrafficOnly: pointer.Bool(true)},
+				}, nil).Times(1)
+				expectedErr = status.Errorf(codes.InvalidArgument, "protocol(auto) requires storage account supporting both smb and nfs protocols: nfs protocol is not supported by storage account(stoacc), it requires premium account with secure transfer disabled")
+				_, err = d.CreateVolume(context.Background(), req)
+				assert.Equal(t, expectedErr, err)
+
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "stoacc").Return(storage.Account{
+					Kind:              storage.KindFileStorage,
+					AccountProperties: &storage.AccountProperties{EnableHTTPSTrafficOnly: pointer.Bool(false)},
+				}, nil).Times(1)
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1)
+				mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), "").DoAndReturn(
+					func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+						assert.Equal(t, storage.EnabledProtocolsSMB, shareOptions.Protocol)
+						return storage.FileShare{}, nil
+					}).Times(1)
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}}, nil).Times(1)
+
+				resp, err := d.CreateVolume(context.Background(), req)
+				assert.NoError(t, err)
+				assert.Equal(t, smb, resp.GetVolume().GetVolumeContext()[protocolField])
+				assert.Nil(t, resp.GetVolume().GetAccessibleTopology())
+			},
+		},
+		{
+			name: "restoreFromSoftDelete when soft delete is not enabled",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					storageAccountField:        "stoacc",
+					resourceGroupField:         "rg",
+					shareNameField:             "share",
+					restoreFromSoftDeleteField: "true",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-restore",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1)
+				mockFileClient.EXPECT().GetServiceProperties(gomock.Any(), "rg", "stoacc").Return(storage.FileServiceProperties{}, nil).Times(1)
+
+				expectedErr := status.Errorf(codes.FailedPrecondition, "file share soft delete is not enabled on account(stoacc), could not restore file share(share)")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v, expected error: %v", err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "Create disk returns error",
+			testFunc: func(t *testing.T) {
+				skipIfTestingOnWindows(t)
+				name := "baz"
+				sku := "sku"
+				kind := "StorageV2"
+				location := "centralus"
+				value := "foo bar"
+				accounts := []storage.Account{
+					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				}
+				keys := storage.AccountListKeysResult{
+					Keys: &[]storage.AccountKey{
+						{Value: &value},
+					},
+				}
+
+				allParam := map[string]string{
+					skuNameField:            "premium",
+					storageAccountTypeField: "stoacctype",
+					locationField:           "loc",
+					storageAccountField:     "stoacc",
+					resourceGroupField:      "rg",
+					fsTypeField:             "ext4",
+					storeAccountKeyField:    "storeaccountkey",
+					secretNamespaceField:    "default",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-create-disk-error",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      lessThanPremCapRange,
+					Parameters:         allParam,
+				}
+
+				driverOptions := DriverOptions{
+					NodeID:               fakeNodeID,
+					DriverName:           DefaultDriverName,
 					EnableVHDDiskFeature: true,
 				}
 				d := NewFakeDriverCustomOptions(driverOptions)
@@ -1363,101 +1957,753 @@ func TestCreateVolume(t *testing.T) {
 	}
 }
 
-func TestDeleteVolume(t *testing.T) {
-	testCases := []struct {
-		name     string
-		testFunc func(t *testing.T)
+func TestValidateCreateVolumeParameters(t *testing.T) {
+	d := NewFakeDriver()
+	d.enableVHDDiskFeature = true
+
+	tests := []struct {
+		desc             string
+		parameters       map[string]string
+		nilCloud         bool
+		expectedWarnings []string
+		expectedErr      error
 	}{
 		{
-			name: "Volume ID missing",
-			testFunc: func(t *testing.T) {
-				req := &csi.DeleteVolumeRequest{
-					Secrets: map[string]string{},
-				}
-
-				ctx := context.Background()
-				d := NewFakeDriver()
-
-				expectedErr := status.Error(codes.InvalidArgument, "Volume ID missing in request")
-				_, err := d.DeleteVolume(ctx, req)
-				if !reflect.DeepEqual(err, expectedErr) {
-					t.Errorf("Unexpected error: %v", err)
-				}
-			},
+			desc:       "empty parameters",
+			parameters: map[string]string{},
 		},
 		{
-			name: "Controller capability missing",
-			testFunc: func(t *testing.T) {
-				req := &csi.DeleteVolumeRequest{
-					VolumeId: "vol_1-cap-missing",
-					Secrets:  map[string]string{},
-				}
-
-				ctx := context.Background()
-				d := NewFakeDriver()
-
-				expectedErr := status.Errorf(codes.InvalidArgument, "invalid delete volume request: %v", req)
-				_, err := d.DeleteVolume(ctx, req)
-				if !reflect.DeepEqual(err, expectedErr) {
-					t.Errorf("Unexpected error: %v", err)
-				}
+			desc: "valid parameters",
+			parameters: map[string]string{
+				"skuName":               "Premium_LRS",
+				"shareName":             "share",
+				"enableMultichannel":    "true",
+				"mountPermissions":      "0755",
+				"mountOptionsConfigMap": "default/mount-options",
+				"windowsMountOptions":   "RequirePrivacy",
+				"linuxMountOptions":     "dir_mode=0777,file_mode=0777",
+				"mountTimeout":          "30s",
+				"tags":                  "key=value",
+				pvcNamespaceKey:         "default",
 			},
 		},
 		{
-			name: "Invalid volume ID",
-			testFunc: func(t *testing.T) {
-				req := &csi.DeleteVolumeRequest{
-					VolumeId: "vol_1",
-					Secrets:  map[string]string{},
-				}
-
-				ctx := context.Background()
-				d := NewFakeDriver()
-				d.Cap = []*csi.ControllerServiceCapability{
-					{
-						Type: &csi.ControllerServiceCapability_Rpc{
-							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
-						},
-					},
-				}
-
-				_, err := d.DeleteVolume(ctx, req)
-				if !reflect.DeepEqual(err, nil) {
-					t.Errorf("Unexpected error: %v", err)
-				}
-			},
+			desc:             "deprecated parameter alias",
+			parameters:       map[string]string{"storageAccountType": "Premium_LRS"},
+			expectedWarnings: []string{"parameter storageAccountType is deprecated, use skuname instead"},
 		},
 		{
-			name: "failed to get account info",
-			testFunc: func(t *testing.T) {
-				req := &csi.DeleteVolumeRequest{
-					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd##secret",
-					Secrets:  map[string]string{},
-				}
-
-				ctx := context.Background()
-				d := NewFakeDriver()
-				d.Cap = []*csi.ControllerServiceCapability{
-					{
-						Type: &csi.ControllerServiceCapability_Rpc{
-							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
-						},
-					},
-				}
-				d.dataPlaneAPIAccountCache, _ = azcache.NewTimedcache(10*time.Minute, func(key string) (interface{}, error) { return nil, nil })
-				d.dataPlaneAPIAccountCache.Set("f5713de20cde511e8ba4900", "1")
-				d.cloud = &azure.Cloud{}
-
-				expectedErr := status.Errorf(codes.NotFound, "get account info from(vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd##secret) failed with error: could not get account key from secret(azure-storage-account-f5713de20cde511e8ba4900-secret): KubeClient is nil")
-				_, err := d.DeleteVolume(ctx, req)
-				if !reflect.DeepEqual(err, expectedErr) {
-					t.Errorf("Unexpected error: %v", err)
-				}
-			},
+			desc:        "deprecated parameter alias with different value of canonical name",
+			parameters:  map[string]string{"storageAccountType": "Premium_LRS", "skuName": "Standard_LRS"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "parameter storageAccountType is deprecated alias of skuName, they could not be set with different values(Premium_LRS, Standard_LRS)"),
 		},
 		{
-			name: "Delete file share returns error",
-			testFunc: func(t *testing.T) {
+			desc:        "useExistingShare without storageAccount",
+			parameters:  map[string]string{"useExistingShare": "true", "shareName": "share"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "storageAccount and shareName must be provided when useExistingShare is true"),
+		},
+		{
+			desc:        "useExistingShare with restoreFromSoftDelete",
+			parameters:  map[string]string{"useExistingShare": "true", "shareName": "share", "storageAccount": "account", "restoreFromSoftDelete": "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "useExistingShare is not supported with restoreFromSoftDelete"),
+		},
+		{
+			desc:       "useExistingShare with nfs protocol",
+			parameters: map[string]string{"useExistingShare": "true", "shareName": "share", "storageAccount": "account", protocolField: nfs},
+		},
+		{
+			desc:        "nfsExportPathTemplate with smb protocol",
+			parameters:  map[string]string{"nfsExportPathTemplate": "/${account}/${share}"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "nfsexportpathtemplate is only supported with nfs protocol"),
+		},
+		{
+			desc:       "dnsSearchDomain with nfs protocol",
+			parameters: map[string]string{"dnsSearchDomain": "corp.contoso.com", protocolField: nfs},
+		},
+		{
+			desc:        "dnsSearchDomain with smb protocol",
+			parameters:  map[string]string{"dnsSearchDomain": "corp.contoso.com"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "dnssearchdomain is only supported with nfs protocol"),
+		},
+		{
+			desc:        "invalid mountTimeout",
+			parameters:  map[string]string{"mountTimeout": "1x"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid mounttimeout: 1x, error: time: unknown unit \"x\" in duration \"1x\""),
+		},
+		{
+			desc:        "initialDirectories with path traversal",
+			parameters:  map[string]string{"initialDirectories": "data,../etc"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "directory(../etc) in initialdirectories should not contain '..'"),
+		},
+		{
+			desc:        "initialDirectories with nfs protocol",
+			parameters:  map[string]string{"initialDirectories": "data", protocolField: nfs},
+			expectedErr: status.Errorf(codes.InvalidArgument, "initialdirectories is only supported with smb protocol"),
+		},
+		{
+			desc:             "initialDirectories with vhd disk",
+			parameters:       map[string]string{"initialDirectories": "data", fsTypeField: "ext4"},
+			expectedWarnings: []string{"fsType(ext4) enables experimental VHD disk feature"},
+			expectedErr:      status.Errorf(codes.InvalidArgument, "initialdirectories is not supported with vhd disk fsType(ext4)"),
+		},
+		{
+			desc:        "invalid windowsMountOptions",
+			parameters:  map[string]string{"windowsMountOptions": "dir_mode=0777"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "mount option(dir_mode=0777) is not supported in windowsmountoptions, supported options: [requireintegrity requireprivacy usewritethrough]"),
+		},
+		{
+			desc:        "invalid parameter",
+			parameters:  map[string]string{"invalidParameter": "value"},
+			expectedErr: status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", "invalidParameter")),
+		},
+		{
+			desc:        "invalid bool value",
+			parameters:  map[string]string{"allowBlobPublicAccess": "invalid"},
+			expectedErr: status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", allowBlobPublicAccessField, "invalid")),
+		},
+		{
+			desc:        "invalid mountPermissions",
+			parameters:  map[string]string{mountPermissionsField: "0abc"},
+			expectedErr: status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid mountPermissions %s in storage class", "0abc")),
+		},
+		{
+			desc:        "invalid tags",
+			parameters:  map[string]string{tagsField: "key:value"},
+			expectedErr: status.Errorf(codes.InvalidArgument, fmt.Errorf("Tags '%s' are invalid, the format should like: 'key1=value1,key2=value2'", "key:value").Error()),
+		},
+		{
+			desc:        "cross subscription without resourceGroup",
+			parameters:  map[string]string{subscriptionIDField: "otherSubscriptionID"},
+			expectedErr: status.Errorf(codes.InvalidArgument, fmt.Sprintf("resourceGroup must be provided in cross subscription(%s)", "otherSubscriptionID")),
+		},
+		{
+			desc:             "subscriptionID without resourceGroup could not be fully validated without cloud",
+			parameters:       map[string]string{subscriptionIDField: "otherSubscriptionID"},
+			nilCloud:         true,
+			expectedWarnings: []string{"resourceGroup must be provided if subscriptionID(otherSubscriptionID) is not the subscription of the cluster"},
+		},
+		{
+			desc:        "networkDefaultAction Deny without subnetName",
+			parameters:  map[string]string{networkDefaultActionField: "Deny"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "subnetName or allowedIPs must be provided when networkDefaultAction is %s", storage.DefaultActionDeny),
+		},
+		{
+			desc:             "nfs protocol with standard sku",
+			parameters:       map[string]string{protocolField: nfs, skuNameField: "Standard_LRS"},
+			expectedWarnings: []string{"skuName(Standard_LRS) would be replaced by Premium_LRS since nfs protocol only supports premium storage account"},
+		},
+		{
+			desc:        "smb multichannel with nfs protocol",
+			parameters:  map[string]string{protocolField: nfs, enableMultichannelField: "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "smb multichannel is only supported with smb protocol, current protocol: %s", nfs),
+		},
+		{
+			desc:       "valid auto protocol",
+			parameters: map[string]string{protocolField: auto, skuNameField: "Premium_ZRS", mountPermissionsField: "0755"},
+		},
+		{
+			desc:        "auto protocol with standard sku",
+			parameters:  map[string]string{protocolField: auto, skuNameField: "Standard_LRS"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "skuName(Standard_LRS) is not supported with protocol(auto) since nfs protocol only supports premium storage account"),
+		},
+		{
+			desc:        "auto protocol with fsType",
+			parameters:  map[string]string{protocolField: auto, fsTypeField: nfs},
+			expectedErr: status.Errorf(codes.InvalidArgument, "fsType(nfs) is not supported with protocol(auto)"),
+		},
+		{
+			desc:        "auto protocol with existing share",
+			parameters:  map[string]string{protocolField: auto, useExistingShareField: "true", storageAccountField: "account", shareNameField: "share"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "useexistingshare is not supported with protocol(auto) since protocol of existing file share could not be changed"),
+		},
+		{
+			desc:        "auto protocol with nfs only parameter",
+			parameters:  map[string]string{protocolField: auto, enableNFSACLField: "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "protocol(auto) requires parameters valid with smb protocol: enablenfsacl is only supported with nfs protocol, current protocol: smb"),
+		},
+		{
+			desc:        "auto protocol with smb only parameter",
+			parameters:  map[string]string{protocolField: auto, enableMultichannelField: "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "protocol(auto) requires parameters valid with nfs protocol: smb multichannel is only supported with smb protocol, current protocol: nfs"),
+		},
+		{
+			desc:        "enableNfsAcl with smb protocol",
+			parameters:  map[string]string{protocolField: smb, enableNFSACLField: "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "enablenfsacl is only supported with nfs protocol, current protocol: %s", smb),
+		},
+		{
+			desc:        "secretNamespace and secretNamespaces",
+			parameters:  map[string]string{"secretNamespace": "default", "secretNamespaces": "team-a,team-b"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "secretnamespace and secretnamespaces could not be set at the same time"),
+		},
+		{
+			desc:       "valid secretNamespaces",
+			parameters: map[string]string{"secretNamespaces": "team-a,team-b"},
+		},
+		{
+			desc:        "nfsVersion with smb protocol",
+			parameters:  map[string]string{"nfsVersion": "3"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "nfsversion is only supported with nfs protocol, current protocol: "),
+		},
+		{
+			desc:       "valid nfsVersion",
+			parameters: map[string]string{"protocol": "nfs", "nfsVersion": "3"},
+		},
+		{
+			desc:        "keyVaultURL without keyVaultSecretName",
+			parameters:  map[string]string{"keyVaultURL": "https://vault.vault.azure.net"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "keyvaulturl and keyvaultsecretname should be set together"),
+		},
+		{
+			desc:        "keyVaultURL not in key vault dns suffix of cloud environment",
+			parameters:  map[string]string{"keyVaultURL": "https://vault.example.com", "keyVaultSecretName": "account-key"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid keyvaulturl: https://vault.example.com, the format should be like https://<vault-name>.vault.azure.net"),
+		},
+		{
+			desc:       "valid keyVaultURL and keyVaultSecretName",
+			parameters: map[string]string{"keyVaultURL": "https://vault.vault.azure.net/", "keyVaultSecretName": "account-key"},
+		},
+		{
+			desc:        "invalid keyExpirationDays",
+			parameters:  map[string]string{"keyExpirationDays": "0"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid keyexpirationdays: 0, it should be an integer in range [1, 3650]"),
+		},
+		{
+			desc:       "valid keyExpirationDays",
+			parameters: map[string]string{"keyExpirationDays": "90"},
+		},
+		{
+			desc:        "invalid useKey",
+			parameters:  map[string]string{"useKey": "secondary"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid usekey: secondary, supported values: [key1 key2]"),
+		},
+		{
+			desc:        "useKey without storing account key",
+			parameters:  map[string]string{"useKey": "key2", "storeAccountKey": "false"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "usekey requires account key stored in k8s secret, could not be set with storeaccountkey(false)"),
+		},
+		{
+			desc:       "valid useKey",
+			parameters: map[string]string{"useKey": "key2"},
+		},
+		{
+			desc:        "profile not found",
+			parameters:  map[string]string{"profile": "premium"},
+			expectedErr: status.Error(codes.InvalidArgument, "profile(premium) is not found, supported profiles: [premium-nfs premium-smb standard-smb]"),
+		},
+		{
+			desc:        "parameters of profile are validated",
+			parameters:  map[string]string{"profile": "premium-nfs", "fsType": "ext4"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "fsType(ext4) is not supported with protocol(nfs)"),
+		},
+		{
+			desc:       "valid profile",
+			parameters: map[string]string{"profile": "standard-smb"},
+		},
+		{
+			desc:        "rootDirACL with nfs protocol",
+			parameters:  map[string]string{protocolField: nfs, "rootDirACL": "D:(A;OICI;FA;;;BA)"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "rootdiracl is only supported with smb protocol"),
+		},
+		{
+			desc:        "invalid rootDirACL",
+			parameters:  map[string]string{"rootDirACL": "(A;OICI;FA;;;BA)"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid rootdiracl: SDDL((A;OICI;FA;;;BA)) should start with one of O:, G:, D:, S:"),
+		},
+		{
+			desc:       "valid rootDirACL",
+			parameters: map[string]string{"rootDirACL": "O:BAG:SYD:P(A;OICI;FA;;;BA)(A;OICI;0x1301bf;;;AU)"},
+		},
+		{
+			desc:        "useCredentialFile with nfs protocol",
+			parameters:  map[string]string{protocolField: nfs, useCredentialFileField: "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "usecredentialfile is only supported with smb protocol"),
+		},
+		{
+			desc:        "invalid useCredentialFile",
+			parameters:  map[string]string{useCredentialFileField: "invalid"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid %s: %s in storage class", useCredentialFileField, "invalid"),
+		},
+		{
+			desc:        "invalid enableNfsAcl",
+			parameters:  map[string]string{protocolField: nfs, enableNFSACLField: "invalid"},
+			expectedErr: status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", enableNFSACLField, "invalid")),
+		},
+		{
+			desc:             "vhd disk",
+			parameters:       map[string]string{fsTypeField: "ext4"},
+			expectedWarnings: []string{"fsType(ext4) enables experimental VHD disk feature"},
+		},
+		{
+			desc:        "restoreFromSoftDelete without shareName",
+			parameters:  map[string]string{restoreFromSoftDeleteField: "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "shareName must be provided when restoreFromSoftDelete is true"),
+		},
+		{
+			desc:        "generateSasToken with nfs protocol",
+			parameters:  map[string]string{"generateSasToken": "true", protocolField: nfs},
+			expectedErr: status.Errorf(codes.InvalidArgument, "generateSasToken is only supported with smb protocol"),
+		},
+		{
+			desc:        "invalid sasTokenExpiry",
+			parameters:  map[string]string{"generateSasToken": "true", "sasTokenExpiry": "1000h"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "sastokenexpiry(1000h) should be in range [1h0m0s, 720h0m0s]"),
+		},
+		{
+			desc:        "disableAccountKeyAuth with useDataPlaneAPI",
+			parameters:  map[string]string{"disableAccountKeyAuth": "true", useDataPlaneAPIField: "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "disableaccountkeyauth is not supported with useDataPlaneAPI since data plane API requires account key"),
+		},
+		{
+			desc:        "aad with generateSasToken",
+			parameters:  map[string]string{dataPlaneAuthField: "aad", "generateSasToken": "true"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "dataPlaneAuth(aad) is not supported with generateSasToken since SAS token is signed by account key"),
+		},
+		{
+			desc:        "invalid disableAccountKeyAuth",
+			parameters:  map[string]string{"disableAccountKeyAuth": "yes"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid disableaccountkeyauth: yes in storage class"),
+		},
+		{
+			desc:       "valid SAS token parameters",
+			parameters: map[string]string{"generateSasToken": "true", "sasTokenPermissions": "rwl", "sasTokenExpiry": "48h"},
+		},
+	}
+
+	for _, test := range tests {
+		cloud := d.cloud
+		if test.nilCloud {
+			d.cloud = nil
+		}
+		warnings, err := d.ValidateCreateVolumeParameters(test.parameters)
+		d.cloud = cloud
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedWarnings, warnings, test.desc)
+	}
+}
+
+func TestValidateSKU(t *testing.T) {
+	tests := []struct {
+		desc        string
+		allowedSKUs []string
+		sku         string
+		protocol    string
+		expectedErr error
+	}{
+		{
+			desc:     "all skus are allowed by default",
+			sku:      "Premium_ZRS",
+			protocol: smb,
+		},
+		{
+			desc:        "allowed sku is case insensitive",
+			allowedSKUs: []string{"Standard_LRS", "Standard_ZRS"},
+			sku:         "standard_zrs",
+			protocol:    smb,
+		},
+		{
+			desc:        "premium sku is not allowed",
+			allowedSKUs: []string{"Standard_LRS", "Standard_ZRS"},
+			sku:         "Premium_LRS",
+			protocol:    smb,
+			expectedErr: fmt.Errorf("skuName(Premium_LRS) is not allowed, allowed skuName list: [Standard_LRS Standard_ZRS]"),
+		},
+		{
+			desc:        "empty sku is Standard_LRS by default",
+			allowedSKUs: []string{"Standard_LRS"},
+			sku:         "",
+			protocol:    smb,
+		},
+		{
+			desc:        "empty sku is not allowed if Standard_LRS is not allowed",
+			allowedSKUs: []string{"Premium_LRS"},
+			sku:         "",
+			protocol:    smb,
+			expectedErr: fmt.Errorf("skuName(Standard_LRS) is not allowed, allowed skuName list: [Premium_LRS]"),
+		},
+		{
+			desc:        "standard sku is replaced by Premium_LRS for nfs",
+			allowedSKUs: []string{"Standard_LRS"},
+			sku:         "Standard_LRS",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("skuName(Premium_LRS) is not allowed, allowed skuName list: [Standard_LRS]"),
+		},
+	}
+
+	d := NewFakeDriver()
+	for _, test := range tests {
+		d.allowedSKUs = test.allowedSKUs
+		err := d.validateSKU(test.sku, test.protocol)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+func TestCreateVolumeWithAllowedSKUs(t *testing.T) {
+	d := NewFakeDriverCustomOptions(DriverOptions{
+		NodeID:      fakeNodeID,
+		DriverName:  DefaultDriverName,
+		AllowedSKUs: "Standard_LRS, Standard_ZRS",
+	})
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	assert.Equal(t, []string{"Standard_LRS", "Standard_ZRS"}, d.allowedSKUs)
+
+	req := &csi.CreateVolumeRequest{
+		Name: "vol",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			},
+		},
+		Parameters: map[string]string{skuNameField: "Premium_LRS"},
+	}
+	expectedErr := status.Error(codes.InvalidArgument, "skuName(Premium_LRS) is not allowed, allowed skuName list: [Standard_LRS Standard_ZRS]")
+	_, err := d.CreateVolume(context.Background(), req)
+	assert.Equal(t, expectedErr, err)
+}
+
+func TestValidateRequiredTags(t *testing.T) {
+	tests := []struct {
+		desc         string
+		requiredTags []string
+		parameters   map[string]string
+		expectedErr  error
+	}{
+		{
+			desc:       "no tag is required by default",
+			parameters: map[string]string{},
+		},
+		{
+			desc:         "required tag is set",
+			requiredTags: []string{"costCenter"},
+			parameters:   map[string]string{"tags": "costCenter=1234,env=prod"},
+		},
+		{
+			desc:         "required tag key is case insensitive",
+			requiredTags: []string{"costCenter", "owner"},
+			parameters:   map[string]string{"Tags": "COSTCENTER=1234, owner=team-a"},
+		},
+		{
+			desc:         "tags parameter is not set",
+			requiredTags: []string{"costCenter"},
+			parameters:   map[string]string{"skuName": "Premium_LRS"},
+			expectedErr:  status.Error(codes.InvalidArgument, "required tags[costCenter] are not set in tags parameter, the format should like: 'key1=value1,key2=value2'"),
+		},
+		{
+			desc:         "one of required tags is missing",
+			requiredTags: []string{"costCenter", "owner"},
+			parameters:   map[string]string{"tags": "owner=team-a"},
+			expectedErr:  status.Error(codes.InvalidArgument, "required tags[costCenter] are not set in tags parameter, the format should like: 'key1=value1,key2=value2'"),
+		},
+		{
+			desc:         "required tag with empty value",
+			requiredTags: []string{"costCenter", "owner"},
+			parameters:   map[string]string{"tags": "costCenter="},
+			expectedErr:  status.Error(codes.InvalidArgument, "required tags[costCenter owner] are not set in tags parameter, the format should like: 'key1=value1,key2=value2'"),
+		},
+	}
+
+	d := NewFakeDriver()
+	for _, test := range tests {
+		d.requiredTags = test.requiredTags
+		_, err := d.ValidateCreateVolumeParameters(test.parameters)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+func TestCreateVolumeWithRequiredTags(t *testing.T) {
+	d := NewFakeDriverCustomOptions(DriverOptions{
+		NodeID:       fakeNodeID,
+		DriverName:   DefaultDriverName,
+		RequiredTags: []string{" costCenter ", ""},
+	})
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	assert.Equal(t, []string{"costCenter"}, d.requiredTags)
+
+	req := &csi.CreateVolumeRequest{
+		Name: "vol",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			},
+		},
+		Parameters: map[string]string{tagsField: "env=prod"},
+	}
+	expectedErr := status.Error(codes.InvalidArgument, "required tags[costCenter] are not set in tags parameter, the format should like: 'key1=value1,key2=value2'")
+	_, err := d.CreateVolume(context.Background(), req)
+	assert.Equal(t, expectedErr, err)
+}
+
+func TestCreateVolumeWithMinShareQuota(t *testing.T) {
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MinShareQuotaGiB: -1}))
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MinShareQuotaGiB: maximumShareSize + 1}))
+
+	tests := []struct {
+		desc             string
+		minShareQuotaGiB int
+		capacityRange    *csi.CapacityRange
+		expectedQuotaGiB int
+		expectedErr      error
+	}{
+		{
+			desc:             "request below minimum is rounded up",
+			minShareQuotaGiB: 50,
+			capacityRange:    &csi.CapacityRange{RequiredBytes: 10 * 1024 * 1024 * 1024},
+			expectedQuotaGiB: 50,
+		},
+		{
+			desc:             "default quota below minimum is rounded up",
+			minShareQuotaGiB: 200,
+			expectedQuotaGiB: 200,
+		},
+		{
+			desc:             "request at minimum is not changed",
+			minShareQuotaGiB: 50,
+			capacityRange:    &csi.CapacityRange{RequiredBytes: 50 * 1024 * 1024 * 1024},
+			expectedQuotaGiB: 50,
+		},
+		{
+			desc:             "request above minimum is not changed",
+			minShareQuotaGiB: 50,
+			capacityRange:    &csi.CapacityRange{RequiredBytes: 80 * 1024 * 1024 * 1024},
+			expectedQuotaGiB: 80,
+		},
+		{
+			desc:             "minimum is disabled by default",
+			capacityRange:    &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+			expectedQuotaGiB: 1,
+		},
+		{
+			desc:             "limit is smaller than minimum",
+			minShareQuotaGiB: 50,
+			capacityRange:    &csi.CapacityRange{RequiredBytes: 10 * 1024 * 1024 * 1024, LimitBytes: 20 * 1024 * 1024 * 1024},
+			expectedErr:      status.Errorf(codes.OutOfRange, "LimitBytes(21474836480) in capacity range is smaller than minimum share quota(50 GiB)"),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MinShareQuotaGiB: test.minShareQuotaGiB})
+		d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+		ctrl := gomock.NewController(t)
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		if test.expectedErr == nil {
+			mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1)
+			mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), "").DoAndReturn(
+				func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+					assert.Equal(t, test.expectedQuotaGiB, shareOptions.RequestGiB, test.desc)
+					return storage.FileShare{}, nil
+				}).Times(1)
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(int32(test.expectedQuotaGiB))}}, nil).Times(1)
+		}
+
+		req := &csi.CreateVolumeRequest{
+			Name:          "vol",
+			CapacityRange: test.capacityRange,
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+				},
+			},
+			Parameters: map[string]string{
+				storageAccountField:  "stoacc",
+				resourceGroupField:   "rg",
+				shareNameField:       "share",
+				storeAccountKeyField: "false",
+			},
+		}
+		resp, err := d.CreateVolume(context.Background(), req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		if test.expectedErr == nil {
+			assert.Equal(t, int64(test.expectedQuotaGiB)*1024*1024*1024, resp.GetVolume().GetCapacityBytes(), test.desc)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestMaxShareQuota(t *testing.T) {
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MaxShareQuotaGiB: -1}))
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MaxShareQuotaGiB: maximumShareSize + 1}))
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MinShareQuotaGiB: 100, MaxShareQuotaGiB: 50}))
+
+	tests := []struct {
+		desc             string
+		maxShareQuotaGiB int
+		requestGiB       int64
+		expectedErr      error
+	}{
+		{
+			desc:             "request below maximum",
+			maxShareQuotaGiB: 100,
+			requestGiB:       50,
+		},
+		{
+			desc:             "request at maximum",
+			maxShareQuotaGiB: 100,
+			requestGiB:       100,
+		},
+		{
+			desc:             "request above maximum",
+			maxShareQuotaGiB: 100,
+			requestGiB:       101,
+			expectedErr:      status.Errorf(codes.InvalidArgument, "requested quota(101 GiB) is larger than maximum share quota(100 GiB)"),
+		},
+		{
+			desc:       "maximum is disabled by default",
+			requestGiB: maximumShareSize,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MaxShareQuotaGiB: test.maxShareQuotaGiB})
+		d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		})
+		ctrl := gomock.NewController(t)
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		if test.expectedErr == nil {
+			mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1)
+			mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), "").DoAndReturn(
+				func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+					assert.Equal(t, int(test.requestGiB), shareOptions.RequestGiB, test.desc)
+					return storage.FileShare{}, nil
+				}).Times(1)
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(int32(test.requestGiB))}}, nil).Times(1)
+		}
+
+		capacityRange := &csi.CapacityRange{RequiredBytes: test.requestGiB * 1024 * 1024 * 1024}
+		req := &csi.CreateVolumeRequest{
+			Name:          "vol",
+			CapacityRange: capacityRange,
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+				},
+			},
+			Parameters: map[string]string{
+				storageAccountField:  "stoacc",
+				resourceGroupField:   "rg",
+				shareNameField:       "share",
+				storeAccountKeyField: "false",
+			},
+		}
+		_, err := d.CreateVolume(context.Background(), req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		ctrl.Finish()
+
+		// expansion within maximum fails later since volume ID is invalid
+		expandReq := &csi.ControllerExpandVolumeRequest{VolumeId: "vol_1", CapacityRange: capacityRange}
+		expectedErr := test.expectedErr
+		if expectedErr == nil {
+			expectedErr = status.Error(codes.InvalidArgument, `GetFileShareInfo(vol_1) failed with error: error parsing volume id: "vol_1", should at least contain two #`)
+		}
+		_, err = d.ControllerExpandVolume(context.Background(), expandReq)
+		assert.Equal(t, expectedErr, err, test.desc)
+	}
+}
+
+func TestDeleteVolume(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "Volume ID missing",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					Secrets: map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				expectedErr := status.Error(codes.InvalidArgument, "Volume ID missing in request")
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Controller capability missing",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1-cap-missing",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "invalid delete volume request: %v", req)
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Invalid volume ID",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, nil) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "failed to get account info",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd##secret",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				d.dataPlaneAPIAccountCache, _ = azcache.NewTimedcache(10*time.Minute, func(key string) (interface{}, error) { return nil, nil })
+				d.dataPlaneAPIAccountCache.Set("f5713de20cde511e8ba4900", "1")
+				d.cloud = &azure.Cloud{}
+
+				expectedErr := status.Errorf(codes.NotFound, "get account info from(vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd##secret) failed with error: could not get account key from secret(azure-storage-account-f5713de20cde511e8ba4900-secret): KubeClient is nil")
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Delete file share returns error",
+			testFunc: func(t *testing.T) {
 				req := &csi.DeleteVolumeRequest{
 					VolumeId: "#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
 					Secrets:  map[string]string{},
@@ -1487,6 +2733,113 @@ func TestDeleteVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Delete file share returns success when account does not exist or is being deleted",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.FileClient = mockFileClient
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("test error")).Times(3)
+
+				// account does not exist
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "vol_1", "f5713de20cde511e8ba4900").Return(storage.Account{}, &retry.Error{HTTPStatusCode: http.StatusNotFound}).Times(1)
+				_, err := d.DeleteVolume(ctx, req)
+				assert.NoError(t, err)
+
+				// account is being deleted
+				deletingAccount := storage.Account{AccountProperties: &storage.AccountProperties{ProvisioningState: accountProvisioningStateDeleting}}
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "vol_1", "f5713de20cde511e8ba4900").Return(deletingAccount, nil).Times(1)
+				_, err = d.DeleteVolume(ctx, req)
+				assert.NoError(t, err)
+
+				// account exists
+				account := storage.Account{AccountProperties: &storage.AccountProperties{ProvisioningState: storage.ProvisioningStateSucceeded}}
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "vol_1", "f5713de20cde511e8ba4900").Return(account, nil).Times(1)
+				_, err = d.DeleteVolume(ctx, req)
+				assert.Equal(t, status.Errorf(codes.Internal, "DeleteFileShare fileshare under account(f5713de20cde511e8ba4900) rg(vol_1) failed with error: test error"), err)
+			},
+		},
+		{
+			name: "Existing file share is not deleted",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "rg#f5713de20cde511e8ba4900#fileshare##existing-pvc-xxx#",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.FileClient = mockFileClient
+				mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				_, err := d.DeleteVolume(ctx, req)
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name: "Volume is regarded as deleted when account is not found in resource group of driver config",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.ResourceGroup = "flagRG"
+				d.cloud.FileClient = mockFileClient
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				// file share is never deleted since it could be in another resource group
+				mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "flagRG", "f5713de20cde511e8ba4900").Return(storage.Account{}, &retry.Error{HTTPStatusCode: http.StatusNotFound}).Times(1)
+
+				_, err := d.DeleteVolume(ctx, req)
+				assert.NoError(t, err)
+			},
+		},
 		{
 			name: "Valid request",
 			testFunc: func(t *testing.T) {
@@ -1519,12 +2872,209 @@ func TestDeleteVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Snapshot is created before deleting file share",
+			testFunc: func(t *testing.T) {
+				volumeID := "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#"
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: volumeID,
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriverCustomOptions(DriverOptions{SnapshotBeforeDelete: true})
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.FileClient = mockFileClient
+				snapshotName := snapshotBeforeDeleteName
+				expectedShareOptions := &fileclient.ShareOptions{
+					Name:       "fileshare",
+					RequestGiB: defaultAzureFileQuota,
+					Metadata:   map[string]*string{snapshotNameKey: &snapshotName, deletedVolumeIDKey: &volumeID},
+				}
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				gomock.InOrder(
+					mockFileClient.EXPECT().GetServiceProperties(gomock.Any(), "vol_1", "f5713de20cde511e8ba4900").Return(storage.FileServiceProperties{
+						FileServicePropertiesProperties: &storage.FileServicePropertiesProperties{
+							ShareDeleteRetentionPolicy: &storage.DeleteRetentionPolicy{Enabled: pointer.Bool(true)},
+						},
+					}, nil).Times(1),
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "vol_1", "f5713de20cde511e8ba4900", expectedShareOptions, snapshotsExpand).Return(storage.FileShare{}, nil).Times(1),
+					mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), "vol_1", "f5713de20cde511e8ba4900", "fileshare", "").Return(nil).Times(1),
+				)
+
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, nil) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Failure of snapshot before delete does not block file share deletion",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriverCustomOptions(DriverOptions{SnapshotBeforeDelete: true})
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.FileClient = mockFileClient
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				gomock.InOrder(
+					mockFileClient.EXPECT().GetServiceProperties(gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileServiceProperties{
+						FileServicePropertiesProperties: &storage.FileServicePropertiesProperties{
+							ShareDeleteRetentionPolicy: &storage.DeleteRetentionPolicy{Enabled: pointer.Bool(true)},
+						},
+					}, nil).Times(1),
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), snapshotsExpand).Return(storage.FileShare{}, fmt.Errorf("snapshot is not supported")).Times(1),
+					mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1),
+				)
+
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, nil) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Snapshot is not created before delete if share soft delete is disabled",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriverCustomOptions(DriverOptions{SnapshotBeforeDelete: true})
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.FileClient = mockFileClient
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				gomock.InOrder(
+					mockFileClient.EXPECT().GetServiceProperties(gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileServiceProperties{}, nil).Times(1),
+					mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1),
+				)
+
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, nil) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, tc.testFunc)
 	}
 }
 
+func TestEnsureStorageAccount(t *testing.T) {
+	value := "key"
+	keys := storage.AccountListKeysResult{
+		Keys: &[]storage.AccountKey{
+			{Value: &value},
+		},
+	}
+	nameTakenErr := &retry.Error{
+		HTTPStatusCode: http.StatusConflict,
+		RawError:       fmt.Errorf("Code=\"StorageAccountAlreadyTaken\" Message=\"The storage account named f123 is already taken.\""),
+	}
+
+	tests := []struct {
+		desc              string
+		createErrors      []*retry.Error
+		expectedCreations int
+		expectedErr       bool
+	}{
+		{
+			desc:              "account created at first attempt",
+			createErrors:      []*retry.Error{nil},
+			expectedCreations: 1,
+		},
+		{
+			desc:              "account name is regenerated when it's already taken",
+			createErrors:      []*retry.Error{nameTakenErr, nameTakenErr, nil},
+			expectedCreations: 3,
+		},
+		{
+			desc:              "account names are always taken",
+			createErrors:      []*retry.Error{nameTakenErr, nameTakenErr, nameTakenErr, nameTakenErr},
+			expectedCreations: maxAccountNameRetries + 1,
+			expectedErr:       true,
+		},
+		{
+			desc:              "account name is not regenerated on other errors",
+			createErrors:      []*retry.Error{retry.NewError(false, fmt.Errorf("create error"))},
+			expectedCreations: 1,
+			expectedErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		ctrl := gomock.NewController(t)
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+		var accountNames []string
+		creations := 0
+		mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, subsID, resourceGroup, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+				accountNames = append(accountNames, accountName)
+				rerr := test.createErrors[creations]
+				creations++
+				return rerr
+			}).Times(test.expectedCreations)
+		mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+
+		accountOptions := &azure.AccountOptions{
+			ResourceGroup: "rg",
+			CreateAccount: true,
+		}
+		accountName, accountKey, err := d.ensureStorageAccount(context.Background(), accountOptions)
+		assert.Equal(t, test.expectedErr, err != nil, test.desc)
+		if !test.expectedErr {
+			assert.Equal(t, accountNames[len(accountNames)-1], accountName, test.desc)
+			assert.Equal(t, value, accountKey, test.desc)
+		}
+		// a new account name is generated on every attempt
+		for i := 1; i < len(accountNames); i++ {
+			assert.NotEqual(t, accountNames[i-1], accountNames[i], test.desc)
+		}
+		ctrl.Finish()
+	}
+}
+
 func TestControllerGetVolume(t *testing.T) {
 	d := NewFakeDriver()
 	req := csi.ControllerGetVolumeRequest{}
@@ -1949,6 +3499,130 @@ func TestCreateSnapshot(t *testing.T) {
 	}
 }
 
+func TestCreateSnapshotWithParentSnapshot(t *testing.T) {
+	sourceVolumeID := "rg#account#share#diskname#uuid#default#subsID"
+	parentSnapshotID := sourceVolumeID + "#2023-01-01T00:00:00.0000000Z"
+	snapshotTime := date.Time{Time: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		desc           string
+		parentSnapshot string
+		getParentErr   error
+		expectedErr    error
+	}{
+		{
+			desc:           "parent snapshot is not a snapshot of source volume",
+			parentSnapshot: "rg#account#othershare#diskname#uuid#default#subsID#2023-01-01T00:00:00.0000000Z",
+			expectedErr:    status.Errorf(codes.InvalidArgument, "parent snapshot(rg#account#othershare#diskname#uuid#default#subsID#2023-01-01T00:00:00.0000000Z) is not a snapshot of source volume(%s)", sourceVolumeID),
+		},
+		{
+			desc:           "parent snapshot does not exist",
+			parentSnapshot: parentSnapshotID,
+			getParentErr:   fmt.Errorf("ErrorCode=ShareSnapshotNotFound"),
+			expectedErr:    status.Errorf(codes.InvalidArgument, "parent snapshot(%s) does not exist", parentSnapshotID),
+		},
+		{
+			desc:           "failed to get parent snapshot",
+			parentSnapshot: parentSnapshotID,
+			getParentErr:   fmt.Errorf("test error"),
+			expectedErr:    status.Errorf(codes.Internal, "failed to check if parent snapshot(%s) exists: test error", parentSnapshotID),
+		},
+		{
+			desc:           "parent snapshot is stored in snapshot metadata",
+			parentSnapshot: parentSnapshotID,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	for _, test := range tests {
+		d := NewFakeDriver()
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "2023-01-01T00:00:00.0000000Z").Return(storage.FileShare{}, test.getParentErr).AnyTimes()
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(storage.FileShare{}, nil).AnyTimes()
+		mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", snapshotsExpand).Return(nil, nil).AnyTimes()
+
+		var createdMetadata map[string]*string
+		mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "account", gomock.Any(), snapshotsExpand).DoAndReturn(
+			func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+				createdMetadata = shareOptions.Metadata
+				return storage.FileShare{FileShareProperties: &storage.FileShareProperties{SnapshotTime: &snapshotTime, ShareQuota: pointer.Int32(100)}}, nil
+			}).AnyTimes()
+
+		req := &csi.CreateSnapshotRequest{
+			SourceVolumeId: sourceVolumeID,
+			Name:           "snapname",
+			Parameters:     map[string]string{"parentSnapshot": test.parentSnapshot},
+		}
+		resp, err := d.CreateSnapshot(context.Background(), req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		if test.expectedErr == nil {
+			assert.Equal(t, sourceVolumeID+"#"+snapshotTime.Format(snapshotTimeFormat), resp.Snapshot.SnapshotId, test.desc)
+			assert.Equal(t, map[string]*string{snapshotNameKey: pointer.String("snapname"), parentSnapshotKey: pointer.String(parentSnapshotID)}, createdMetadata, test.desc)
+		}
+	}
+}
+
+func TestCreateSnapshotSizeBytes(t *testing.T) {
+	sourceVolumeID := "rg#account#share#diskname#uuid#default#subsID"
+	snapshotTime := date.Time{Time: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		desc              string
+		sourceShare       storage.FileShare
+		getSourceErr      error
+		expectedSizeBytes int64
+	}{
+		{
+			desc:              "usage of source file share is returned",
+			sourceShare:       storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), ShareUsageBytes: pointer.Int64(12345)}},
+			expectedSizeBytes: 12345,
+		},
+		{
+			desc:              "quota of snapshot is returned if usage is not returned",
+			sourceShare:       storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}},
+			expectedSizeBytes: 10 * 1024 * 1024 * 1024,
+		},
+		{
+			desc:              "quota of snapshot is returned if source file share could not be got",
+			getSourceErr:      fmt.Errorf("test error"),
+			expectedSizeBytes: 10 * 1024 * 1024 * 1024,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	for _, test := range tests {
+		d := NewFakeDriver()
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(test.sourceShare, test.getSourceErr).Times(2)
+		mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", snapshotsExpand).Return(nil, nil).Times(1)
+		mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "account", gomock.Any(), snapshotsExpand).
+			Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{SnapshotTime: &snapshotTime, ShareQuota: pointer.Int32(10)}}, nil).Times(1)
+
+		req := &csi.CreateSnapshotRequest{SourceVolumeId: sourceVolumeID, Name: "snapname"}
+		resp, err := d.CreateSnapshot(context.Background(), req)
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectedSizeBytes, resp.Snapshot.SizeBytes, test.desc)
+
+		// the same size is returned if snapshot already exists
+		mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", snapshotsExpand).Return([]storage.FileShareItem{
+			{Name: pointer.String("share"), FileShareProperties: &storage.FileShareProperties{SnapshotTime: &snapshotTime, ShareQuota: pointer.Int32(10)}},
+		}, nil).Times(1)
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", snapshotTime.Format(snapshotTimeFormat)).Return(storage.FileShare{
+			Name:                pointer.String("share"),
+			FileShareProperties: &storage.FileShareProperties{Metadata: map[string]*string{snapshotNameKey: pointer.String("snapname")}},
+		}, nil).Times(1)
+		resp, err = d.CreateSnapshot(context.Background(), req)
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectedSizeBytes, resp.Snapshot.SizeBytes, test.desc)
+	}
+}
+
 func TestDeleteSnapshot(t *testing.T) {
 	d := NewFakeDriver()
 	d.cloud = &azure.Cloud{}
@@ -2053,6 +3727,24 @@ func TestControllerExpandVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Volume Capacity range inverted",
+			testFunc: func(t *testing.T) {
+				req := &csi.ControllerExpandVolumeRequest{
+					VolumeId:      "vol_1",
+					CapacityRange: &csi.CapacityRange{RequiredBytes: stdVolSize, LimitBytes: stdVolSize - 1},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				expectedErr := status.Error(codes.InvalidArgument, "RequiredBytes(5368709120) in capacity range must not be larger than LimitBytes(5368709119)")
+				_, err := d.ControllerExpandVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
 		{
 			name: "Volume capabilities missing",
 			testFunc: func(t *testing.T) {
@@ -2164,6 +3856,8 @@ func TestControllerExpandVolume(t *testing.T) {
 				mockFileClient := mockfileclient.NewMockInterface(ctrl)
 				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
 				mockFileClient.EXPECT().ResizeFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("test error")).AnyTimes()
+				shareQuota := int32(0)
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &shareQuota}}, nil).AnyTimes()
 				d.cloud.FileClient = mockFileClient
 
 				expectErr := status.Errorf(codes.Internal, "expand volume error: test error")
@@ -2262,6 +3956,128 @@ func TestControllerExpandVolume(t *testing.T) {
 		},
 	}
 
+	expandTestCases := []struct {
+		name         string
+		capRange     *csi.CapacityRange
+		shareQuota   int32
+		protocol     storage.EnabledProtocols
+		getShareErr  error
+		expectResize bool
+		// number of gets before new quota is reported after resize, new quota is never reported if it's negative
+		staleQuotaGets int
+		expectedResp   *csi.ControllerExpandVolumeResponse
+		expectedErr    error
+	}{
+		{
+			name:         "expand smb file share",
+			capRange:     stdCapRange,
+			shareQuota:   1,
+			protocol:     storage.EnabledProtocolsSMB,
+			expectResize: true,
+			expectedResp: &csi.ControllerExpandVolumeResponse{CapacityBytes: stdVolSize, NodeExpansionRequired: false},
+		},
+		{
+			name:         "expand nfs file share requires node expansion",
+			capRange:     stdCapRange,
+			shareQuota:   1,
+			protocol:     storage.EnabledProtocolsNFS,
+			expectResize: true,
+			expectedResp: &csi.ControllerExpandVolumeResponse{CapacityBytes: stdVolSize, NodeExpansionRequired: true},
+		},
+		{
+			name:           "new quota is reported after retries",
+			capRange:       stdCapRange,
+			shareQuota:     1,
+			protocol:       storage.EnabledProtocolsSMB,
+			expectResize:   true,
+			staleQuotaGets: 2,
+			expectedResp:   &csi.ControllerExpandVolumeResponse{CapacityBytes: stdVolSize, NodeExpansionRequired: false},
+		},
+		{
+			name:           "new quota is never reported",
+			capRange:       stdCapRange,
+			shareQuota:     1,
+			protocol:       storage.EnabledProtocolsSMB,
+			expectResize:   true,
+			staleQuotaGets: -1,
+			expectedErr:    status.Errorf(codes.Internal, "failed to verify quota of volume(rg#f5713de20cde511e8ba4900#filename#) after expansion: quota(1) of file share(filename) on account(f5713de20cde511e8ba4900) is smaller than 5"),
+		},
+		{
+			name:         "no-op when file share is already at requested size",
+			capRange:     stdCapRange,
+			shareQuota:   5,
+			protocol:     storage.EnabledProtocolsSMB,
+			expectedResp: &csi.ControllerExpandVolumeResponse{CapacityBytes: stdVolSize, NodeExpansionRequired: false},
+		},
+		{
+			name:         "no-op when nfs file share is larger than requested size",
+			capRange:     stdCapRange,
+			shareQuota:   10,
+			protocol:     storage.EnabledProtocolsNFS,
+			expectedResp: &csi.ControllerExpandVolumeResponse{CapacityBytes: 10 * 1024 * 1024 * 1024, NodeExpansionRequired: true},
+		},
+		{
+			name:        "shrinking file share is rejected",
+			capRange:    &csi.CapacityRange{RequiredBytes: stdVolSize, LimitBytes: stdVolSize},
+			shareQuota:  10,
+			protocol:    storage.EnabledProtocolsSMB,
+			expectedErr: status.Errorf(codes.InvalidArgument, "shrinking volume(rg#f5713de20cde511e8ba4900#filename#) from 10737418240 bytes to limit 5368709120 bytes is not supported"),
+		},
+		{
+			name:        "file share does not exist",
+			capRange:    stdCapRange,
+			getShareErr: fmt.Errorf("ShareNotFound"),
+			expectedErr: status.Errorf(codes.NotFound, "the requested volume(rg#f5713de20cde511e8ba4900#filename#) does not exist."),
+		},
+	}
+
+	for _, tc := range expandTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.AddControllerServiceCapabilities(
+				[]csi.ControllerServiceCapability_RPC_Type{
+					csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+				})
+			d.cloud = &azure.Cloud{}
+			d.fileShareVerifyBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 4}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockFileClient := mockfileclient.NewMockInterface(ctrl)
+			mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+			quota, resizedQuota, staleQuotaGets := tc.shareQuota, int32(0), tc.staleQuotaGets
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, resourceGroupName, accountName, name, xMsSnapshot string) (storage.FileShare, error) {
+					if resizedQuota > 0 && staleQuotaGets == 0 {
+						quota = resizedQuota
+					} else if resizedQuota > 0 && staleQuotaGets > 0 {
+						staleQuotaGets--
+					}
+					return storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(quota), EnabledProtocols: tc.protocol}}, tc.getShareErr
+				}).AnyTimes()
+			if tc.expectResize {
+				mockFileClient.EXPECT().ResizeFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, resourceGroupName, accountName, name string, sizeGiB int) error {
+						resizedQuota = int32(sizeGiB)
+						return nil
+					}).Times(1)
+			}
+			d.cloud.FileClient = mockFileClient
+
+			req := &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "rg#f5713de20cde511e8ba4900#filename#",
+				CapacityRange: tc.capRange,
+			}
+			resp, err := d.ControllerExpandVolume(context.Background(), req)
+			if !reflect.DeepEqual(err, tc.expectedErr) {
+				t.Errorf("Unexpected error: %v, expected error: %v", err, tc.expectedErr)
+			}
+			if !reflect.DeepEqual(resp, tc.expectedResp) {
+				t.Errorf("Unexpected response: %v, expected response: %v", resp, tc.expectedResp)
+			}
+		})
+	}
+
 	for _, tc := range testCases {
 		t.Run(tc.name, tc.testFunc)
 	}
@@ -2435,6 +4251,29 @@ func TestSnapshotExists(t *testing.T) {
 	}
 }
 
+func TestSetAzureCredentialsWithConnectionString(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+
+	connectionString := buildConnectionString("testName", "testKey", "core.windows.net")
+	result, err := d.setAzureCredentials(context.Background(), "testName", "testKey", connectionString, "", "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "azure-storage-account-testName-secret", result)
+
+	secret, err := d.cloud.KubeClient.CoreV1().Secrets("default").Get(context.Background(), result, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		defaultSecretAccountName:      []byte("testName"),
+		defaultSecretConnectionString: []byte(connectionString),
+	}, secret.Data)
+
+	accountName, accountKey, err := d.GetStorageAccountFromSecret(context.Background(), result, "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "testName", accountName)
+	assert.Equal(t, "testKey", accountKey)
+}
+
 func TestGetCapacity(t *testing.T) {
 	d := NewFakeDriver()
 	req := csi.GetCapacityRequest{}
@@ -2541,3 +4380,444 @@ func TestSetAzureCredentials(t *testing.T) {
 		}
 	}
 }
+
+func TestSetAzureCredentialsWithSecretNamespaceCreation(t *testing.T) {
+	newFakeClient := func(namespaceForbidden bool) *fake.Clientset {
+		client := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+		// secret could not be created in namespace which does not exist
+		client.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if _, err := client.Tracker().Get(v1.SchemeGroupVersion.WithResource("namespaces"), "", action.GetNamespace()); err != nil {
+				return true, nil, err
+			}
+			return false, nil, nil
+		})
+		if namespaceForbidden {
+			client.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(v1.Resource("namespaces"), "", fmt.Errorf("test error"))
+			})
+		}
+		return client
+	}
+
+	tests := []struct {
+		desc                  string
+		createSecretNamespace bool
+		namespaceForbidden    bool
+		secretNamespace       string
+		expectedErr           string
+	}{
+		{
+			desc:                  "secret namespace exists",
+			createSecretNamespace: true,
+			secretNamespace:       "default",
+		},
+		{
+			desc:            "secret namespace is not created by default",
+			secretNamespace: "newns",
+			expectedErr:     `couldn't create secret namespaces "newns" not found`,
+		},
+		{
+			desc:                  "secret namespace is created",
+			createSecretNamespace: true,
+			secretNamespace:       "newns",
+		},
+		{
+			desc:                  "secret namespace creation is forbidden",
+			createSecretNamespace: true,
+			namespaceForbidden:    true,
+			secretNamespace:       "newns",
+			expectedErr:           "couldn't create secret could not create secret namespace(newns), create permission of namespaces should be granted to controller",
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.createSecretNamespace = test.createSecretNamespace
+		client := newFakeClient(test.namespaceForbidden)
+		d.cloud.KubeClient = client
+
+		secretName, err := d.SetAzureCredentials(context.Background(), "testName", "testKey", "", test.secretNamespace)
+		if test.expectedErr != "" {
+			assert.Error(t, err, test.desc)
+			assert.Contains(t, err.Error(), test.expectedErr, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		_, err = client.CoreV1().Namespaces().Get(context.Background(), test.secretNamespace, metav1.GetOptions{})
+		assert.NoError(t, err, test.desc)
+		_, err = client.CoreV1().Secrets(test.secretNamespace).Get(context.Background(), secretName, metav1.GetOptions{})
+		assert.NoError(t, err, test.desc)
+	}
+}
+
+func TestGetPreferredRegions(t *testing.T) {
+	tests := []struct {
+		desc            string
+		requirement     *csi.TopologyRequirement
+		expectedRegions []string
+	}{
+		{
+			desc: "no topology requirement",
+		},
+		{
+			desc: "preferred regions come before requisite regions",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{Segments: map[string]string{topologyRegionKey: "westus"}},
+					{Segments: map[string]string{topologyRegionKey: "eastus"}},
+					{Segments: map[string]string{topologyRegionKey: "centralus"}},
+				},
+				Preferred: []*csi.Topology{
+					{Segments: map[string]string{topologyRegionKey: "eastus"}},
+					{Segments: map[string]string{topologyRegionKey: "westus"}},
+				},
+			},
+			expectedRegions: []string{"eastus", "westus", "centralus"},
+		},
+		{
+			desc: "duplicate regions and topology without region are skipped",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{Segments: map[string]string{"topology.kubernetes.io/zone": "eastus-1"}},
+					{Segments: map[string]string{topologyRegionKey: "EastUS"}},
+				},
+				Preferred: []*csi.Topology{
+					{Segments: map[string]string{topologyRegionKey: "eastus"}},
+				},
+			},
+			expectedRegions: []string{"eastus"},
+		},
+	}
+
+	for _, test := range tests {
+		regions := getPreferredRegions(test.requirement)
+		assert.Equal(t, test.expectedRegions, regions, test.desc)
+	}
+}
+
+func TestGetPreferredNodeOS(t *testing.T) {
+	tests := []struct {
+		desc           string
+		requirement    *csi.TopologyRequirement
+		expectedNodeOS string
+	}{
+		{
+			desc: "no topology requirement",
+		},
+		{
+			desc: "node OS is not in topology",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: map[string]string{topologyRegionKey: "eastus"}}},
+			},
+		},
+		{
+			desc: "node OS of preferred topology comes first",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{Segments: map[string]string{topologyOSKey: "linux"}},
+					{Segments: map[string]string{topologyOSKey: "windows"}},
+				},
+				Preferred: []*csi.Topology{
+					{Segments: map[string]string{topologyRegionKey: "eastus"}},
+					{Segments: map[string]string{topologyRegionKey: "eastus", topologyOSKey: "windows"}},
+				},
+			},
+			expectedNodeOS: "windows",
+		},
+		{
+			desc: "node OS of requisite topology",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: map[string]string{topologyOSKey: "linux"}}},
+			},
+			expectedNodeOS: "linux",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedNodeOS, getPreferredNodeOS(test.requirement), test.desc)
+	}
+}
+
+func TestEnsureStorageAccountInRegions(t *testing.T) {
+	value := "key"
+	keys := storage.AccountListKeysResult{
+		Keys: &[]storage.AccountKey{
+			{Value: &value},
+		},
+	}
+	tests := []struct {
+		desc              string
+		regions           []string
+		failedRegions     map[string]bool
+		failure           string
+		expectedLocations []string
+		expectedLocation  string
+		expectedErr       bool
+	}{
+		{
+			desc:              "account is created in the preferred region",
+			regions:           []string{"eastus", "westus"},
+			expectedLocations: []string{"eastus"},
+			expectedLocation:  "eastus",
+		},
+		{
+			desc:              "fall back to next region when it fails in the preferred region",
+			regions:           []string{"eastus", "westus"},
+			failedRegions:     map[string]bool{"eastus": true},
+			failure:           "ResourceQuotaExceeded",
+			expectedLocations: []string{"eastus", "westus"},
+			expectedLocation:  "westus",
+		},
+		{
+			desc:              "no fall back when it fails in the preferred region due to other errors",
+			regions:           []string{"eastus", "westus"},
+			failedRegions:     map[string]bool{"eastus": true},
+			failure:           "AuthorizationFailed",
+			expectedLocations: []string{"eastus"},
+			expectedErr:       true,
+		},
+		{
+			desc:              "fail in all regions",
+			regions:           []string{"eastus", "westus"},
+			failedRegions:     map[string]bool{"eastus": true, "westus": true},
+			failure:           "SkuNotAvailable",
+			expectedLocations: []string{"eastus", "westus"},
+			expectedErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		ctrl := gomock.NewController(t)
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+		var locations []string
+		mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, subsID, resourceGroup, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+				location := pointer.StringDeref(parameters.Location, "")
+				locations = append(locations, location)
+				if test.failedRegions[location] {
+					return retry.NewError(false, fmt.Errorf("%s in region %s", test.failure, location))
+				}
+				return nil
+			}).AnyTimes()
+		mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+
+		accountOptions := &azure.AccountOptions{
+			ResourceGroup: "rg",
+			CreateAccount: true,
+		}
+		_, accountKey, err := d.ensureStorageAccountInRegions(context.Background(), accountOptions, test.regions)
+		assert.Equal(t, test.expectedErr, err != nil, test.desc)
+		assert.Equal(t, test.expectedLocations, locations, test.desc)
+		if !test.expectedErr {
+			assert.Equal(t, value, accountKey, test.desc)
+			assert.Equal(t, test.expectedLocation, accountOptions.Location, test.desc)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestEnsureSingleAccount(t *testing.T) {
+	value := "key"
+	keys := storage.AccountListKeysResult{
+		Keys: &[]storage.AccountKey{
+			{Value: &value},
+		},
+	}
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, SingleAccountName: "singleaccount"})
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	// account does not exist until it's created, it should be created only once by concurrent calls
+	var created int32
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "rg", "singleaccount").DoAndReturn(
+		func(ctx context.Context, subsID, resourceGroup, accountName string) (storage.AccountListKeysResult, *retry.Error) {
+			if atomic.LoadInt32(&created) == 0 {
+				return storage.AccountListKeysResult{}, retry.NewError(false, fmt.Errorf("account not found"))
+			}
+			return keys, nil
+		}).AnyTimes()
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", "singleaccount", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, subsID, resourceGroup, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+			atomic.AddInt32(&created, 1)
+			return nil
+		}).Times(1)
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "singleaccount").
+		Return(storage.Account{Sku: &storage.Sku{Name: storage.SkuNameStandardLRS}, Kind: storage.KindStorageV2}, nil).Times(1)
+
+	accountKeys := make(chan string, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			accountKey, err := d.ensureSingleAccount(context.Background(), &azure.AccountOptions{ResourceGroup: "rg", Type: "Standard_LRS"})
+			assert.NoError(t, err)
+			accountKeys <- accountKey
+		}()
+	}
+	wg.Wait()
+	close(accountKeys)
+
+	// account key is only returned by the call which ensures the account
+	var returnedKeys []string
+	for accountKey := range accountKeys {
+		if accountKey != "" {
+			returnedKeys = append(returnedKeys, accountKey)
+		}
+	}
+	assert.Equal(t, []string{value}, returnedKeys)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created))
+	assert.True(t, d.singleAccountEnsured)
+	assert.Equal(t, string(storage.SkuNameStandardLRS), d.singleAccountSKU)
+	assert.False(t, d.singleAccountSupportsNFS)
+}
+
+func TestCheckSingleAccount(t *testing.T) {
+	tests := []struct {
+		desc          string
+		accountSKU    string
+		supportsNFS   bool
+		sku           string
+		protocol      string
+		expectedError error
+	}{
+		{
+			desc:       "empty sku matches any account",
+			accountSKU: "Standard_LRS",
+			protocol:   smb,
+		},
+		{
+			desc:       "sku matches account",
+			accountSKU: "Premium_LRS",
+			sku:        "premium_lrs",
+			protocol:   smb,
+		},
+		{
+			desc:          "sku conflicts with account",
+			accountSKU:    "Standard_LRS",
+			sku:           "Standard_GRS",
+			protocol:      smb,
+			expectedError: fmt.Errorf("skuName(Standard_GRS) conflicts with skuName(Standard_LRS) of single storage account(singleaccount)"),
+		},
+		{
+			desc:        "nfs protocol is supported by account",
+			accountSKU:  "Premium_LRS",
+			supportsNFS: true,
+			protocol:    nfs,
+		},
+		{
+			desc:          "nfs protocol is not supported by account",
+			accountSKU:    "Premium_LRS",
+			protocol:      nfs,
+			expectedError: fmt.Errorf("nfs protocol is not supported by single storage account(singleaccount), it requires premium account with secure transfer disabled"),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, SingleAccountName: "singleaccount"})
+		d.singleAccountSKU = test.accountSKU
+		d.singleAccountSupportsNFS = test.supportsNFS
+		assert.Equal(t, test.expectedError, d.checkSingleAccount(test.sku, test.protocol), test.desc)
+	}
+}
+
+func TestCreateVolumeWithSingleAccount(t *testing.T) {
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, SingleAccountName: "Invalid_Account"}))
+
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, SingleAccountName: "singleaccount"})
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	d.cloud.ResourceGroup = "rg"
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// storage account is not selected from accounts in resource group
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "rg", "singleaccount").Return(storage.AccountListKeysResult{}, retry.NewError(false, fmt.Errorf("account not found"))).Times(1)
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", "singleaccount", gomock.Any()).Return(retry.NewError(false, fmt.Errorf("create error"))).Times(1)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	req := &csi.CreateVolumeRequest{
+		Name: "vol",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			},
+		},
+	}
+	_, err := d.CreateVolume(context.Background(), req)
+	assert.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), "failed to ensure single storage account(singleaccount)")
+	assert.False(t, d.singleAccountEnsured)
+}
+
+func TestCheckAccessModeConflicts(t *testing.T) {
+	getVolumeCapabilities := func(modes ...csi.VolumeCapability_AccessMode_Mode) []*csi.VolumeCapability {
+		var volCaps []*csi.VolumeCapability
+		for _, mode := range modes {
+			volCaps = append(volCaps, &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+			})
+		}
+		return volCaps
+	}
+
+	tests := []struct {
+		desc        string
+		volCaps     []*csi.VolumeCapability
+		expectedErr error
+	}{
+		{
+			desc:    "single access mode",
+			volCaps: getVolumeCapabilities(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		},
+		{
+			desc: "read only modes are compatible with writer modes",
+			volCaps: getVolumeCapabilities(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+		},
+		{
+			desc: "single node writer modes",
+			volCaps: getVolumeCapabilities(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER),
+		},
+		{
+			desc: "multiple node writer modes",
+			volCaps: getVolumeCapabilities(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+		},
+		{
+			desc: "single node writer and multiple node writer",
+			volCaps: getVolumeCapabilities(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+			expectedErr: fmt.Errorf("access mode SINGLE_NODE_WRITER conflicts with access mode MULTI_NODE_MULTI_WRITER"),
+		},
+		{
+			desc: "multiple node single writer and single node writer",
+			volCaps: getVolumeCapabilities(csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+				csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER),
+			expectedErr: fmt.Errorf("access mode SINGLE_NODE_SINGLE_WRITER conflicts with access mode MULTI_NODE_SINGLE_WRITER"),
+		},
+		{
+			desc: "single writer and multiple writers",
+			volCaps: getVolumeCapabilities(csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+				csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER),
+			expectedErr: fmt.Errorf("access mode SINGLE_NODE_SINGLE_WRITER conflicts with access mode SINGLE_NODE_MULTI_WRITER"),
+		},
+	}
+
+	for _, test := range tests {
+		err := checkAccessModeConflicts(test.volCaps)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+
+	assert.Equal(t, accessModeConflictReject, NewFakeDriver().accessModeConflictPolicy)
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, AccessModeConflictPolicy: "random"}))
+}
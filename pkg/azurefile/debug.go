@@ -0,0 +1,296 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultDebugEndpointAddress is the default address of debug endpoint which exports driver config
+	DefaultDebugEndpointAddress = "localhost:29615"
+	debugConfigPath             = "/config"
+	debugVolumesPath            = "/volumes"
+)
+
+// driverConfig is the effective configuration of driver exported by debug endpoint,
+// never put secrets (e.g. aadClientSecret in cloud config) into this struct
+type driverConfig struct {
+	DriverName                             string        `json:"driverName"`
+	DriverVersion                          string        `json:"driverVersion"`
+	NodeID                                 string        `json:"nodeID"`
+	CloudConfigSecretName                  string        `json:"cloudConfigSecretName"`
+	CloudConfigSecretNamespace             string        `json:"cloudConfigSecretNamespace"`
+	CustomUserAgent                        string        `json:"customUserAgent"`
+	UserAgentSuffix                        string        `json:"userAgentSuffix"`
+	AllowEmptyCloudConfig                  bool          `json:"allowEmptyCloudConfig"`
+	AllowInlineVolumeKeyAccessWithIdentity bool          `json:"allowInlineVolumeKeyAccessWithIdentity"`
+	EnableVHDDiskFeature                   bool          `json:"enableVHDDiskFeature"`
+	EnableGetVolumeStats                   bool          `json:"enableGetVolumeStats"`
+	AppendMountErrorHelpLink               bool          `json:"appendMountErrorHelpLink"`
+	MountPermissions                       string        `json:"mountPermissions"`
+	FSGroupChangePolicy                    string        `json:"fsGroupChangePolicy"`
+	KubeAPIQPS                             float64       `json:"kubeAPIQPS"`
+	KubeAPIBurst                           int           `json:"kubeAPIBurst"`
+	EnableWindowsHostProcess               bool          `json:"enableWindowsHostProcess"`
+	MinimumTLSVersion                      string        `json:"minimumTLSVersion"`
+	EmitAuditEvents                        bool          `json:"emitAuditEvents"`
+	SnapshotBeforeDelete                   bool          `json:"snapshotBeforeDelete"`
+	MaxVolumesPerNode                      int64         `json:"maxVolumesPerNode"`
+	EnableOrphanGC                         bool          `json:"enableOrphanGC"`
+	OrphanGCInterval                       string        `json:"orphanGCInterval"`
+	OrphanGCThreshold                      string        `json:"orphanGCThreshold"`
+	MaxOperationTimeout                    string        `json:"maxOperationTimeout"`
+	EnableTracing                          bool          `json:"enableTracing"`
+	OTLPEndpoint                           string        `json:"otlpEndpoint"`
+	EnableSMBVersionFallback               bool          `json:"enableSMBVersionFallback"`
+	NodeCredentialCacheEnabled             bool          `json:"nodeCredentialCacheEnabled"`
+	ARMQPS                                 float64       `json:"armQPS"`
+	ARMBurst                               int           `json:"armBurst"`
+	DefaultShareQuotaGiB                   int           `json:"defaultShareQuotaGiB"`
+	ApplyDefaultMountOptions               bool          `json:"applyDefaultMountOptions"`
+	PVCAnnotationAllowlist                 []string      `json:"pvcAnnotationAllowlist"`
+	CheckShareCapacityOnMount              bool          `json:"checkShareCapacityOnMount"`
+	FailOnFullShare                        bool          `json:"failOnFullShare"`
+	ShutdownTimeout                        string        `json:"shutdownTimeout"`
+	AllowedSKUs                            []string      `json:"allowedSKUs"`
+	AllowMismatchedStagingMount            bool          `json:"allowMismatchedStagingMount"`
+	EnableRegionalAccountAffinity          bool          `json:"enableRegionalAccountAffinity"`
+	MountHealthCheckInterval               string        `json:"mountHealthCheckInterval"`
+	SingleAccountName                      string        `json:"singleAccountName"`
+	WaitForShareDelete                     bool          `json:"waitForShareDelete"`
+	WaitForShareDeleteTimeout              string        `json:"waitForShareDeleteTimeout"`
+	PreferFlagResourceGroup                bool          `json:"preferFlagResourceGroup"`
+	CSISocketPermissions                   string        `json:"csiSocketPermissions"`
+	MaxGRPCMessageSize                     int           `json:"maxGRPCMessageSize"`
+	SecretLabelSelector                    string        `json:"secretLabelSelector"`
+	NodeMountRetries                       int           `json:"nodeMountRetries"`
+	NodeMountRetryInterval                 string        `json:"nodeMountRetryInterval"`
+	ProvisionRetries                       int           `json:"provisionRetries"`
+	ProvisionRetryInterval                 string        `json:"provisionRetryInterval"`
+	EnableNodeOSTopology                   bool          `json:"enableNodeOSTopology"`
+	AccountSelectionStrategy               string        `json:"accountSelectionStrategy"`
+	LazyUnmountOnBusy                      bool          `json:"lazyUnmountOnBusy"`
+	RequiredTags                           []string      `json:"requiredTags"`
+	MinShareQuotaGiB                       int           `json:"minShareQuotaGiB"`
+	MaxShareQuotaGiB                       int           `json:"maxShareQuotaGiB"`
+	EnableFailoverAwareness                bool          `json:"enableFailoverAwareness"`
+	VolumeHandleVersion                    string        `json:"volumeHandleVersion"`
+	VolumeFullThresholdPercent             int           `json:"volumeFullThresholdPercent"`
+	MaxConcurrentFormat                    int           `json:"maxConcurrentFormat"`
+	CABundlePath                           string        `json:"caBundlePath"`
+	ShareNameCollisionStrategy             string        `json:"shareNameCollisionStrategy"`
+	FailOnLockedResourceGroup              bool          `json:"failOnLockedResourceGroup"`
+	AccessModeConflictPolicy               string        `json:"accessModeConflictPolicy"`
+	ProfileConfigMap                       string        `json:"profileConfigMap"`
+	NetworkRulePropagationRetries          int           `json:"networkRulePropagationRetries"`
+	NetworkRulePropagationRetryInterval    string        `json:"networkRulePropagationRetryInterval"`
+	CreateSecretNamespace                  bool          `json:"createSecretNamespace"`
+	Cloud                                  *cloudSummary `json:"cloud,omitempty"`
+}
+
+// cloudSummary is the non-secret part of cloud provider config
+type cloudSummary struct {
+	Cloud                       string `json:"cloud"`
+	TenantID                    string `json:"tenantId"`
+	SubscriptionID              string `json:"subscriptionId"`
+	ResourceGroup               string `json:"resourceGroup"`
+	Location                    string `json:"location"`
+	VnetName                    string `json:"vnetName"`
+	VnetResourceGroup           string `json:"vnetResourceGroup"`
+	SubnetName                  string `json:"subnetName"`
+	UseManagedIdentityExtension bool   `json:"useManagedIdentityExtension"`
+}
+
+// getDriverConfig returns the effective configuration of driver, subscription ID is redacted if redactSubscriptionID is set
+func (d *Driver) getDriverConfig() *driverConfig {
+	config := &driverConfig{
+		DriverName:                             d.Name,
+		DriverVersion:                          d.Version,
+		NodeID:                                 d.NodeID,
+		CloudConfigSecretName:                  d.cloudConfigSecretName,
+		CloudConfigSecretNamespace:             d.cloudConfigSecretNamespace,
+		CustomUserAgent:                        d.customUserAgent,
+		UserAgentSuffix:                        d.userAgentSuffix,
+		AllowEmptyCloudConfig:                  d.allowEmptyCloudConfig,
+		AllowInlineVolumeKeyAccessWithIdentity: d.allowInlineVolumeKeyAccessWithIdentity,
+		EnableVHDDiskFeature:                   d.enableVHDDiskFeature,
+		EnableGetVolumeStats:                   d.enableGetVolumeStats,
+		AppendMountErrorHelpLink:               d.appendMountErrorHelpLink,
+		MountPermissions:                       fmt.Sprintf("%#o", d.mountPermissions),
+		FSGroupChangePolicy:                    d.fsGroupChangePolicy,
+		KubeAPIQPS:                             d.kubeAPIQPS,
+		KubeAPIBurst:                           d.kubeAPIBurst,
+		EnableWindowsHostProcess:               d.enableWindowsHostProcess,
+		MinimumTLSVersion:                      d.minimumTLSVersion,
+		EmitAuditEvents:                        d.emitAuditEvents,
+		SnapshotBeforeDelete:                   d.snapshotBeforeDelete,
+		MaxVolumesPerNode:                      d.maxVolumesPerNode,
+		EnableOrphanGC:                         d.enableOrphanGC,
+		OrphanGCInterval:                       d.orphanGCInterval.String(),
+		OrphanGCThreshold:                      d.orphanGCThreshold.String(),
+		MaxOperationTimeout:                    d.maxOperationTimeout.String(),
+		EnableTracing:                          d.enableTracing,
+		OTLPEndpoint:                           d.otlpEndpoint,
+		EnableSMBVersionFallback:               d.enableSMBVersionFallback,
+		NodeCredentialCacheEnabled:             d.nodeCredentialCache != nil,
+		ARMQPS:                                 d.armQPS,
+		ARMBurst:                               d.armBurst,
+		DefaultShareQuotaGiB:                   d.defaultShareQuotaGiB,
+		ApplyDefaultMountOptions:               d.enableDefaultMountOptions,
+		PVCAnnotationAllowlist:                 []string{},
+		CheckShareCapacityOnMount:              d.checkShareCapacityOnMount,
+		FailOnFullShare:                        d.failOnFullShare,
+		ShutdownTimeout:                        d.shutdownTimeout.String(),
+		AllowedSKUs:                            d.allowedSKUs,
+		AllowMismatchedStagingMount:            d.allowMismatchedStagingMount,
+		EnableRegionalAccountAffinity:          d.enableRegionalAccountAffinity,
+		MountHealthCheckInterval:               d.mountHealthCheckInterval.String(),
+		SingleAccountName:                      d.singleAccountName,
+		WaitForShareDelete:                     d.waitForShareDelete,
+		WaitForShareDeleteTimeout:              d.waitForShareDeleteTimeout.String(),
+		PreferFlagResourceGroup:                d.preferFlagResourceGroup,
+		CSISocketPermissions:                   fmt.Sprintf("%#o", d.csiSocketPermissions),
+		MaxGRPCMessageSize:                     d.maxGRPCMessageSize,
+		NodeMountRetries:                       d.nodeMountRetries,
+		NodeMountRetryInterval:                 d.nodeMountRetryInterval.String(),
+		ProvisionRetries:                       d.provisionRetries,
+		ProvisionRetryInterval:                 d.provisionRetryInterval.String(),
+		EnableNodeOSTopology:                   d.enableNodeOSTopology,
+		AccountSelectionStrategy:               d.accountSelectionStrategy,
+		LazyUnmountOnBusy:                      d.lazyUnmountOnBusy,
+		RequiredTags:                           d.requiredTags,
+		MinShareQuotaGiB:                       d.minShareQuotaGiB,
+		MaxShareQuotaGiB:                       d.maxShareQuotaGiB,
+		EnableFailoverAwareness:                d.enableFailoverAwareness,
+		VolumeHandleVersion:                    d.volumeHandleVersion,
+		VolumeFullThresholdPercent:             d.volumeFullThresholdPercent,
+		MaxConcurrentFormat:                    d.maxConcurrentFormat,
+		CABundlePath:                           d.caBundlePath,
+		ShareNameCollisionStrategy:             d.shareNameCollisionStrategy,
+		FailOnLockedResourceGroup:              d.failOnLockedResourceGroup,
+		AccessModeConflictPolicy:               d.accessModeConflictPolicy,
+		ProfileConfigMap:                       d.profileConfigMap,
+		NetworkRulePropagationRetries:          d.networkRulePropagationRetries,
+		NetworkRulePropagationRetryInterval:    d.networkRulePropagationRetryInterval.String(),
+		CreateSecretNamespace:                  d.createSecretNamespace,
+	}
+	if d.secretLabelSelector != nil {
+		config.SecretLabelSelector = d.secretLabelSelector.String()
+	}
+	for name := range d.pvcAnnotationAllowlist {
+		config.PVCAnnotationAllowlist = append(config.PVCAnnotationAllowlist, name)
+	}
+	sort.Strings(config.PVCAnnotationAllowlist)
+	if d.cloud != nil {
+		config.Cloud = &cloudSummary{
+			Cloud:                       d.cloud.Cloud,
+			TenantID:                    d.cloud.TenantID,
+			SubscriptionID:              d.cloud.SubscriptionID,
+			ResourceGroup:               d.cloud.ResourceGroup,
+			Location:                    d.cloud.Location,
+			VnetName:                    d.cloud.VnetName,
+			VnetResourceGroup:           d.cloud.VnetResourceGroup,
+			SubnetName:                  d.cloud.SubnetName,
+			UseManagedIdentityExtension: d.cloud.UseManagedIdentityExtension,
+		}
+		if d.redactSubscriptionID && config.Cloud.SubscriptionID != "" {
+			config.Cloud.SubscriptionID = redactedValue
+		}
+	}
+	return config
+}
+
+// validateDebugEndpointAddress checks that debug endpoint only listens on loopback address
+func validateDebugEndpointAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid debug endpoint address(%s): %v", address, err)
+	}
+	if strings.EqualFold(host, "localhost") {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("debug endpoint address(%s) must be a loopback address", address)
+}
+
+// getStagedVolumes returns volumes staged on this node sorted by volume ID
+func (d *Driver) getStagedVolumes() []*stagedVolume {
+	volumes := []*stagedVolume{}
+	d.stagedVolumes.Range(func(_, v interface{}) bool {
+		volumes = append(volumes, v.(*stagedVolume))
+		return true
+	})
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].VolumeID < volumes[j].VolumeID })
+	return volumes
+}
+
+// serveJSON writes the value returned by get in JSON, only GET method is allowed
+func serveJSON(w http.ResponseWriter, r *http.Request, name string, get func() interface{}) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(get()); err != nil {
+		klog.Errorf("failed to encode %s: %v", name, err)
+	}
+}
+
+// serveDebugConfig writes the effective configuration of driver in JSON
+func (d *Driver) serveDebugConfig(w http.ResponseWriter, r *http.Request) {
+	serveJSON(w, r, "driver config", func() interface{} { return d.getDriverConfig() })
+}
+
+// serveStagedVolumes writes volumes staged on this node with effective mount options in JSON
+func (d *Driver) serveStagedVolumes(w http.ResponseWriter, r *http.Request) {
+	serveJSON(w, r, "staged volumes", func() interface{} { return d.getStagedVolumes() })
+}
+
+// runDebugEndpoint serves driver config and staged volumes on debug endpoint address, it's only called after cloud provider is initialized
+func (d *Driver) runDebugEndpoint() error {
+	if err := validateDebugEndpointAddress(d.debugEndpointAddress); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", d.debugEndpointAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on debug endpoint address(%s): %v", d.debugEndpointAddress, err)
+	}
+	m := http.NewServeMux()
+	m.HandleFunc(debugConfigPath, d.serveDebugConfig)
+	m.HandleFunc(debugVolumesPath, d.serveStagedVolumes)
+	server := &http.Server{Handler: m, ReadHeaderTimeout: 10 * time.Second}
+	klog.V(2).Infof("serve driver config on debug endpoint http://%s%s and staged volumes on http://%s%s", l.Addr().String(), debugConfigPath, l.Addr().String(), debugVolumesPath)
+	go func() {
+		if err := server.Serve(l); err != nil {
+			klog.Errorf("debug endpoint(%s) stopped with error: %v", d.debugEndpointAddress, err)
+		}
+	}()
+	return nil
+}
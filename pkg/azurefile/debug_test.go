@@ -0,0 +1,147 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDebugConfig(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud.SubscriptionID = "subsID"
+	d.cloud.ResourceGroup = "rg"
+	d.cloud.AADClientSecret = "aadClientSecretValue"
+	d.cloud.AADClientCertPassword = "aadClientCertPasswordValue"
+	d.maxVolumesPerNode = 8
+	d.mountPermissions = 0777
+	d.maxOperationTimeout = 5 * time.Minute
+
+	tests := []struct {
+		desc                   string
+		redactSubscriptionID   bool
+		expectedSubscriptionID string
+	}{
+		{
+			desc:                   "subscription ID is redacted",
+			redactSubscriptionID:   true,
+			expectedSubscriptionID: redactedValue,
+		},
+		{
+			desc:                   "subscription ID is not redacted",
+			redactSubscriptionID:   false,
+			expectedSubscriptionID: "subsID",
+		},
+	}
+
+	for _, test := range tests {
+		d.redactSubscriptionID = test.redactSubscriptionID
+		w := httptest.NewRecorder()
+		d.serveDebugConfig(w, httptest.NewRequest(http.MethodGet, debugConfigPath, nil))
+		assert.Equal(t, http.StatusOK, w.Code, test.desc)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"), test.desc)
+
+		body := w.Body.String()
+		assert.NotContains(t, body, "aadClientSecretValue", test.desc)
+		assert.NotContains(t, body, "aadClientCertPasswordValue", test.desc)
+
+		config := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &config), test.desc)
+		assert.Equal(t, fakeDriverName, config["driverName"], test.desc)
+		assert.Equal(t, float64(8), config["maxVolumesPerNode"], test.desc)
+		assert.Equal(t, "5m0s", config["maxOperationTimeout"], test.desc)
+		assert.Equal(t, "0777", config["mountPermissions"], test.desc)
+		cloud, ok := config["cloud"].(map[string]interface{})
+		assert.True(t, ok, test.desc)
+		assert.Equal(t, test.expectedSubscriptionID, cloud["subscriptionId"], test.desc)
+		assert.Equal(t, "rg", cloud["resourceGroup"], test.desc)
+		assert.NotContains(t, cloud, "aadClientSecret", test.desc)
+	}
+
+	w := httptest.NewRecorder()
+	d.serveDebugConfig(w, httptest.NewRequest(http.MethodPost, debugConfigPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestServeStagedVolumes(t *testing.T) {
+	d := NewFakeDriver()
+	w := httptest.NewRecorder()
+	d.serveStagedVolumes(w, httptest.NewRequest(http.MethodGet, debugVolumesPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]\n", w.Body.String())
+
+	d.stagedVolumes.Store("vol_2", &stagedVolume{VolumeID: "vol_2", StagingPath: "/staging/vol_2", MountOptions: []string{"vers=4,minorversion=1"}})
+	d.stagedVolumes.Store("vol_1", &stagedVolume{VolumeID: "vol_1", StagingPath: "/staging/vol_1", MountOptions: []string{"dir_mode=0777", "username=***,password=***"}})
+	w = httptest.NewRecorder()
+	d.serveStagedVolumes(w, httptest.NewRequest(http.MethodGet, debugVolumesPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	volumes := []stagedVolume{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &volumes))
+	expected := []stagedVolume{
+		{VolumeID: "vol_1", StagingPath: "/staging/vol_1", MountOptions: []string{"dir_mode=0777", "username=***,password=***"}},
+		{VolumeID: "vol_2", StagingPath: "/staging/vol_2", MountOptions: []string{"vers=4,minorversion=1"}},
+	}
+	assert.Equal(t, expected, volumes)
+
+	w = httptest.NewRecorder()
+	d.serveStagedVolumes(w, httptest.NewRequest(http.MethodPut, debugVolumesPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestValidateDebugEndpointAddress(t *testing.T) {
+	tests := []struct {
+		address     string
+		expectedErr error
+	}{
+		{
+			address:     "localhost:29615",
+			expectedErr: nil,
+		},
+		{
+			address:     "127.0.0.1:29615",
+			expectedErr: nil,
+		},
+		{
+			address:     "[::1]:29615",
+			expectedErr: nil,
+		},
+		{
+			address:     "0.0.0.0:29615",
+			expectedErr: fmt.Errorf("debug endpoint address(0.0.0.0:29615) must be a loopback address"),
+		},
+		{
+			address:     ":29615",
+			expectedErr: fmt.Errorf("debug endpoint address(:29615) must be a loopback address"),
+		},
+		{
+			address:     "localhost",
+			expectedErr: fmt.Errorf("invalid debug endpoint address(localhost): address localhost: missing port in address"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateDebugEndpointAddress(test.address)
+		assert.Equal(t, test.expectedErr, err, test.address)
+	}
+}
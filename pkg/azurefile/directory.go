@@ -0,0 +1,119 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// max number of directories in initialDirectories parameter
+	maxInitialDirectories = 100
+	// max length of a directory name on file share
+	maxDirectoryNameLength = 255
+	// characters which are not allowed in directory name on file share
+	invalidDirectoryNameChars = "\"\\:|<>*?"
+)
+
+// parseInitialDirectories parses comma separated directory paths(e.g. "data,logs/app") relative to root of file share,
+// paths are cleaned and paths which could escape file share(e.g. "../data") are rejected
+func parseInitialDirectories(value string) ([]string, error) {
+	var dirs []string
+	for _, dir := range strings.Split(value, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
+		}
+		if strings.HasPrefix(dir, "/") || strings.HasPrefix(dir, "\\") {
+			return nil, fmt.Errorf("directory(%s) in %s should be a relative path", dir, initialDirectoriesField)
+		}
+		for _, name := range strings.Split(dir, "/") {
+			if name == ".." {
+				return nil, fmt.Errorf("directory(%s) in %s should not contain '..'", dir, initialDirectoriesField)
+			}
+			if len(name) > maxDirectoryNameLength {
+				return nil, fmt.Errorf("directory name(%s) in %s is longer than %d characters", name, initialDirectoriesField, maxDirectoryNameLength)
+			}
+			if strings.ContainsAny(name, invalidDirectoryNameChars) || strings.IndexFunc(name, func(r rune) bool { return r < 0x20 }) >= 0 {
+				return nil, fmt.Errorf("directory(%s) in %s contains invalid characters", dir, initialDirectoriesField)
+			}
+		}
+		if dir = path.Clean(dir); dir == "." {
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	if len(dirs) > maxInitialDirectories {
+		return nil, fmt.Errorf("number of directories(%d) in %s exceeds limit(%d)", len(dirs), initialDirectoriesField, maxInitialDirectories)
+	}
+	return dirs, nil
+}
+
+// getDirectoriesToCreate returns directories to create in order, parent directories are created before sub directories
+// since file share does not create parent directories automatically, e.g. ["a/b", "c"] -> ["a", "a/b", "c"]
+func getDirectoriesToCreate(dirs []string) []string {
+	var result []string
+	created := make(map[string]bool)
+	for _, dir := range dirs {
+		names := strings.Split(dir, "/")
+		for i := range names {
+			parent := strings.Join(names[:i+1], "/")
+			if !created[parent] {
+				created[parent] = true
+				result = append(result, parent)
+			}
+		}
+	}
+	return result
+}
+
+// createDirectories creates directories on file share, existing directories are skipped
+func createDirectories(ctx context.Context, shareURL azfile.ShareURL, dirs []string) error {
+	for _, dir := range getDirectoriesToCreate(dirs) {
+		if _, err := shareURL.NewDirectoryURL(dir).Create(ctx, azfile.Metadata{}, azfile.SMBProperties{}); err != nil {
+			if stgErr, ok := err.(azfile.StorageError); ok && stgErr.ServiceCode() == azfile.ServiceCodeResourceAlreadyExists {
+				klog.V(4).Infof("directory(%s) already exists", dir)
+				continue
+			}
+			return fmt.Errorf("failed to create directory(%s): %v", dir, err)
+		}
+		klog.V(2).Infof("directory(%s) is created", dir)
+	}
+	return nil
+}
+
+// createInitialDirectories creates directory layout on file share by data plane API after file share is created
+func createInitialDirectories(ctx context.Context, accountName, accountKey, storageEndpointSuffix, shareName string, dirs []string, httpClient *http.Client) error {
+	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return fmt.Errorf("NewSharedKeyCredential(%s) failed with error: %v", accountName, err)
+	}
+	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, accountName, storageEndpointSuffix))
+	if err != nil {
+		return fmt.Errorf("parse serviceURLTemplate error: %v", err)
+	}
+	shareURL := azfile.NewServiceURL(*u, newFilePipeline(credential, azfile.PipelineOptions{}, httpClient)).NewShareURL(shareName)
+	return createDirectories(ctx, shareURL, dirs)
+}
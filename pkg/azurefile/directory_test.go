@@ -0,0 +1,161 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInitialDirectories(t *testing.T) {
+	tests := []struct {
+		desc         string
+		value        string
+		expectedDirs []string
+		expectedErr  error
+	}{
+		{
+			desc: "empty value",
+		},
+		{
+			desc:         "valid directories",
+			value:        "data, logs/app,,config/",
+			expectedDirs: []string{"data", "logs/app", "config"},
+		},
+		{
+			desc:         "paths are cleaned",
+			value:        "./data//raw,logs/./app,.",
+			expectedDirs: []string{"data/raw", "logs/app"},
+		},
+		{
+			desc:        "absolute path",
+			value:       "/data",
+			expectedErr: fmt.Errorf("directory(/data) in initialdirectories should be a relative path"),
+		},
+		{
+			desc:        "path traversal",
+			value:       "data/../../etc",
+			expectedErr: fmt.Errorf("directory(data/../../etc) in initialdirectories should not contain '..'"),
+		},
+		{
+			desc:        "path traversal in the middle",
+			value:       "data/../logs",
+			expectedErr: fmt.Errorf("directory(data/../logs) in initialdirectories should not contain '..'"),
+		},
+		{
+			desc:        "backslash is not allowed",
+			value:       "data\\..\\etc",
+			expectedErr: fmt.Errorf("directory(data\\..\\etc) in initialdirectories contains invalid characters"),
+		},
+		{
+			desc:        "invalid character",
+			value:       "data:raw",
+			expectedErr: fmt.Errorf("directory(data:raw) in initialdirectories contains invalid characters"),
+		},
+		{
+			desc:        "directory name is too long",
+			value:       strings.Repeat("a", 256),
+			expectedErr: fmt.Errorf("directory name(%s) in initialdirectories is longer than 255 characters", strings.Repeat("a", 256)),
+		},
+		{
+			desc:        "too many directories",
+			value:       strings.Repeat("a,", 101),
+			expectedErr: fmt.Errorf("number of directories(101) in initialdirectories exceeds limit(100)"),
+		},
+	}
+
+	for _, test := range tests {
+		dirs, err := parseInitialDirectories(test.value)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedDirs, dirs, test.desc)
+	}
+}
+
+func TestGetDirectoriesToCreate(t *testing.T) {
+	tests := []struct {
+		desc     string
+		dirs     []string
+		expected []string
+	}{
+		{
+			desc: "no directories",
+		},
+		{
+			desc:     "parent directories are created first",
+			dirs:     []string{"a/b/c", "d"},
+			expected: []string{"a", "a/b", "a/b/c", "d"},
+		},
+		{
+			desc:     "duplicate directories are created once",
+			dirs:     []string{"a/b", "a", "a/c", "a/b"},
+			expected: []string{"a", "a/b", "a/c"},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getDirectoriesToCreate(test.dirs), test.desc)
+	}
+}
+
+func TestCreateDirectories(t *testing.T) {
+	var lock sync.Mutex
+	var created []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Query().Get("restype") != "directory" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		dir := strings.TrimPrefix(r.URL.Path, "/share/")
+		switch dir {
+		case "existing":
+			w.Header().Set("x-ms-error-code", string(azfile.ServiceCodeResourceAlreadyExists))
+			w.WriteHeader(http.StatusConflict)
+		case "forbidden":
+			w.Header().Set("x-ms-error-code", "AuthorizationFailure")
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			lock.Lock()
+			created = append(created, dir)
+			lock.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	shareURL := azfile.NewServiceURL(*u, azfile.NewPipeline(azfile.NewAnonymousCredential(), azfile.PipelineOptions{})).NewShareURL("share")
+
+	// existing directory is skipped
+	assert.NoError(t, createDirectories(context.Background(), shareURL, []string{"data/raw", "existing/app"}))
+	assert.Equal(t, []string{"data", "data/raw", "existing/app"}, created)
+
+	created = nil
+	err = createDirectories(context.Background(), shareURL, []string{"logs", "forbidden/app"})
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "failed to create directory(forbidden)"), err.Error())
+	assert.Equal(t, []string{"logs"}, created)
+}
@@ -66,4 +66,18 @@ func TestGetPluginCapabilities(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, resp)
 	assert.Equal(t, resp.XXX_sizecache, int32(0))
+	assert.Equal(t, 1, len(resp.GetCapabilities()))
+
+	d.enableRegionalAccountAffinity = true
+	resp, err = d.GetPluginCapabilities(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(resp.GetCapabilities()))
+	assert.Equal(t, csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS, resp.GetCapabilities()[1].GetService().GetType())
+
+	d.enableRegionalAccountAffinity = false
+	d.enableNodeOSTopology = true
+	resp, err = d.GetPluginCapabilities(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(resp.GetCapabilities()))
+	assert.Equal(t, csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS, resp.GetCapabilities()[1].GetService().GetType())
 }
@@ -0,0 +1,157 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.1/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	azure2 "github.com/Azure/go-autorest/autorest/azure"
+	"k8s.io/klog/v2"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	azureconfig "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
+)
+
+const (
+	// account keys read from key vault are cached for keyVaultSecretCacheTTL
+	keyVaultSecretCacheTTL = 5 * time.Minute
+)
+
+// secret name in key vault could only contain alphanumeric characters and dashes
+var keyVaultSecretNameRegex = regexp.MustCompile(`^[0-9a-zA-Z-]{1,127}$`)
+
+// keyVaultSecretGetter gets value of secret in Azure Key Vault
+type keyVaultSecretGetter interface {
+	GetSecret(ctx context.Context, vaultURL, secretName string) (string, error)
+}
+
+// keyVaultSecretClient gets latest version of key vault secret by key vault client of Azure SDK with the credential of cloud provider
+type keyVaultSecretClient struct {
+	cloud      *azure.Cloud
+	httpClient *http.Client
+}
+
+func (c *keyVaultSecretClient) GetSecret(ctx context.Context, vaultURL, secretName string) (string, error) {
+	if c.cloud == nil {
+		return "", fmt.Errorf("cloud provider is nil")
+	}
+	env := getKeyVaultEnvironment(c.cloud)
+	token, err := azureconfig.GetServicePrincipalToken(&c.cloud.Config.AzureAuthConfig, &env, strings.TrimSuffix(env.KeyVaultEndpoint, "/"))
+	if err != nil {
+		return "", fmt.Errorf("failed to get service principal token of key vault: %w", err)
+	}
+	token.SetSender(c.httpClient)
+
+	client := keyvault.New()
+	client.Authorizer = autorest.NewBearerAuthorizer(token)
+	client.Sender = c.httpClient
+	// latest version of secret is returned if secret version is empty
+	bundle, err := client.GetSecret(ctx, strings.TrimSuffix(vaultURL, "/"), secretName, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret(%s) from key vault(%s): %w", secretName, vaultURL, err)
+	}
+	if bundle.Value == nil || *bundle.Value == "" {
+		return "", fmt.Errorf("value of secret(%s) in key vault(%s) is empty", secretName, vaultURL)
+	}
+	return *bundle.Value, nil
+}
+
+// getKeyVaultEnvironment returns cloud environment which contains key vault dns suffix, e.g.
+// vault.azure.net (AzurePublicCloud), vault.usgovcloudapi.net (AzureUSGovernmentCloud), vault.azure.cn (AzureChinaCloud)
+func getKeyVaultEnvironment(cloud *azure.Cloud) azure2.Environment {
+	if cloud == nil {
+		return azure2.PublicCloud
+	}
+	if cloud.Environment.KeyVaultDNSSuffix != "" && cloud.Environment.KeyVaultEndpoint != "" {
+		return cloud.Environment
+	}
+	if cloud.Config.Cloud != "" {
+		env, err := azure2.EnvironmentFromName(cloud.Config.Cloud)
+		if err == nil {
+			return env
+		}
+		klog.Warningf("could not get key vault environment of cloud(%s), use %s instead", cloud.Config.Cloud, azure2.PublicCloud.Name)
+	}
+	return azure2.PublicCloud
+}
+
+// getKeyVaultSecretID returns identifier of the latest version of secret in key vault, e.g. https://vault.vault.azure.net/secrets/name
+func getKeyVaultSecretID(vaultURL, secretName string) string {
+	return fmt.Sprintf("%s/secrets/%s", strings.TrimSuffix(vaultURL, "/"), secretName)
+}
+
+// validateKeyVaultSecret checks key vault url and secret name of account key, they should be set together
+func validateKeyVaultSecret(vaultURL, secretName, dnsSuffix string) error {
+	if vaultURL == "" && secretName == "" {
+		return nil
+	}
+	if vaultURL == "" || secretName == "" {
+		return fmt.Errorf("%s and %s should be set together", keyVaultURLField, keyVaultSecretNameField)
+	}
+	u, err := url.Parse(vaultURL)
+	// account key should only be read from key vault of the cloud environment
+	if err != nil || u.Scheme != "https" || u.Port() != "" || !strings.HasSuffix(strings.ToLower(u.Hostname()), "."+strings.ToLower(dnsSuffix)) ||
+		strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("invalid %s: %s, the format should be like https://<vault-name>.%s", keyVaultURLField, vaultURL, dnsSuffix)
+	}
+	if !keyVaultSecretNameRegex.MatchString(secretName) {
+		return fmt.Errorf("invalid %s: %s, it should only contain alphanumeric characters and dashes, and be no longer than 127 characters", keyVaultSecretNameField, secretName)
+	}
+	return nil
+}
+
+func (d *Driver) getKeyVaultSecretGetter() keyVaultSecretGetter {
+	if d.keyVaultClient != nil {
+		return d.keyVaultClient
+	}
+	return &keyVaultSecretClient{cloud: d.cloud, httpClient: d.httpClient}
+}
+
+// getAccountKeyFromKeyVault gets account key stored in key vault secret with driver identity, account key is read from
+// keyVaultSecretCache first, cached account key is invalidated with other credentials of the account on authentication failure
+func (d *Driver) getAccountKeyFromKeyVault(ctx context.Context, accountName, vaultURL, secretName string) (string, error) {
+	if accountName == "" {
+		return "", fmt.Errorf("account name is required to use account key in key vault(%s)", vaultURL)
+	}
+	if err := validateKeyVaultSecret(vaultURL, secretName, getKeyVaultEnvironment(d.cloud).KeyVaultDNSSuffix); err != nil {
+		return "", err
+	}
+	secretID := getKeyVaultSecretID(vaultURL, secretName)
+	cache, err := d.keyVaultSecretCache.Get(secretID, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return "", err
+	}
+	if credential, ok := cache.(*secretCredential); ok && credential.accountName == accountName {
+		return credential.accountKey, nil
+	}
+
+	klog.V(2).Infof("get account(%s) key from key vault secret(%s)", accountName, secretID)
+	accountKey, err := d.getKeyVaultSecretGetter().GetSecret(ctx, vaultURL, secretName)
+	if err != nil {
+		return "", err
+	}
+	d.keyVaultSecretCache.Set(secretID, &secretCredential{accountName: accountName, accountKey: accountKey})
+	return accountKey, nil
+}
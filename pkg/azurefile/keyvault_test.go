@@ -0,0 +1,237 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	azure2 "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	azureconfig "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
+)
+
+const testKeyVaultURL = "https://vault.vault.azure.net"
+
+// fakeKeyVaultClient returns secrets in a map and records secrets which are read
+type fakeKeyVaultClient struct {
+	secrets map[string]string
+	reads   []string
+}
+
+func (c *fakeKeyVaultClient) GetSecret(_ context.Context, vaultURL, secretName string) (string, error) {
+	secretID := getKeyVaultSecretID(vaultURL, secretName)
+	c.reads = append(c.reads, secretID)
+	if value, ok := c.secrets[secretID]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("secret(%s) not found", secretID)
+}
+
+func TestValidateKeyVaultSecret(t *testing.T) {
+	tests := []struct {
+		vaultURL    string
+		secretName  string
+		expectedErr error
+	}{
+		{},
+		{
+			vaultURL:   testKeyVaultURL,
+			secretName: "account-key",
+		},
+		{
+			vaultURL:   testKeyVaultURL + "/",
+			secretName: "account-key",
+		},
+		{
+			vaultURL:    testKeyVaultURL,
+			expectedErr: fmt.Errorf("keyvaulturl and keyvaultsecretname should be set together"),
+		},
+		{
+			secretName:  "account-key",
+			expectedErr: fmt.Errorf("keyvaulturl and keyvaultsecretname should be set together"),
+		},
+		{
+			vaultURL:    "http://vault.vault.azure.net",
+			secretName:  "account-key",
+			expectedErr: fmt.Errorf("invalid keyvaulturl: http://vault.vault.azure.net, the format should be like https://<vault-name>.vault.azure.net"),
+		},
+		{
+			vaultURL:    testKeyVaultURL + "/secrets/account-key",
+			secretName:  "account-key",
+			expectedErr: fmt.Errorf("invalid keyvaulturl: https://vault.vault.azure.net/secrets/account-key, the format should be like https://<vault-name>.vault.azure.net"),
+		},
+		{
+			vaultURL:    "https://vault.evil.com",
+			secretName:  "account-key",
+			expectedErr: fmt.Errorf("invalid keyvaulturl: https://vault.evil.com, the format should be like https://<vault-name>.vault.azure.net"),
+		},
+		{
+			vaultURL:    "https://vault.azure.net.evil.com",
+			secretName:  "account-key",
+			expectedErr: fmt.Errorf("invalid keyvaulturl: https://vault.azure.net.evil.com, the format should be like https://<vault-name>.vault.azure.net"),
+		},
+		{
+			vaultURL:    "https://vault.vault.azure.net:8443",
+			secretName:  "account-key",
+			expectedErr: fmt.Errorf("invalid keyvaulturl: https://vault.vault.azure.net:8443, the format should be like https://<vault-name>.vault.azure.net"),
+		},
+		{
+			vaultURL:    "https://vault.vault.azure.net@evil.com",
+			secretName:  "account-key",
+			expectedErr: fmt.Errorf("invalid keyvaulturl: https://vault.vault.azure.net@evil.com, the format should be like https://<vault-name>.vault.azure.net"),
+		},
+		{
+			vaultURL:    testKeyVaultURL,
+			secretName:  "account_key",
+			expectedErr: fmt.Errorf("invalid keyvaultsecretname: account_key, it should only contain alphanumeric characters and dashes, and be no longer than 127 characters"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateKeyVaultSecret(test.vaultURL, test.secretName, azure2.PublicCloud.KeyVaultDNSSuffix)
+		assert.Equal(t, test.expectedErr, err, "vaultURL(%s) secretName(%s)", test.vaultURL, test.secretName)
+	}
+}
+
+func TestGetKeyVaultEnvironment(t *testing.T) {
+	tests := []struct {
+		desc              string
+		cloud             *azure.Cloud
+		expectedDNSSuffix string
+	}{
+		{
+			desc:              "public cloud is used if cloud provider is nil",
+			expectedDNSSuffix: "vault.azure.net",
+		},
+		{
+			desc:              "environment of cloud provider is used",
+			cloud:             &azure.Cloud{Environment: azure2.Environment{KeyVaultDNSSuffix: "vault.azurestack.local", KeyVaultEndpoint: "https://vault.azurestack.local/"}},
+			expectedDNSSuffix: "vault.azurestack.local",
+		},
+		{
+			desc:              "environment is parsed from cloud name",
+			cloud:             &azure.Cloud{Config: azure.Config{AzureAuthConfig: azureconfig.AzureAuthConfig{Cloud: "AzureChinaCloud"}}},
+			expectedDNSSuffix: "vault.azure.cn",
+		},
+		{
+			desc:              "public cloud is used if cloud name is invalid",
+			cloud:             &azure.Cloud{Config: azure.Config{AzureAuthConfig: azureconfig.AzureAuthConfig{Cloud: "invalid"}}},
+			expectedDNSSuffix: "vault.azure.net",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedDNSSuffix, getKeyVaultEnvironment(test.cloud).KeyVaultDNSSuffix, test.desc)
+	}
+}
+
+func TestGetAccountKeyFromKeyVault(t *testing.T) {
+	d := NewFakeDriver()
+	secretID := getKeyVaultSecretID(testKeyVaultURL, "account-key")
+	client := &fakeKeyVaultClient{secrets: map[string]string{secretID: "key"}}
+	d.keyVaultClient = client
+
+	_, err := d.getAccountKeyFromKeyVault(context.Background(), "", testKeyVaultURL, "account-key")
+	assert.Equal(t, fmt.Errorf("account name is required to use account key in key vault(%s)", testKeyVaultURL), err)
+
+	_, err = d.getAccountKeyFromKeyVault(context.Background(), "account", testKeyVaultURL, "")
+	assert.Equal(t, fmt.Errorf("keyvaulturl and keyvaultsecretname should be set together"), err)
+
+	_, err = d.getAccountKeyFromKeyVault(context.Background(), "account", testKeyVaultURL, "not-found")
+	assert.Equal(t, fmt.Errorf("secret(%s) not found", getKeyVaultSecretID(testKeyVaultURL, "not-found")), err)
+
+	// account key is cached
+	for i := 0; i < 2; i++ {
+		accountKey, err := d.getAccountKeyFromKeyVault(context.Background(), "account", testKeyVaultURL+"/", "account-key")
+		assert.NoError(t, err)
+		assert.Equal(t, "key", accountKey)
+	}
+	assert.Equal(t, 2, len(client.reads))
+
+	// cached account key is invalidated on authentication failure of the account
+	client.secrets[secretID] = "newkey"
+	d.invalidateAccountCredential("otheraccount")
+	accountKey, err := d.getAccountKeyFromKeyVault(context.Background(), "account", testKeyVaultURL, "account-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "key", accountKey)
+	d.invalidateAccountCredential("account")
+	accountKey, err = d.getAccountKeyFromKeyVault(context.Background(), "account", testKeyVaultURL, "account-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "newkey", accountKey)
+	assert.Equal(t, 3, len(client.reads))
+
+	// cached account key of another account is not used
+	_, err = d.getAccountKeyFromKeyVault(context.Background(), "otheraccount", testKeyVaultURL, "account-key")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(client.reads))
+}
+
+func TestGetAccountInfoWithKeyVault(t *testing.T) {
+	secretID := getKeyVaultSecretID(testKeyVaultURL, "account-key")
+	tests := []struct {
+		desc          string
+		secrets       map[string]string
+		reqContext    map[string]string
+		expectedKey   string
+		expectedReads int
+		expectedErr   error
+	}{
+		{
+			desc: "account key is read from key vault",
+			reqContext: map[string]string{
+				storageAccountField:     "accountname",
+				keyVaultURLField:        testKeyVaultURL,
+				keyVaultSecretNameField: "account-key",
+			},
+			expectedKey:   "vaultkey",
+			expectedReads: 1,
+		},
+		{
+			desc:    "account key in node stage secrets takes precedence over key vault",
+			secrets: map[string]string{"accountname": "accountname", "accountkey": "secretkey"},
+			reqContext: map[string]string{
+				keyVaultURLField:        testKeyVaultURL,
+				keyVaultSecretNameField: "account-key",
+			},
+			expectedKey: "secretkey",
+		},
+		{
+			desc: "key vault secret is not found",
+			reqContext: map[string]string{
+				storageAccountField:     "accountname",
+				keyVaultURLField:        testKeyVaultURL,
+				keyVaultSecretNameField: "not-found",
+			},
+			expectedReads: 1,
+			expectedErr:   fmt.Errorf("secret(%s) not found", getKeyVaultSecretID(testKeyVaultURL, "not-found")),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		client := &fakeKeyVaultClient{secrets: map[string]string{secretID: "vaultkey"}}
+		d.keyVaultClient = client
+		_, accountName, accountKey, _, _, _, err := d.GetAccountInfo(context.Background(), "rg#accountname#sharename", test.secrets, test.reqContext)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, "accountname", accountName, test.desc)
+		assert.Equal(t, test.expectedKey, accountKey, test.desc)
+		assert.Equal(t, test.expectedReads, len(client.reads), test.desc)
+	}
+}
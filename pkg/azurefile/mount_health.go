@@ -0,0 +1,116 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// a dead mount (e.g. network is broken) may hang stat, it's regarded as unhealthy after this timeout
+	mountHealthProbeTimeout = 10 * time.Second
+	volumeHandleLabel       = "volume_handle"
+)
+
+var (
+	mountHealthyGauge = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      azureFileCSIDriverName,
+			Name:           "mount_healthy",
+			Help:           "Whether the staged mount of volume on node is healthy(1) or not(0)",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{volumeHandleLabel},
+	)
+	registerMountHealthMetricsOnce sync.Once
+)
+
+func registerMountHealthMetrics() {
+	registerMountHealthMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(mountHealthyGauge)
+	})
+}
+
+// probeMount checks whether the mount on path is alive, stat on a dead mount fails (e.g. stale file handle) or hangs,
+// a new probe is not started on path while the previous one is still hanging so that hanging probes would not pile up
+func (d *Driver) probeMount(path string, timeout time.Duration) error {
+	if _, inFlight := d.mountProbes.LoadOrStore(path, ""); inFlight {
+		return fmt.Errorf("previous stat of %s is still in flight", path)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(path)
+		d.mountProbes.Delete(path)
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("stat %s timed out after %v", path, timeout)
+	}
+}
+
+// checkMountHealth probes mounts of staged volumes concurrently and sets mount health gauge of every volume,
+// gauges of volumes in reported which are not staged any more are deleted, returns volumes reported in this round
+func (d *Driver) checkMountHealth(reported map[string]bool, timeout time.Duration) map[string]bool {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	current := map[string]bool{}
+	d.stagedVolumes.Range(func(k, v interface{}) bool {
+		volumeID, stagingPath := k.(string), v.(*stagedVolume).StagingPath
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthy := 1.0
+			if err := d.probeMount(stagingPath, timeout); err != nil {
+				klog.Warningf("mount of volume(%s) on %s is unhealthy: %v", volumeID, stagingPath, err)
+				healthy = 0
+			}
+			mountHealthyGauge.WithLabelValues(volumeID).Set(healthy)
+			mutex.Lock()
+			current[volumeID] = true
+			mutex.Unlock()
+		}()
+		return true
+	})
+	wg.Wait()
+	for volumeID := range reported {
+		if !current[volumeID] {
+			mountHealthyGauge.Delete(map[string]string{volumeHandleLabel: volumeID})
+		}
+	}
+	return current
+}
+
+// runMountHealthChecker probes mounts of staged volumes on node periodically
+func (d *Driver) runMountHealthChecker(stopCh <-chan struct{}) {
+	klog.V(2).Infof("start mount health checker, interval: %v", d.mountHealthCheckInterval)
+	registerMountHealthMetrics()
+	reported := map[string]bool{}
+	wait.Until(func() {
+		reported = d.checkMountHealth(reported, mountHealthProbeTimeout)
+	}, d.mountHealthCheckInterval, stopCh)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// getMountHealthyGaugeValue returns value of mount health gauge of the volume, false is returned if the gauge does not exist
+func getMountHealthyGaugeValue(t *testing.T, volumeID string) (float64, bool) {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != azureFileCSIDriverName+"_mount_healthy" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == volumeHandleLabel && label.GetValue() == volumeID {
+					return metric.GetGauge().GetValue(), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestProbeMount(t *testing.T) {
+	d := NewFakeDriver()
+	path := t.TempDir()
+	assert.NoError(t, d.probeMount(path, time.Second))
+	assert.Error(t, d.probeMount(filepath.Join(t.TempDir(), "not-exist"), time.Second))
+
+	// path is regarded as unhealthy without another stat while previous probe is in flight
+	d.mountProbes.Store(path, "")
+	assert.Error(t, d.probeMount(path, time.Second))
+	d.mountProbes.Delete(path)
+	assert.NoError(t, d.probeMount(path, time.Second))
+}
+
+func TestCheckMountHealth(t *testing.T) {
+	registerMountHealthMetrics()
+	d := NewFakeDriver()
+	healthyPath := t.TempDir()
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	assert.NoError(t, os.MkdirAll(stagingPath, 0750))
+	d.stagedVolumes.Store("vol_1", &stagedVolume{VolumeID: "vol_1", StagingPath: stagingPath})
+	d.stagedVolumes.Store("vol_2", &stagedVolume{VolumeID: "vol_2", StagingPath: healthyPath})
+
+	reported := d.checkMountHealth(nil, time.Second)
+	assert.Equal(t, map[string]bool{"vol_1": true, "vol_2": true}, reported)
+	value, ok := getMountHealthyGaugeValue(t, "vol_1")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, value)
+
+	// gauge flips when probe fails
+	assert.NoError(t, os.RemoveAll(stagingPath))
+	reported = d.checkMountHealth(reported, time.Second)
+	value, ok = getMountHealthyGaugeValue(t, "vol_1")
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, value)
+	value, ok = getMountHealthyGaugeValue(t, "vol_2")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, value)
+
+	// gauge is deleted after volume is unstaged
+	d.stagedVolumes.Delete("vol_1")
+	reported = d.checkMountHealth(reported, time.Second)
+	assert.Equal(t, map[string]bool{"vol_2": true}, reported)
+	_, ok = getMountHealthyGaugeValue(t, "vol_1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	protocolLabel = "protocol"
+	resultLabel   = "result"
+
+	mountSucceeded = "succeeded"
+	mountFailed    = "failed"
+
+	versionLabel = "version"
+	// version of smb mount whose vers option could not be found in mount table
+	unknownSMBVersion = "unknown"
+)
+
+var (
+	mountDurationHistogram = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      azureFileCSIDriverName,
+			Name:           "mount_duration_seconds",
+			Help:           "Duration of file share mount in NodeStageVolume, every mount attempt is observed",
+			Buckets:        metrics.ExponentialBuckets(0.1, 2, 12),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{protocolLabel, resultLabel},
+	)
+	smbNegotiatedVersionCounter = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      azureFileCSIDriverName,
+			Name:           "smb_negotiated_version_total",
+			Help:           "Number of successful SMB mounts in NodeStageVolume by negotiated SMB dialect",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{versionLabel},
+	)
+	registerMountMetricsOnce sync.Once
+)
+
+func registerMountMetrics() {
+	registerMountMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(mountDurationHistogram)
+		legacyregistry.MustRegister(smbNegotiatedVersionCounter)
+	})
+}
+
+// observeMountDuration records duration of the mount attempt started at start in mount duration histogram,
+// the attempt is labeled by protocol(smb or nfs) and whether it succeeded
+func observeMountDuration(protocol string, start time.Time, err error) {
+	registerMountMetrics()
+	result := mountSucceeded
+	if err != nil {
+		result = mountFailed
+	}
+	mountDurationHistogram.WithLabelValues(protocol, result).Observe(time.Since(start).Seconds())
+}
+
+// parseNegotiatedSMBVersion returns value of vers option in options of smb mount in mount table,
+// kernel always shows the dialect negotiated with server in vers option even if it's not set in mount options
+func parseNegotiatedSMBVersion(mountOptions []string) string {
+	for _, option := range mountOptions {
+		if strings.HasPrefix(option, "vers=") {
+			return strings.TrimPrefix(option, "vers=")
+		}
+	}
+	return unknownSMBVersion
+}
+
+// recordNegotiatedSMBVersion logs negotiated dialect of smb mount on target and records it in smb negotiated version counter,
+// it's not supported on Windows since SMB mapping does not show the dialect
+func (d *Driver) recordNegotiatedSMBVersion(volumeID, target string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		klog.Warningf("failed to list mount points to get negotiated smb version of volume(%s): %v", volumeID, err)
+		return
+	}
+	version := unknownSMBVersion
+	for _, mountPoint := range mountPoints {
+		// the last one is on top if there are multiple mounts on target
+		if filepath.Clean(mountPoint.Path) == filepath.Clean(target) {
+			version = parseNegotiatedSMBVersion(mountPoint.Opts)
+		}
+	}
+	klog.V(2).Infof("volume(%s) is mounted on %s with negotiated smb version(%s)", volumeID, target, version)
+	registerMountMetrics()
+	smbNegotiatedVersionCounter.WithLabelValues(version).Inc()
+}
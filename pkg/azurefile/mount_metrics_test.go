@@ -0,0 +1,177 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/component-base/metrics/legacyregistry"
+	mount "k8s.io/mount-utils"
+
+	"sigs.k8s.io/azurefile-csi-driver/test/utils/testutil"
+)
+
+// getMountDurationCount returns number of observations in mount duration histogram with the labels
+func getMountDurationCount(t *testing.T, protocol, result string) uint64 {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != azureFileCSIDriverName+"_mount_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels[protocolLabel] == protocol && labels[resultLabel] == result {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestObserveMountDuration(t *testing.T) {
+	succeeded := getMountDurationCount(t, nfs, mountSucceeded)
+	failed := getMountDurationCount(t, nfs, mountFailed)
+
+	observeMountDuration(nfs, time.Now(), nil)
+	observeMountDuration(nfs, time.Now(), fmt.Errorf("mount failed"))
+	observeMountDuration(nfs, time.Now(), fmt.Errorf("mount failed"))
+
+	assert.Equal(t, succeeded+1, getMountDurationCount(t, nfs, mountSucceeded))
+	assert.Equal(t, failed+2, getMountDurationCount(t, nfs, mountFailed))
+}
+
+func TestNodeStageVolumeObservesMountDuration(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip mount duration test on non-linux platform")
+	}
+	stagingTarget := testutil.GetWorkDirPath("mount_duration_staging", t)
+	defer os.RemoveAll(stagingTarget)
+
+	succeeded := getMountDurationCount(t, smb, mountSucceeded)
+	failed := getMountDurationCount(t, smb, mountFailed)
+
+	d := NewFakeDriverCustomOptions(DriverOptions{
+		NodeID:                 fakeNodeID,
+		DriverName:             DefaultDriverName,
+		NodeMountRetries:       1,
+		NodeMountRetryInterval: time.Millisecond,
+	})
+	// the first mount attempt fails with transient error and the retry succeeds
+	m := &flakyMounter{mountErr: fmt.Errorf("mount error(112): Host is down"), failures: 1}
+	d.mounter = &mount.SafeFormatAndMount{Interface: m}
+	req := csi.NodeStageVolumeRequest{
+		VolumeId:          "vol_1",
+		StagingTargetPath: stagingTarget,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+		},
+		VolumeContext: map[string]string{
+			shareNameField:  "test_sharename",
+			serverNameField: "test_servername",
+		},
+		Secrets: map[string]string{
+			"accountname": "k8s",
+			"accountkey":  "testkey",
+		},
+	}
+	_, err := d.NodeStageVolume(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, m.calls)
+	assert.Equal(t, succeeded+1, getMountDurationCount(t, smb, mountSucceeded))
+	assert.Equal(t, failed+1, getMountDurationCount(t, smb, mountFailed))
+}
+
+// getSMBNegotiatedVersionCount returns value of smb negotiated version counter of the version
+func getSMBNegotiatedVersionCount(t *testing.T, version string) float64 {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != azureFileCSIDriverName+"_smb_negotiated_version_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == versionLabel && label.GetValue() == version {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestParseNegotiatedSMBVersion(t *testing.T) {
+	tests := []struct {
+		desc            string
+		mountLine       string
+		expectedVersion string
+	}{
+		{
+			desc:            "smb 3.1.1 is negotiated",
+			mountLine:       "//account.file.core.windows.net/share /var/lib/kubelet/plugins/kubernetes.io/csi/file.csi.azure.com/globalmount cifs rw,relatime,vers=3.1.1,cache=strict,username=account,uid=0,noforceuid,gid=0,noforcegid,addr=20.150.29.136,file_mode=0777,dir_mode=0777,soft,persistenthandles,nounix,serverino,mapposix,mfsymlinks,rsize=1048576,wsize=1048576,bsize=1048576,echo_interval=60,actimeo=30 0 0",
+			expectedVersion: "3.1.1",
+		},
+		{
+			desc:            "smb 3.0 is negotiated",
+			mountLine:       "//account.file.core.windows.net/share /mnt cifs rw,relatime,vers=3.0,cache=strict,username=account 0 0",
+			expectedVersion: "3.0",
+		},
+		{
+			desc:            "vers option is not found",
+			mountLine:       "//account.file.core.windows.net/share /mnt cifs rw,relatime,cache=strict 0 0",
+			expectedVersion: unknownSMBVersion,
+		},
+	}
+
+	for _, test := range tests {
+		fields := strings.Fields(test.mountLine)
+		assert.Equal(t, test.expectedVersion, parseNegotiatedSMBVersion(strings.Split(fields[3], ",")), test.desc)
+	}
+}
+
+func TestRecordNegotiatedSMBVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip negotiated smb version test on Windows")
+	}
+	d := NewFakeDriver()
+	d.mounter = &mount.SafeFormatAndMount{Interface: &fakeMounter{mount.FakeMounter{MountPoints: []mount.MountPoint{
+		{Path: "/mnt/other", Opts: []string{"rw", "vers=2.1"}},
+		{Path: "/mnt/target", Opts: []string{"rw", "vers=3.1.1"}},
+	}}}}
+	count := getSMBNegotiatedVersionCount(t, "3.1.1")
+	unknownCount := getSMBNegotiatedVersionCount(t, unknownSMBVersion)
+
+	d.recordNegotiatedSMBVersion("vol_1", "/mnt/target/")
+	d.recordNegotiatedSMBVersion("vol_2", "/mnt/notfound")
+
+	assert.Equal(t, count+1, getSMBNegotiatedVersionCount(t, "3.1.1"))
+	assert.Equal(t, unknownCount+1, getSMBNegotiatedVersionCount(t, unknownSMBVersion))
+}
@@ -19,6 +19,7 @@ package azurefile
 import (
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -26,12 +27,19 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume"
 	"k8s.io/kubernetes/pkg/volume/util"
+	mount "k8s.io/mount-utils"
+	"k8s.io/utils/pointer"
+
+	volumehelper "sigs.k8s.io/azurefile-csi-driver/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -56,6 +64,9 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	volumeID := req.GetVolumeId()
 
 	mountPermissions := d.mountPermissions
+	volumeMountGroup := volCap.GetMount().GetVolumeMountGroup()
+	fsGroupChangePolicy := d.fsGroupChangePolicy
+	var protocol string
 	context := req.GetVolumeContext()
 	if context != nil {
 		if strings.EqualFold(context[ephemeralField], trueValue) {
@@ -65,6 +76,11 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 				setKeyValueInMap(context, getAccountKeyFromSecretField, trueValue)
 				setKeyValueInMap(context, storageAccountField, "")
 			}
+			if req.GetReadonly() && volCap.GetMount() != nil {
+				// ephemeral volume is mounted on target directly, readonly is only enforced by mount options
+				volCap = proto.Clone(volCap).(*csi.VolumeCapability)
+				volCap.GetMount().MountFlags = getReadOnlyMountOptions(volCap.GetMount().GetMountFlags(), true)
+			}
 			klog.V(2).Infof("NodePublishVolume: ephemeral volume(%s) mount on %s, VolumeContext: %v", volumeID, target, context)
 			_, err := d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
 				StagingTargetPath: target,
@@ -81,6 +97,19 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid mountPermissions %s", perm))
 			}
 		}
+
+		for k, v := range context {
+			switch strings.ToLower(k) {
+			case protocolField:
+				protocol = v
+			case fsGroupChangePolicyField:
+				fsGroupChangePolicy = v
+			}
+		}
+	}
+
+	if !isSupportedFSGroupChangePolicy(fsGroupChangePolicy) {
+		return nil, status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", fsGroupChangePolicy, supportedFSGroupChangePolicyList)
 	}
 
 	source := req.GetStagingTargetPath()
@@ -88,9 +117,9 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return nil, status.Error(codes.InvalidArgument, "Staging target not provided")
 	}
 
-	mountOptions := []string{"bind"}
-	if req.GetReadonly() {
-		mountOptions = append(mountOptions, "ro")
+	mountOptions := getReadOnlyMountOptions([]string{"bind"}, req.GetReadonly())
+	if _, propagation := getMountPropagation(volCap.GetMount().GetMountFlags()); propagation != "" {
+		mountOptions = append(mountOptions, propagation)
 	}
 
 	mnt, err := d.ensureMountPoint(target, os.FileMode(mountPermissions))
@@ -98,6 +127,9 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return nil, status.Errorf(codes.Internal, "Could not mount target %s: %v", target, err)
 	}
 	if mnt {
+		if err := d.remountIfReadOnlyChanged(target, req.GetReadonly()); err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not remount %s with readonly(%v): %v", target, req.GetReadonly(), err)
+		}
 		klog.V(2).Infof("NodePublishVolume: %s is already mounted", target)
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
@@ -115,6 +147,15 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	}
 	klog.V(2).Infof("NodePublishVolume: mount %s at %s successfully", source, target)
 
+	// NodeStageVolume only runs once per node, apply ownership again since pods sharing one nfs volume could use different fsGroup,
+	// ownership could not be changed on read-only mount
+	if protocol == nfs && volumeMountGroup != "" && fsGroupChangePolicy != FSGroupChangeNone && !req.GetReadonly() {
+		klog.V(2).Infof("NodePublishVolume: set gid of volume(%s) as %s using fsGroupChangePolicy(%s)", volumeID, volumeMountGroup, fsGroupChangePolicy)
+		if err := SetVolumeOwnership(target, volumeMountGroup, fsGroupChangePolicy); err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("SetVolumeOwnership with volume(%s) on %s failed with %v", volumeID, target, err))
+		}
+	}
+
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
@@ -130,14 +171,44 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 	volumeID := req.GetVolumeId()
 
 	klog.V(2).Infof("NodeUnpublishVolume: unmounting volume %s on %s", volumeID, targetPath)
-	if err := CleanupMountPoint(d.mounter, targetPath, true /*extensiveMountPointCheck*/); err != nil {
+	if err := d.cleanupPublishMountPoint(targetPath); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount target %s: %v", targetPath, err)
 	}
+	// ephemeral volume is staged on target directly
+	if err := removeCredentialFile(getCredentialFilePath(targetPath)); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove credential file of target %s: %v", targetPath, err)
+	}
 	klog.V(2).Infof("NodeUnpublishVolume: unmount volume %s on %s successfully", volumeID, targetPath)
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// cleanupPublishMountPoint unmounts target and removes the directory, if lazyUnmountOnBusy is enabled, unmount is retried
+// when target is busy and target is lazily unmounted when it's still busy after retries
+func (d *Driver) cleanupPublishMountPoint(target string) error {
+	if !d.lazyUnmountOnBusy {
+		return CleanupMountPoint(d.mounter, target, true /*extensiveMountPointCheck*/)
+	}
+	var lastErr error
+	err := wait.ExponentialBackoff(d.busyUnmountBackoff, func() (bool, error) {
+		lastErr = CleanupMountPoint(d.mounter, target, true /*extensiveMountPointCheck*/)
+		if isBusyUnmountError(lastErr) {
+			klog.Warningf("unmount %s failed since target is busy: %v, waiting for retrying", target, lastErr)
+			return false, nil
+		}
+		return true, lastErr
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+	klog.Warningf("target %s is still busy after retries, fall back to lazy unmount, last error: %v", target, lastErr)
+	if err := d.lazyUnmounter(target); err != nil {
+		return fmt.Errorf("lazy unmount %s failed with %v, unmount error: %v", target, err, lastErr)
+	}
+	// target is not a mount point after lazy unmount, only the directory is removed
+	return CleanupMountPoint(d.mounter, target, true /*extensiveMountPointCheck*/)
+}
+
 // NodeStageVolume mount the volume to a staging path
 func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	if len(req.GetVolumeId()) == 0 {
@@ -154,21 +225,25 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 
 	volumeID := req.GetVolumeId()
 	context := req.GetVolumeContext()
-	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
+	// mount propagation is only applied on bind mount in NodePublishVolume
+	mountFlags, _ := getMountPropagation(req.GetVolumeCapability().GetMount().GetMountFlags())
 	volumeMountGroup := req.GetVolumeCapability().GetMount().GetVolumeMountGroup()
 	gidPresent := checkGidPresentInMountFlags(mountFlags)
 
-	_, accountName, accountKey, fileShareName, diskName, _, err := d.GetAccountInfo(ctx, volumeID, req.GetSecrets(), context)
+	rgName, accountName, accountKey, fileShareName, diskName, subsID, err := d.GetAccountInfo(ctx, volumeID, req.GetSecrets(), context)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("GetAccountInfo(%s) failed with error: %v", volumeID, err))
 	}
 	if fileShareName == "" {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to get file share name from %s", volumeID))
 	}
-	// don't respect fsType from req.GetVolumeCapability().GetMount().GetFsType()
-	// since it's ext4 by default on Linux
-	var fsType, server, protocol, ephemeralVolMountOptions, storageEndpointSuffix, folderName string
-	var ephemeralVol bool
+	// don't respect fsType from req.GetVolumeCapability().GetMount().GetFsType() for file share
+	// since it's ext4 by default on Linux, it's only used by vhd disk when fsType is not set in volume context
+	var fsType, server, protocol, ephemeralVolMountOptions, storageEndpointSuffix, folderName, mountOptionsConfigMap, dataPlaneAuth, mountServerAddress string
+	var nfsExportPathTemplate, dnsSearchDomain, rootDirACL, nfsVersion string
+	var windowsMountOptions, linuxMountOptions string
+	var mountTimeout time.Duration
+	var ephemeralVol, enableNFSACL, useCredentialFile, disableAccountKeyAuth bool
 	fileShareNameReplaceMap := map[string]string{}
 
 	mountPermissions := d.mountPermissions
@@ -187,12 +262,49 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			folderName = v
 		case serverNameField:
 			server = v
+		case mountServerAddressField:
+			mountServerAddress = v
+		case nfsExportPathTemplateField:
+			nfsExportPathTemplate = v
+		case dnsSearchDomainField:
+			dnsSearchDomain = v
+		case useCredentialFileField:
+			if useCredentialFile, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s", useCredentialFileField, v)
+			}
+		case rootDirACLField:
+			rootDirACL = v
+		case nfsVersionField:
+			nfsVersion = v
 		case ephemeralField:
 			ephemeralVol = strings.EqualFold(v, trueValue)
+		case enableNFSACLField:
+			if enableNFSACL, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s", enableNFSACLField, v)
+			}
+		case disableAccountKeyAuthField:
+			if disableAccountKeyAuth, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s", disableAccountKeyAuthField, v)
+			}
 		case mountOptionsField:
 			ephemeralVolMountOptions = v
+		case mountOptionsConfigMapField:
+			mountOptionsConfigMap = v
+		case windowsMountOptionsField:
+			windowsMountOptions = v
+		case linuxMountOptionsField:
+			linuxMountOptions = v
+		case mountTimeoutField:
+			if runtime.GOOS == "windows" {
+				return nil, status.Errorf(codes.InvalidArgument, "%s is not supported on windows", k)
+			}
+			if mountTimeout, err = parseMountTimeout(v); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
 		case storageEndpointSuffixField:
 			storageEndpointSuffix = v
+		case dataPlaneAuthField:
+			dataPlaneAuth = v
 		case fsGroupChangePolicyField:
 			fsGroupChangePolicy = v
 		case pvcNamespaceKey:
@@ -221,42 +333,123 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to get account name from %s", volumeID))
 	}
 
+	if fsType == "" && strings.HasSuffix(diskName, vhdSuffix) {
+		if fsType, err = getVHDDiskFsType(req.GetVolumeCapability().GetMount().GetFsType()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
 	if !isSupportedFsType(fsType) {
 		return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported, supported fsType list: %v", fsType, supportedFsTypeList)
 	}
 
+	if protocol == auto {
+		// protocol of file share could not be changed, it's resolved in CreateVolume for dynamically provisioned volume
+		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is only supported in storage class, set protocol(%s or %s) of pre-provisioned volume", auto, smb, nfs)
+	}
 	if !isSupportedProtocol(protocol) {
 		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", protocol, supportedProtocolList)
 	}
 
+	if _, err := getDataPlaneAuth(dataPlaneAuth, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	if !isSupportedFSGroupChangePolicy(fsGroupChangePolicy) {
 		return nil, status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", fsGroupChangePolicy, supportedFSGroupChangePolicyList)
 	}
 
+	if mountOptionsConfigMap != "" {
+		extraMountOptions, err := d.getMountOptionsFromConfigMap(mountOptionsConfigMap, protocol)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to get mount options from configmap(%s): %v", mountOptionsConfigMap, err)
+		}
+		mountFlags = mergeMountOptions(mountFlags, extraMountOptions)
+		gidPresent = checkGidPresentInMountFlags(mountFlags)
+	}
+
+	osMountOptions, err := getOSMountOptions(runtime.GOOS, windowsMountOptions, linuxMountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if runtime.GOOS != "windows" && len(osMountOptions) > 0 {
+		// mount options in volume capability take precedence over linuxMountOptions
+		mountFlags = mergeMountOptions(mountFlags, osMountOptions)
+		gidPresent = checkGidPresentInMountFlags(mountFlags)
+	}
+
+	if err := validateNFSMountOptions(mountFlags, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateMountServerAddress(mountServerAddress, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateNFSExportPathTemplate(nfsExportPathTemplate, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateDNSSearchDomain(dnsSearchDomain, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateNFSACL(enableNFSACL, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateNFSVersion(nfsVersion, protocol, enableNFSACL); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateUseCredentialFile(useCredentialFile, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateRootDirACL(rootDirACL, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if rootDirACL != "" && (runtime.GOOS != "windows" || !d.enableWindowsHostProcess) {
+		// ACL is set by host process on windows node, it could not be set on other nodes
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported on windows node with host process", rootDirACLField)
+	}
+
 	if acquired := d.volumeLocks.TryAcquire(volumeID); !acquired {
 		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	if strings.TrimSpace(storageEndpointSuffix) == "" {
-		if d.cloud.Environment.StorageEndpointSuffix != "" {
-			storageEndpointSuffix = d.cloud.Environment.StorageEndpointSuffix
-		} else {
-			storageEndpointSuffix = defaultStorageEndPointSuffix
+	if d.maxVolumesPerNode > 0 {
+		if _, staged := d.stagedVolumes.Load(volumeID); !staged {
+			if count := d.getStagedVolumeCount(); count >= d.maxVolumesPerNode {
+				return nil, status.Errorf(codes.ResourceExhausted, "number of staged volumes(%d) on node(%s) reached max volumes per node(%d), could not stage volume(%s)", count, d.NodeID, d.maxVolumesPerNode, volumeID)
+			}
 		}
 	}
 
+	if strings.TrimSpace(storageEndpointSuffix) == "" {
+		storageEndpointSuffix = getStorageEndpointSuffix(d.cloud)
+	}
+
 	// replace pv/pvc name namespace metadata in fileShareName
 	fileShareName = replaceWithMap(fileShareName, fileShareNameReplaceMap)
 
 	osSeparator := string(os.PathSeparator)
+	// user provided server is never switched to primary endpoint of storage account after failover
+	userProvidedServer := strings.TrimSpace(server) != "" || mountServerAddress != ""
 	if strings.TrimSpace(server) == "" {
 		// server address is "accountname.file.core.windows.net" by default
 		server = fmt.Sprintf("%s.file.%s", accountName, storageEndpointSuffix)
 	}
 	source := fmt.Sprintf("%s%s%s%s%s", osSeparator, osSeparator, server, osSeparator, fileShareName)
 	if protocol == nfs {
-		source = fmt.Sprintf("%s:/%s/%s", server, accountName, fileShareName)
+		if mountServerAddress != "" {
+			// mount through relay or proxy, file share path is still under storage account
+			server = mountServerAddress
+		}
+		// short server name is qualified by DNS search domain in split-DNS environments
+		server = getFQDN(server, dnsSearchDomain)
+		source = fmt.Sprintf("%s:%s", getNFSServerAddress(server), getNFSExportPath(nfsExportPathTemplate, accountName, fileShareName))
 	}
 	if folderName != "" {
 		source = fmt.Sprintf("%s%s%s", source, osSeparator, folderName)
@@ -276,48 +469,146 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		cifsMountPath = filepath.Join(filepath.Dir(targetPath), proxyMount)
 	}
 
+	keyAuthDisabledBy := getAccountKeyAuthDisabledBy(disableAccountKeyAuth, dataPlaneAuth)
+	if keyAuthDisabledBy != "" {
+		if runtime.GOOS == "windows" && protocol != nfs {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with smb protocol on windows", keyAuthDisabledBy)
+		}
+		if useCredentialFile {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with %s since credential file contains account key", keyAuthDisabledBy, useCredentialFileField)
+		}
+		authMethod, err := getIdentityAuthMethod(keyAuthDisabledBy, protocol, cifsMountFlags)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		klog.V(2).Infof("account key auth is disabled on volume(%s), mount with %s auth", volumeID, authMethod)
+	}
+
 	var mountOptions, sensitiveMountOptions []string
 	if protocol == nfs {
-		mountOptions = util.JoinMountOptions(mountFlags, []string{"vers=4,minorversion=1,sec=sys"})
+		if mountOptions, err = getNFSMountOptions(d.applyDefaultMountOptions(mountFlags, protocol), enableNFSACL, nfsVersion); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 	} else {
-		if accountName == "" || accountKey == "" {
+		// account key is not necessary if account key auth is disabled
+		if accountName == "" || (accountKey == "" && keyAuthDisabledBy == "") {
 			return nil, status.Errorf(codes.Internal, "accountName(%s) or accountKey is empty", accountName)
 		}
 		if runtime.GOOS == "windows" {
-			mountOptions = []string{fmt.Sprintf("AZURE\\%s", accountName)}
+			if useCredentialFile {
+				return nil, status.Errorf(codes.InvalidArgument, "%s is not supported on windows", useCredentialFileField)
+			}
+			// username is always the first item, windowsMountOptions are applied in smb global mapping
+			mountOptions = append([]string{fmt.Sprintf("AZURE\\%s", accountName)}, osMountOptions...)
 			sensitiveMountOptions = []string{accountKey}
 		} else {
 			if err := os.MkdirAll(targetPath, os.FileMode(mountPermissions)); err != nil {
 				return nil, status.Error(codes.Internal, fmt.Sprintf("MkdirAll %s failed with error: %v", targetPath, err))
 			}
-			// parameters suggested by https://azure.microsoft.com/en-us/documentation/articles/storage-how-to-use-files-linux/
-			sensitiveMountOptions = []string{fmt.Sprintf("username=%s,password=%s", accountName, accountKey)}
 			if ephemeralVol {
 				cifsMountFlags = util.JoinMountOptions(cifsMountFlags, strings.Split(ephemeralVolMountOptions, ","))
 			}
-			mountOptions = appendDefaultMountOptions(cifsMountFlags)
+			mountOptions = d.applyDefaultMountOptions(cifsMountFlags, protocol)
+			if keyAuthDisabledBy != "" {
+				// kerberos ticket of the node is used in mount, neither account name nor key is passed
+				klog.V(2).Infof("skip passing account key in mount of volume(%s)", volumeID)
+			} else if useCredentialFile {
+				// account name and key are read from credential file by mount.cifs, not passed in mount command line,
+				// credential file is rewritten on every stage so that rotated account key is used
+				credFilePath := getCredentialFilePath(targetPath)
+				if err := writeCredentialFile(credFilePath, accountName, accountKey); err != nil {
+					return nil, status.Errorf(codes.Internal, "failed to write credential file(%s): %v", credFilePath, err)
+				}
+				mountOptions = append(mountOptions, fmt.Sprintf("credentials=%s", credFilePath))
+			} else {
+				// parameters suggested by https://azure.microsoft.com/en-us/documentation/articles/storage-how-to-use-files-linux/
+				sensitiveMountOptions = []string{fmt.Sprintf("username=%s,password=%s", accountName, accountKey)}
+			}
 		}
 	}
 
-	klog.V(2).Infof("cifsMountPath(%v) fstype(%v) volumeID(%v) context(%v) mountflags(%v) mountOptions(%v) volumeMountGroup(%s)", cifsMountPath, fsType, volumeID, context, mountFlags, mountOptions, volumeMountGroup)
+	klog.V(2).Infof("cifsMountPath(%v) fstype(%v) volumeID(%v) context(%v) mountflags(%v) mountOptions(%v) volumeMountGroup(%s)", cifsMountPath, fsType, volumeID, context, redactMountOptions(mountFlags, nil), redactMountOptions(mountOptions, nil), volumeMountGroup)
 
+	// effective mount options of staged volume, they are replaced by the options of successful mount below
+	effectiveMountOptions := redactMountOptions(mountOptions, sensitiveMountOptions)
 	isDirMounted, err := d.ensureMountPoint(cifsMountPath, os.FileMode(mountPermissions))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Could not mount target %s: %v", cifsMountPath, err)
 	}
 	if isDirMounted {
+		if !d.allowMismatchedStagingMount {
+			if err := d.checkStagingMount(source, cifsMountPath, mountOptions); err != nil {
+				return nil, status.Errorf(codes.AlreadyExists, "volume(%s) is incompatible with existing mount on %s: %v", volumeID, cifsMountPath, err)
+			}
+		}
 		klog.V(2).Infof("NodeStageVolume: volume %s is already mounted on %s", volumeID, targetPath)
 	} else {
-		mountFsType := cifs
+		mountFsType, mountProtocol := cifs, smb
 		if protocol == nfs {
-			mountFsType = nfs
+			mountFsType, mountProtocol = nfs, nfs
+		}
+		if d.checkShareCapacityOnMount {
+			if err := d.checkShareCapacity(ctx, subsID, rgName, accountName, fileShareName); err != nil {
+				return nil, err
+			}
 		}
 		if err := prepareStagePath(cifsMountPath, d.mounter); err != nil {
 			return nil, status.Errorf(codes.Internal, "prepare stage path failed for %s with error: %v", cifsMountPath, err)
 		}
-		if err := wait.PollImmediate(1*time.Second, 2*time.Minute, func() (bool, error) {
-			return true, SMBMount(d.mounter, source, cifsMountPath, mountFsType, mountOptions, sensitiveMountOptions)
-		}); err != nil {
+		_, span := startSpan(ctx, "mount", volumeIDAttribute.String(volumeID), protocolAttribute.String(protocol), fsTypeAttribute.String(mountFsType))
+		// mount command is killed once mountTimeout is reached, deadline is shared by all mount retries
+		mountCtx, cancel := newMountContext(mountTimeout)
+		defer cancel()
+		mountWithRetries := func(source string) error {
+			var lastErr error
+			err := wait.ExponentialBackoff(d.getNodeMountBackoff(), func() (bool, error) {
+				mountStart := time.Now()
+				usedMountOptions, err := d.smbMount(mountCtx, source, cifsMountPath, mountFsType, mountOptions, sensitiveMountOptions)
+				observeMountDuration(mountProtocol, mountStart, err)
+				if err != nil && mountCtx.Err() != nil {
+					// mount could complete right before it's killed
+					if cerr := CleanupMountPoint(d.mounter, cifsMountPath, true); cerr != nil {
+						klog.Warningf("failed to clean up %s after mount timeout: %v", cifsMountPath, cerr)
+					}
+					return true, status.Errorf(codes.DeadlineExceeded, "mount on %s did not complete within %v: %v", cifsMountPath, mountTimeout, err)
+				}
+				if err == nil {
+					effectiveMountOptions = redactMountOptions(usedMountOptions, sensitiveMountOptions)
+				}
+				if isRetriableMountError(err) {
+					klog.Warningf("volume(%s) mount %s on %s failed with %v, waiting for retrying", volumeID, source, cifsMountPath, err)
+					lastErr = err
+					return false, nil
+				}
+				return true, err
+			})
+			if err == wait.ErrWaitTimeout && lastErr != nil {
+				err = lastErr
+			}
+			return err
+		}
+		err := mountWithRetries(source)
+		if d.enableFailoverAwareness && protocol != nfs && !userProvidedServer && isRetriableMountError(err) {
+			// primary endpoint of geo-redundant account changes after account failover
+			newServer, ferr := d.getFailoverServer(ctx, subsID, rgName, accountName, server)
+			if ferr != nil {
+				klog.Warningf("failed to check failover state of account(%s) after mount failure: %v", accountName, ferr)
+			} else if newServer != "" {
+				newSource := replaceSourceServer(source, server, newServer)
+				klog.Warningf("account(%s) has failed over, primary endpoint changed from %s to %s, retrying mount %s on %s", accountName, server, newServer, newSource, cifsMountPath)
+				source = newSource
+				err = mountWithRetries(source)
+			}
+		}
+		endSpan(span, err)
+		if s, ok := status.FromError(err); ok && s.Code() == codes.DeadlineExceeded {
+			return nil, status.Errorf(codes.DeadlineExceeded, "volume(%s) mount %s on %s: %s", volumeID, source, cifsMountPath, s.Message())
+		}
+		if err != nil {
+			if protocol != nfs && isSMBAuthError(err) {
+				// account key may be rotated, read it again in next NodeStageVolume
+				d.invalidateAccountCredential(accountName)
+			}
 			var helpLinkMsg string
 			if d.appendMountErrorHelpLink {
 				helpLinkMsg = "\nPlease refer to http://aka.ms/filemounterror for possible causes and solutions for mount errors."
@@ -333,6 +624,14 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 				klog.V(2).Infof("skip chmod on targetPath(%s) since mountPermissions is set as 0", targetPath)
 			}
 		}
+		if rootDirACL != "" {
+			if err := d.applyRootDirACL(volumeID, source, rootDirACL); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+		if protocol != nfs {
+			d.recordNegotiatedSMBVersion(volumeID, cifsMountPath)
+		}
 		klog.V(2).Infof("volume(%s) mount %s on %s succeeded", volumeID, source, cifsMountPath)
 	}
 
@@ -343,19 +642,21 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		}
 		if mnt {
 			klog.V(2).Infof("NodeStageVolume: volume %s is already mounted on %s", volumeID, targetPath)
+			d.stagedVolumes.Store(volumeID, &stagedVolume{VolumeID: volumeID, StagingPath: targetPath, MountOptions: effectiveMountOptions})
 			return &csi.NodeStageVolumeResponse{}, nil
 		}
 
 		diskPath := filepath.Join(cifsMountPath, diskName)
-		options := util.JoinMountOptions(mountFlags, []string{"loop"})
-		if strings.HasPrefix(fsType, "ext") {
-			// following mount options are only valid for ext2/ext3/ext4 file systems
-			options = util.JoinMountOptions(options, []string{"noatime", "barrier=1", "errors=remount-ro"})
+		klog.V(2).Infof("NodeStageVolume: volume %s formatting %s as %s and mounting at %s", volumeID, diskPath, fsType, targetPath)
+		release, err := d.acquireFormatSlot(ctx)
+		if err != nil {
+			return nil, status.Errorf(status.FromContextError(err).Code(), "waiting for format slot of volume(%s) failed with %v", volumeID, err)
 		}
-
-		klog.V(2).Infof("NodeStageVolume: volume %s formatting %s and mounting at %s with mount options(%s)", volumeID, targetPath, diskPath, options)
-		// FormatAndMount will format only if needed
-		if err := d.mounter.FormatAndMount(diskPath, targetPath, fsType, options); err != nil {
+		_, span := startSpan(ctx, "formatAndMountDisk", volumeIDAttribute.String(volumeID), fsTypeAttribute.String(fsType))
+		err = d.formatAndMountDisk(diskPath, targetPath, fsType, mountFlags)
+		endSpan(span, err)
+		release()
+		if err != nil {
 			return nil, status.Error(codes.Internal, fmt.Sprintf("could not format %s and mount it at %s", targetPath, diskPath))
 		}
 		klog.V(2).Infof("NodeStageVolume: volume %s format %s and mounting at %s successfully", volumeID, targetPath, diskPath)
@@ -369,6 +670,7 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			}
 		}
 	}
+	d.stagedVolumes.Store(volumeID, &stagedVolume{VolumeID: volumeID, StagingPath: targetPath, MountOptions: effectiveMountOptions})
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -398,7 +700,11 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 	if err := CleanupMountPoint(d.mounter, targetPath, false); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount staging target %s: %v", targetPath, err)
 	}
+	if err := removeCredentialFile(getCredentialFilePath(stagingTargetPath)); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove credential file of staging target %s: %v", stagingTargetPath, err)
+	}
 	klog.V(2).Infof("NodeUnstageVolume: unmount volume %s on %s successfully", volumeID, stagingTargetPath)
+	d.stagedVolumes.Delete(volumeID)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
@@ -412,9 +718,38 @@ func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabi
 
 // NodeGetInfo return info of the node on which this plugin is running
 func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{
-		NodeId: d.NodeID,
-	}, nil
+	resp := &csi.NodeGetInfoResponse{
+		NodeId:            d.NodeID,
+		MaxVolumesPerNode: d.maxVolumesPerNode,
+	}
+	segments := map[string]string{}
+	if d.enableRegionalAccountAffinity {
+		if region := d.getNodeRegion(ctx); region != "" {
+			segments[topologyRegionKey] = region
+		}
+	}
+	if d.enableNodeOSTopology {
+		segments[topologyOSKey] = runtime.GOOS
+	}
+	if len(segments) > 0 {
+		resp.AccessibleTopology = &csi.Topology{Segments: segments}
+	}
+	return resp, nil
+}
+
+// getNodeRegion returns region of the node from node label, region of cloud config is returned if node label is not available
+func (d *Driver) getNodeRegion(ctx context.Context) string {
+	if d.cloud == nil {
+		return ""
+	}
+	if d.cloud.KubeClient != nil {
+		node, err := d.cloud.KubeClient.CoreV1().Nodes().Get(ctx, d.NodeID, metav1.GetOptions{})
+		if err == nil && node.Labels[topologyRegionKey] != "" {
+			return node.Labels[topologyRegionKey]
+		}
+		klog.Warningf("failed to get region label of node(%s), use region(%s) in cloud config, error: %v", d.NodeID, d.cloud.Location, err)
+	}
+	return d.cloud.Location
 }
 
 // NodeGetVolumeStats get volume stats
@@ -479,9 +814,26 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 				Used:      inodesUsed,
 			},
 		},
+		VolumeCondition: getVolumeCondition(used, capacity, d.volumeFullThresholdPercent),
 	}, nil
 }
 
+// getVolumeCondition returns abnormal volume condition if usage percentage of volume reaches thresholdPercent,
+// it returns nil if threshold is disabled or capacity is unknown
+func getVolumeCondition(used, capacity int64, thresholdPercent int) *csi.VolumeCondition {
+	if thresholdPercent <= 0 || capacity <= 0 {
+		return nil
+	}
+	usagePercent := float64(used) * 100 / float64(capacity)
+	if usagePercent < float64(thresholdPercent) {
+		return &csi.VolumeCondition{Message: fmt.Sprintf("volume usage(%.1f%%) is below threshold(%d%%)", usagePercent, thresholdPercent)}
+	}
+	return &csi.VolumeCondition{
+		Abnormal: true,
+		Message:  fmt.Sprintf("volume usage(%.1f%%) reaches threshold(%d%%): %d of %d bytes are used, expand the volume or remove data before writes fail", usagePercent, thresholdPercent, used, capacity),
+	}
+}
+
 // NodeExpandVolume node expand volume
 // N/A for azure file
 func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
@@ -545,6 +897,107 @@ func (d *Driver) ensureMountPoint(target string, perm os.FileMode) (bool, error)
 	return !notMnt, nil
 }
 
+// acquireFormatSlot waits until the number of concurrent format operations is below maxConcurrentFormat,
+// it returns a function which releases the slot, or the error of ctx if ctx is done before a slot is available
+func (d *Driver) acquireFormatSlot(ctx context.Context) (func(), error) {
+	if d.formatSemaphore == nil {
+		return func() {}, nil
+	}
+	select {
+	case d.formatSemaphore <- struct{}{}:
+		return func() { <-d.formatSemaphore }, nil
+	default:
+	}
+	klog.V(2).Infof("waiting for format slot, %d format operations are running", len(d.formatSemaphore))
+	select {
+	case d.formatSemaphore <- struct{}{}:
+		return func() { <-d.formatSemaphore }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// formatAndMountDisk mounts vhd disk on targetPath, disk is formatted with fsType (mkfs.<fsType>)
+// only if it's not formatted, an already formatted disk would never be formatted again
+func (d *Driver) formatAndMountDisk(diskPath, targetPath, fsType string, mountFlags []string) error {
+	options := util.JoinMountOptions(mountFlags, []string{"loop"})
+	if strings.HasPrefix(fsType, "ext") {
+		// following mount options are only valid for ext2/ext3/ext4 file systems
+		options = util.JoinMountOptions(options, []string{"noatime", "barrier=1", "errors=remount-ro"})
+	}
+	return d.mounter.FormatAndMount(diskPath, targetPath, fsType, options)
+}
+
+// applyRootDirACL sets acl on root directory of smb mount on first mount unless the same acl is already set,
+// acl is inherited by all clients of the file share, it's only supported on windows node with host process
+func (d *Driver) applyRootDirACL(volumeID, path, acl string) error {
+	currentACL, err := getDirACL(path)
+	if err != nil {
+		return err
+	}
+	apply, err := shouldApplyRootDirACL(acl, currentACL)
+	if err != nil {
+		return err
+	}
+	if !apply {
+		klog.V(2).Infof("%s(%s) of volume(%s) is already set on %s", rootDirACLField, acl, volumeID, path)
+		return nil
+	}
+	klog.V(2).Infof("set %s(%s) of volume(%s) on %s, current ACL: %s", rootDirACLField, acl, volumeID, path, currentACL)
+	return setDirACL(path, acl)
+}
+
+// smbMount mounts smb file share, if SMB version fallback is enabled, mount is retried with lower SMB version
+// on protocol negotiation errors, it never downgrades SMB version on other errors, e.g. authentication errors,
+// mount options of the last mount are returned, mount is killed once ctx is done
+func (d *Driver) smbMount(ctx context.Context, source, target, fsType string, mountOptions, sensitiveMountOptions []string) ([]string, error) {
+	for {
+		klog.V(2).Infof("mount command: mount -t %s -o %s %s %s", fsType, strings.Join(redactMountOptions(mountOptions, sensitiveMountOptions), ","), source, target)
+		err := SMBMount(ctx, d.mounter, source, target, fsType, mountOptions, sensitiveMountOptions)
+		if err == nil || !d.enableSMBVersionFallback || fsType != cifs || runtime.GOOS == "windows" || !isSMBNegotiationError(err) {
+			return mountOptions, err
+		}
+		lowerMountOptions, currentVersion, lowerVersion := getLowerSMBVersionMountOptions(mountOptions)
+		if lowerVersion == "" {
+			return mountOptions, err
+		}
+		klog.Warningf("mount %s on %s with SMB version(%s) failed with negotiation error: %v, downgrade SMB version to %s and retry", source, target, currentVersion, err, lowerVersion)
+		mountOptions = lowerMountOptions
+	}
+}
+
+// newMountContext returns context of mount which is done after timeout, mount is never killed if timeout is 0
+func newMountContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// getLowerSMBVersionMountOptions returns mount options with the next lower SMB version in smbVersionFallbackList,
+// current SMB version is the highest version if vers is not set, lower version is empty if there is no lower version
+func getLowerSMBVersionMountOptions(mountOptions []string) ([]string, string, string) {
+	currentVersion := smbVersionFallbackList[0]
+	var otherMountOptions []string
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			if key, value, _ := strings.Cut(option, "="); key == smbVersionMountOption {
+				currentVersion = value
+			} else {
+				otherMountOptions = append(otherMountOptions, option)
+			}
+		}
+	}
+
+	for i, version := range smbVersionFallbackList {
+		if version == currentVersion && i+1 < len(smbVersionFallbackList) {
+			lowerVersion := smbVersionFallbackList[i+1]
+			return append(otherMountOptions, fmt.Sprintf("%s=%s", smbVersionMountOption, lowerVersion)), currentVersion, lowerVersion
+		}
+	}
+	return mountOptions, currentVersion, ""
+}
+
 func makeDir(pathname string, perm os.FileMode) error {
 	err := os.MkdirAll(pathname, perm)
 	if err != nil {
@@ -563,3 +1016,171 @@ func checkGidPresentInMountFlags(mountFlags []string) bool {
 	}
 	return false
 }
+
+// checkShareCapacity checks whether usage of file share reaches its quota before mount since mount still succeeds on a full share
+// while writes would fail, it's best effort and only returns error when share is full and failOnFullShare is set
+func (d *Driver) checkShareCapacity(ctx context.Context, subsID, resourceGroupName, accountName, fileShareName string) error {
+	if d.cloud == nil || d.cloud.FileClient == nil {
+		klog.V(2).Infof("skip capacity check of file share(%s) since file client is nil", fileShareName)
+		return nil
+	}
+	if resourceGroupName == "" {
+		resourceGroupName = d.cloud.ResourceGroup
+	}
+	fileShare, err := d.cloud.GetFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName)
+	if err != nil {
+		klog.Warningf("skip capacity check of file share(%s) under account(%s) since GetFileShare failed with error: %v", fileShareName, accountName, err)
+		return nil
+	}
+	if fileShare.FileShareProperties == nil || fileShare.FileShareProperties.ShareQuota == nil || fileShare.FileShareProperties.ShareUsageBytes == nil {
+		klog.V(2).Infof("skip capacity check of file share(%s) under account(%s) since quota or usage is not returned", fileShareName, accountName)
+		return nil
+	}
+	usageBytes, quotaGiB := *fileShare.FileShareProperties.ShareUsageBytes, *fileShare.FileShareProperties.ShareQuota
+	if !isShareFull(usageBytes, quotaGiB) {
+		return nil
+	}
+	msg := fmt.Sprintf("file share(%s) under account(%s) is full: usage(%d bytes) reached quota(%d GiB), writes on the volume would fail until the volume is expanded or data is removed",
+		fileShareName, accountName, usageBytes, quotaGiB)
+	if d.failOnFullShare {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	klog.Warning(msg)
+	return nil
+}
+
+// isShareFull returns true if usage of file share reaches its quota
+func isShareFull(usageBytes int64, quotaGiB int32) bool {
+	return quotaGiB > 0 && usageBytes >= volumehelper.GiBToBytes(int64(quotaGiB))
+}
+
+// getFailoverServer checks failover state of storage account when mount against server keeps failing,
+// it returns the new primary file endpoint if account has failed over, or empty string if the endpoint is not changed
+func (d *Driver) getFailoverServer(ctx context.Context, subsID, resourceGroupName, accountName, server string) (string, error) {
+	if d.cloud == nil || d.cloud.StorageAccountClient == nil {
+		return "", fmt.Errorf("storage account client is nil")
+	}
+	if resourceGroupName == "" {
+		resourceGroupName = d.cloud.ResourceGroup
+	}
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroupName, accountName)
+	if rerr != nil {
+		return "", rerr.Error()
+	}
+	return getAccountFailoverServer(account, server)
+}
+
+// getAccountFailoverServer returns host of primary file endpoint of storage account if it's different from server,
+// it returns error if account failover is still in progress or primary location is unavailable
+func getAccountFailoverServer(account storage.Account, server string) (string, error) {
+	accountName := getAccountName(account)
+	if account.AccountProperties == nil {
+		return "", fmt.Errorf("properties of account(%s) are not returned", accountName)
+	}
+	if account.FailoverInProgress != nil && *account.FailoverInProgress {
+		return "", fmt.Errorf("failover of account(%s) is in progress", accountName)
+	}
+	if account.StatusOfPrimary == storage.AccountStatusUnavailable {
+		return "", fmt.Errorf("primary location(%s) of account(%s) is unavailable", pointer.StringDeref(account.PrimaryLocation, ""), accountName)
+	}
+	if account.PrimaryEndpoints == nil || account.PrimaryEndpoints.File == nil {
+		return "", fmt.Errorf("primary file endpoint of account(%s) is not returned", accountName)
+	}
+	endpoint, err := url.Parse(*account.PrimaryEndpoints.File)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse primary file endpoint(%s) of account(%s): %v", *account.PrimaryEndpoints.File, accountName, err)
+	}
+	if endpoint.Hostname() == "" || strings.EqualFold(endpoint.Hostname(), server) {
+		return "", nil
+	}
+	return endpoint.Hostname(), nil
+}
+
+// replaceSourceServer replaces server in SMB mount source(e.g. //server/share/folder) with newServer
+func replaceSourceServer(source, server, newServer string) string {
+	prefix := strings.Repeat(string(os.PathSeparator), 2)
+	if !strings.HasPrefix(source, prefix+server) {
+		return source
+	}
+	return prefix + newServer + strings.TrimPrefix(source, prefix+server)
+}
+
+// getTopMountPoint returns the mount on top of target in mount list, nil is returned if target is not in mount list
+func (d *Driver) getTopMountPoint(target string) (*mount.MountPoint, error) {
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		return nil, err
+	}
+	var mountPoint *mount.MountPoint
+	for i := range mountPoints {
+		// the last one is on top if there are multiple mounts on target
+		if filepath.Clean(mountPoints[i].Path) == filepath.Clean(target) {
+			mountPoint = &mountPoints[i]
+		}
+	}
+	return mountPoint, nil
+}
+
+// remountIfReadOnlyChanged remounts the existing bind mount on target if its read-only state conflicts with the requested one,
+// e.g. NodePublishVolume with readonly=true is followed by NodePublishVolume with readonly=false on the same target
+func (d *Driver) remountIfReadOnlyChanged(target string, readOnly bool) error {
+	if runtime.GOOS == "windows" {
+		// read-only bind mount is not supported on Windows
+		return nil
+	}
+	mountPoint, err := d.getTopMountPoint(target)
+	if err != nil || mountPoint == nil {
+		return err
+	}
+	isReadOnly := hasMountOption(mountPoint.Opts, "ro")
+	if isReadOnly == readOnly {
+		return nil
+	}
+	option := "rw"
+	if readOnly {
+		option = "ro"
+	}
+	klog.V(2).Infof("NodePublishVolume: remount %s with %s since read-only state(%v) of existing mount conflicts with request", target, option, isReadOnly)
+	// mounter.Mount would create another bind mount on target before remount if options contain bind,
+	// only the per mount flag of the existing bind mount is changed by remount with bind
+	if output, err := d.mounter.Exec.Command("mount", "-o", "remount,bind,"+option, target).CombinedOutput(); err != nil {
+		return fmt.Errorf("remount %s with %s failed with %v, output: %s", target, option, err, string(output))
+	}
+	return nil
+}
+
+// checkStagingMount checks whether the existing mount on target is a mount of source with the same read-only state,
+// other mount options are not compared since they are normalized by kernel, e.g. vers=4,minorversion=1 is shown as vers=4.1
+func (d *Driver) checkStagingMount(source, target string, mountOptions []string) error {
+	if runtime.GOOS == "windows" {
+		// mount list is not available on Windows
+		return nil
+	}
+	mountPoint, err := d.getTopMountPoint(target)
+	if err != nil {
+		return err
+	}
+	if mountPoint == nil {
+		// target is likely a mount point while it's not in mount list, keep the existing behavior
+		return nil
+	}
+	if !strings.EqualFold(strings.TrimSuffix(mountPoint.Device, "/"), strings.TrimSuffix(source, "/")) {
+		return fmt.Errorf("source of existing mount is %s, expected %s", mountPoint.Device, source)
+	}
+	if isReadOnly, expectReadOnly := hasMountOption(mountPoint.Opts, "ro"), hasMountOption(mountOptions, "ro"); isReadOnly != expectReadOnly {
+		return fmt.Errorf("read-only state(%v) of existing mount is different from expected(%v)", isReadOnly, expectReadOnly)
+	}
+	return nil
+}
+
+// hasMountOption checks whether option is in mountOptions, an item of mountOptions could contain comma separated options
+func hasMountOption(mountOptions []string, option string) bool {
+	for _, opts := range mountOptions {
+		for _, opt := range strings.Split(opts, ",") {
+			if strings.TrimSpace(opt) == option {
+				return true
+			}
+		}
+	}
+	return false
+}
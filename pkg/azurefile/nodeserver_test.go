@@ -25,21 +25,34 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
+	"time"
 
 	"sigs.k8s.io/azurefile-csi-driver/test/utils/testutil"
 
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	azure2 "github.com/Azure/go-autorest/autorest/azure"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
 	mount "k8s.io/mount-utils"
 	"k8s.io/utils/exec"
 	testingexec "k8s.io/utils/exec/testing"
+	"k8s.io/utils/pointer"
 
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
 )
 
 const (
@@ -73,6 +86,39 @@ func TestNodeGetInfo(t *testing.T) {
 	resp, err := d.NodeGetInfo(context.Background(), &req)
 	assert.NoError(t, err)
 	assert.Equal(t, resp.GetNodeId(), fakeNodeID)
+	assert.Equal(t, resp.GetMaxVolumesPerNode(), int64(0))
+
+	d = NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, MaxVolumesPerNode: 10})
+	resp, err = d.NodeGetInfo(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.GetNodeId(), fakeNodeID)
+	assert.Equal(t, resp.GetMaxVolumesPerNode(), int64(10))
+	assert.Nil(t, resp.GetAccessibleTopology())
+
+	// node region is reported in topology if regional account affinity is enabled
+	d = NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, EnableRegionalAccountAffinity: true})
+	d.cloud.Location = "eastus"
+	resp, err = d.NodeGetInfo(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{topologyRegionKey: "eastus"}, resp.GetAccessibleTopology().GetSegments())
+
+	d.cloud.KubeClient = fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: fakeNodeID, Labels: map[string]string{topologyRegionKey: "westus"}},
+	})
+	resp, err = d.NodeGetInfo(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{topologyRegionKey: "westus"}, resp.GetAccessibleTopology().GetSegments())
+
+	// node OS is reported in topology if node OS topology is enabled
+	d.enableNodeOSTopology = true
+	resp, err = d.NodeGetInfo(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{topologyRegionKey: "westus", topologyOSKey: runtime.GOOS}, resp.GetAccessibleTopology().GetSegments())
+
+	d.enableRegionalAccountAffinity = false
+	resp, err = d.NodeGetInfo(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{topologyOSKey: runtime.GOOS}, resp.GetAccessibleTopology().GetSegments())
 }
 
 func TestNodeGetCapabilities(t *testing.T) {
@@ -225,6 +271,40 @@ func TestNodePublishVolume(t *testing.T) {
 				Readonly:          true},
 			expectedErr: testutil.TestError{},
 		},
+		{
+			desc: "[Error] invalid fsGroupChangePolicy",
+			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+				VolumeContext:     map[string]string{fsGroupChangePolicyField: "test_fsGroupChangePolicy"},
+			},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "fsGroupChangePolicy(test_fsGroupChangePolicy) is not supported, supported fsGroupChangePolicy list: [None Always OnRootMismatch]"),
+			},
+		},
+		{
+			desc: "[Success] Valid nfs request with volumeMountGroup",
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &volumeCap,
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: fmt.Sprintf("%d", os.Getgid())},
+					},
+				},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+				VolumeContext: map[string]string{
+					protocolField:            nfs,
+					fsGroupChangePolicyField: "OnRootMismatch",
+				},
+			},
+			cleanup: func() {
+				_ = os.RemoveAll(targetTest)
+			},
+			expectedErr: testutil.TestError{},
+		},
 		{
 			desc: "[Error] invalid mountPermissions",
 			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
@@ -357,6 +437,20 @@ func TestNodeStageVolume(t *testing.T) {
 			},
 		},
 	}
+	softMountVolCap := csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"soft", "timeo=600"},
+			},
+		},
+	}
+	invalidTimeoVolCap := csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"hard,timeo=0"},
+			},
+		},
+	}
 	d := NewFakeDriver()
 
 	var (
@@ -401,6 +495,11 @@ func TestNodeStageVolume(t *testing.T) {
 		shareNameField:  "test_sharename",
 		serverNameField: "test_servername",
 	}
+	volContextEmptyFsType := map[string]string{
+		diskNameField:   "test_disk.vhd",
+		shareNameField:  "test_sharename",
+		serverNameField: "test_servername",
+	}
 	errorSource := `\\test_servername\test_sharename`
 	errorSourceNFS := `test_servername://test_sharename`
 
@@ -497,6 +596,129 @@ func TestNodeStageVolume(t *testing.T) {
 				DefaultError: status.Error(codes.InvalidArgument, "protocol(test_protocol) is not supported, supported protocol list: [smb nfs]"),
 			},
 		},
+		{
+			desc: "[Error] auto protocol of pre-provisioned volume",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					protocolField:   auto,
+					shareNameField:  "test_sharename",
+					serverNameField: "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "protocol(auto) is only supported in storage class, set protocol(smb or nfs) of pre-provisioned volume"),
+			},
+		},
+		{
+			desc: "[Error] mountServerAddress with smb protocol",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:          "test_sharename",
+					serverNameField:         "test_servername",
+					mountServerAddressField: "10.0.0.4",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "mountserveraddress is only supported with nfs protocol"),
+			},
+		},
+		{
+			desc: "[Error] invalid nfsExportPathTemplate",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:             "test_sharename",
+					serverNameField:            "test_servername",
+					protocolField:              nfs,
+					nfsExportPathTemplateField: "/${account}/${volume}",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid nfsexportpathtemplate(/${account}/${volume}), only ${account} and ${share} are supported placeholders"),
+			},
+		},
+		{
+			desc: "[Error] invalid dnsSearchDomain",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:       "test_sharename",
+					serverNameField:      "test_servername",
+					protocolField:        nfs,
+					dnsSearchDomainField: "corp_domain",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid dnssearchdomain(corp_domain): a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')"),
+			},
+		},
+		{
+			desc: "[Error] enableNfsAcl with smb protocol",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:    "test_sharename",
+					serverNameField:   "test_servername",
+					protocolField:     smb,
+					enableNFSACLField: "true",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "enablenfsacl is only supported with nfs protocol, current protocol: smb"),
+			},
+		},
+		{
+			desc: "[Error] useCredentialFile with nfs protocol",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:         "test_sharename",
+					serverNameField:        "test_servername",
+					protocolField:          nfs,
+					useCredentialFileField: "true",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "usecredentialfile is only supported with smb protocol"),
+			},
+		},
+		{
+			desc: "[Error] rootDirACL with nfs protocol",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:  "test_sharename",
+					serverNameField: "test_servername",
+					protocolField:   nfs,
+					rootDirACLField: "D:(A;OICI;FA;;;BA)",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "rootdiracl is only supported with smb protocol"),
+			},
+		},
+		{
+			desc: "[Error] rootDirACL without windows host process",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:  "test_sharename",
+					serverNameField: "test_servername",
+					rootDirACLField: "D:(A;OICI;FA;;;BA)",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "rootdiracl is only supported on windows node with host process"),
+			},
+		},
+		{
+			desc: "[Error] invalid enableNfsAcl",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:    "test_sharename",
+					serverNameField:   "test_servername",
+					protocolField:     nfs,
+					enableNFSACLField: "invalid",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid enablenfsacl: invalid"),
+			},
+		},
 		{
 			desc: "[Error] Invalid fsGroupChangePolicy",
 			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
@@ -510,6 +732,60 @@ func TestNodeStageVolume(t *testing.T) {
 				DefaultError: status.Error(codes.InvalidArgument, "fsGroupChangePolicy(test_fsGroupChangePolicy) is not supported, supported fsGroupChangePolicy list: [None Always OnRootMismatch]"),
 			},
 		},
+		{
+			desc: "[Error] nfs mount options with smb protocol",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &softMountVolCap,
+				VolumeContext: map[string]string{
+					protocolField:   "smb",
+					shareNameField:  "test_sharename",
+					serverNameField: "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "mount option(soft) is only supported with nfs protocol, current protocol: smb"),
+			},
+		},
+		{
+			desc: "[Error] Invalid timeo mount option with nfs protocol",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &invalidTimeoVolCap,
+				VolumeContext: map[string]string{
+					protocolField:   "nfs",
+					shareNameField:  "test_sharename",
+					serverNameField: "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "mount option(timeo=0) is invalid, timeo should be a positive integer"),
+			},
+		},
+		{
+			desc: "[Error] Invalid mount options of node OS",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					windowsMountOptionsField: "dir_mode=0777",
+					linuxMountOptionsField:   "uid=0 gid=0",
+					shareNameField:           "test_sharename",
+					serverNameField:          "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid mount option(uid=0 gid=0) in linuxmountoptions"),
+				WindowsError: status.Error(codes.InvalidArgument, "mount option(dir_mode=0777) is not supported in windowsmountoptions, supported options: [requireintegrity requireprivacy usewritethrough]"),
+			},
+		},
+		{
+			desc: "[Error] Get mount options from configmap failed",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					mountOptionsConfigMapField: "default/mount-options",
+					shareNameField:             "test_sharename",
+					serverNameField:            "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "failed to get mount options from configmap(default/mount-options): could not get configmap(default/mount-options): KubeClient is nil"),
+			},
+		},
 		{
 			desc: "[Error] Empty accountname",
 			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
@@ -617,6 +893,42 @@ func TestNodeStageVolume(t *testing.T) {
 				DefaultError: status.Errorf(codes.Internal, "could not format %v and mount it at %v", sourceTest, testDiskPath),
 			},
 		},
+		{
+			desc: "[Error] Unsupported fsType of vhd disk in volume capability",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{FsType: "ntfs"},
+					},
+				},
+				VolumeContext: volContextEmptyFsType,
+				Secrets:       secrets},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Errorf(codes.InvalidArgument, "fsType(ntfs) is not supported for vhd disk, supported fsType list: %v", supportedDiskFsTypeList),
+			},
+		},
+		{
+			desc: "[Error] FormatAndMount with fsType of vhd disk in volume capability",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"},
+					},
+				},
+				VolumeContext: volContextEmptyFsType,
+				Secrets:       secrets},
+			execScripts: []ExecArgs{
+				{"blkid", []string{"-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", testDiskPath}, "", &testingexec.FakeExitError{Status: 2}},
+				{"mkfs.xfs", []string{"-f", testDiskPath}, "", fmt.Errorf("formatting failed")},
+			},
+			skipOnDarwin: true,
+			flakyWindowsErrorMessage: fmt.Sprintf("volume(vol_1##) mount %s on %v failed with "+
+				"smb mapping failed with error: rpc error: code = Unknown desc = NewSmbGlobalMapping failed.",
+				errorSource, proxyMountPath),
+			expectedErr: testutil.TestError{
+				DefaultError: status.Errorf(codes.Internal, "could not format %v and mount it at %v", sourceTest, testDiskPath),
+			},
+		},
 		{
 			desc: "[Success] Valid request",
 			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
@@ -752,6 +1064,134 @@ func TestNodeStageVolume(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestNodeStageVolumeMaxVolumesPerNode(t *testing.T) {
+	stdVolCap := csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+	sourceTest := testutil.GetWorkDirPath("source_test", t)
+	volContext := map[string]string{
+		shareNameField:  "test_sharename",
+		serverNameField: "test_servername",
+	}
+
+	tests := []struct {
+		desc          string
+		stagedVolumes []string
+		expectedErr   error
+	}{
+		{
+			desc:          "[Error] max volumes per node reached",
+			stagedVolumes: []string{"vol_2"},
+			expectedErr:   status.Errorf(codes.ResourceExhausted, "number of staged volumes(1) on node(%s) reached max volumes per node(1), could not stage volume(vol_1)", fakeNodeID),
+		},
+		{
+			desc:          "[Success] volume already staged is not counted as a new volume",
+			stagedVolumes: []string{"vol_1"},
+			expectedErr:   status.Errorf(codes.Internal, "accountName() or accountKey is empty"),
+		},
+		{
+			desc:        "[Success] max volumes per node not reached",
+			expectedErr: status.Errorf(codes.Internal, "accountName() or accountKey is empty"),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, MaxVolumesPerNode: 1})
+		for _, volumeID := range test.stagedVolumes {
+			d.stagedVolumes.Store(volumeID, &stagedVolume{VolumeID: volumeID, StagingPath: sourceTest})
+		}
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+			VolumeCapability: &stdVolCap,
+			VolumeContext:    volContext,
+		}
+		_, err := d.NodeStageVolume(context.Background(), &req)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test case: %s, Unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+	}
+}
+
+func TestFormatAndMountDisk(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip format test on non-linux platform")
+	}
+	diskPath := "/tmp/proxy-mount/disk.vhd"
+	targetPath := "/tmp/target"
+
+	tests := []struct {
+		desc        string
+		fsType      string
+		execScripts []ExecArgs
+		expectedErr bool
+	}{
+		{
+			desc:   "unformatted disk is formatted with ext4",
+			fsType: "ext4",
+			execScripts: []ExecArgs{
+				{"blkid", []string{"-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath}, "", &testingexec.FakeExitError{Status: 2}},
+				{"mkfs.ext4", []string{"-F", "-m0", diskPath}, "", nil},
+			},
+		},
+		{
+			desc:   "unformatted disk is formatted with xfs",
+			fsType: "xfs",
+			execScripts: []ExecArgs{
+				{"blkid", []string{"-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath}, "", &testingexec.FakeExitError{Status: 2}},
+				{"mkfs.xfs", []string{"-f", diskPath}, "", nil},
+			},
+		},
+		{
+			desc:   "formatted disk is not formatted again",
+			fsType: "ext4",
+			execScripts: []ExecArgs{
+				{"blkid", []string{"-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath}, "DEVNAME=" + diskPath + "\nTYPE=ext4", nil},
+				{"fsck", []string{"-a", diskPath}, "", nil},
+			},
+		},
+		{
+			desc:   "disk formatted with different fsType is not formatted again",
+			fsType: "xfs",
+			execScripts: []ExecArgs{
+				{"blkid", []string{"-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath}, "DEVNAME=" + diskPath + "\nTYPE=ext4", nil},
+				{"fsck", []string{"-a", diskPath}, "", nil},
+			},
+		},
+		{
+			desc:   "format failure",
+			fsType: "ext4",
+			execScripts: []ExecArgs{
+				{"blkid", []string{"-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath}, "", &testingexec.FakeExitError{Status: 2}},
+				{"mkfs.ext4", []string{"-F", "-m0", diskPath}, "", fmt.Errorf("formatting failed")},
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("failed to get fake mounter: %v", err))
+		}
+		fakeExec := &testingexec.FakeExec{ExactOrder: true}
+		for _, script := range test.execScripts {
+			fakeCmd := &testingexec.FakeCmd{}
+			cmdAction := makeFakeCmd(fakeCmd, script.command, script.args...)
+			outputAction := makeFakeOutput(script.output, script.err)
+			fakeCmd.CombinedOutputScript = append(fakeCmd.CombinedOutputScript, outputAction)
+			fakeExec.CommandScript = append(fakeExec.CommandScript, cmdAction)
+		}
+		mounter.Exec = fakeExec
+		d.mounter = mounter
+
+		err = d.formatAndMountDisk(diskPath, targetPath, test.fsType, nil)
+		assert.Equal(t, test.expectedErr, err != nil, test.desc)
+		assert.Equal(t, len(test.execScripts), fakeExec.CommandCalls, test.desc)
+	}
+}
+
 func TestNodeUnstageVolume(t *testing.T) {
 	var (
 		errorTarget = testutil.GetWorkDirPath("error_is_likely_target", t)
@@ -884,11 +1324,74 @@ func TestNodeGetVolumeStats(t *testing.T) {
 		}
 	}
 
-	// Clean up
-	err := os.RemoveAll(fakePath)
+	// volume condition is only reported if volume full threshold is set
+	resp, err := d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumePath: fakePath, VolumeId: "vol_1"})
+	assert.NoError(t, err)
+	assert.Nil(t, resp.VolumeCondition)
+	d.volumeFullThresholdPercent = 100
+	resp, err = d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumePath: fakePath, VolumeId: "vol_1"})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp.VolumeCondition)
+
+	// Clean up
+	err = os.RemoveAll(fakePath)
 	assert.NoError(t, err)
 }
 
+func TestGetVolumeCondition(t *testing.T) {
+	tests := []struct {
+		desc              string
+		used              int64
+		capacity          int64
+		thresholdPercent  int
+		expectedCondition *csi.VolumeCondition
+	}{
+		{
+			desc:     "threshold is disabled",
+			used:     100,
+			capacity: 100,
+		},
+		{
+			desc:             "capacity is unknown",
+			used:             100,
+			thresholdPercent: 90,
+		},
+		{
+			desc:              "usage is below threshold",
+			used:              85,
+			capacity:          100,
+			thresholdPercent:  90,
+			expectedCondition: &csi.VolumeCondition{Message: "volume usage(85.0%) is below threshold(90%)"},
+		},
+		{
+			desc:             "usage reaches threshold",
+			used:             90,
+			capacity:         100,
+			thresholdPercent: 90,
+			expectedCondition: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  "volume usage(90.0%) reaches threshold(90%): 90 of 100 bytes are used, expand the volume or remove data before writes fail",
+			},
+		},
+		{
+			desc:             "usage exceeds threshold",
+			used:             999,
+			capacity:         1000,
+			thresholdPercent: 90,
+			expectedCondition: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  "volume usage(99.9%) reaches threshold(90%): 999 of 1000 bytes are used, expand the volume or remove data before writes fail",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		condition := getVolumeCondition(test.used, test.capacity, test.thresholdPercent)
+		assert.Equal(t, test.expectedCondition, condition, test.desc)
+	}
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, VolumeFullThresholdPercent: 101}))
+}
+
 func TestEnsureMountPoint(t *testing.T) {
 	errorTarget := "./error_is_likely_target"
 	alreadyExistTarget := "./false_is_likely_exist_target"
@@ -1066,3 +1569,1102 @@ func makeFakeOutput(output string, err error) testingexec.FakeAction {
 		return []byte(o), nil, err
 	}
 }
+
+func TestGetLowerSMBVersionMountOptions(t *testing.T) {
+	tests := []struct {
+		desc                 string
+		mountOptions         []string
+		expectedMountOptions []string
+		expectedCurrent      string
+		expectedLower        string
+	}{
+		{
+			desc:                 "vers is not set",
+			mountOptions:         []string{"dir_mode=0777", "file_mode=0777"},
+			expectedMountOptions: []string{"dir_mode=0777", "file_mode=0777", "vers=3.0"},
+			expectedCurrent:      "3.1.1",
+			expectedLower:        "3.0",
+		},
+		{
+			desc:                 "vers=3.1.1",
+			mountOptions:         []string{"vers=3.1.1,dir_mode=0777", "actimeo=30"},
+			expectedMountOptions: []string{"dir_mode=0777", "actimeo=30", "vers=3.0"},
+			expectedCurrent:      "3.1.1",
+			expectedLower:        "3.0",
+		},
+		{
+			desc:                 "vers=3.0",
+			mountOptions:         []string{"vers=3.0", "actimeo=30"},
+			expectedMountOptions: []string{"actimeo=30", "vers=2.1"},
+			expectedCurrent:      "3.0",
+			expectedLower:        "2.1",
+		},
+		{
+			desc:                 "lowest version",
+			mountOptions:         []string{"actimeo=30", "vers=2.1"},
+			expectedMountOptions: []string{"actimeo=30", "vers=2.1"},
+			expectedCurrent:      "2.1",
+			expectedLower:        "",
+		},
+		{
+			desc:                 "version not in fallback list",
+			mountOptions:         []string{"vers=3.02"},
+			expectedMountOptions: []string{"vers=3.02"},
+			expectedCurrent:      "3.02",
+			expectedLower:        "",
+		},
+	}
+
+	for _, test := range tests {
+		mountOptions, current, lower := getLowerSMBVersionMountOptions(test.mountOptions)
+		assert.Equal(t, test.expectedMountOptions, mountOptions, test.desc)
+		assert.Equal(t, test.expectedCurrent, current, test.desc)
+		assert.Equal(t, test.expectedLower, lower, test.desc)
+	}
+}
+
+// smbVersionMounter fails SMB mount with mountErr unless vers is set as supportedVersion
+type smbVersionMounter struct {
+	mount.FakeMounter
+	supportedVersion string
+	mountErr         error
+	mountOptions     [][]string
+}
+
+func (m *smbVersionMounter) MountSensitive(source, target, fstype string, options, sensitiveOptions []string) error {
+	m.mountOptions = append(m.mountOptions, options)
+	for _, option := range options {
+		if option == "vers="+m.supportedVersion {
+			return nil
+		}
+	}
+	return m.mountErr
+}
+
+func TestSMBMountWithVersionFallback(t *testing.T) {
+	skipIfTestingOnWindows(t)
+	negotiationErr := fmt.Errorf("mount error(95): Operation not supported")
+	authErr := fmt.Errorf("mount error(13): Permission denied")
+
+	tests := []struct {
+		desc                 string
+		enableFallback       bool
+		fsType               string
+		supportedVersion     string
+		mountErr             error
+		expectedErr          error
+		expectedMountOptions [][]string
+	}{
+		{
+			desc:                 "fallback is disabled",
+			fsType:               cifs,
+			supportedVersion:     "3.0",
+			mountErr:             negotiationErr,
+			expectedErr:          negotiationErr,
+			expectedMountOptions: [][]string{{"actimeo=30"}},
+		},
+		{
+			desc:                 "downgrade to 3.0",
+			enableFallback:       true,
+			fsType:               cifs,
+			supportedVersion:     "3.0",
+			mountErr:             negotiationErr,
+			expectedMountOptions: [][]string{{"actimeo=30"}, {"actimeo=30", "vers=3.0"}},
+		},
+		{
+			desc:                 "downgrade to 2.1",
+			enableFallback:       true,
+			fsType:               cifs,
+			supportedVersion:     "2.1",
+			mountErr:             negotiationErr,
+			expectedMountOptions: [][]string{{"actimeo=30"}, {"actimeo=30", "vers=3.0"}, {"actimeo=30", "vers=2.1"}},
+		},
+		{
+			desc:                 "no lower version",
+			enableFallback:       true,
+			fsType:               cifs,
+			supportedVersion:     "2.0",
+			mountErr:             negotiationErr,
+			expectedErr:          negotiationErr,
+			expectedMountOptions: [][]string{{"actimeo=30"}, {"actimeo=30", "vers=3.0"}, {"actimeo=30", "vers=2.1"}},
+		},
+		{
+			desc:                 "never downgrade on auth error",
+			enableFallback:       true,
+			fsType:               cifs,
+			supportedVersion:     "3.0",
+			mountErr:             authErr,
+			expectedErr:          authErr,
+			expectedMountOptions: [][]string{{"actimeo=30"}},
+		},
+		{
+			desc:                 "never downgrade for nfs",
+			enableFallback:       true,
+			fsType:               nfs,
+			supportedVersion:     "3.0",
+			mountErr:             negotiationErr,
+			expectedErr:          negotiationErr,
+			expectedMountOptions: [][]string{{"actimeo=30"}},
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.enableSMBVersionFallback = test.enableFallback
+		m := &smbVersionMounter{supportedVersion: test.supportedVersion, mountErr: test.mountErr}
+		d.mounter = &mount.SafeFormatAndMount{Interface: m}
+
+		mountOptions, err := d.smbMount(context.Background(), "//server/share", "target", test.fsType, []string{"actimeo=30"}, []string{"username=user,password=key"})
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedMountOptions, m.mountOptions, test.desc)
+		assert.Equal(t, test.expectedMountOptions[len(test.expectedMountOptions)-1], mountOptions, test.desc)
+	}
+}
+
+func TestIsShareFull(t *testing.T) {
+	tests := []struct {
+		usageBytes int64
+		quotaGiB   int32
+		expected   bool
+	}{
+		{usageBytes: 0, quotaGiB: 100, expected: false},
+		{usageBytes: 100*1024*1024*1024 - 1, quotaGiB: 100, expected: false},
+		{usageBytes: 100 * 1024 * 1024 * 1024, quotaGiB: 100, expected: true},
+		{usageBytes: 101 * 1024 * 1024 * 1024, quotaGiB: 100, expected: true},
+		{usageBytes: 1024, quotaGiB: 0, expected: false},
+	}
+
+	for _, test := range tests {
+		result := isShareFull(test.usageBytes, test.quotaGiB)
+		assert.Equal(t, test.expected, result, "usageBytes: %d, quotaGiB: %d", test.usageBytes, test.quotaGiB)
+	}
+}
+
+func TestCheckShareCapacity(t *testing.T) {
+	fullShare := storage.FileShare{
+		FileShareProperties: &storage.FileShareProperties{
+			ShareQuota:      pointer.Int32(1),
+			ShareUsageBytes: pointer.Int64(1024 * 1024 * 1024),
+		},
+	}
+	tests := []struct {
+		desc            string
+		failOnFullShare bool
+		fileShare       storage.FileShare
+		getErr          error
+		expectedErr     error
+	}{
+		{
+			desc:      "share is not full",
+			fileShare: storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(1), ShareUsageBytes: pointer.Int64(1024)}},
+		},
+		{
+			desc:      "share is full without failOnFullShare",
+			fileShare: fullShare,
+		},
+		{
+			desc:            "share is full with failOnFullShare",
+			failOnFullShare: true,
+			fileShare:       fullShare,
+			expectedErr:     status.Error(codes.ResourceExhausted, "file share(share) under account(account) is full: usage(1073741824 bytes) reached quota(1 GiB), writes on the volume would fail until the volume is expanded or data is removed"),
+		},
+		{
+			desc:            "usage is not returned",
+			failOnFullShare: true,
+			fileShare:       storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(1)}},
+		},
+		{
+			desc:            "GetFileShare returns error",
+			failOnFullShare: true,
+			getErr:          fmt.Errorf("test error"),
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.failOnFullShare = test.failOnFullShare
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		d.cloud.ResourceGroup = "rg"
+		mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).Times(1)
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(test.fileShare, test.getErr).Times(1)
+
+		err := d.checkShareCapacity(context.Background(), "", "", "account", "share")
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+func TestGetAccountFailoverServer(t *testing.T) {
+	server := "account.file.core.windows.net"
+	tests := []struct {
+		desc           string
+		account        storage.Account
+		expectedServer string
+		expectedErr    error
+	}{
+		{
+			desc: "primary endpoint is not changed",
+			account: storage.Account{Name: pointer.String("account"), AccountProperties: &storage.AccountProperties{
+				PrimaryEndpoints: &storage.Endpoints{File: pointer.String("https://account.file.core.windows.net/")},
+				StatusOfPrimary:  storage.AccountStatusAvailable,
+			}},
+		},
+		{
+			desc: "primary endpoint is not changed in different case",
+			account: storage.Account{Name: pointer.String("account"), AccountProperties: &storage.AccountProperties{
+				PrimaryEndpoints: &storage.Endpoints{File: pointer.String("https://Account.File.Core.Windows.Net/")},
+			}},
+		},
+		{
+			desc: "primary endpoint is changed after failover",
+			account: storage.Account{Name: pointer.String("account"), AccountProperties: &storage.AccountProperties{
+				PrimaryEndpoints: &storage.Endpoints{File: pointer.String("https://account-secondary.file.core.windows.net/")},
+				StatusOfPrimary:  storage.AccountStatusAvailable,
+			}},
+			expectedServer: "account-secondary.file.core.windows.net",
+		},
+		{
+			desc: "failover is in progress",
+			account: storage.Account{Name: pointer.String("account"), AccountProperties: &storage.AccountProperties{
+				PrimaryEndpoints:   &storage.Endpoints{File: pointer.String("https://account-secondary.file.core.windows.net/")},
+				FailoverInProgress: pointer.Bool(true),
+			}},
+			expectedErr: fmt.Errorf("failover of account(account) is in progress"),
+		},
+		{
+			desc: "primary location is unavailable",
+			account: storage.Account{Name: pointer.String("account"), AccountProperties: &storage.AccountProperties{
+				PrimaryEndpoints: &storage.Endpoints{File: pointer.String("https://account.file.core.windows.net/")},
+				PrimaryLocation:  pointer.String("eastus"),
+				StatusOfPrimary:  storage.AccountStatusUnavailable,
+			}},
+			expectedErr: fmt.Errorf("primary location(eastus) of account(account) is unavailable"),
+		},
+		{
+			desc:        "account properties are not returned",
+			account:     storage.Account{Name: pointer.String("account")},
+			expectedErr: fmt.Errorf("properties of account(account) are not returned"),
+		},
+		{
+			desc:        "primary file endpoint is not returned",
+			account:     storage.Account{Name: pointer.String("account"), AccountProperties: &storage.AccountProperties{PrimaryEndpoints: &storage.Endpoints{}}},
+			expectedErr: fmt.Errorf("primary file endpoint of account(account) is not returned"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := getAccountFailoverServer(test.account, server)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedServer, result, test.desc)
+	}
+}
+
+func TestReplaceSourceServer(t *testing.T) {
+	sep := string(os.PathSeparator)
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{
+			source:   sep + sep + "server" + sep + "share",
+			expected: sep + sep + "newserver" + sep + "share",
+		},
+		{
+			source:   sep + sep + "server" + sep + "share" + sep + "folder",
+			expected: sep + sep + "newserver" + sep + "share" + sep + "folder",
+		},
+		{
+			source:   sep + sep + "other" + sep + "share",
+			expected: sep + sep + "other" + sep + "share",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, replaceSourceServer(test.source, "server", "newserver"), test.source)
+	}
+}
+
+func TestRemountIfReadOnlyChanged(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	target := "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount"
+	tests := []struct {
+		desc         string
+		mountPoints  []mount.MountPoint
+		readOnly     bool
+		remountErr   error
+		expectedArgs []string
+		expectedErr  error
+	}{
+		{
+			desc:        "target is not in mount list",
+			mountPoints: []mount.MountPoint{{Path: "/other", Opts: []string{"ro"}}},
+			readOnly:    false,
+		},
+		{
+			desc:        "read-only state is not changed",
+			mountPoints: []mount.MountPoint{{Path: target, Opts: []string{"ro", "relatime"}}},
+			readOnly:    true,
+		},
+		{
+			desc:         "remount read-only mount as read-write",
+			mountPoints:  []mount.MountPoint{{Path: target, Opts: []string{"ro", "relatime"}}},
+			readOnly:     false,
+			expectedArgs: []string{"-o", "remount,bind,rw", target},
+		},
+		{
+			desc:         "remount read-write mount as read-only",
+			mountPoints:  []mount.MountPoint{{Path: target + "/", Opts: []string{"rw", "relatime"}}},
+			readOnly:     true,
+			expectedArgs: []string{"-o", "remount,bind,ro", target},
+		},
+		{
+			desc: "only the top mount on target is checked",
+			mountPoints: []mount.MountPoint{
+				{Path: target, Opts: []string{"rw"}},
+				{Path: target, Opts: []string{"ro"}},
+			},
+			readOnly: true,
+		},
+		{
+			desc:         "remount failure",
+			mountPoints:  []mount.MountPoint{{Path: target, Opts: []string{"rw"}}},
+			readOnly:     true,
+			remountErr:   fmt.Errorf("permission denied"),
+			expectedArgs: []string{"-o", "remount,bind,ro", target},
+			expectedErr:  fmt.Errorf("remount %s with ro failed with permission denied, output: ", target),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		var args []string
+		fakeExec := &testingexec.FakeExec{}
+		fakeExec.CommandScript = []testingexec.FakeCommandAction{
+			func(cmd string, a ...string) exec.Cmd {
+				args = a
+				fakeCmd := &testingexec.FakeCmd{CombinedOutputScript: []testingexec.FakeAction{makeFakeOutput("", test.remountErr)}}
+				return testingexec.InitFakeCmd(fakeCmd, cmd, a...)
+			},
+		}
+		d.mounter = &mount.SafeFormatAndMount{Interface: &fakeMounter{mount.FakeMounter{MountPoints: test.mountPoints}}, Exec: fakeExec}
+
+		err := d.remountIfReadOnlyChanged(target, test.readOnly)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedArgs, args, test.desc)
+	}
+}
+
+func TestCheckStagingMount(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	source := "//account.file.core.windows.net/share"
+	target := "/var/lib/kubelet/plugins/kubernetes.io/csi/file.csi.azure.com/globalmount"
+	tests := []struct {
+		desc         string
+		mountPoints  []mount.MountPoint
+		mountOptions []string
+		expectedErr  error
+	}{
+		{
+			desc:        "target is not in mount list",
+			mountPoints: []mount.MountPoint{{Device: "//other/share", Path: "/other"}},
+		},
+		{
+			desc:         "existing mount of the same source",
+			mountPoints:  []mount.MountPoint{{Device: "//ACCOUNT.file.core.windows.net/share/", Path: target + "/", Opts: []string{"rw", "relatime"}}},
+			mountOptions: []string{"dir_mode=0777,file_mode=0777", "mfsymlinks"},
+		},
+		{
+			desc:        "existing mount of a different source",
+			mountPoints: []mount.MountPoint{{Device: "//account.file.core.windows.net/othershare", Path: target, Opts: []string{"rw"}}},
+			expectedErr: fmt.Errorf("source of existing mount is //account.file.core.windows.net/othershare, expected %s", source),
+		},
+		{
+			desc:         "existing mount of the same source with different read-only state",
+			mountPoints:  []mount.MountPoint{{Device: source, Path: target, Opts: []string{"rw"}}},
+			mountOptions: []string{"ro,mfsymlinks"},
+			expectedErr:  fmt.Errorf("read-only state(false) of existing mount is different from expected(true)"),
+		},
+		{
+			desc: "only the top mount on target is checked",
+			mountPoints: []mount.MountPoint{
+				{Device: "//account.file.core.windows.net/othershare", Path: target},
+				{Device: source, Path: target},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.mounter = &mount.SafeFormatAndMount{Interface: &fakeMounter{mount.FakeMounter{MountPoints: test.mountPoints}}}
+
+		err := d.checkStagingMount(source, target, test.mountOptions)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+func TestNodeStageVolumeWithExistingMount(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip existing mount test on non-linux platform")
+	}
+	stagingTarget := testutil.GetWorkDirPath("false_is_likely_staging", t)
+	assert.NoError(t, os.MkdirAll(stagingTarget, 0750))
+	defer os.RemoveAll(stagingTarget)
+
+	req := csi.NodeStageVolumeRequest{
+		VolumeId:          "vol_1",
+		StagingTargetPath: stagingTarget,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+		},
+		VolumeContext: map[string]string{
+			shareNameField:  "test_sharename",
+			serverNameField: "test_servername",
+		},
+		Secrets: map[string]string{
+			"accountname": "k8s",
+			"accountkey":  "testkey",
+		},
+	}
+
+	tests := []struct {
+		desc                        string
+		device                      string
+		allowMismatchedStagingMount bool
+		expectedErr                 error
+	}{
+		{
+			desc:   "[Success] existing mount of the same source",
+			device: "//test_servername/test_sharename",
+		},
+		{
+			desc:        "[Error] existing mount of a different source",
+			device:      "//test_servername/other_sharename",
+			expectedErr: status.Errorf(codes.AlreadyExists, "volume(vol_1) is incompatible with existing mount on %s: source of existing mount is //test_servername/other_sharename, expected //test_servername/test_sharename", stagingTarget),
+		},
+		{
+			desc:                        "[Success] existing mount of a different source is allowed",
+			device:                      "//test_servername/other_sharename",
+			allowMismatchedStagingMount: true,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, AllowMismatchedStagingMount: test.allowMismatchedStagingMount})
+		d.mounter = &mount.SafeFormatAndMount{Interface: &fakeMounter{mount.FakeMounter{MountPoints: []mount.MountPoint{
+			{Device: test.device, Path: stagingTarget, Type: cifs, Opts: []string{"rw"}},
+		}}}}
+
+		_, err := d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+func TestNodeStageVolumeWithAccountKeyAuthDisabled(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip kerberos mount test on non-linux platform")
+	}
+	stagingTarget := testutil.GetWorkDirPath("disable_key_auth_staging", t)
+	defer os.RemoveAll(stagingTarget)
+
+	tests := []struct {
+		desc        string
+		mountFlags  []string
+		volContext  map[string]string
+		expectedErr error
+	}{
+		{
+			desc:        "[Error] smb mount without kerberos auth",
+			mountFlags:  []string{"dir_mode=0777"},
+			volContext:  map[string]string{disableAccountKeyAuthField: "true"},
+			expectedErr: status.Error(codes.InvalidArgument, "account key auth is disabled by disableaccountkeyauth while no identity based auth is available, use nfs protocol or sec=krb5 mount option with smb protocol"),
+		},
+		{
+			desc:        "[Error] credential file contains account key",
+			mountFlags:  []string{"sec=krb5"},
+			volContext:  map[string]string{disableAccountKeyAuthField: "true", useCredentialFileField: "true"},
+			expectedErr: status.Error(codes.InvalidArgument, "disableaccountkeyauth is not supported with usecredentialfile since credential file contains account key"),
+		},
+		{
+			desc:       "[Success] smb mount with kerberos auth",
+			mountFlags: []string{"sec=krb5", "cruid=0"},
+			volContext: map[string]string{disableAccountKeyAuthField: "true"},
+		},
+		{
+			desc:       "[Success] nfs mount",
+			volContext: map[string]string{disableAccountKeyAuthField: "true", protocolField: nfs},
+		},
+		{
+			desc:        "[Error] smb mount with aad auth but without kerberos auth",
+			mountFlags:  []string{"dir_mode=0777"},
+			volContext:  map[string]string{dataPlaneAuthField: "aad"},
+			expectedErr: status.Error(codes.InvalidArgument, "account key auth is disabled by dataPlaneAuth(aad) while no identity based auth is available, use nfs protocol or sec=krb5 mount option with smb protocol"),
+		},
+		{
+			desc:       "[Success] smb mount with aad and kerberos auth",
+			mountFlags: []string{"sec=krb5"},
+			volContext: map[string]string{dataPlaneAuthField: "aad"},
+		},
+		{
+			desc:       "[Success] nfs mount with aad auth",
+			volContext: map[string]string{dataPlaneAuthField: "aad", protocolField: nfs},
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		mounter, err := NewFakeMounter()
+		assert.NoError(t, err)
+		d.mounter = mounter
+		req := csi.NodeStageVolumeRequest{
+			VolumeId:          "rg#accountname#sharename",
+			StagingTargetPath: stagingTarget,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+				},
+			},
+			VolumeContext: test.volContext,
+		}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+// slowExec runs a hanging command instead of mount so that it's killed only when context is done
+type slowExec struct {
+	exec.Interface
+	commands []string
+}
+
+func (e *slowExec) CommandContext(ctx context.Context, cmd string, args ...string) exec.Cmd {
+	e.commands = append(e.commands, cmd)
+	return exec.New().CommandContext(ctx, "sleep", "60")
+}
+
+func TestSMBMountWithContext(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip mount timeout test on non-linux platform")
+	}
+	e := &slowExec{}
+	m := &mount.SafeFormatAndMount{Interface: &fakeMounter{}, Exec: e}
+
+	// mount is run by mounter if ctx could not be done
+	err := SMBMount(context.Background(), m, "//server/share", "target", cifs, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, e.commands)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = SMBMount(ctx, m, "//server/share", "target", cifs, nil, nil)
+	assert.Equal(t, fmt.Errorf("mount is killed: context deadline exceeded"), err)
+	assert.Less(t, time.Since(start), 30*time.Second)
+	assert.Equal(t, []string{"mount"}, e.commands)
+}
+
+func TestNodeStageVolumeWithMountTimeout(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip mount timeout test on non-linux platform")
+	}
+	stagingTarget := testutil.GetWorkDirPath("mount_timeout_staging", t)
+	defer os.RemoveAll(stagingTarget)
+
+	tests := []struct {
+		desc         string
+		mountTimeout string
+		expectedErr  error
+	}{
+		{
+			desc:         "[Error] invalid mountTimeout",
+			mountTimeout: "-1s",
+			expectedErr:  status.Error(codes.InvalidArgument, "invalid mounttimeout: -1s, it should be positive"),
+		},
+		{
+			desc:         "[Error] mount does not complete within mountTimeout",
+			mountTimeout: "100ms",
+			expectedErr:  status.Errorf(codes.DeadlineExceeded, "volume(vol_1) mount //test_servername/test_sharename on %s: mount on %s did not complete within 100ms: mount is killed: context deadline exceeded", stagingTarget, stagingTarget),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.mounter = &mount.SafeFormatAndMount{Interface: &fakeMounter{}, Exec: &slowExec{}}
+		req := csi.NodeStageVolumeRequest{
+			VolumeId:          "vol_1",
+			StagingTargetPath: stagingTarget,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeContext: map[string]string{
+				shareNameField:    "test_sharename",
+				serverNameField:   "test_servername",
+				mountTimeoutField: test.mountTimeout,
+			},
+			Secrets: map[string]string{
+				"accountname": "k8s",
+				"accountkey":  "testkey",
+			},
+		}
+		start := time.Now()
+		_, err := d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Less(t, time.Since(start), 30*time.Second, test.desc)
+	}
+}
+
+// flakyMounter fails MountSensitive with mountErr for the first failures calls
+type flakyMounter struct {
+	fakeMounter
+	mountErr error
+	failures int
+	calls    int
+	sources  []string
+}
+
+func (m *flakyMounter) MountSensitive(source string, target string, fstype string, options []string, sensitiveOptions []string) error {
+	m.calls++
+	m.sources = append(m.sources, source)
+	if m.calls <= m.failures {
+		return m.mountErr
+	}
+	return nil
+}
+
+func TestNodeStageVolumeWithMountRetries(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip mount retries test on non-linux platform")
+	}
+	stagingTarget := testutil.GetWorkDirPath("mount_retries_staging", t)
+	defer os.RemoveAll(stagingTarget)
+
+	transientErr := fmt.Errorf("mount error(112): Host is down")
+	authErr := fmt.Errorf("mount error(13): Permission denied")
+	tests := []struct {
+		desc               string
+		nodeMountRetries   int
+		provisionRetries   int
+		mountErr           error
+		failures           int
+		expectedMountCalls int
+		expectedErr        error
+	}{
+		{
+			desc:               "[Error] mount is tried once by default",
+			mountErr:           transientErr,
+			failures:           1,
+			expectedMountCalls: 1,
+			expectedErr:        transientErr,
+		},
+		{
+			desc:               "[Error] provision retries are not used in mount",
+			provisionRetries:   3,
+			mountErr:           transientErr,
+			failures:           1,
+			expectedMountCalls: 1,
+			expectedErr:        transientErr,
+		},
+		{
+			desc:               "[Success] mount succeeds after retries",
+			nodeMountRetries:   2,
+			mountErr:           transientErr,
+			failures:           2,
+			expectedMountCalls: 3,
+		},
+		{
+			desc:               "[Error] mount retries are exhausted",
+			nodeMountRetries:   2,
+			mountErr:           transientErr,
+			failures:           3,
+			expectedMountCalls: 3,
+			expectedErr:        transientErr,
+		},
+		{
+			desc:               "[Error] authentication error is not retried",
+			nodeMountRetries:   2,
+			mountErr:           authErr,
+			failures:           1,
+			expectedMountCalls: 1,
+			expectedErr:        authErr,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{
+			NodeID:                 fakeNodeID,
+			DriverName:             DefaultDriverName,
+			NodeMountRetries:       test.nodeMountRetries,
+			NodeMountRetryInterval: time.Millisecond,
+			ProvisionRetries:       test.provisionRetries,
+			ProvisionRetryInterval: time.Millisecond,
+		})
+		m := &flakyMounter{mountErr: test.mountErr, failures: test.failures}
+		d.mounter = &mount.SafeFormatAndMount{Interface: m}
+		req := csi.NodeStageVolumeRequest{
+			VolumeId:          "vol_1",
+			StagingTargetPath: stagingTarget,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeContext: map[string]string{
+				shareNameField:  "test_sharename",
+				serverNameField: "test_servername",
+			},
+			Secrets: map[string]string{
+				"accountname": "k8s",
+				"accountkey":  "testkey",
+			},
+		}
+		_, err := d.NodeStageVolume(context.Background(), &req)
+		if test.expectedErr == nil {
+			assert.NoError(t, err, test.desc)
+		} else {
+			expectedErr := status.Error(codes.Internal, fmt.Sprintf("volume(vol_1) mount //test_servername/test_sharename on %s failed with %v", stagingTarget, test.expectedErr))
+			assert.Equal(t, expectedErr, err, test.desc)
+		}
+		assert.Equal(t, test.expectedMountCalls, m.calls, test.desc)
+	}
+}
+
+func TestNodeStageVolumeRecordsMountOptions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip smb mount options test on non-linux platform")
+	}
+	stagingTarget := testutil.GetWorkDirPath("mount_options_staging", t)
+	defer os.RemoveAll(stagingTarget)
+
+	d := NewFakeDriver()
+	mounter, err := NewFakeMounter()
+	assert.NoError(t, err)
+	d.mounter = mounter
+	req := csi.NodeStageVolumeRequest{
+		VolumeId:          "vol_1",
+		StagingTargetPath: stagingTarget,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"dir_mode=0777", "password=flagpassword"}},
+			},
+		},
+		VolumeContext: map[string]string{
+			shareNameField:  "test_sharename",
+			serverNameField: "test_servername",
+		},
+		Secrets: map[string]string{
+			"accountname": "k8s",
+			"accountkey":  "testkey",
+		},
+	}
+	_, err = d.NodeStageVolume(context.Background(), &req)
+	assert.NoError(t, err)
+
+	v, ok := d.stagedVolumes.Load("vol_1")
+	assert.True(t, ok)
+	volume := v.(*stagedVolume)
+	assert.Equal(t, "vol_1", volume.VolumeID)
+	assert.Equal(t, stagingTarget, volume.StagingPath)
+	assert.Contains(t, volume.MountOptions, "dir_mode=0777")
+	assert.Contains(t, volume.MountOptions, "password=***")
+	assert.Contains(t, volume.MountOptions, "username=***,password=***")
+	assert.NotContains(t, strings.Join(volume.MountOptions, ","), "testkey")
+	assert.NotContains(t, strings.Join(volume.MountOptions, ","), "flagpassword")
+
+	_, err = d.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: stagingTarget})
+	assert.NoError(t, err)
+	_, ok = d.stagedVolumes.Load("vol_1")
+	assert.False(t, ok)
+}
+
+// busyMounter fails Unmount with target busy error for the first busyUnmounts calls, target is a mount point until it's unmounted
+type busyMounter struct {
+	fakeMounter
+	busyUnmounts int
+	unmounts     int
+	mounted      bool
+}
+
+func (m *busyMounter) Unmount(target string) error {
+	m.unmounts++
+	if m.unmounts <= m.busyUnmounts {
+		return fmt.Errorf("umount: %s: target is busy", target)
+	}
+	m.mounted = false
+	return nil
+}
+
+func (m *busyMounter) IsLikelyNotMountPoint(file string) (bool, error) {
+	return !m.mounted, nil
+}
+
+func (m *busyMounter) IsMountPoint(file string) (bool, error) {
+	return m.mounted, nil
+}
+
+func TestNodeUnpublishVolumeWithLazyUnmount(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip lazy unmount test on non-linux platform")
+	}
+	target := testutil.GetWorkDirPath("lazy_unmount_target", t)
+	defer os.RemoveAll(target)
+
+	tests := []struct {
+		desc                 string
+		lazyUnmountOnBusy    bool
+		busyUnmounts         int
+		lazyUnmountErr       error
+		expectedUnmounts     int
+		expectedLazyUnmounts int
+		expectedErr          error
+	}{
+		{
+			desc:             "unmount busy target fails if lazy unmount is disabled",
+			busyUnmounts:     5,
+			expectedUnmounts: 1,
+			expectedErr:      status.Errorf(codes.Internal, "failed to unmount target %s: umount: %s: target is busy", target, target),
+		},
+		{
+			desc:              "target is not busy after retries",
+			lazyUnmountOnBusy: true,
+			busyUnmounts:      2,
+			expectedUnmounts:  3,
+		},
+		{
+			desc:                 "target is lazily unmounted if it's still busy after retries",
+			lazyUnmountOnBusy:    true,
+			busyUnmounts:         5,
+			expectedUnmounts:     3,
+			expectedLazyUnmounts: 1,
+		},
+		{
+			desc:                 "lazy unmount fails",
+			lazyUnmountOnBusy:    true,
+			busyUnmounts:         5,
+			lazyUnmountErr:       fmt.Errorf("invalid argument"),
+			expectedUnmounts:     3,
+			expectedLazyUnmounts: 1,
+			expectedErr:          status.Errorf(codes.Internal, "failed to unmount target %s: lazy unmount %s failed with invalid argument, unmount error: umount: %s: target is busy", target, target, target),
+		},
+	}
+
+	for _, test := range tests {
+		assert.NoError(t, os.MkdirAll(target, 0755), test.desc)
+		d := NewFakeDriver()
+		d.lazyUnmountOnBusy = test.lazyUnmountOnBusy
+		d.busyUnmountBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 3}
+		m := &busyMounter{busyUnmounts: test.busyUnmounts, mounted: true}
+		d.mounter = &mount.SafeFormatAndMount{Interface: m}
+		lazyUnmounts := 0
+		d.lazyUnmounter = func(target string) error {
+			lazyUnmounts++
+			if test.lazyUnmountErr != nil {
+				return test.lazyUnmountErr
+			}
+			m.mounted = false
+			return nil
+		}
+
+		_, err := d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol_1", TargetPath: target})
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedUnmounts, m.unmounts, test.desc)
+		assert.Equal(t, test.expectedLazyUnmounts, lazyUnmounts, test.desc)
+		if test.expectedErr == nil {
+			_, statErr := os.Stat(target)
+			assert.True(t, os.IsNotExist(statErr), test.desc)
+		}
+	}
+}
+
+func TestNodeStageVolumeWithFailoverAwareness(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip failover awareness test on non-linux platform")
+	}
+	stagingTarget := testutil.GetWorkDirPath("failover_staging", t)
+	defer os.RemoveAll(stagingTarget)
+
+	transientErr := fmt.Errorf("mount error(112): Host is down")
+	failedOverAccount := storage.Account{Name: pointer.String("k8s"), AccountProperties: &storage.AccountProperties{
+		PrimaryEndpoints: &storage.Endpoints{File: pointer.String("https://k8s-secondary.file.core.windows.net/")},
+	}}
+	tests := []struct {
+		desc                    string
+		enableFailoverAwareness bool
+		server                  string
+		account                 storage.Account
+		getErr                  *retry.Error
+		expectGetProperties     bool
+		mountErr                error
+		failures                int
+		expectedSources         []string
+		expectedErr             error
+	}{
+		{
+			desc:            "[Error] failover is not checked if failover awareness is disabled",
+			mountErr:        transientErr,
+			failures:        1,
+			expectedSources: []string{"//k8s.file.core.windows.net/test_sharename"},
+			expectedErr:     transientErr,
+		},
+		{
+			desc:                    "[Success] mount is retried against new primary endpoint after failover",
+			enableFailoverAwareness: true,
+			account:                 failedOverAccount,
+			expectGetProperties:     true,
+			mountErr:                transientErr,
+			failures:                1,
+			expectedSources:         []string{"//k8s.file.core.windows.net/test_sharename", "//k8s-secondary.file.core.windows.net/test_sharename"},
+		},
+		{
+			desc:                    "[Error] mount is not retried if primary endpoint is not changed",
+			enableFailoverAwareness: true,
+			account: storage.Account{Name: pointer.String("k8s"), AccountProperties: &storage.AccountProperties{
+				PrimaryEndpoints: &storage.Endpoints{File: pointer.String("https://k8s.file.core.windows.net/")},
+			}},
+			expectGetProperties: true,
+			mountErr:            transientErr,
+			failures:            1,
+			expectedSources:     []string{"//k8s.file.core.windows.net/test_sharename"},
+			expectedErr:         transientErr,
+		},
+		{
+			desc:                    "[Error] mount is not retried if failover state could not be checked",
+			enableFailoverAwareness: true,
+			getErr:                  retry.NewError(false, fmt.Errorf("test error")),
+			expectGetProperties:     true,
+			mountErr:                transientErr,
+			failures:                1,
+			expectedSources:         []string{"//k8s.file.core.windows.net/test_sharename"},
+			expectedErr:             transientErr,
+		},
+		{
+			desc:                    "[Error] user provided server is not switched to new primary endpoint",
+			enableFailoverAwareness: true,
+			server:                  "k8s.privatelink.file.core.windows.net",
+			mountErr:                transientErr,
+			failures:                1,
+			expectedSources:         []string{"//k8s.privatelink.file.core.windows.net/test_sharename"},
+			expectedErr:             transientErr,
+		},
+		{
+			desc:                    "[Error] failover is not checked on authentication error",
+			enableFailoverAwareness: true,
+			mountErr:                fmt.Errorf("mount error(13): Permission denied"),
+			failures:                1,
+			expectedSources:         []string{"//k8s.file.core.windows.net/test_sharename"},
+			expectedErr:             fmt.Errorf("mount error(13): Permission denied"),
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{
+			NodeID:                  fakeNodeID,
+			DriverName:              DefaultDriverName,
+			EnableFailoverAwareness: test.enableFailoverAwareness,
+		})
+		d.cloud.ResourceGroup = "rg"
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud.StorageAccountClient = mockStorageAccountsClient
+		if test.expectGetProperties {
+			mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "k8s").Return(test.account, test.getErr).Times(1)
+		}
+		m := &flakyMounter{mountErr: test.mountErr, failures: test.failures}
+		d.mounter = &mount.SafeFormatAndMount{Interface: m}
+		req := csi.NodeStageVolumeRequest{
+			VolumeId:          "vol_1",
+			StagingTargetPath: stagingTarget,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeContext: map[string]string{
+				shareNameField: "test_sharename",
+			},
+			Secrets: map[string]string{
+				"accountname": "k8s",
+				"accountkey":  "testkey",
+			},
+		}
+		if test.server != "" {
+			req.VolumeContext[serverNameField] = test.server
+		}
+		_, err := d.NodeStageVolume(context.Background(), &req)
+		if test.expectedErr == nil {
+			assert.NoError(t, err, test.desc)
+		} else {
+			expectedErr := status.Error(codes.Internal, fmt.Sprintf("volume(vol_1) mount %s on %s failed with %v", test.expectedSources[len(test.expectedSources)-1], stagingTarget, test.expectedErr))
+			assert.Equal(t, expectedErr, err, test.desc)
+		}
+		assert.Equal(t, test.expectedSources, m.sources, test.desc)
+	}
+}
+
+func TestAcquireFormatSlot(t *testing.T) {
+	// format operations are not bounded by default
+	d := NewFakeDriver()
+	assert.Nil(t, d.formatSemaphore)
+	release, err := d.acquireFormatSlot(context.Background())
+	assert.NoError(t, err)
+	release()
+
+	d = NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MaxConcurrentFormat: 2})
+	assert.Equal(t, 2, d.maxConcurrentFormat)
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := d.acquireFormatSlot(context.Background())
+			assert.NoError(t, err)
+			current := atomic.AddInt32(&running, 1)
+			for {
+				prev := atomic.LoadInt32(&maxRunning)
+				if current <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			release()
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxRunning, int32(2))
+	assert.Equal(t, 0, len(d.formatSemaphore))
+
+	// waiting for format slot is stopped once context is done
+	release1, err := d.acquireFormatSlot(context.Background())
+	assert.NoError(t, err)
+	release2, err := d.acquireFormatSlot(context.Background())
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = d.acquireFormatSlot(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = d.acquireFormatSlot(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	release1()
+	release, err = d.acquireFormatSlot(context.Background())
+	assert.NoError(t, err)
+	release()
+	release2()
+	assert.Equal(t, 0, len(d.formatSemaphore))
+
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, MaxConcurrentFormat: -1}))
+}
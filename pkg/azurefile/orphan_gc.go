@@ -0,0 +1,226 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
+)
+
+const (
+	// pendingDeleteShareTagPrefix marks a file share (tag key suffix) in the storage account as pending delete,
+	// value is the RFC3339 time of marking
+	pendingDeleteShareTagPrefix = "k8s-azure-pending-delete-share-"
+	// driverCreatedByTagValue is the value of consts.CreatedByTag set on storage accounts created by driver
+	driverCreatedByTagValue = "azure"
+
+	defaultOrphanGCInterval  = time.Hour
+	defaultOrphanGCThreshold = 24 * time.Hour
+	maxOrphanGCRetryDelay    = 24 * time.Hour
+)
+
+// orphanGCRetryEntry records deletion failures of one orphaned resource
+type orphanGCRetryEntry struct {
+	failures    int
+	nextAttempt time.Time
+}
+
+// orphanGCRetryScheduler schedules deletion retries of orphaned resources with exponential backoff
+type orphanGCRetryScheduler struct {
+	sync.Mutex
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	entries   map[string]*orphanGCRetryEntry
+}
+
+func newOrphanGCRetryScheduler(baseDelay, maxDelay time.Duration) *orphanGCRetryScheduler {
+	return &orphanGCRetryScheduler{
+		baseDelay: baseDelay,
+		maxDelay:  maxDelay,
+		entries:   make(map[string]*orphanGCRetryEntry),
+	}
+}
+
+// shouldAttempt returns true if deletion of the resource could be attempted at now
+func (s *orphanGCRetryScheduler) shouldAttempt(key string, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	entry, ok := s.entries[key]
+	return !ok || !now.Before(entry.nextAttempt)
+}
+
+// recordFailure records a deletion failure and returns the time of next attempt,
+// retry delay doubles on every failure and is capped by maxDelay
+func (s *orphanGCRetryScheduler) recordFailure(key string, now time.Time) time.Time {
+	s.Lock()
+	defer s.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &orphanGCRetryEntry{}
+		s.entries[key] = entry
+	}
+	entry.failures++
+	delay := s.baseDelay
+	for i := 1; i < entry.failures && delay < s.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.maxDelay {
+		delay = s.maxDelay
+	}
+	entry.nextAttempt = now.Add(delay)
+	return entry.nextAttempt
+}
+
+// recordSuccess removes the retry schedule of the resource
+func (s *orphanGCRetryScheduler) recordSuccess(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.entries, key)
+}
+
+// isDriverOwnedAccount returns true if storage account is created by driver
+func isDriverOwnedAccount(tags map[string]*string) bool {
+	for k, v := range tags {
+		if strings.EqualFold(k, consts.CreatedByTag) && v != nil && strings.EqualFold(*v, driverCreatedByTagValue) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPendingDeleteExpired returns true if the pending delete tag value is older than threshold
+func isPendingDeleteExpired(value *string, now time.Time, threshold time.Duration) bool {
+	if value == nil {
+		return false
+	}
+	markTime, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		klog.Warningf("invalid pending delete time(%s): %v", *value, err)
+		return false
+	}
+	return now.Sub(markTime) >= threshold
+}
+
+// getOrphanedFileShares returns <file share name, tag key> of file shares marked as pending delete for longer than threshold,
+// nothing is returned if storage account is not created by driver
+func getOrphanedFileShares(tags map[string]*string, now time.Time, threshold time.Duration) map[string]string {
+	shares := make(map[string]string)
+	if !isDriverOwnedAccount(tags) {
+		return shares
+	}
+	for k, v := range tags {
+		if len(k) <= len(pendingDeleteShareTagPrefix) || !strings.EqualFold(k[:len(pendingDeleteShareTagPrefix)], pendingDeleteShareTagPrefix) {
+			continue
+		}
+		if isPendingDeleteExpired(v, now, threshold) {
+			shares[strings.ToLower(k[len(pendingDeleteShareTagPrefix):])] = k
+		}
+	}
+	return shares
+}
+
+// markFileSharePendingDelete tags storage account with the file share which could not be deleted,
+// original mark time is kept if file share is already marked.
+// only file shares in driver resource group are marked since garbage collection only scans driver resource group
+func (d *Driver) markFileSharePendingDelete(ctx context.Context, subsID, resourceGroup, accountName, shareName string) error {
+	if d.cloud.StorageAccountClient == nil {
+		return fmt.Errorf("StorageAccountClient is nil")
+	}
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+	if !strings.EqualFold(subsID, d.cloud.SubscriptionID) || !strings.EqualFold(resourceGroup, d.cloud.ResourceGroup) {
+		return fmt.Errorf("account(%s) subsID(%s) rg(%s) is not in driver resource group which is scanned by garbage collection", accountName, subsID, resourceGroup)
+	}
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return rerr.Error()
+	}
+	if !isDriverOwnedAccount(account.Tags) {
+		return fmt.Errorf("account(%s) is not created by driver", accountName)
+	}
+	key := pendingDeleteShareTagPrefix + shareName
+	if _, ok := account.Tags[key]; ok {
+		return nil
+	}
+	account.Tags[key] = pointer.String(time.Now().UTC().Format(time.RFC3339))
+	klog.V(2).Infof("mark file share(%s) on account(%s) subsID(%s) rg(%s) as pending delete", shareName, accountName, subsID, resourceGroup)
+	if rerr := d.cloud.StorageAccountClient.Update(ctx, subsID, resourceGroup, accountName, storage.AccountUpdateParameters{Tags: account.Tags}); rerr != nil {
+		return rerr.Error()
+	}
+	return nil
+}
+
+// runOrphanGC runs orphaned resource garbage collection periodically until stopCh is closed
+func (d *Driver) runOrphanGC(stopCh <-chan struct{}) {
+	klog.V(2).Infof("start orphaned resource garbage collection, interval: %v, threshold: %v", d.orphanGCInterval, d.orphanGCThreshold)
+	wait.Until(func() {
+		d.collectOrphanedResources(context.Background(), time.Now())
+	}, d.orphanGCInterval, stopCh)
+}
+
+// collectOrphanedResources deletes file shares in storage accounts created by driver in driver resource group
+// which are marked as pending delete for longer than threshold, storage accounts are never deleted
+func (d *Driver) collectOrphanedResources(ctx context.Context, now time.Time) {
+	if d.cloud == nil || d.cloud.StorageAccountClient == nil {
+		klog.Warningf("skip orphaned resource garbage collection since StorageAccountClient is nil")
+		return
+	}
+	subsID, resourceGroup := d.cloud.SubscriptionID, d.cloud.ResourceGroup
+	accounts, rerr := d.cloud.StorageAccountClient.ListByResourceGroup(ctx, subsID, resourceGroup)
+	if rerr != nil {
+		klog.Errorf("ListByResourceGroup(%s) in orphaned resource garbage collection failed with error: %v", resourceGroup, rerr.Error())
+		return
+	}
+
+	for _, account := range accounts {
+		if account.Name == nil {
+			continue
+		}
+		accountName := *account.Name
+		for shareName, tagKey := range getOrphanedFileShares(account.Tags, now, d.orphanGCThreshold) {
+			key := fmt.Sprintf("%s/%s/%s/%s", subsID, resourceGroup, accountName, shareName)
+			if !d.orphanGCRetryScheduler.shouldAttempt(key, now) {
+				continue
+			}
+			klog.V(2).Infof("delete orphaned file share(%s) on account(%s) subsID(%s) rg(%s)", shareName, accountName, subsID, resourceGroup)
+			if err := d.DeleteFileShare(ctx, subsID, resourceGroup, accountName, shareName, nil); err != nil {
+				next := d.orphanGCRetryScheduler.recordFailure(key, now)
+				klog.Warningf("delete orphaned file share(%s) on account(%s) subsID(%s) rg(%s) failed with error: %v, retry after %v", shareName, accountName, subsID, resourceGroup, err, next)
+				continue
+			}
+			if rerr := d.cloud.RemoveStorageAccountTag(ctx, subsID, resourceGroup, accountName, tagKey); rerr != nil {
+				next := d.orphanGCRetryScheduler.recordFailure(key, now)
+				klog.Warningf("RemoveStorageAccountTag(%s) on account(%s) subsID(%s) rg(%s) failed with error: %v, retry after %v", tagKey, accountName, subsID, resourceGroup, rerr.Error(), next)
+				continue
+			}
+			d.orphanGCRetryScheduler.recordSuccess(key)
+		}
+	}
+}
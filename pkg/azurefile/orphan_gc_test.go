@@ -0,0 +1,201 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestOrphanGCRetryScheduler(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newOrphanGCRetryScheduler(time.Hour, 5*time.Hour)
+
+	assert.True(t, s.shouldAttempt("key", now))
+
+	tests := []struct {
+		desc          string
+		expectedDelay time.Duration
+	}{
+		{desc: "first failure", expectedDelay: time.Hour},
+		{desc: "second failure", expectedDelay: 2 * time.Hour},
+		{desc: "third failure", expectedDelay: 4 * time.Hour},
+		{desc: "delay is capped", expectedDelay: 5 * time.Hour},
+		{desc: "delay is still capped", expectedDelay: 5 * time.Hour},
+	}
+	for _, test := range tests {
+		next := s.recordFailure("key", now)
+		assert.Equal(t, now.Add(test.expectedDelay), next, test.desc)
+		assert.False(t, s.shouldAttempt("key", next.Add(-time.Second)), test.desc)
+		assert.True(t, s.shouldAttempt("key", next), test.desc)
+		assert.True(t, s.shouldAttempt("otherkey", now), test.desc)
+	}
+
+	s.recordSuccess("key")
+	assert.True(t, s.shouldAttempt("key", now))
+	assert.Equal(t, now.Add(time.Hour), s.recordFailure("key", now))
+}
+
+func TestGetOrphanedFileShares(t *testing.T) {
+	now := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	expired := pointer.String("2023-01-01T00:00:00Z")
+	notExpired := pointer.String("2023-01-01T12:00:00Z")
+
+	tests := []struct {
+		desc     string
+		tags     map[string]*string
+		expected map[string]string
+	}{
+		{
+			desc:     "not created by driver",
+			tags:     map[string]*string{pendingDeleteShareTagPrefix + "share": expired},
+			expected: map[string]string{},
+		},
+		{
+			desc: "only expired file shares are returned",
+			tags: map[string]*string{
+				consts.CreatedByTag:                      pointer.String(driverCreatedByTagValue),
+				pendingDeleteShareTagPrefix + "share1":   expired,
+				pendingDeleteShareTagPrefix + "share2":   notExpired,
+				pendingDeleteShareTagPrefix:              expired,
+				"K8s-Azure-Pending-Delete-Share-Share3":  expired,
+				"other-tag":                              expired,
+				pendingDeleteShareTagPrefix + "invalid1": pointer.String("invalid"),
+			},
+			expected: map[string]string{
+				"share1": pendingDeleteShareTagPrefix + "share1",
+				"share3": "K8s-Azure-Pending-Delete-Share-Share3",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result := getOrphanedFileShares(test.tags, now, 24*time.Hour)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestMarkFileSharePendingDelete(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	err := d.markFileSharePendingDelete(context.Background(), "subsID", "rg", "account", "share")
+	assert.Equal(t, fmt.Errorf("StorageAccountClient is nil"), err)
+
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.cloud.SubscriptionID = "subsID"
+	d.cloud.ResourceGroup = "rg"
+
+	// account not in driver resource group
+	err = d.markFileSharePendingDelete(context.Background(), "subsID", "otherrg", "account", "share")
+	assert.Equal(t, fmt.Errorf("account(account) subsID(subsID) rg(otherrg) is not in driver resource group which is scanned by garbage collection"), err)
+
+	// account not created by driver
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(storage.Account{}, nil).Times(1)
+	err = d.markFileSharePendingDelete(context.Background(), "subsID", "rg", "account", "share")
+	assert.Equal(t, fmt.Errorf("account(account) is not created by driver"), err)
+
+	// file share already marked, keep original mark time
+	markedAccount := storage.Account{Tags: map[string]*string{
+		consts.CreatedByTag:                   pointer.String(driverCreatedByTagValue),
+		pendingDeleteShareTagPrefix + "share": pointer.String("2023-01-01T00:00:00Z"),
+	}}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(markedAccount, nil).Times(1)
+	err = d.markFileSharePendingDelete(context.Background(), "subsID", "rg", "account", "share")
+	assert.NoError(t, err)
+
+	// mark file share
+	account := storage.Account{Tags: map[string]*string{consts.CreatedByTag: pointer.String(driverCreatedByTagValue)}}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(account, nil).Times(1)
+	mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "subsID", "rg", "account", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, subsID, rg, accountName string, parameters storage.AccountUpdateParameters) *retry.Error {
+			value, ok := parameters.Tags[pendingDeleteShareTagPrefix+"share"]
+			assert.True(t, ok)
+			_, err := time.Parse(time.RFC3339, *value)
+			assert.NoError(t, err)
+			assert.Equal(t, driverCreatedByTagValue, *parameters.Tags[consts.CreatedByTag])
+			return nil
+		}).Times(1)
+	err = d.markFileSharePendingDelete(context.Background(), "subsID", "rg", "account", "share")
+	assert.NoError(t, err)
+}
+
+func TestCollectOrphanedResources(t *testing.T) {
+	now := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	expired := pointer.String("2023-01-01T00:00:00Z")
+
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.cloud.FileClient = mockFileClient
+	d.cloud.SubscriptionID = "subsID"
+	d.cloud.ResourceGroup = "rg"
+	d.orphanGCThreshold = 24 * time.Hour
+	d.orphanGCRetryScheduler = newOrphanGCRetryScheduler(time.Hour, 24*time.Hour)
+
+	shareTagKey := pendingDeleteShareTagPrefix + "share"
+	accounts := []storage.Account{
+		{
+			Name: pointer.String("account"),
+			Tags: map[string]*string{consts.CreatedByTag: pointer.String(driverCreatedByTagValue), shareTagKey: expired},
+		},
+		{
+			Name: pointer.String("unownedaccount"),
+			Tags: map[string]*string{shareTagKey: expired},
+		},
+	}
+
+	// first run: file share deletion fails
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(accounts, nil).Times(1)
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+	mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), "rg", "account", "share", "").Return(fmt.Errorf("delete error")).Times(1)
+	d.collectOrphanedResources(context.Background(), now)
+
+	// second run before next attempt time: nothing is deleted
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(accounts, nil).Times(1)
+	d.collectOrphanedResources(context.Background(), now.Add(30*time.Minute))
+
+	// third run after next attempt time: deletion succeeds and pending delete tag of file share is removed
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(accounts, nil).Times(1)
+	mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), "rg", "account", "share", "").Return(nil).Times(1)
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(accounts[0], nil).Times(1)
+	mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "subsID", "rg", "account", storage.AccountUpdateParameters{
+		Tags: map[string]*string{consts.CreatedByTag: pointer.String(driverCreatedByTagValue)},
+	}).Return(nil).Times(1)
+	d.collectOrphanedResources(context.Background(), now.Add(time.Hour))
+	assert.Equal(t, 0, len(d.orphanGCRetryScheduler.entries))
+
+	// list error
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(nil, retry.NewError(false, fmt.Errorf("list error"))).Times(1)
+	d.collectOrphanedResources(context.Background(), now)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const parameterLabel = "parameter"
+
+var (
+	// deprecatedParameterAliases maps deprecated alias(lower case) of storage class parameter to its canonical name,
+	// parameter names are case insensitive so that different cases of the same name are not aliases
+	deprecatedParameterAliases = map[string]string{
+		storageAccountTypeField: skuNameField,
+		accessTierField:         shareAccessTierField,
+	}
+
+	deprecatedParameterCounter = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      azureFileCSIDriverName,
+			Name:           "deprecated_parameter_total",
+			Help:           "Number of CreateVolume requests using deprecated alias of storage class parameter",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{parameterLabel},
+	)
+	registerDeprecatedParameterMetricsOnce sync.Once
+)
+
+func registerDeprecatedParameterMetrics() {
+	registerDeprecatedParameterMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(deprecatedParameterCounter)
+	})
+}
+
+// getCanonicalParameterName returns canonical name(lower case) of parameter and whether name is a deprecated alias
+func getCanonicalParameterName(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if canonical, ok := deprecatedParameterAliases[name]; ok {
+		return canonical, true
+	}
+	return name, false
+}
+
+// canonicalizeParameters replaces deprecated parameter aliases with canonical names, returns warnings of the aliases,
+// a warning is logged and deprecated parameter counter is increased for every alias,
+// it fails if both alias and canonical name are set with different values
+func canonicalizeParameters(parameters map[string]string) (map[string]string, []string, error) {
+	var aliases []string
+	for k := range parameters {
+		if _, deprecated := getCanonicalParameterName(k); deprecated {
+			aliases = append(aliases, k)
+		}
+	}
+	if len(aliases) == 0 {
+		return parameters, nil, nil
+	}
+	sort.Strings(aliases)
+
+	registerDeprecatedParameterMetrics()
+	result := make(map[string]string, len(parameters))
+	for k, v := range parameters {
+		result[k] = v
+	}
+	var warnings []string
+	for _, alias := range aliases {
+		canonical, _ := getCanonicalParameterName(alias)
+		value := result[alias]
+		delete(result, alias)
+		for k, v := range result {
+			if strings.EqualFold(k, canonical) && v != value {
+				return nil, nil, fmt.Errorf("parameter %s is deprecated alias of %s, they could not be set with different values(%s, %s)", alias, k, value, v)
+			}
+		}
+		setKeyValueInMap(result, canonical, value)
+		warning := fmt.Sprintf("parameter %s is deprecated, use %s instead", alias, canonical)
+		klog.Warning(warning)
+		warnings = append(warnings, warning)
+		deprecatedParameterCounter.WithLabelValues(strings.ToLower(alias)).Inc()
+	}
+	return result, warnings, nil
+}
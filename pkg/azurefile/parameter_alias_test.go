@@ -0,0 +1,122 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// getDeprecatedParameterCount returns value of deprecated parameter counter of the parameter
+func getDeprecatedParameterCount(t *testing.T, parameter string) float64 {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != azureFileCSIDriverName+"_deprecated_parameter_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == parameterLabel && label.GetValue() == parameter {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestGetCanonicalParameterName(t *testing.T) {
+	for alias, canonical := range deprecatedParameterAliases {
+		name, deprecated := getCanonicalParameterName(alias)
+		assert.Equal(t, canonical, name, alias)
+		assert.True(t, deprecated, alias)
+		name, deprecated = getCanonicalParameterName(canonical)
+		assert.Equal(t, canonical, name, canonical)
+		assert.False(t, deprecated, canonical)
+	}
+
+	name, deprecated := getCanonicalParameterName("SkuName")
+	assert.Equal(t, skuNameField, name)
+	assert.False(t, deprecated, "different case of parameter name is not an alias")
+}
+
+func TestCanonicalizeParameters(t *testing.T) {
+	tests := []struct {
+		desc               string
+		parameters         map[string]string
+		expectedParameters map[string]string
+		expectedWarnings   []string
+		expectedErr        error
+		expectedCounts     map[string]float64
+	}{
+		{
+			desc:               "no deprecated alias",
+			parameters:         map[string]string{"skuName": "Premium_LRS", "shareAccessTier": "Hot"},
+			expectedParameters: map[string]string{"skuName": "Premium_LRS", "shareAccessTier": "Hot"},
+		},
+		{
+			desc:               "storageAccountType is replaced by skuName",
+			parameters:         map[string]string{"storageAccountType": "Premium_LRS", "protocol": "smb"},
+			expectedParameters: map[string]string{skuNameField: "Premium_LRS", "protocol": "smb"},
+			expectedWarnings:   []string{"parameter storageAccountType is deprecated, use skuname instead"},
+			expectedCounts:     map[string]float64{storageAccountTypeField: 1},
+		},
+		{
+			desc:               "accessTier is replaced by shareAccessTier",
+			parameters:         map[string]string{"accessTier": "Cool"},
+			expectedParameters: map[string]string{shareAccessTierField: "Cool"},
+			expectedWarnings:   []string{"parameter accessTier is deprecated, use shareaccesstier instead"},
+			expectedCounts:     map[string]float64{accessTierField: 1},
+		},
+		{
+			desc:               "all aliases with the same values of canonical names",
+			parameters:         map[string]string{"STORAGEACCOUNTTYPE": "Premium_LRS", "skuName": "Premium_LRS", "accesstier": "Hot", "shareAccessTier": "Hot"},
+			expectedParameters: map[string]string{"skuName": "Premium_LRS", "shareAccessTier": "Hot"},
+			expectedWarnings: []string{
+				"parameter STORAGEACCOUNTTYPE is deprecated, use skuname instead",
+				"parameter accesstier is deprecated, use shareaccesstier instead",
+			},
+			expectedCounts: map[string]float64{storageAccountTypeField: 1, accessTierField: 1},
+		},
+		{
+			desc:        "alias and canonical name with different values",
+			parameters:  map[string]string{"storageAccountType": "Premium_LRS", "skuName": "Standard_LRS"},
+			expectedErr: fmt.Errorf("parameter storageAccountType is deprecated alias of skuName, they could not be set with different values(Premium_LRS, Standard_LRS)"),
+		},
+	}
+
+	for _, test := range tests {
+		counts := map[string]float64{}
+		for alias := range deprecatedParameterAliases {
+			counts[alias] = getDeprecatedParameterCount(t, alias)
+		}
+
+		parameters, warnings, err := canonicalizeParameters(test.parameters)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedParameters, parameters, test.desc)
+		assert.Equal(t, test.expectedWarnings, warnings, test.desc)
+		if err == nil {
+			for alias := range deprecatedParameterAliases {
+				assert.Equal(t, counts[alias]+test.expectedCounts[alias], getDeprecatedParameterCount(t, alias), test.desc)
+			}
+		}
+	}
+}
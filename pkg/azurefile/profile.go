@@ -0,0 +1,143 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+// builtinProfiles are provisioning profiles which could be specified by profile parameter,
+// profile name is case insensitive
+var builtinProfiles = map[string]map[string]string{
+	"premium-nfs": {
+		skuNameField:  "Premium_LRS",
+		protocolField: nfs,
+	},
+	"premium-smb": {
+		skuNameField:  "Premium_LRS",
+		protocolField: smb,
+	},
+	"standard-smb": {
+		skuNameField:  "Standard_LRS",
+		protocolField: smb,
+	},
+}
+
+// getCustomProfiles returns provisioning profiles in profile configmap, every key of configmap data is a profile name
+// and the value is a JSON object of parameters, e.g. `premium-nfs-private: '{"skuName":"Premium_LRS","protocol":"nfs","networkEndpointType":"privateEndpoint"}'`
+func (d *Driver) getCustomProfiles() (map[string]map[string]string, error) {
+	if d.profileConfigMap == "" {
+		return nil, nil
+	}
+	cache, err := d.mountOptionsConfigMapCache.Get(d.profileConfigMap, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cache.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("invalid data of configmap(%s)", d.profileConfigMap)
+	}
+
+	profiles := make(map[string]map[string]string, len(data))
+	for name, value := range data {
+		profile := map[string]string{}
+		if err := json.Unmarshal([]byte(value), &profile); err != nil {
+			return nil, fmt.Errorf("invalid profile(%s) in configmap(%s): %v", name, d.profileConfigMap, err)
+		}
+		for k := range profile {
+			if strings.EqualFold(k, profileField) {
+				return nil, fmt.Errorf("invalid profile(%s) in configmap(%s): nested profile is not supported", name, d.profileConfigMap)
+			}
+		}
+		profiles[strings.ToLower(name)] = profile
+	}
+	return profiles, nil
+}
+
+// getProfile returns parameters of the profile, custom profile takes precedence over builtin profile with the same name
+func (d *Driver) getProfile(name string) (map[string]string, error) {
+	name = strings.ToLower(name)
+	customProfiles, err := d.getCustomProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if profile, ok := customProfiles[name]; ok {
+		return profile, nil
+	}
+	if profile, ok := builtinProfiles[name]; ok {
+		return profile, nil
+	}
+
+	var names []string
+	for n := range builtinProfiles {
+		names = append(names, n)
+	}
+	for n := range customProfiles {
+		if _, ok := builtinProfiles[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("profile(%s) is not found, supported profiles: %v", name, names)
+}
+
+// expandProfile replaces profile parameter with parameters of the profile, parameters set explicitly
+// (including deprecated aliases) take precedence over the same parameters in profile
+func (d *Driver) expandProfile(parameters map[string]string) (map[string]string, error) {
+	var profileName string
+	var hasProfile bool
+	explicit := map[string]bool{}
+	for k, v := range parameters {
+		if strings.EqualFold(k, profileField) {
+			profileName, hasProfile = v, true
+			continue
+		}
+		canonical, _ := getCanonicalParameterName(k)
+		explicit[canonical] = true
+	}
+	if !hasProfile {
+		return parameters, nil
+	}
+	// empty profile does not set any parameter
+	profile := map[string]string{}
+	if profileName != "" {
+		var err error
+		if profile, err = d.getProfile(profileName); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string]string, len(parameters)+len(profile))
+	for k, v := range profile {
+		if canonical, _ := getCanonicalParameterName(k); !explicit[canonical] {
+			result[k] = v
+		}
+	}
+	for k, v := range parameters {
+		if !strings.EqualFold(k, profileField) {
+			result[k] = v
+		}
+	}
+	klog.V(2).Infof("expand profile(%s) with parameters: %v", profileName, profile)
+	return result, nil
+}
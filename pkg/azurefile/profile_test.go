@@ -0,0 +1,130 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExpandProfile(t *testing.T) {
+	d := NewFakeDriver()
+	d.profileConfigMap = "kube-system/azurefile-profiles"
+	clientSet := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "azurefile-profiles", Namespace: "kube-system"},
+		Data: map[string]string{
+			"Premium-NFS-Private": `{"skuName":"Premium_LRS","protocol":"nfs","networkEndpointType":"privateEndpoint"}`,
+			"standard-smb":        `{"skuName":"Standard_ZRS","protocol":"smb"}`,
+		},
+	})
+	d.cloud.KubeClient = clientSet
+
+	tests := []struct {
+		desc               string
+		parameters         map[string]string
+		expectedParameters map[string]string
+		expectedErr        error
+	}{
+		{
+			desc:               "no profile",
+			parameters:         map[string]string{"skuName": "Standard_LRS"},
+			expectedParameters: map[string]string{"skuName": "Standard_LRS"},
+		},
+		{
+			desc:               "empty profile",
+			parameters:         map[string]string{"profile": "", "skuName": "Standard_LRS"},
+			expectedParameters: map[string]string{"skuName": "Standard_LRS"},
+		},
+		{
+			desc:               "builtin profile",
+			parameters:         map[string]string{"Profile": "Premium-NFS", "shareName": "share"},
+			expectedParameters: map[string]string{skuNameField: "Premium_LRS", protocolField: nfs, "shareName": "share"},
+		},
+		{
+			desc:               "explicit parameters override profile",
+			parameters:         map[string]string{"profile": "premium-nfs", "skuName": "Premium_ZRS", "PROTOCOL": "smb"},
+			expectedParameters: map[string]string{"skuName": "Premium_ZRS", "PROTOCOL": "smb"},
+		},
+		{
+			desc:               "deprecated alias overrides profile",
+			parameters:         map[string]string{"profile": "premium-nfs", "storageAccountType": "Premium_ZRS"},
+			expectedParameters: map[string]string{"storageAccountType": "Premium_ZRS", protocolField: nfs},
+		},
+		{
+			desc:               "custom profile",
+			parameters:         map[string]string{"profile": "premium-nfs-private", "networkEndpointType": ""},
+			expectedParameters: map[string]string{"skuName": "Premium_LRS", "protocol": "nfs", "networkEndpointType": ""},
+		},
+		{
+			desc:               "custom profile takes precedence over builtin profile",
+			parameters:         map[string]string{"profile": "standard-smb"},
+			expectedParameters: map[string]string{"skuName": "Standard_ZRS", "protocol": "smb"},
+		},
+		{
+			desc:        "profile not found",
+			parameters:  map[string]string{"profile": "premium"},
+			expectedErr: fmt.Errorf("profile(premium) is not found, supported profiles: [premium-nfs premium-nfs-private premium-smb standard-smb]"),
+		},
+	}
+
+	for _, test := range tests {
+		parameters, err := d.expandProfile(test.parameters)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedParameters, parameters, test.desc)
+	}
+}
+
+func TestGetCustomProfiles(t *testing.T) {
+	d := NewFakeDriver()
+	profiles, err := d.getCustomProfiles()
+	assert.NoError(t, err)
+	assert.Nil(t, profiles)
+
+	// KubeClient is nil
+	d.profileConfigMap = "kube-system/azurefile-profiles"
+	_, err = d.getCustomProfiles()
+	assert.Equal(t, fmt.Errorf("could not get configmap(kube-system/azurefile-profiles): KubeClient is nil"), err)
+
+	clientSet := fake.NewSimpleClientset()
+	d.cloud.KubeClient = clientSet
+	for name, data := range map[string]map[string]string{
+		"invalid-json":   {"profile": "skuName=Premium_LRS"},
+		"nested-profile": {"profile": `{"profile":"premium-nfs"}`},
+	} {
+		_, err := clientSet.CoreV1().ConfigMaps("kube-system").Create(context.TODO(), &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system"},
+			Data:       data,
+		}, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+
+	d.profileConfigMap = "kube-system/invalid-json"
+	_, err = d.getCustomProfiles()
+	assert.Error(t, err)
+	d.profileConfigMap = "kube-system/nested-profile"
+	_, err = d.getCustomProfiles()
+	assert.Equal(t, fmt.Errorf("invalid profile(profile) in configmap(kube-system/nested-profile): nested profile is not supported"), err)
+
+	assert.Nil(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, ProfileConfigMap: "invalid"}))
+	assert.Equal(t, "kube-system/profiles", NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, ProfileConfigMap: "kube-system/profiles"}).profileConfigMap)
+}
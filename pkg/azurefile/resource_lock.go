@@ -0,0 +1,174 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2016-09-01/locks"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	azureconfig "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	// management locks of resource group are cached for resourceGroupLockCacheTTL
+	resourceGroupLockCacheTTL = 10 * time.Minute
+	// error message of storage account creation in resource group with management locks
+	resourceGroupLockedError = "has management locks"
+)
+
+// managementLock is a management lock which applies to resource group, level is CanNotDelete or ReadOnly
+type managementLock struct {
+	Name  string
+	Level string
+}
+
+// resourceGroupLockLister lists management locks which apply to resource group,
+// locks at subscription level are included since they are inherited by resource group
+type resourceGroupLockLister interface {
+	ListResourceGroupLocks(ctx context.Context, subsID, resourceGroup string) ([]managementLock, error)
+}
+
+// managementLockClient lists management locks by management lock client of Azure SDK with the credential of cloud provider
+type managementLockClient struct {
+	cloud      *azure.Cloud
+	httpClient *http.Client
+}
+
+func (c *managementLockClient) ListResourceGroupLocks(ctx context.Context, subsID, resourceGroup string) ([]managementLock, error) {
+	if c.cloud == nil {
+		return nil, fmt.Errorf("cloud provider is nil")
+	}
+	token, err := azureconfig.GetServicePrincipalToken(&c.cloud.Config.AzureAuthConfig, &c.cloud.Environment, c.cloud.Environment.ServiceManagementEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service principal token: %w", err)
+	}
+	token.SetSender(c.httpClient)
+
+	client := locks.NewManagementLocksClientWithBaseURI(c.cloud.Environment.ResourceManagerEndpoint, subsID)
+	client.Authorizer = autorest.NewBearerAuthorizer(token)
+	client.Sender = c.httpClient
+	iter, err := client.ListAtResourceGroupLevelComplete(ctx, resourceGroup, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list management locks of resource group(%s): %w", resourceGroup, err)
+	}
+	result := []managementLock{}
+	for iter.NotDone() {
+		lock := iter.Value()
+		var level string
+		if lock.ManagementLockProperties != nil {
+			level = string(lock.ManagementLockProperties.Level)
+		}
+		result = append(result, managementLock{Name: pointer.StringDeref(lock.Name, ""), Level: level})
+		if err := iter.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("failed to list management locks of resource group(%s): %w", resourceGroup, err)
+		}
+	}
+	return result, nil
+}
+
+// checkManagementLocks decides whether storage account could be created in resource group with management locks,
+// storage account could not be deleted from resource group with any lock, it fails if failOnLocked is true,
+// otherwise only a warning is logged
+func checkManagementLocks(resourceGroup string, locks []managementLock, failOnLocked bool) error {
+	if len(locks) == 0 {
+		return nil
+	}
+	var descriptions []string
+	for _, lock := range locks {
+		descriptions = append(descriptions, fmt.Sprintf("%s(%s)", lock.Name, lock.Level))
+	}
+	msg := fmt.Sprintf("resource group(%s) %s %v, storage account created in it could not be deleted until the locks are removed", resourceGroup, resourceGroupLockedError, descriptions)
+	if failOnLocked {
+		return fmt.Errorf("%s", msg)
+	}
+	klog.Warning(msg)
+	return nil
+}
+
+func (d *Driver) getResourceGroupLockLister() resourceGroupLockLister {
+	if d.resourceGroupLockClient != nil {
+		return d.resourceGroupLockClient
+	}
+	return &managementLockClient{cloud: d.cloud, httpClient: d.httpClient}
+}
+
+// checkResourceGroupLocks checks management locks of resource group before storage account is created in it,
+// locks are read from resourceGroupLockCache first, lock check is skipped with a warning if locks could not be listed
+func (d *Driver) checkResourceGroupLocks(ctx context.Context, subsID, resourceGroup string) error {
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+	cacheKey := subsID + "/" + resourceGroup
+	cache, err := d.resourceGroupLockCache.Get(cacheKey, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return err
+	}
+	locks, ok := cache.([]managementLock)
+	if !ok {
+		if locks, err = d.getResourceGroupLockLister().ListResourceGroupLocks(ctx, subsID, resourceGroup); err != nil {
+			klog.Warningf("skip management lock check of resource group(%s): %v", resourceGroup, err)
+			locks = []managementLock{}
+		}
+		d.resourceGroupLockCache.Set(cacheKey, locks)
+	}
+	return checkManagementLocks(resourceGroup, locks, d.failOnLockedResourceGroup)
+}
+
+// isResourceGroupLockedError returns true if storage account is not created since resource group has management locks
+func isResourceGroupLockedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), resourceGroupLockedError)
+}
+
+// resourceLockStorageAccountClient checks management locks of resource group right before storage account is created in it,
+// so that locks are not checked if an existing storage account is matched
+type resourceLockStorageAccountClient struct {
+	storageaccountclient.Interface
+	checkLocks func(ctx context.Context, subsID, resourceGroup string) error
+}
+
+var _ storageaccountclient.Interface = &resourceLockStorageAccountClient{}
+
+func (c *resourceLockStorageAccountClient) Create(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+	if err := c.checkLocks(ctx, subsID, resourceGroupName); err != nil {
+		return retry.NewError(false, err)
+	}
+	return c.Interface.Create(ctx, subsID, resourceGroupName, accountName, parameters)
+}
+
+// setResourceLockClient wraps storage account client of cloud provider so that management locks of resource group
+// are checked before storage account is created
+func (d *Driver) setResourceLockClient() {
+	if d.cloud.StorageAccountClient == nil {
+		return
+	}
+	d.cloud.StorageAccountClient = &resourceLockStorageAccountClient{Interface: d.cloud.StorageAccountClient, checkLocks: d.checkResourceGroupLocks}
+}
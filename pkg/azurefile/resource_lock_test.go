@@ -0,0 +1,139 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+)
+
+// fakeResourceGroupLockClient returns management locks in a map and records resource groups which are listed
+type fakeResourceGroupLockClient struct {
+	locks map[string][]managementLock
+	lists []string
+}
+
+func (c *fakeResourceGroupLockClient) ListResourceGroupLocks(_ context.Context, subsID, resourceGroup string) ([]managementLock, error) {
+	c.lists = append(c.lists, subsID+"/"+resourceGroup)
+	if locks, ok := c.locks[resourceGroup]; ok {
+		return locks, nil
+	}
+	return nil, fmt.Errorf("resource group(%s) not found", resourceGroup)
+}
+
+func TestCheckManagementLocks(t *testing.T) {
+	locks := []managementLock{{Name: "no-delete", Level: "CanNotDelete"}}
+	tests := []struct {
+		desc         string
+		locks        []managementLock
+		failOnLocked bool
+		expectedErr  error
+	}{
+		{
+			desc: "no lock",
+		},
+		{
+			desc:         "no lock with fail on locked",
+			failOnLocked: true,
+		},
+		{
+			desc:  "locked resource group only logs a warning",
+			locks: locks,
+		},
+		{
+			desc:         "locked resource group fails with fail on locked",
+			locks:        locks,
+			failOnLocked: true,
+			expectedErr:  fmt.Errorf("resource group(rg) has management locks [no-delete(CanNotDelete)], storage account created in it could not be deleted until the locks are removed"),
+		},
+	}
+
+	for _, test := range tests {
+		err := checkManagementLocks("rg", test.locks, test.failOnLocked)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+func TestCheckResourceGroupLocks(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud.ResourceGroup = "default-rg"
+	client := &fakeResourceGroupLockClient{
+		locks: map[string][]managementLock{
+			"default-rg": {},
+			"locked-rg":  {{Name: "no-delete", Level: "CanNotDelete"}},
+		},
+	}
+	d.resourceGroupLockClient = client
+
+	assert.NoError(t, d.checkResourceGroupLocks(context.Background(), "", ""))
+	assert.NoError(t, d.checkResourceGroupLocks(context.Background(), "subsID", "locked-rg"))
+	// lock check is skipped if locks could not be listed
+	assert.NoError(t, d.checkResourceGroupLocks(context.Background(), "subsID", "unknown-rg"))
+
+	d.failOnLockedResourceGroup = true
+	assert.Error(t, d.checkResourceGroupLocks(context.Background(), "subsID", "locked-rg"))
+	assert.NoError(t, d.checkResourceGroupLocks(context.Background(), "subsID", "unknown-rg"))
+	// locks are read from cache
+	assert.Equal(t, []string{"subscriptionID/default-rg", "subsID/locked-rg", "subsID/unknown-rg"}, client.lists)
+
+	assert.True(t, NewDriver(&DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, FailOnLockedResourceGroup: true}).failOnLockedResourceGroup)
+}
+
+func TestIsResourceGroupLockedError(t *testing.T) {
+	err := checkManagementLocks("rg", []managementLock{{Name: "no-delete", Level: "CanNotDelete"}}, true)
+	assert.True(t, isResourceGroupLockedError(err))
+	assert.True(t, isResourceGroupLockedError(fmt.Errorf("failed to create storage account account, error: %v", err)))
+	assert.False(t, isResourceGroupLockedError(fmt.Errorf("test error")))
+	assert.False(t, isResourceGroupLockedError(nil))
+}
+
+func TestResourceLockStorageAccountClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.resourceGroupLockClient = &fakeResourceGroupLockClient{
+		locks: map[string][]managementLock{
+			"rg":        {},
+			"locked-rg": {{Name: "no-delete", Level: "CanNotDelete"}},
+		},
+	}
+	d.failOnLockedResourceGroup = true
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.setResourceLockClient()
+
+	// locks are not checked if storage account is not created
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "locked-rg").Return([]storage.Account{}, nil).Times(1)
+	_, rerr := d.cloud.StorageAccountClient.ListByResourceGroup(context.Background(), "subsID", "locked-rg")
+	assert.Nil(t, rerr)
+
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), "subsID", "rg", "account", gomock.Any()).Return(nil).Times(1)
+	rerr = d.cloud.StorageAccountClient.Create(context.Background(), "subsID", "rg", "account", storage.AccountCreateParameters{})
+	assert.Nil(t, rerr)
+
+	// storage account is not created in locked resource group
+	rerr = d.cloud.StorageAccountClient.Create(context.Background(), "subsID", "locked-rg", "account", storage.AccountCreateParameters{})
+	assert.NotNil(t, rerr)
+	assert.True(t, isResourceGroupLockedError(rerr.Error()))
+}
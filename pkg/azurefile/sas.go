@@ -0,0 +1,210 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultSASTokenPermissions = "rl"
+	defaultSASTokenExpiry      = 24 * time.Hour
+	minSASTokenExpiry          = time.Hour
+	maxSASTokenExpiry          = 30 * 24 * time.Hour
+	// max number of stored access policies on one file share
+	maxStoredAccessPolicies = 5
+	// max length of stored access policy ID
+	maxStoredAccessPolicyIDLength = 64
+
+	sasSecretNameTemplate = "azure-storage-sas-%s"
+	defaultSecretSASToken = "azurestorageaccountsastoken"
+	// label of SAS token secret, value is hash of volumeID since volumeID is not a valid label value
+	sasTokenVolumeLabel = "file.csi.azure.com/sas-token-volume"
+	// length of hash of volumeID in sasTokenVolumeLabel
+	sasTokenVolumeHashLength = 32
+)
+
+// parseSASTokenPermissions validates share SAS permissions(e.g. "rl"), returns permissions in canonical order
+func parseSASTokenPermissions(permissions string) (string, error) {
+	if permissions == "" {
+		return defaultSASTokenPermissions, nil
+	}
+	p := &azfile.ShareSASPermissions{}
+	if err := p.Parse(permissions); err != nil {
+		return "", fmt.Errorf("invalid %s(%s), supported permissions: rcwdl", sasTokenPermissionsField, permissions)
+	}
+	return p.String(), nil
+}
+
+// parseSASTokenExpiry parses expiry duration of SAS token(e.g. "24h") which should be in [minSASTokenExpiry, maxSASTokenExpiry]
+func parseSASTokenExpiry(expiry string) (time.Duration, error) {
+	if expiry == "" {
+		return defaultSASTokenExpiry, nil
+	}
+	duration, err := time.ParseDuration(expiry)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s(%s): %v", sasTokenExpiryField, expiry, err)
+	}
+	if duration < minSASTokenExpiry || duration > maxSASTokenExpiry {
+		return 0, fmt.Errorf("%s(%s) should be in range [%v, %v]", sasTokenExpiryField, expiry, minSASTokenExpiry, maxSASTokenExpiry)
+	}
+	return duration, nil
+}
+
+// getStoredAccessPolicyID returns ID of stored access policy on file share for the volume
+func getStoredAccessPolicyID(volName string) string {
+	if len(volName) > maxStoredAccessPolicyIDLength {
+		return volName[:maxStoredAccessPolicyIDLength]
+	}
+	return volName
+}
+
+// generateShareSASToken generates share SAS token which refers to stored access policy(policyID),
+// permissions and expiry are defined in stored access policy so that the SAS token could be revoked by removing the policy
+func generateShareSASToken(accountName, accountKey, shareName, policyID string) (string, error) {
+	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return "", fmt.Errorf("NewSharedKeyCredential(%s) failed with error: %v", accountName, err)
+	}
+	sasQueryParams, err := azfile.FileSASSignatureValues{
+		Protocol:   azfile.SASProtocolHTTPS,
+		ShareName:  shareName,
+		Identifier: policyID,
+	}.NewSASQueryParameters(credential)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate SAS token of file share(%s): %v", shareName, err)
+	}
+	return sasQueryParams.Encode(), nil
+}
+
+// setStoredAccessPolicy creates or updates stored access policy(policyID) on file share, other policies are kept
+func setStoredAccessPolicy(ctx context.Context, shareURL azfile.ShareURL, policyID, permissions string, expiry time.Duration) error {
+	identifiers, err := shareURL.GetPermissions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get stored access policies: %v", err)
+	}
+	start := time.Now().UTC()
+	end := start.Add(expiry)
+	policy := azfile.SignedIdentifier{
+		ID: policyID,
+		AccessPolicy: &azfile.AccessPolicy{
+			Start:      &start,
+			Expiry:     &end,
+			Permission: &permissions,
+		},
+	}
+	items := []azfile.SignedIdentifier{policy}
+	for _, item := range identifiers.Items {
+		if item.ID != policyID {
+			items = append(items, item)
+		}
+	}
+	if len(items) > maxStoredAccessPolicies {
+		return fmt.Errorf("number of stored access policies(%d) exceeds limit(%d)", len(items), maxStoredAccessPolicies)
+	}
+	if _, err := shareURL.SetPermissions(ctx, items); err != nil {
+		return fmt.Errorf("failed to set stored access policy(%s): %v", policyID, err)
+	}
+	return nil
+}
+
+// createShareSASToken creates stored access policy on file share and returns SAS token which refers to the policy
+func createShareSASToken(ctx context.Context, accountName, accountKey, storageEndpointSuffix, shareName, policyID, permissions string, expiry time.Duration, httpClient *http.Client) (string, error) {
+	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return "", fmt.Errorf("NewSharedKeyCredential(%s) failed with error: %v", accountName, err)
+	}
+	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, accountName, storageEndpointSuffix))
+	if err != nil {
+		return "", fmt.Errorf("parse serviceURLTemplate error: %v", err)
+	}
+	shareURL := azfile.NewServiceURL(*u, newFilePipeline(credential, azfile.PipelineOptions{}, httpClient)).NewShareURL(shareName)
+	if err := setStoredAccessPolicy(ctx, shareURL, policyID, permissions, expiry); err != nil {
+		return "", err
+	}
+	return generateShareSASToken(accountName, accountKey, shareName, policyID)
+}
+
+// getSASTokenVolumeLabelValue returns value of sasTokenVolumeLabel on SAS token secret of volumeID
+func getSASTokenVolumeLabelValue(volumeID string) string {
+	hash := sha256.Sum256([]byte(volumeID))
+	return hex.EncodeToString(hash[:])[:sasTokenVolumeHashLength]
+}
+
+// setSASTokenSecret stores SAS token of volumeID in k8s secret, existing secret would be updated
+func (d *Driver) setSASTokenSecret(ctx context.Context, accountName, sasToken, secretName, secretNamespace, volumeID string) error {
+	if d.cloud.KubeClient == nil {
+		return fmt.Errorf("could not create secret(%s): kubeClient is nil", secretName)
+	}
+	secretLabels := map[string]string{sasTokenVolumeLabel: getSASTokenVolumeLabelValue(volumeID)}
+	for k, v := range d.secretLabels {
+		secretLabels[k] = v
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: secretNamespace,
+			Name:      secretName,
+			Labels:    secretLabels,
+		},
+		Data: map[string][]byte{
+			defaultSecretAccountName: []byte(accountName),
+			defaultSecretSASToken:    []byte(sasToken),
+		},
+		Type: "Opaque",
+	}
+	err := d.createSecret(ctx, secret)
+	if errors.IsAlreadyExists(err) {
+		_, err = d.cloud.KubeClient.CoreV1().Secrets(secretNamespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't create secret(%s/%s): %v", secretNamespace, secretName, err)
+	}
+	klog.V(2).Infof("store SAS token of account(%s) to k8s secret(%s) in %s namespace", accountName, secretName, secretNamespace)
+	return nil
+}
+
+// deleteSASTokenSecrets deletes SAS token secrets of volumeID in secretNamespace, secrets already deleted are skipped
+func (d *Driver) deleteSASTokenSecrets(ctx context.Context, volumeID, secretNamespace string) error {
+	if d.cloud.KubeClient == nil || secretNamespace == "" {
+		return nil
+	}
+	selector := labels.Set{sasTokenVolumeLabel: getSASTokenVolumeLabelValue(volumeID)}.String()
+	secrets, err := d.cloud.KubeClient.CoreV1().Secrets(secretNamespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("couldn't list secrets(%s) in %s namespace: %v", selector, secretNamespace, err)
+	}
+	for _, secret := range secrets.Items {
+		if err := d.cloud.KubeClient.CoreV1().Secrets(secretNamespace).Delete(ctx, secret.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("couldn't delete secret(%s/%s): %v", secretNamespace, secret.Name, err)
+		}
+		klog.V(2).Infof("deleted SAS token secret(%s) of volume(%s) in %s namespace", secret.Name, volumeID, secretNamespace)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseSASTokenPermissions(t *testing.T) {
+	tests := []struct {
+		permissions         string
+		expectedPermissions string
+		expectedErr         error
+	}{
+		{
+			permissions:         "",
+			expectedPermissions: defaultSASTokenPermissions,
+		},
+		{
+			permissions:         "lr",
+			expectedPermissions: "rl",
+		},
+		{
+			permissions:         "ldwcr",
+			expectedPermissions: "rcwdl",
+		},
+		{
+			permissions: "ra",
+			expectedErr: fmt.Errorf("invalid sastokenpermissions(ra), supported permissions: rcwdl"),
+		},
+	}
+
+	for _, test := range tests {
+		permissions, err := parseSASTokenPermissions(test.permissions)
+		assert.Equal(t, test.expectedErr, err, test.permissions)
+		assert.Equal(t, test.expectedPermissions, permissions, test.permissions)
+	}
+}
+
+func TestParseSASTokenExpiry(t *testing.T) {
+	tests := []struct {
+		expiry         string
+		expectedExpiry time.Duration
+		expectedErr    error
+	}{
+		{
+			expiry:         "",
+			expectedExpiry: defaultSASTokenExpiry,
+		},
+		{
+			expiry:         "2h",
+			expectedExpiry: 2 * time.Hour,
+		},
+		{
+			expiry:      "30m",
+			expectedErr: fmt.Errorf("sastokenexpiry(30m) should be in range [1h0m0s, 720h0m0s]"),
+		},
+		{
+			expiry:      "721h",
+			expectedErr: fmt.Errorf("sastokenexpiry(721h) should be in range [1h0m0s, 720h0m0s]"),
+		},
+		{
+			expiry:      "1d",
+			expectedErr: fmt.Errorf("invalid sastokenexpiry(1d): time: unknown unit \"d\" in duration \"1d\""),
+		},
+	}
+
+	for _, test := range tests {
+		expiry, err := parseSASTokenExpiry(test.expiry)
+		assert.Equal(t, test.expectedErr, err, test.expiry)
+		assert.Equal(t, test.expectedExpiry, expiry, test.expiry)
+	}
+}
+
+func TestGetStoredAccessPolicyID(t *testing.T) {
+	assert.Equal(t, "pvc-uuid", getStoredAccessPolicyID("pvc-uuid"))
+	assert.Equal(t, strings.Repeat("a", maxStoredAccessPolicyIDLength), getStoredAccessPolicyID(strings.Repeat("a", 100)))
+}
+
+func TestGenerateShareSASToken(t *testing.T) {
+	fakeKey := base64.StdEncoding.EncodeToString([]byte("fakeAccountKey"))
+	sasToken, err := generateShareSASToken("account", fakeKey, "share", "pvc-uuid")
+	assert.NoError(t, err)
+
+	values, err := url.ParseQuery(sasToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "pvc-uuid", values.Get("si"))
+	assert.Equal(t, "s", values.Get("sr"))
+	assert.Equal(t, "https", values.Get("spr"))
+	assert.NotEmpty(t, values.Get("sig"))
+	// permissions and expiry are defined in stored access policy
+	assert.Empty(t, values.Get("sp"))
+	assert.Empty(t, values.Get("se"))
+
+	// SAS token is signed by account key
+	otherKey := base64.StdEncoding.EncodeToString([]byte("otherAccountKey"))
+	otherSASToken, err := generateShareSASToken("account", otherKey, "share", "pvc-uuid")
+	assert.NoError(t, err)
+	assert.NotEqual(t, sasToken, otherSASToken)
+
+	_, err = generateShareSASToken("account", "invalid key", "share", "pvc-uuid")
+	assert.Error(t, err)
+}
+
+func TestSetSASTokenSecret(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud.KubeClient = nil
+	assert.Error(t, d.setSASTokenSecret(context.Background(), "account", "sasToken", "secret", "default", "vol_1"))
+
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+	d.secretLabels = map[string]string{"app": "azurefile"}
+	for _, sasToken := range []string{"sasToken1", "sasToken2"} {
+		assert.NoError(t, d.setSASTokenSecret(context.Background(), "account", sasToken, "secret", "default", "vol_1"))
+		secret, err := d.cloud.KubeClient.CoreV1().Secrets("default").Get(context.Background(), "secret", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "account", string(secret.Data[defaultSecretAccountName]))
+		assert.Equal(t, sasToken, string(secret.Data[defaultSecretSASToken]))
+		assert.Equal(t, map[string]string{"app": "azurefile", sasTokenVolumeLabel: getSASTokenVolumeLabelValue("vol_1")}, secret.Labels)
+	}
+}
+
+func TestDeleteSASTokenSecrets(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+	ctx := context.Background()
+	assert.NoError(t, d.setSASTokenSecret(ctx, "account", "sasToken", "secret1", "default", "vol_1"))
+	assert.NoError(t, d.setSASTokenSecret(ctx, "account", "sasToken", "secret2", "default", "vol_2"))
+
+	assert.NoError(t, d.deleteSASTokenSecrets(ctx, "vol_1", "default"))
+	_, err := d.cloud.KubeClient.CoreV1().Secrets("default").Get(ctx, "secret1", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+	_, err = d.cloud.KubeClient.CoreV1().Secrets("default").Get(ctx, "secret2", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// secret already deleted
+	assert.NoError(t, d.deleteSASTokenSecrets(ctx, "vol_1", "default"))
+	assert.Len(t, getSASTokenVolumeLabelValue("vol_1"), sasTokenVolumeHashLength)
+}
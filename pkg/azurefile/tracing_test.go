@@ -0,0 +1,73 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type inMemoryExporter struct {
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (e *inMemoryExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *inMemoryExporter) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func TestSpans(t *testing.T) {
+	exporter := &inMemoryExporter{}
+	originalTP := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(originalTP)
+
+	ctx, parent := startSpan(context.Background(), "CreateVolume")
+	_, span := startSpan(ctx, "CreateFileShare", accountNameAttribute.String("account"), shareNameAttribute.String("share"), protocolAttribute.String("SMB"))
+	endSpan(span, fmt.Errorf("test error"))
+	setSpanAttributes(ctx, volumeIDAttribute.String("rg#account#share###"))
+	endSpan(parent, nil)
+
+	assert.Equal(t, 2, len(exporter.spans))
+	child := exporter.spans[0]
+	assert.Equal(t, "CreateFileShare", child.Name())
+	assert.Equal(t, []attribute.KeyValue{
+		accountNameAttribute.String("account"),
+		shareNameAttribute.String("share"),
+		protocolAttribute.String("SMB"),
+	}, child.Attributes())
+	assert.Equal(t, otelcodes.Error, child.Status().Code)
+	assert.Equal(t, "test error", child.Status().Description)
+	assert.Equal(t, 1, len(child.Events()))
+
+	root := exporter.spans[1]
+	assert.Equal(t, "CreateVolume", root.Name())
+	assert.Equal(t, root.SpanContext().SpanID(), child.Parent().SpanID())
+	assert.Equal(t, []attribute.KeyValue{volumeIDAttribute.String("rg#account#share###")}, root.Attributes())
+	assert.Equal(t, otelcodes.Unset, root.Status().Code)
+}
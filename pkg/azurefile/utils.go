@@ -17,23 +17,44 @@ limitations under the License.
 package azurefile
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume"
+	smbglobalmapping "sigs.k8s.io/azurefile-csi-driver/pkg/os/smb"
 )
 
 const (
 	tagsDelimiter        = ","
 	tagKeyValueDelimiter = "="
+
+	// provenance tags set on storage account and file share (as metadata) created by driver
+	createdByDriverVersionTag = "createdByDriverVersion"
+	createdAtTag              = "createdAt"
 )
 
+// share metadata key should follow C# identifier naming rules
+var shareMetadataKeyRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// storage account name should be between 3 and 24 characters and only contain numbers and lowercase letters
+var storageAccountNameRegex = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+
 // lockMap used to lock on entries
 type lockMap struct {
 	sync.Mutex
@@ -91,6 +112,18 @@ func isDiskFsType(fsType string) bool {
 	return false
 }
 
+// getVHDDiskFsType returns the file system type used to format vhd disk when fsType is not set in volume context,
+// ext4 is the default value if fsType is not set in volume capability either
+func getVHDDiskFsType(fsType string) (string, error) {
+	if fsType == "" {
+		return ext4, nil
+	}
+	if !isDiskFsType(fsType) {
+		return "", fmt.Errorf("fsType(%s) is not supported for vhd disk, supported fsType list: %v", fsType, supportedDiskFsTypeList)
+	}
+	return fsType, nil
+}
+
 // File share names can contain only lowercase letters, numbers, and hyphens,
 // and must begin and end with a letter or a number
 func isSupportedShareNamePrefix(prefix string) bool {
@@ -111,6 +144,24 @@ func isSupportedShareNamePrefix(prefix string) bool {
 	return true
 }
 
+// isSupportedShareName returns true if file share name is 3 through 63 characters long,
+// contains only lowercase letters, numbers, and hyphens, begins and ends with a letter or a number,
+// and does not contain two consecutive hyphens
+func isSupportedShareName(name string) bool {
+	if len(name) < fileShareNameMinLength || len(name) > fileShareNameMaxLength {
+		return false
+	}
+	if !checkShareNameBeginAndEnd(name) || strings.Contains(name, "--") {
+		return false
+	}
+	for _, v := range name {
+		if v != '-' && (v < '0' || v > '9') && (v < 'a' || v > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
 func isSupportedFsType(fsType string) bool {
 	if fsType == "" {
 		return true
@@ -134,6 +185,77 @@ func isRetriableError(err error) bool {
 	return false
 }
 
+// isNetworkRulePropagationError returns true if request is denied by network rules of storage account with 403,
+// the error is transient right after network rules are set since they take a while to propagate
+func isNetworkRulePropagationError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, authorizationFailure) && strings.Contains(msg, "403")
+}
+
+// isRetriableMountError returns true if mount error may be transient, mount timeout and authentication errors are not retried
+func isRetriableMountError(err error) bool {
+	if err == nil || isSMBAuthError(err) {
+		return false
+	}
+	if _, ok := status.FromError(err); ok {
+		return false
+	}
+	return true
+}
+
+// isSMBNegotiationError returns true if mount error is caused by SMB protocol negotiation failure,
+// authentication and permission errors are never regarded as negotiation errors
+func isSMBNegotiationError(err error) bool {
+	if err == nil || isSMBAuthError(err) {
+		return false
+	}
+	for _, v := range smbNegotiationErrors {
+		if strings.Contains(err.Error(), v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSMBAuthError returns true if mount error is caused by authentication or permission failure
+func isSMBAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, v := range smbAuthErrors {
+		if strings.Contains(err.Error(), v) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAccountNameUnavailableError(err error) bool {
+	if err != nil {
+		for _, v := range accountNameUnavailableErrors {
+			if strings.Contains(strings.ToLower(err.Error()), strings.ToLower(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isRegionCapacityError returns true if storage account could not be created due to capacity or quota of region
+func isRegionCapacityError(err error) bool {
+	if err != nil {
+		for _, v := range regionCapacityErrors {
+			if strings.Contains(strings.ToLower(err.Error()), strings.ToLower(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func sleepIfThrottled(err error, sleepSec int) {
 	if strings.Contains(strings.ToLower(err.Error()), strings.ToLower(tooManyRequests)) || strings.Contains(strings.ToLower(err.Error()), clientThrottled) {
 		klog.Warningf("sleep %d more seconds, waiting for throttling complete", sleepSec)
@@ -159,6 +281,53 @@ func createStorageAccountSecret(account, key string) map[string]string {
 	return secret
 }
 
+// buildConnectionString returns storage account connection string
+func buildConnectionString(accountName, accountKey, storageEndpointSuffix string) string {
+	return fmt.Sprintf("DefaultEndpointsProtocol=https;AccountName=%s;AccountKey=%s;EndpointSuffix=%s", accountName, accountKey, storageEndpointSuffix)
+}
+
+// parseConnectionString returns account name and account key in storage account connection string
+func parseConnectionString(connectionString string) (string, string, error) {
+	var accountName, accountKey string
+	for _, pair := range strings.Split(connectionString, ";") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return "", "", fmt.Errorf("invalid connection string, %q is not a key=value pair", pair)
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "accountname":
+			accountName = strings.TrimSpace(kv[1])
+		case "accountkey":
+			accountKey = strings.TrimSpace(kv[1])
+		}
+	}
+	if accountName == "" || accountKey == "" {
+		return "", "", fmt.Errorf("could not find AccountName or AccountKey in connection string")
+	}
+	return accountName, accountKey, nil
+}
+
+// getDataPlaneAuth returns how data plane operations (file share creation, mount) authenticate,
+// aad means no account key is used and mount uses identity based auth as disableAccountKeyAuth does
+func getDataPlaneAuth(dataPlaneAuth, protocol string) (string, error) {
+	if dataPlaneAuth == "" {
+		return dataPlaneAuthKey, nil
+	}
+	for _, v := range supportedDataPlaneAuthList {
+		if !strings.EqualFold(dataPlaneAuth, v) {
+			continue
+		}
+		if v == dataPlaneAuthConnectionString && protocol == nfs {
+			return "", fmt.Errorf("dataPlaneAuth(%s) is not supported with nfs protocol since nfs mount does not use account key", v)
+		}
+		return v, nil
+	}
+	return "", fmt.Errorf("dataPlaneAuth(%s) is not supported, supported dataPlaneAuth list: %v", dataPlaneAuth, supportedDataPlaneAuthList)
+}
+
 func ConvertTagsToMap(tags string) (map[string]string, error) {
 	m := make(map[string]string)
 	if tags == "" {
@@ -180,6 +349,119 @@ func ConvertTagsToMap(tags string) (map[string]string, error) {
 	return m, nil
 }
 
+// redactMountOptions returns mount options followed by sensitive mount options in which sensitive values are redacted,
+// values of sensitive keys(e.g. password) in mount options and all values in sensitive mount options are redacted
+func redactMountOptions(mountOptions, sensitiveMountOptions []string) []string {
+	redact := func(option string, sensitive bool) string {
+		opts := strings.Split(option, ",")
+		for i, opt := range opts {
+			if kv := strings.SplitN(opt, "=", 2); len(kv) == 2 {
+				if sensitive || isSensitiveParameter(kv[0]) {
+					opts[i] = kv[0] + "=" + redactedValue
+				}
+			} else if sensitive {
+				opts[i] = redactedValue
+			}
+		}
+		return strings.Join(opts, ",")
+	}
+	result := make([]string, 0, len(mountOptions)+len(sensitiveMountOptions))
+	for _, option := range mountOptions {
+		result = append(result, redact(option, false))
+	}
+	for _, option := range sensitiveMountOptions {
+		result = append(result, redact(option, true))
+	}
+	return result
+}
+
+// parseShareMetadata parses share metadata in 'key1=value1,key2=value2' format
+func parseShareMetadata(metadata string) (map[string]*string, error) {
+	m, err := ConvertTagsToMap(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("share metadata '%s' is invalid, the format should like: 'key1=value1,key2=value2'", metadata)
+	}
+	result := make(map[string]*string, len(m))
+	for k, v := range m {
+		if !shareMetadataKeyRegex.MatchString(k) {
+			return nil, fmt.Errorf("share metadata key(%s) is invalid, it should start with a letter or underscore and only contain letters, numbers and underscores", k)
+		}
+		value := v
+		result[k] = &value
+	}
+	return result, nil
+}
+
+// getProvenanceTags returns tags recording driver version and creation time of resources created by driver
+func getProvenanceTags(now time.Time) map[string]string {
+	return map[string]string{
+		createdByDriverVersionTag: driverVersion,
+		createdAtTag:              now.UTC().Format(time.RFC3339),
+	}
+}
+
+// mergeProvenanceTags adds provenance tags into user tags, user tags with the same key(case insensitive) are not overwritten
+func mergeProvenanceTags(tags, provenanceTags map[string]string) map[string]string {
+	result := make(map[string]string, len(tags)+len(provenanceTags))
+	for k, v := range tags {
+		result[k] = v
+	}
+	for k, v := range provenanceTags {
+		if !containsKeyIgnoreCase(tags, k) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// mergeProvenanceMetadata adds provenance tags into share metadata, user metadata with the same key(case insensitive) is not overwritten
+func mergeProvenanceMetadata(metadata map[string]*string, provenanceTags map[string]string) map[string]*string {
+	result := make(map[string]*string, len(metadata)+len(provenanceTags))
+	keys := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[k] = v
+		keys[k] = ""
+	}
+	for k, v := range provenanceTags {
+		if !containsKeyIgnoreCase(keys, k) {
+			value := v
+			result[k] = &value
+		}
+	}
+	return result
+}
+
+// containsKeyIgnoreCase returns true if key(case insensitive) is in m
+func containsKeyIgnoreCase(m map[string]string, key string) bool {
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowedIPs parses comma separated IP addresses or CIDR ranges, e.g. "10.0.0.1,20.0.0.0/24"
+func parseAllowedIPs(allowedIPs string) ([]string, error) {
+	var ips []string
+	if strings.TrimSpace(allowedIPs) == "" {
+		return ips, nil
+	}
+	for _, ip := range strings.Split(allowedIPs, tagsDelimiter) {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return nil, fmt.Errorf("allowedIPs '%s' is invalid, %s is not a valid IP address or CIDR range", allowedIPs, ip)
+			}
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
 type VolumeMounter struct {
 	path       string
 	attributes volume.Attributes
@@ -238,9 +520,470 @@ func SetVolumeOwnership(path, gid, policy string) error {
 	if policy != "" {
 		fsGroupChangePolicy = v1.PodFSGroupChangePolicy(policy)
 	}
+	// OnRootMismatch is handled by kubelet volume library: recursive change is skipped only if both gid and
+	// permission bits(including setgid) of the root directory already match
 	return volume.SetVolumeOwnership(&VolumeMounter{path: path}, &gidInt64, &fsGroupChangePolicy, nil)
 }
 
+// getPremiumFileShareProvisionedPerformance returns provisioned <IOPS, bandwidth in MiB/s> of premium file share,
+// IOPS = 3000 + 1 * sizeGiB, bandwidth = 100 + ceil(0.04 * sizeGiB) + ceil(0.06 * sizeGiB)
+func getPremiumFileShareProvisionedPerformance(sizeGiB int) (int, int) {
+	if sizeGiB < minimumPremiumShareSize {
+		sizeGiB = minimumPremiumShareSize
+	}
+	iops := 3000 + sizeGiB
+	if iops > maxPremiumFileShareIops {
+		iops = maxPremiumFileShareIops
+	}
+	bandwidth := 100 + (4*sizeGiB+99)/100 + (6*sizeGiB+99)/100
+	if bandwidth > maxPremiumFileShareBandwidthMiBps {
+		bandwidth = maxPremiumFileShareBandwidthMiBps
+	}
+	return iops, bandwidth
+}
+
+// getMountPropagation returns mount flags without mount propagation flags and the propagation of bind mount,
+// the last propagation flag takes effect if there are multiple ones
+func getMountPropagation(mountFlags []string) ([]string, string) {
+	var propagation string
+	var found bool
+	var otherMountFlags []string
+	for _, mountFlag := range mountFlags {
+		for _, flag := range strings.Split(mountFlag, ",") {
+			if v, ok := mountPropagationMap[strings.ToLower(strings.TrimSpace(flag))]; ok {
+				propagation = v
+				found = true
+			} else {
+				otherMountFlags = append(otherMountFlags, flag)
+			}
+		}
+	}
+	if !found {
+		return mountFlags, ""
+	}
+	return otherMountFlags, propagation
+}
+
+// getReadOnlyMountOptions appends "ro" to mount options if readonly is true, "rw" is removed since it conflicts with "ro",
+// so that volume is mounted read-only at client side even if file share or nfs export is writable
+func getReadOnlyMountOptions(mountOptions []string, readonly bool) []string {
+	if !readonly {
+		return mountOptions
+	}
+	var result []string
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			if option = strings.TrimSpace(option); option != "" && option != "rw" && option != "ro" {
+				result = append(result, option)
+			}
+		}
+	}
+	return append(result, "ro")
+}
+
+// validateMountServerAddress checks that mount server address is an IP address or a hostname,
+// it's only supported with nfs protocol
+func validateMountServerAddress(address, protocol string) error {
+	if address == "" {
+		return nil
+	}
+	if protocol != nfs {
+		return fmt.Errorf("%s is only supported with nfs protocol", mountServerAddressField)
+	}
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	if errs := validation.IsDNS1123Subdomain(strings.ToLower(address)); len(errs) > 0 {
+		return fmt.Errorf("invalid %s(%s), it should be an IP address or a hostname: %s", mountServerAddressField, address, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// validateNFSExportPathTemplate checks that NFS export path template is an absolute path which only contains
+// ${account} and ${share} placeholders, it's only supported with nfs protocol
+func validateNFSExportPathTemplate(template, protocol string) error {
+	if template == "" {
+		return nil
+	}
+	if protocol != nfs {
+		return fmt.Errorf("%s is only supported with nfs protocol", nfsExportPathTemplateField)
+	}
+	if !strings.HasPrefix(template, "/") {
+		return fmt.Errorf("invalid %s(%s), it should start with /", nfsExportPathTemplateField, template)
+	}
+	path := strings.NewReplacer(nfsExportPathAccountName, "", nfsExportPathShareName, "").Replace(template)
+	if strings.ContainsAny(path, "${}") {
+		return fmt.Errorf("invalid %s(%s), only %s and %s are supported placeholders", nfsExportPathTemplateField, template, nfsExportPathAccountName, nfsExportPathShareName)
+	}
+	if strings.IndexFunc(path, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) || r == ':' || r == ',' }) >= 0 {
+		return fmt.Errorf("invalid %s(%s), it should not contain whitespace, ':' or ','", nfsExportPathTemplateField, template)
+	}
+	for _, name := range strings.Split(template, "/") {
+		if name == ".." {
+			return fmt.Errorf("invalid %s(%s), it should not contain '..'", nfsExportPathTemplateField, template)
+		}
+	}
+	return nil
+}
+
+// getNFSExportPath returns NFS export path of file share by replacing placeholders in template,
+// default template is used if template is empty, e.g. /${account}/${share} -> /accountname/sharename
+func getNFSExportPath(template, accountName, fileShareName string) string {
+	if template == "" {
+		template = defaultNFSExportPathTemplate
+	}
+	return strings.NewReplacer(nfsExportPathAccountName, accountName, nfsExportPathShareName, fileShareName).Replace(template)
+}
+
+// validateDNSSearchDomain checks that DNS search domain of nfs mount server is a valid domain name, e.g. corp.contoso.com
+func validateDNSSearchDomain(domain, protocol string) error {
+	if domain == "" {
+		return nil
+	}
+	if protocol != nfs {
+		return fmt.Errorf("%s is only supported with nfs protocol", dnsSearchDomainField)
+	}
+	if errs := validation.IsDNS1123Subdomain(strings.ToLower(strings.TrimSuffix(domain, "."))); len(errs) > 0 {
+		return fmt.Errorf("invalid %s(%s): %s", dnsSearchDomainField, domain, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// parseSecretNamespaces parses comma separated secret namespaces in fallback order, empty items are ignored
+func parseSecretNamespaces(secretNamespaces string) []string {
+	var result []string
+	for _, ns := range strings.Split(secretNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			result = append(result, ns)
+		}
+	}
+	return result
+}
+
+// validateSecretNamespaces checks that secret namespaces are valid namespace names without duplicates,
+// they could not be set together with secretNamespace
+func validateSecretNamespaces(secretNamespaces, secretNamespace string) error {
+	if secretNamespaces == "" {
+		return nil
+	}
+	if secretNamespace != "" {
+		return fmt.Errorf("%s and %s could not be set at the same time", secretNamespaceField, secretNamespacesField)
+	}
+	namespaces := parseSecretNamespaces(secretNamespaces)
+	if len(namespaces) == 0 {
+		return fmt.Errorf("%s(%s) should contain at least one namespace", secretNamespacesField, secretNamespaces)
+	}
+	seen := map[string]bool{}
+	for _, ns := range namespaces {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace(%s) in %s: %s", ns, secretNamespacesField, strings.Join(errs, ", "))
+		}
+		if seen[ns] {
+			return fmt.Errorf("duplicate namespace(%s) in %s", ns, secretNamespacesField)
+		}
+		seen[ns] = true
+	}
+	return nil
+}
+
+// getFQDN appends DNS search domain to server name if it's a single label name(e.g. "accountname" -> "accountname.corp.contoso.com"),
+// IP address and name which contains "." are returned as is
+func getFQDN(server, searchDomain string) string {
+	if server == "" || searchDomain == "" || strings.Contains(server, ".") || net.ParseIP(server) != nil {
+		return server
+	}
+	return server + "." + strings.TrimSuffix(searchDomain, ".")
+}
+
+// getNFSServerAddress wraps IPv6 literal in brackets so that it could be used in "server:/path" source of nfs mount
+func getNFSServerAddress(server string) string {
+	if net.ParseIP(server) != nil && strings.Contains(server, ":") {
+		return "[" + server + "]"
+	}
+	return server
+}
+
+// validateNFSACL checks that NFSv4 ACL is only enabled with nfs protocol
+func validateNFSACL(enableNFSACL bool, protocol string) error {
+	if enableNFSACL && protocol != nfs {
+		return fmt.Errorf("%s is only supported with nfs protocol, current protocol: %s", enableNFSACLField, protocol)
+	}
+	return nil
+}
+
+// validateNFSVersion checks that nfs version is supported and only set with nfs protocol, NFSv4 ACL requires nfs v4.1
+func validateNFSVersion(nfsVersion, protocol string, enableNFSACL bool) error {
+	if nfsVersion == "" {
+		return nil
+	}
+	if _, ok := nfsVersionMountOptions[nfsVersion]; !ok {
+		return fmt.Errorf("%s(%s) is not supported, supported versions: [%s %s]", nfsVersionField, nfsVersion, nfsVersion41, nfsVersion3)
+	}
+	if protocol != nfs {
+		return fmt.Errorf("%s is only supported with nfs protocol, current protocol: %s", nfsVersionField, protocol)
+	}
+	if enableNFSACL && nfsVersion != nfsVersion41 {
+		return fmt.Errorf("%s is true, nfs v4.1 is required, current %s: %s", enableNFSACLField, nfsVersionField, nfsVersion)
+	}
+	return nil
+}
+
+// isNFSVersionMountOption returns true if nfs version mount option(vers, nfsvers or minorversion) matches nfsVersion
+func isNFSVersionMountOption(key, value, nfsVersion string) bool {
+	switch key {
+	case "vers", "nfsvers":
+		if nfsVersion == nfsVersion3 {
+			return value == "3"
+		}
+		return value == "4" || value == "4.1"
+	case "minorversion":
+		return nfsVersion == nfsVersion41 && value == "1"
+	}
+	return false
+}
+
+// getNFSMountOptions returns mount options of nfs protocol, version options in mount options must match nfsVersion(v4.1 by default)
+// and they are replaced by default nfs mount options of the version, if NFSv4 ACL is enabled, security flavor must be sys
+func getNFSMountOptions(mountFlags []string, enableNFSACL bool, nfsVersion string) ([]string, error) {
+	if nfsVersion == "" {
+		nfsVersion = nfsVersion41
+	}
+	var mountOptions []string
+	for _, mountFlag := range mountFlags {
+		for _, option := range strings.Split(mountFlag, ",") {
+			option = strings.TrimSpace(option)
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "":
+				continue
+			case "vers", "nfsvers", "minorversion":
+				if !isNFSVersionMountOption(key, value, nfsVersion) {
+					if enableNFSACL {
+						return nil, fmt.Errorf("mount option(%s) is not supported when %s is true, nfs v4.1 is required", option, enableNFSACLField)
+					}
+					return nil, fmt.Errorf("mount option(%s) conflicts with %s(%s), set %s to use another nfs version", option, nfsVersionField, nfsVersion, nfsVersionField)
+				}
+				continue
+			case "sec":
+				if enableNFSACL {
+					if value != "sys" {
+						return nil, fmt.Errorf("mount option(%s) is not supported when %s is true, only sec=sys is supported", option, enableNFSACLField)
+					}
+					continue
+				}
+			case "noacl":
+				if enableNFSACL {
+					return nil, fmt.Errorf("mount option(%s) is not supported when %s is true", option, enableNFSACLField)
+				}
+			}
+			mountOptions = append(mountOptions, option)
+		}
+	}
+	return append(mountOptions, nfsVersionMountOptions[nfsVersion]), nil
+}
+
+// getAccountKeyAuthDisabledBy returns the parameter which disables account key auth (disableAccountKeyAuth or dataPlaneAuth(aad)),
+// empty string means account key auth is not disabled
+func getAccountKeyAuthDisabledBy(disableAccountKeyAuth bool, dataPlaneAuth string) string {
+	if disableAccountKeyAuth {
+		return disableAccountKeyAuthField
+	}
+	if strings.EqualFold(dataPlaneAuth, dataPlaneAuthAAD) {
+		return fmt.Sprintf("dataPlaneAuth(%s)", dataPlaneAuthAAD)
+	}
+	return ""
+}
+
+// validateDisableAccountKeyAuth checks that no feature which requires account key is used when account key auth is disabled by disabledBy
+func validateDisableAccountKeyAuth(disabledBy, dataPlaneAuth, fsType string, useDataPlaneAPI, generateSASToken bool) error {
+	if useDataPlaneAPI {
+		return fmt.Errorf("%s is not supported with useDataPlaneAPI since data plane API requires account key", disabledBy)
+	}
+	if strings.EqualFold(dataPlaneAuth, dataPlaneAuthConnectionString) {
+		return fmt.Errorf("%s is not supported with dataPlaneAuth(%s) since connection string contains account key", disabledBy, dataPlaneAuth)
+	}
+	if generateSASToken {
+		return fmt.Errorf("%s is not supported with generateSasToken since SAS token is signed by account key", disabledBy)
+	}
+	if isDiskFsType(fsType) {
+		return fmt.Errorf("%s is not supported with vhd disk fsType(%s) since vhd disk is created with account key", disabledBy, fsType)
+	}
+	return nil
+}
+
+// getIdentityAuthMethod returns identity based auth method of mount when account key auth is disabled by disabledBy,
+// nfs mount is authorized by network, smb mount requires kerberos auth with sec=krb5 or sec=krb5i mount option
+func getIdentityAuthMethod(disabledBy, protocol string, mountOptions []string) (string, error) {
+	if protocol == nfs {
+		return identityAuthNetwork, nil
+	}
+	if hasMountOption(mountOptions, "sec=krb5") || hasMountOption(mountOptions, "sec=krb5i") {
+		return identityAuthKerberos, nil
+	}
+	return "", fmt.Errorf("account key auth is disabled by %s while no identity based auth is available, use nfs protocol or sec=krb5 mount option with smb protocol", disabledBy)
+}
+
+// validateStorageAccountName checks that storage account name is between 3 and 24 characters and only contains numbers and lowercase letters
+func validateStorageAccountName(name string) error {
+	if !storageAccountNameRegex.MatchString(name) {
+		return fmt.Errorf("storage account name(%s) should be between 3 and 24 characters and only contain numbers and lowercase letters", name)
+	}
+	return nil
+}
+
+// isBusyUnmountError checks whether unmount failed since target is still used by processes(EBUSY)
+func isBusyUnmountError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.EBUSY) {
+		return true
+	}
+	errMsg := strings.ToLower(err.Error())
+	return strings.Contains(errMsg, "target is busy") || strings.Contains(errMsg, "device is busy") || strings.Contains(errMsg, "device or resource busy")
+}
+
+// getProtocolByNodeOS resolves protocol(auto) by node OS, smb is used on windows node and nfs is used on other nodes,
+// other protocols are returned as is
+func getProtocolByNodeOS(protocol, nodeOS string) string {
+	if protocol != auto {
+		return protocol
+	}
+	if strings.EqualFold(nodeOS, "windows") {
+		return smb
+	}
+	return nfs
+}
+
+// validateUseCredentialFile checks that cifs credential file is only used with smb protocol
+func validateUseCredentialFile(useCredentialFile bool, protocol string) error {
+	if useCredentialFile && protocol == nfs {
+		return fmt.Errorf("%s is only supported with smb protocol", useCredentialFileField)
+	}
+	return nil
+}
+
+// parseSDDL splits security descriptor in SDDL format into components keyed by owner(O), group(G), DACL(D) and SACL(S),
+// e.g. O:BAG:SYD:P(A;OICI;FA;;;BA) is split into O: BA, G: SY, D: P(A;OICI;FA;;;BA)
+func parseSDDL(sddl string) (map[string]string, error) {
+	components := map[string]string{}
+	current := ""
+	start, depth := 0, 0
+	for i := 0; i < len(sddl); i++ {
+		switch c := sddl[i]; {
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in SDDL(%s)", sddl)
+			}
+		case depth == 0 && i+1 < len(sddl) && sddl[i+1] == ':' && strings.ContainsRune("OGDS", rune(c)):
+			if current != "" {
+				components[current] = sddl[start:i]
+			} else if i != 0 {
+				return nil, fmt.Errorf("SDDL(%s) should start with one of O:, G:, D:, S:", sddl)
+			}
+			current = string(c)
+			if _, ok := components[current]; ok {
+				return nil, fmt.Errorf("duplicate %s: in SDDL(%s)", current, sddl)
+			}
+			start = i + 2
+			i++
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in SDDL(%s)", sddl)
+	}
+	if current == "" {
+		return nil, fmt.Errorf("SDDL(%s) should start with one of O:, G:, D:, S:", sddl)
+	}
+	components[current] = sddl[start:]
+	return components, nil
+}
+
+// validateRootDirACL checks that ACL of root directory is in SDDL format, it's only supported with smb protocol
+func validateRootDirACL(acl, protocol string) error {
+	if acl == "" {
+		return nil
+	}
+	if protocol == nfs {
+		return fmt.Errorf("%s is only supported with smb protocol", rootDirACLField)
+	}
+	if _, err := parseSDDL(acl); err != nil {
+		return fmt.Errorf("invalid %s: %v", rootDirACLField, err)
+	}
+	return nil
+}
+
+// shouldApplyRootDirACL returns whether acl should be applied on root directory whose ACL is currentACL,
+// only components set in acl are compared (case insensitive) so that applying the same acl again is skipped
+func shouldApplyRootDirACL(acl, currentACL string) (bool, error) {
+	if acl == "" {
+		return false, nil
+	}
+	expected, err := parseSDDL(acl)
+	if err != nil {
+		return false, err
+	}
+	current, err := parseSDDL(currentACL)
+	if err != nil {
+		klog.Warningf("failed to parse current ACL(%s) of root directory: %v", currentACL, err)
+		return true, nil
+	}
+	for k, v := range expected {
+		if !strings.EqualFold(v, current[k]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getCredentialFilePath returns path of cifs credential file of volume staged on stagingPath,
+// it's in the same volume directory as stagingPath which is owned by driver
+func getCredentialFilePath(stagingPath string) string {
+	return filepath.Join(filepath.Dir(stagingPath), credentialFile)
+}
+
+// writeCredentialFile writes account name and key in cifs credential file which is only accessible by owner,
+// credential file is replaced atomically so that concurrent mount never reads a partially written file
+func writeCredentialFile(path, accountName, accountKey string) error {
+	if accountName == "" || accountKey == "" {
+		return fmt.Errorf("accountName(%s) or accountKey is empty", accountName)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), credentialFile+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	if err = f.Chmod(0600); err == nil {
+		if _, err = fmt.Fprintf(f, "username=%s\npassword=%s\n", accountName, accountKey); err == nil {
+			err = f.Sync()
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			klog.Warningf("failed to remove temporary credential file(%s): %v", tmpPath, removeErr)
+		}
+		return err
+	}
+	klog.V(2).Infof("wrote credential file(%s) of account(%s)", path, accountName)
+	return nil
+}
+
+// removeCredentialFile removes cifs credential file, it's not an error if it does not exist
+func removeCredentialFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // setKeyValueInMap set key/value pair in map
 // key in the map is case insensitive, if key already exists, overwrite existing value
 func setKeyValueInMap(m map[string]string, key, value string) {
@@ -265,3 +1008,166 @@ func replaceWithMap(str string, m map[string]string) string {
 	}
 	return str
 }
+
+// parseOperationTimeout parses operationTimeout parameter in duration format, e.g. "10m",
+// the timeout is capped by maxTimeout
+func parseOperationTimeout(value string, maxTimeout time.Duration) (time.Duration, error) {
+	return parseTimeoutParameter(operationTimeoutField, value, maxTimeout)
+}
+
+// parseMountTimeout parses mountTimeout parameter(e.g. "30s") which is capped by maxMountTimeout
+func parseMountTimeout(value string) (time.Duration, error) {
+	return parseTimeoutParameter(mountTimeoutField, value, maxMountTimeout)
+}
+
+// parseTimeoutParameter parses positive duration of timeout parameter(field), maxTimeout is returned if it exceeds maxTimeout
+func parseTimeoutParameter(field, value string, maxTimeout time.Duration) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s, error: %v", field, value, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s, it should be positive", field, value)
+	}
+	if timeout > maxTimeout {
+		klog.Warningf("%s(%v) exceeds max timeout(%v), use %v instead", field, timeout, maxTimeout, maxTimeout)
+		return maxTimeout, nil
+	}
+	return timeout, nil
+}
+
+// parseKeyExpirationDays parses keyExpirationDays parameter which should be an integer in range [minKeyExpirationDays, maxKeyExpirationDays]
+func parseKeyExpirationDays(value string) (int32, error) {
+	days, err := strconv.Atoi(value)
+	if err != nil || days < minKeyExpirationDays || days > maxKeyExpirationDays {
+		return 0, fmt.Errorf("invalid %s: %s, it should be an integer in range [%d, %d]", keyExpirationDaysField, value, minKeyExpirationDays, maxKeyExpirationDays)
+	}
+	return int32(days), nil
+}
+
+// validateUseKey checks useKey parameter which is key1 or key2, it could not be set with keyVaultURL
+// since account key in key vault is used instead, and the key should be stored in k8s secret which is read in mount
+func validateUseKey(useKey, keyVaultURL string, storeAccountKey bool) error {
+	if useKey == "" {
+		return nil
+	}
+	if !strings.EqualFold(useKey, accountKey1) && !strings.EqualFold(useKey, accountKey2) {
+		return fmt.Errorf("invalid %s: %s, supported values: [%s %s]", useKeyField, useKey, accountKey1, accountKey2)
+	}
+	if keyVaultURL != "" {
+		return fmt.Errorf("%s could not be set with %s", useKeyField, keyVaultURLField)
+	}
+	if !storeAccountKey {
+		return fmt.Errorf("%s requires account key stored in k8s secret, could not be set with %s(false)", useKeyField, storeAccountKeyField)
+	}
+	return nil
+}
+
+// detachedContext keeps values of parent context while ignoring its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// extendContextTimeout returns a context which expires after timeout if the deadline of ctx is earlier,
+// otherwise deadline of ctx is kept
+func extendContextTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); !ok || !deadline.Before(time.Now().Add(timeout)) {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(detachedContext{parent: ctx}, timeout)
+}
+
+// validateCapacityRange validates capacity range and returns the effective size in bytes,
+// RequiredBytes is preferred, LimitBytes is used if RequiredBytes is not set, 0 means capacity range is not set
+func validateCapacityRange(capRange *csi.CapacityRange) (int64, error) {
+	if capRange == nil {
+		return 0, nil
+	}
+	requiredBytes, limitBytes := capRange.GetRequiredBytes(), capRange.GetLimitBytes()
+	if requiredBytes < 0 {
+		return 0, fmt.Errorf("RequiredBytes(%d) in capacity range must not be negative", requiredBytes)
+	}
+	if limitBytes < 0 {
+		return 0, fmt.Errorf("LimitBytes(%d) in capacity range must not be negative", limitBytes)
+	}
+	if limitBytes > 0 && requiredBytes > limitBytes {
+		return 0, fmt.Errorf("RequiredBytes(%d) in capacity range must not be larger than LimitBytes(%d)", requiredBytes, limitBytes)
+	}
+	if requiredBytes > 0 {
+		return requiredBytes, nil
+	}
+	return limitBytes, nil
+}
+
+// validateNFSMountOptions validates soft, hard, timeo and retrans mount options, which are only supported by nfs protocol
+func validateNFSMountOptions(mountOptions []string, protocol string) error {
+	var soft, hard bool
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+			switch key {
+			case softMountOption, hardMountOption, timeoMountOption, retransMountOption:
+				if protocol != nfs {
+					return fmt.Errorf("mount option(%s) is only supported with nfs protocol, current protocol: %s", key, protocol)
+				}
+			default:
+				continue
+			}
+			switch key {
+			case softMountOption:
+				soft = true
+			case hardMountOption:
+				hard = true
+			default:
+				if v, err := strconv.Atoi(value); err != nil || v <= 0 {
+					return fmt.Errorf("mount option(%s) is invalid, %s should be a positive integer", option, key)
+				}
+			}
+		}
+	}
+	if soft && hard {
+		return fmt.Errorf("mount options %s and %s could not be set at the same time", softMountOption, hardMountOption)
+	}
+	if soft {
+		klog.V(2).Infof("warning: %s mount option is set, nfs requests would fail after %s retries on server issues, which may cause data corruption for applications which are not tolerant of I/O errors", softMountOption, retransMountOption)
+	}
+	return nil
+}
+
+// parseOSMountOptions parses comma separated mount options of windowsMountOptions or linuxMountOptions parameter,
+// credentials are not allowed since they are always passed by driver
+func parseOSMountOptions(field, value string) ([]string, error) {
+	var mountOptions []string
+	for _, mountOption := range strings.Split(value, ",") {
+		mountOption = strings.TrimSpace(mountOption)
+		if mountOption == "" {
+			continue
+		}
+		if strings.ContainsAny(mountOption, " \t\n") {
+			return nil, fmt.Errorf("invalid mount option(%s) in %s", mountOption, field)
+		}
+		key := strings.ToLower(strings.SplitN(mountOption, "=", 2)[0])
+		if key == "username" || key == "password" || key == "credentials" {
+			return nil, fmt.Errorf("mount option(%s) is not allowed in %s", key, field)
+		}
+		if field == windowsMountOptionsField && !smbglobalmapping.IsSupportedGlobalMappingOption(mountOption) {
+			return nil, fmt.Errorf("mount option(%s) is not supported in %s, supported options: %v", mountOption, field, smbglobalmapping.SupportedGlobalMappingOptions())
+		}
+		mountOptions = append(mountOptions, mountOption)
+	}
+	return mountOptions, nil
+}
+
+// getOSMountOptions returns mount options of the node OS(goos), windowsMountOptions is only used on windows nodes
+// and linuxMountOptions is only used on other nodes, so that one storage class could serve mixed clusters
+func getOSMountOptions(goos, windowsMountOptions, linuxMountOptions string) ([]string, error) {
+	if goos == "windows" {
+		return parseOSMountOptions(windowsMountOptionsField, windowsMountOptions)
+	}
+	return parseOSMountOptions(linuxMountOptionsField, linuxMountOptions)
+}
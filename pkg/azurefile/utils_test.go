@@ -17,15 +17,23 @@ limitations under the License.
 package azurefile
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	utiltesting "k8s.io/client-go/util/testing"
+	"k8s.io/utils/pointer"
 )
 
 func TestSimpleLockEntry(t *testing.T) {
@@ -190,6 +198,57 @@ func TestIsSupportedShareNamePrefix(t *testing.T) {
 	}
 }
 
+func TestIsSupportedShareName(t *testing.T) {
+	tests := []struct {
+		name           string
+		expectedResult bool
+	}{
+		{
+			name:           "pvc-file-share",
+			expectedResult: true,
+		},
+		{
+			name:           "abc",
+			expectedResult: true,
+		},
+		{
+			name:           "ab",
+			expectedResult: false,
+		},
+		{
+			name:           strings.Repeat("a", 64),
+			expectedResult: false,
+		},
+		{
+			name:           "-share",
+			expectedResult: false,
+		},
+		{
+			name:           "share-",
+			expectedResult: false,
+		},
+		{
+			name:           "file--share",
+			expectedResult: false,
+		},
+		{
+			name:           "File-Share",
+			expectedResult: false,
+		},
+		{
+			name:           "file.share",
+			expectedResult: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isSupportedShareName(test.name)
+		if result != test.expectedResult {
+			t.Errorf("isSupportedShareName(%s) returned with %v, not equal to %v", test.name, result, test.expectedResult)
+		}
+	}
+}
+
 func TestIsSupportedFsType(t *testing.T) {
 	tests := []struct {
 		fsType         string
@@ -318,6 +377,52 @@ func TestIsRetriableError(t *testing.T) {
 	}
 }
 
+func TestIsNetworkRulePropagationError(t *testing.T) {
+	tests := []struct {
+		desc         string
+		err          error
+		expectedBool bool
+	}{
+		{
+			desc:         "nil error",
+			err:          nil,
+			expectedBool: false,
+		},
+		{
+			desc:         "denied by network rules in data plane API",
+			err:          errors.New("failed to create file share, err: storage: service returned error: StatusCode=403, ErrorCode=AuthorizationFailure, ErrorMessage=This request is not authorized to perform this operation."),
+			expectedBool: true,
+		},
+		{
+			desc:         "denied by network rules in management API",
+			err:          errors.New("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 403, RawError: {\"error\":{\"code\":\"AuthorizationFailure\",\"message\":\"This request is not authorized to perform this operation.\"}}"),
+			expectedBool: true,
+		},
+		{
+			desc:         "denied by RBAC",
+			err:          errors.New("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 403, RawError: {\"error\":{\"code\":\"AuthorizationFailed\",\"message\":\"The client does not have authorization to perform action\"}}"),
+			expectedBool: false,
+		},
+		{
+			desc:         "invalid account key",
+			err:          errors.New("storage: service returned error: StatusCode=403, ErrorCode=AuthenticationFailed, ErrorMessage=Server failed to authenticate the request."),
+			expectedBool: false,
+		},
+		{
+			desc:         "throttled",
+			err:          errors.New("Retriable: true, RetryAfter: 0s, HTTPStatusCode: 429, RawError: TooManyRequests"),
+			expectedBool: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isNetworkRulePropagationError(test.err)
+		if result != test.expectedBool {
+			t.Errorf("desc: (%s), isNetworkRulePropagationError returned with bool(%v), not equal to expectedBool(%v)", test.desc, result, test.expectedBool)
+		}
+	}
+}
+
 func TestSleepIfThrottled(t *testing.T) {
 	start := time.Now()
 	sleepIfThrottled(errors.New("tooManyRequests"), 10)
@@ -374,218 +479,2327 @@ func TestConvertTagsToMap(t *testing.T) {
 	}
 }
 
-func TestChmodIfPermissionMismatch(t *testing.T) {
-	permissionMatchingPath, _ := getWorkDirPath("permissionMatchingPath")
-	_ = makeDir(permissionMatchingPath, 0755)
-	defer os.RemoveAll(permissionMatchingPath)
+func TestParseConnectionString(t *testing.T) {
+	tests := []struct {
+		connectionString    string
+		expectedAccountName string
+		expectedAccountKey  string
+		expectedError       error
+	}{
+		{
+			connectionString:    buildConnectionString("account", "key==", "core.windows.net"),
+			expectedAccountName: "account",
+			expectedAccountKey:  "key==",
+		},
+		{
+			connectionString:    "accountname=account;accountkey=key;",
+			expectedAccountName: "account",
+			expectedAccountKey:  "key",
+		},
+		{
+			connectionString: "AccountName=account;invalid",
+			expectedError:    fmt.Errorf("invalid connection string, \"invalid\" is not a key=value pair"),
+		},
+		{
+			connectionString: "AccountName=account;EndpointSuffix=core.windows.net",
+			expectedError:    fmt.Errorf("could not find AccountName or AccountKey in connection string"),
+		},
+	}
 
-	permissionMismatchPath, _ := getWorkDirPath("permissionMismatchPath")
-	_ = makeDir(permissionMismatchPath, 0721)
-	defer os.RemoveAll(permissionMismatchPath)
+	for _, test := range tests {
+		accountName, accountKey, err := parseConnectionString(test.connectionString)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("connectionString(%s): unexpected error: %v, expected error: %v", test.connectionString, err, test.expectedError)
+		}
+		if accountName != test.expectedAccountName || accountKey != test.expectedAccountKey {
+			t.Errorf("connectionString(%s): unexpected result: (%s, %s), expected result: (%s, %s)", test.connectionString, accountName, accountKey, test.expectedAccountName, test.expectedAccountKey)
+		}
+	}
+}
 
+func TestGetDataPlaneAuth(t *testing.T) {
 	tests := []struct {
 		desc          string
-		path          string
-		mode          os.FileMode
+		dataPlaneAuth string
+		protocol      string
+		expected      string
 		expectedError error
 	}{
 		{
-			desc:          "Invalid path",
-			path:          "invalid-path",
-			mode:          0755,
-			expectedError: fmt.Errorf("CreateFile invalid-path: The system cannot find the file specified"),
+			desc:     "key is the default value",
+			protocol: smb,
+			expected: dataPlaneAuthKey,
 		},
 		{
-			desc:          "permission matching path",
-			path:          permissionMatchingPath,
-			mode:          0755,
-			expectedError: nil,
+			desc:          "key",
+			dataPlaneAuth: "Key",
+			protocol:      nfs,
+			expected:      dataPlaneAuthKey,
 		},
 		{
-			desc:          "permission mismatch path",
-			path:          permissionMismatchPath,
-			mode:          0755,
-			expectedError: nil,
+			desc:          "connection string with smb protocol",
+			dataPlaneAuth: "connectionstring",
+			protocol:      smb,
+			expected:      dataPlaneAuthConnectionString,
+		},
+		{
+			desc:          "connection string with nfs protocol",
+			dataPlaneAuth: "connectionString",
+			protocol:      nfs,
+			expectedError: fmt.Errorf("dataPlaneAuth(connectionString) is not supported with nfs protocol since nfs mount does not use account key"),
+		},
+		{
+			desc:          "aad with nfs protocol",
+			dataPlaneAuth: "AAD",
+			protocol:      nfs,
+			expected:      dataPlaneAuthAAD,
+		},
+		{
+			desc:          "aad with smb protocol",
+			dataPlaneAuth: "aad",
+			protocol:      smb,
+			expected:      dataPlaneAuthAAD,
+		},
+		{
+			desc:          "unsupported value",
+			dataPlaneAuth: "sas",
+			protocol:      smb,
+			expectedError: fmt.Errorf("dataPlaneAuth(sas) is not supported, supported dataPlaneAuth list: %v", supportedDataPlaneAuthList),
 		},
 	}
 
 	for _, test := range tests {
-		err := chmodIfPermissionMismatch(test.path, test.mode)
+		result, err := getDataPlaneAuth(test.dataPlaneAuth, test.protocol)
 		if !reflect.DeepEqual(err, test.expectedError) {
-			if err == nil || test.expectedError == nil && !strings.Contains(err.Error(), test.expectedError.Error()) {
-				t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
-			}
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
+		}
+		if result != test.expected {
+			t.Errorf("test[%s]: unexpected result: %s, expected result: %s", test.desc, result, test.expected)
 		}
 	}
 }
 
-// getWorkDirPath returns the path to the current working directory
-func getWorkDirPath(dir string) (string, error) {
-	path, err := os.Getwd()
-	if err != nil {
-		return "", err
+func TestIsSMBNegotiationError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      nil,
+			expected: false,
+		},
+		{
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(95): Operation not supported"),
+			expected: true,
+		},
+		{
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(112): Host is down"),
+			expected: true,
+		},
+		{
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(13): Permission denied"),
+			expected: false,
+		},
+		{
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(1): Operation not permitted"),
+			expected: false,
+		},
+		{
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(126): Required key not available"),
+			expected: false,
+		},
+		{
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(2): No such file or directory"),
+			expected: false,
+		},
 	}
-	return fmt.Sprintf("%s%c%s", path, os.PathSeparator, dir), nil
-}
 
-func TestSetVolumeOwnership(t *testing.T) {
-	tmpVDir, err := utiltesting.MkTmpdir("SetVolumeOwnership")
-	if err != nil {
-		t.Fatalf("can't make a temp dir: %v", err)
+	for _, test := range tests {
+		result := isSMBNegotiationError(test.err)
+		if result != test.expected {
+			t.Errorf("isSMBNegotiationError(%v) returned with %v, not equal to %v", test.err, result, test.expected)
+		}
 	}
-	//deferred clean up
-	defer os.RemoveAll(tmpVDir)
+}
 
+func TestIsSMBAuthError(t *testing.T) {
 	tests := []struct {
-		path                string
-		gid                 string
-		fsGroupChangePolicy string
-		expectedError       error
+		err      error
+		expected bool
 	}{
 		{
-			path:          "path",
-			gid:           "",
-			expectedError: fmt.Errorf("convert %s to int failed with %v", "", `strconv.Atoi: parsing "": invalid syntax`),
-		},
-		{
-			path:          "path",
-			gid:           "alpha",
-			expectedError: fmt.Errorf("convert %s to int failed with %v", "alpha", `strconv.Atoi: parsing "alpha": invalid syntax`),
+			err:      nil,
+			expected: false,
 		},
 		{
-			path:          "not-exists",
-			gid:           "1000",
-			expectedError: fmt.Errorf("lstat not-exists: no such file or directory"),
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(13): Permission denied"),
+			expected: true,
 		},
 		{
-			path:          tmpVDir,
-			gid:           "1000",
-			expectedError: nil,
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(1): Operation not permitted"),
+			expected: true,
 		},
 		{
-			path:                tmpVDir,
-			gid:                 "1000",
-			fsGroupChangePolicy: "Always",
-			expectedError:       nil,
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(126): Required key not available"),
+			expected: true,
 		},
 		{
-			path:                tmpVDir,
-			gid:                 "1000",
-			fsGroupChangePolicy: "OnRootMismatch",
-			expectedError:       nil,
+			err:      errors.New("mount failed: exit status 32\nOutput: mount error(112): Host is down"),
+			expected: false,
 		},
 	}
 
 	for _, test := range tests {
-		err := SetVolumeOwnership(test.path, test.gid, test.fsGroupChangePolicy)
-		if err != nil && (err.Error() != test.expectedError.Error()) {
-			t.Errorf("unexpected error: %v, expected error: %v", err, test.expectedError)
+		result := isSMBAuthError(test.err)
+		if result != test.expected {
+			t.Errorf("isSMBAuthError(%v) returned with %v, not equal to %v", test.err, result, test.expected)
 		}
 	}
 }
 
-func TestSetKeyValueInMap(t *testing.T) {
+func TestValidateMountServerAddress(t *testing.T) {
 	tests := []struct {
-		desc     string
-		m        map[string]string
-		key      string
-		value    string
-		expected map[string]string
+		desc        string
+		address     string
+		protocol    string
+		expectedErr error
 	}{
 		{
-			desc:  "nil map",
-			key:   "key",
-			value: "value",
+			desc:        "empty address",
+			address:     "",
+			protocol:    smb,
+			expectedErr: nil,
 		},
 		{
-			desc:     "empty map",
-			m:        map[string]string{},
-			key:      "key",
-			value:    "value",
-			expected: map[string]string{"key": "value"},
+			desc:        "IP address",
+			address:     "10.0.0.4",
+			protocol:    nfs,
+			expectedErr: nil,
 		},
 		{
-			desc:  "non-empty map",
-			m:     map[string]string{"k": "v"},
-			key:   "key",
-			value: "value",
-			expected: map[string]string{
-				"k":   "v",
-				"key": "value",
-			},
+			desc:        "hostname",
+			address:     "NFS-Relay.example.com",
+			protocol:    nfs,
+			expectedErr: nil,
 		},
 		{
-			desc:     "same key already exists",
-			m:        map[string]string{"subDir": "value2"},
-			key:      "subDir",
-			value:    "value",
-			expected: map[string]string{"subDir": "value"},
+			desc:        "invalid hostname",
+			address:     "invalid_host!",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("invalid mountserveraddress(invalid_host!), it should be an IP address or a hostname: a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')"),
 		},
 		{
-			desc:     "case insensitive key already exists",
-			m:        map[string]string{"subDir": "value2"},
-			key:      "subdir",
-			value:    "value",
-			expected: map[string]string{"subDir": "value"},
+			desc:        "smb protocol",
+			address:     "10.0.0.4",
+			protocol:    smb,
+			expectedErr: fmt.Errorf("mountserveraddress is only supported with nfs protocol"),
 		},
 	}
 
 	for _, test := range tests {
-		setKeyValueInMap(test.m, test.key, test.value)
-		if !reflect.DeepEqual(test.m, test.expected) {
-			t.Errorf("test[%s]: unexpected output: %v, expected result: %v", test.desc, test.m, test.expected)
+		err := validateMountServerAddress(test.address, test.protocol)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
 		}
 	}
 }
 
-func TestReplaceWithMap(t *testing.T) {
+func TestValidateNFSExportPathTemplate(t *testing.T) {
 	tests := []struct {
-		desc     string
-		str      string
-		m        map[string]string
-		expected string
+		desc        string
+		template    string
+		protocol    string
+		expectedErr error
 	}{
 		{
-			desc:     "empty string",
-			str:      "",
-			expected: "",
+			desc:     "empty template",
+			protocol: smb,
 		},
 		{
-			desc:     "empty map",
-			str:      "",
-			m:        map[string]string{},
-			expected: "",
+			desc:     "standard layout",
+			template: "/${account}/${share}",
+			protocol: nfs,
 		},
 		{
-			desc:     "empty key",
-			str:      "prefix-" + pvNameMetadata,
-			m:        map[string]string{"": "pv"},
-			expected: "prefix-" + pvNameMetadata,
+			desc:     "share path without account",
+			template: "/exports/${share}",
+			protocol: nfs,
 		},
 		{
-			desc:     "empty value",
-			str:      "prefix-" + pvNameMetadata,
-			m:        map[string]string{pvNameMetadata: ""},
-			expected: "prefix-",
+			desc:     "account root",
+			template: "/${account}",
+			protocol: nfs,
 		},
 		{
-			desc:     "one replacement",
-			str:      "prefix-" + pvNameMetadata,
-			m:        map[string]string{pvNameMetadata: "pv"},
-			expected: "prefix-pv",
+			desc:        "smb protocol",
+			template:    "/${account}/${share}",
+			protocol:    smb,
+			expectedErr: fmt.Errorf("nfsexportpathtemplate is only supported with nfs protocol"),
 		},
 		{
-			desc:     "multiple replacements",
-			str:      pvcNamespaceMetadata + pvcNameMetadata,
-			m:        map[string]string{pvcNamespaceMetadata: "namespace", pvcNameMetadata: "pvcname"},
-			expected: "namespacepvcname",
+			desc:        "relative path",
+			template:    "${account}/${share}",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("invalid nfsexportpathtemplate(${account}/${share}), it should start with /"),
+		},
+		{
+			desc:        "unknown placeholder",
+			template:    "/${account}/${pvc.metadata.name}",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("invalid nfsexportpathtemplate(/${account}/${pvc.metadata.name}), only ${account} and ${share} are supported placeholders"),
+		},
+		{
+			desc:        "whitespace",
+			template:    "/${account} /${share}",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("invalid nfsexportpathtemplate(/${account} /${share}), it should not contain whitespace, ':' or ','"),
+		},
+		{
+			desc:        "path traversal",
+			template:    "/${account}/../${share}",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("invalid nfsexportpathtemplate(/${account}/../${share}), it should not contain '..'"),
 		},
 	}
 
 	for _, test := range tests {
-		result := replaceWithMap(test.str, test.m)
-		if result != test.expected {
+		err := validateNFSExportPathTemplate(test.template, test.protocol)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+	}
+}
+
+func TestGetNFSExportPath(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{
+			template: "",
+			expected: "/account/share",
+		},
+		{
+			template: "/${account}/${share}",
+			expected: "/account/share",
+		},
+		{
+			template: "/${share}",
+			expected: "/share",
+		},
+		{
+			template: "/${account}",
+			expected: "/account",
+		},
+		{
+			template: "/exports/${account}-${share}/data",
+			expected: "/exports/account-share/data",
+		},
+	}
+
+	for _, test := range tests {
+		result := getNFSExportPath(test.template, "account", "share")
+		if result != test.expected {
+			t.Errorf("template(%s): unexpected result: %s, expected: %s", test.template, result, test.expected)
+		}
+	}
+}
+
+func TestValidateDNSSearchDomain(t *testing.T) {
+	tests := []struct {
+		domain      string
+		protocol    string
+		expectedErr error
+	}{
+		{
+			domain:   "",
+			protocol: smb,
+		},
+		{
+			domain:   "corp.contoso.com",
+			protocol: nfs,
+		},
+		{
+			domain:   "Corp.Contoso.com.",
+			protocol: nfs,
+		},
+		{
+			domain:      "corp.contoso.com",
+			protocol:    smb,
+			expectedErr: fmt.Errorf("dnssearchdomain is only supported with nfs protocol"),
+		},
+		{
+			domain:      "-corp.com",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("invalid dnssearchdomain(-corp.com): a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateDNSSearchDomain(test.domain, test.protocol)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("validateDNSSearchDomain(%s, %s) returned with error: %v, expected error: %v", test.domain, test.protocol, err, test.expectedErr)
+		}
+	}
+}
+
+func TestGetFQDN(t *testing.T) {
+	tests := []struct {
+		server       string
+		searchDomain string
+		expected     string
+	}{
+		{
+			server:   "account",
+			expected: "account",
+		},
+		{
+			server:       "account",
+			searchDomain: "corp.contoso.com",
+			expected:     "account.corp.contoso.com",
+		},
+		{
+			server:       "account",
+			searchDomain: "corp.contoso.com.",
+			expected:     "account.corp.contoso.com",
+		},
+		{
+			server:       "account.file.core.windows.net",
+			searchDomain: "corp.contoso.com",
+			expected:     "account.file.core.windows.net",
+		},
+		{
+			server:       "10.0.0.4",
+			searchDomain: "corp.contoso.com",
+			expected:     "10.0.0.4",
+		},
+		{
+			server:       "fd00::4",
+			searchDomain: "corp.contoso.com",
+			expected:     "fd00::4",
+		},
+		{
+			server:       "",
+			searchDomain: "corp.contoso.com",
+			expected:     "",
+		},
+	}
+
+	for _, test := range tests {
+		result := getFQDN(test.server, test.searchDomain)
+		if result != test.expected {
+			t.Errorf("getFQDN(%s, %s) returned %s, expected %s", test.server, test.searchDomain, result, test.expected)
+		}
+	}
+}
+
+func TestGetNFSServerAddress(t *testing.T) {
+	tests := []struct {
+		server   string
+		expected string
+	}{
+		{
+			server:   "account.file.core.windows.net",
+			expected: "account.file.core.windows.net",
+		},
+		{
+			server:   "10.0.0.4",
+			expected: "10.0.0.4",
+		},
+		{
+			server:   "fd00::4",
+			expected: "[fd00::4]",
+		},
+		{
+			server:   "::ffff:10.0.0.4",
+			expected: "[::ffff:10.0.0.4]",
+		},
+	}
+
+	for _, test := range tests {
+		result := getNFSServerAddress(test.server)
+		if result != test.expected {
+			t.Errorf("getNFSServerAddress(%s) returned %s, expected %s", test.server, result, test.expected)
+		}
+	}
+}
+
+func TestValidateNFSACL(t *testing.T) {
+	tests := []struct {
+		enableNFSACL bool
+		protocol     string
+		expectedErr  error
+	}{
+		{
+			enableNFSACL: false,
+			protocol:     smb,
+			expectedErr:  nil,
+		},
+		{
+			enableNFSACL: true,
+			protocol:     nfs,
+			expectedErr:  nil,
+		},
+		{
+			enableNFSACL: true,
+			protocol:     smb,
+			expectedErr:  fmt.Errorf("enablenfsacl is only supported with nfs protocol, current protocol: smb"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateNFSACL(test.enableNFSACL, test.protocol)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("validateNFSACL(%v, %s) returned with error: %v, expected error: %v", test.enableNFSACL, test.protocol, err, test.expectedErr)
+		}
+	}
+}
+
+func TestGetNFSMountOptions(t *testing.T) {
+	tests := []struct {
+		desc                 string
+		mountFlags           []string
+		enableNFSACL         bool
+		nfsVersion           string
+		expectedMountOptions []string
+		expectedErr          error
+	}{
+		{
+			desc:                 "NFSv4 ACL disabled",
+			mountFlags:           []string{"nconnect=4"},
+			expectedMountOptions: []string{"nconnect=4", defaultNFSMountOptions},
+		},
+		{
+			desc:                 "nfs v4.1 by default without mount options",
+			expectedMountOptions: []string{"vers=4,minorversion=1,sec=sys"},
+		},
+		{
+			desc:                 "nfs v4.1 version options are replaced",
+			mountFlags:           []string{"nconnect=4,vers=4.1", "actimeo=30"},
+			nfsVersion:           nfsVersion41,
+			expectedMountOptions: []string{"nconnect=4", "actimeo=30", "vers=4,minorversion=1,sec=sys"},
+		},
+		{
+			desc:                 "nfs v3 without portmapper dependent locking",
+			mountFlags:           []string{"nconnect=4", "nfsvers=3"},
+			nfsVersion:           nfsVersion3,
+			expectedMountOptions: []string{"nconnect=4", "vers=3,proto=tcp,nolock,sec=sys"},
+		},
+		{
+			desc:        "nfs v3 mount option without nfsVersion",
+			mountFlags:  []string{"vers=3"},
+			expectedErr: fmt.Errorf("mount option(vers=3) conflicts with nfsversion(4.1), set nfsversion to use another nfs version"),
+		},
+		{
+			desc:        "nfs v4.1 mount option with nfs v3",
+			mountFlags:  []string{"minorversion=1"},
+			nfsVersion:  nfsVersion3,
+			expectedErr: fmt.Errorf("mount option(minorversion=1) conflicts with nfsversion(3), set nfsversion to use another nfs version"),
+		},
+		{
+			desc:        "nfs v4.2 mount option with nfs v4.1",
+			mountFlags:  []string{"nconnect=4", "minorversion=2"},
+			expectedErr: fmt.Errorf("mount option(minorversion=2) conflicts with nfsversion(4.1), set nfsversion to use another nfs version"),
+		},
+		{
+			desc:                 "NFSv4 ACL enabled without mount options",
+			enableNFSACL:         true,
+			expectedMountOptions: []string{defaultNFSMountOptions},
+		},
+		{
+			desc:                 "NFSv4 ACL enabled, version and security flavor are replaced",
+			mountFlags:           []string{"nconnect=4,vers=4.1", "sec=sys", "minorversion=1", "nfsvers=4", "actimeo=30, "},
+			enableNFSACL:         true,
+			expectedMountOptions: []string{"nconnect=4", "actimeo=30", defaultNFSMountOptions},
+		},
+		{
+			desc:         "NFSv4 ACL enabled with nfs v3",
+			mountFlags:   []string{"nconnect=4", "vers=3"},
+			enableNFSACL: true,
+			expectedErr:  fmt.Errorf("mount option(vers=3) is not supported when enablenfsacl is true, nfs v4.1 is required"),
+		},
+		{
+			desc:         "NFSv4 ACL enabled with minor version 0",
+			mountFlags:   []string{"minorversion=0"},
+			enableNFSACL: true,
+			expectedErr:  fmt.Errorf("mount option(minorversion=0) is not supported when enablenfsacl is true, nfs v4.1 is required"),
+		},
+		{
+			desc:         "NFSv4 ACL enabled with krb5 security flavor",
+			mountFlags:   []string{"sec=krb5"},
+			enableNFSACL: true,
+			expectedErr:  fmt.Errorf("mount option(sec=krb5) is not supported when enablenfsacl is true, only sec=sys is supported"),
+		},
+		{
+			desc:         "NFSv4 ACL enabled with noacl",
+			mountFlags:   []string{"noacl"},
+			enableNFSACL: true,
+			expectedErr:  fmt.Errorf("mount option(noacl) is not supported when enablenfsacl is true"),
+		},
+	}
+
+	for _, test := range tests {
+		mountOptions, err := getNFSMountOptions(test.mountFlags, test.enableNFSACL, test.nfsVersion)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+		if !reflect.DeepEqual(mountOptions, test.expectedMountOptions) {
+			t.Errorf("test[%s]: unexpected mount options: %v, expected mount options: %v", test.desc, mountOptions, test.expectedMountOptions)
+		}
+	}
+}
+
+func TestValidateNFSVersion(t *testing.T) {
+	tests := []struct {
+		nfsVersion   string
+		protocol     string
+		enableNFSACL bool
+		expectedErr  error
+	}{
+		{
+			protocol: smb,
+		},
+		{
+			nfsVersion: nfsVersion41,
+			protocol:   nfs,
+		},
+		{
+			nfsVersion: nfsVersion3,
+			protocol:   nfs,
+		},
+		{
+			nfsVersion:   nfsVersion41,
+			protocol:     nfs,
+			enableNFSACL: true,
+		},
+		{
+			nfsVersion:  "4.2",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("nfsversion(4.2) is not supported, supported versions: [4.1 3]"),
+		},
+		{
+			nfsVersion:  nfsVersion3,
+			protocol:    smb,
+			expectedErr: fmt.Errorf("nfsversion is only supported with nfs protocol, current protocol: smb"),
+		},
+		{
+			nfsVersion:   nfsVersion3,
+			protocol:     nfs,
+			enableNFSACL: true,
+			expectedErr:  fmt.Errorf("enablenfsacl is true, nfs v4.1 is required, current nfsversion: 3"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateNFSVersion(test.nfsVersion, test.protocol, test.enableNFSACL)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("validateNFSVersion(%s, %s, %v) returned with: %v, expected: %v", test.nfsVersion, test.protocol, test.enableNFSACL, err, test.expectedErr)
+		}
+	}
+}
+
+func TestValidateUseCredentialFile(t *testing.T) {
+	tests := []struct {
+		useCredentialFile bool
+		protocol          string
+		expectedErr       error
+	}{
+		{
+			useCredentialFile: false,
+			protocol:          nfs,
+			expectedErr:       nil,
+		},
+		{
+			useCredentialFile: true,
+			protocol:          smb,
+			expectedErr:       nil,
+		},
+		{
+			useCredentialFile: true,
+			protocol:          "",
+			expectedErr:       nil,
+		},
+		{
+			useCredentialFile: true,
+			protocol:          nfs,
+			expectedErr:       fmt.Errorf("usecredentialfile is only supported with smb protocol"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateUseCredentialFile(test.useCredentialFile, test.protocol)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("validateUseCredentialFile(%v, %s) returned with error: %v, expected error: %v", test.useCredentialFile, test.protocol, err, test.expectedErr)
+		}
+	}
+}
+
+func TestGetAccountKeyAuthDisabledBy(t *testing.T) {
+	tests := []struct {
+		desc                  string
+		disableAccountKeyAuth bool
+		dataPlaneAuth         string
+		expected              string
+	}{
+		{
+			desc:          "account key auth is enabled by default",
+			dataPlaneAuth: dataPlaneAuthKey,
+		},
+		{
+			desc:                  "disableAccountKeyAuth",
+			disableAccountKeyAuth: true,
+			dataPlaneAuth:         dataPlaneAuthAAD,
+			expected:              disableAccountKeyAuthField,
+		},
+		{
+			desc:          "dataPlaneAuth(aad)",
+			dataPlaneAuth: "AAD",
+			expected:      "dataPlaneAuth(aad)",
+		},
+	}
+
+	for _, test := range tests {
+		result := getAccountKeyAuthDisabledBy(test.disableAccountKeyAuth, test.dataPlaneAuth)
+		if result != test.expected {
+			t.Errorf("test[%s]: unexpected result: %s, expected result: %s", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestParseSDDL(t *testing.T) {
+	tests := []struct {
+		sddl               string
+		expectedComponents map[string]string
+		expectedErr        error
+	}{
+		{
+			sddl:               "D:(A;OICI;FA;;;BA)",
+			expectedComponents: map[string]string{"D": "(A;OICI;FA;;;BA)"},
+		},
+		{
+			sddl:               "O:BAG:SYD:PAI(A;OICI;FA;;;BA)(A;OICI;0x1301bf;;;AU)S:(AU;SA;FA;;;WD)",
+			expectedComponents: map[string]string{"O": "BA", "G": "SY", "D": "PAI(A;OICI;FA;;;BA)(A;OICI;0x1301bf;;;AU)", "S": "(AU;SA;FA;;;WD)"},
+		},
+		{
+			sddl:               "O:S-1-5-21-1-2-3-500G:DGD:",
+			expectedComponents: map[string]string{"O": "S-1-5-21-1-2-3-500", "G": "DG", "D": ""},
+		},
+		{
+			sddl:        "",
+			expectedErr: fmt.Errorf("SDDL() should start with one of O:, G:, D:, S:"),
+		},
+		{
+			sddl:        "BAD:(A;;FA;;;BA)",
+			expectedErr: fmt.Errorf("SDDL(BAD:(A;;FA;;;BA)) should start with one of O:, G:, D:, S:"),
+		},
+		{
+			sddl:        "D:(A;;FA;;;BA)D:(A;;FA;;;AU)",
+			expectedErr: fmt.Errorf("duplicate D: in SDDL(D:(A;;FA;;;BA)D:(A;;FA;;;AU))"),
+		},
+		{
+			sddl:        "D:(A;;FA;;;BA",
+			expectedErr: fmt.Errorf("unbalanced parentheses in SDDL(D:(A;;FA;;;BA)"),
+		},
+		{
+			sddl:        "D:A;;FA;;;BA)",
+			expectedErr: fmt.Errorf("unbalanced parentheses in SDDL(D:A;;FA;;;BA))"),
+		},
+	}
+
+	for _, test := range tests {
+		components, err := parseSDDL(test.sddl)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("parseSDDL(%s) returned with error: %v, expected error: %v", test.sddl, err, test.expectedErr)
+		}
+		if !reflect.DeepEqual(components, test.expectedComponents) {
+			t.Errorf("parseSDDL(%s) returned %v, expected %v", test.sddl, components, test.expectedComponents)
+		}
+	}
+}
+
+func TestValidateSecretNamespaces(t *testing.T) {
+	tests := []struct {
+		secretNamespaces string
+		secretNamespace  string
+		expectedErr      error
+	}{
+		{
+			secretNamespaces: "",
+			secretNamespace:  "default",
+			expectedErr:      nil,
+		},
+		{
+			secretNamespaces: "team-a, team-b,default",
+			expectedErr:      nil,
+		},
+		{
+			secretNamespaces: "team-a,team-b",
+			secretNamespace:  "default",
+			expectedErr:      fmt.Errorf("secretnamespace and secretnamespaces could not be set at the same time"),
+		},
+		{
+			secretNamespaces: " , ",
+			expectedErr:      fmt.Errorf("secretnamespaces( , ) should contain at least one namespace"),
+		},
+		{
+			secretNamespaces: "team-a,Team_B",
+			expectedErr:      fmt.Errorf("invalid namespace(Team_B) in secretnamespaces: a lowercase RFC 1123 label must consist of lower case alphanumeric characters or '-', and must start and end with an alphanumeric character (e.g. 'my-name',  or '123-abc', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?')"),
+		},
+		{
+			secretNamespaces: "team-a,team-b,team-a",
+			expectedErr:      fmt.Errorf("duplicate namespace(team-a) in secretnamespaces"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateSecretNamespaces(test.secretNamespaces, test.secretNamespace)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("validateSecretNamespaces(%s, %s) returned with error: %v, expected error: %v", test.secretNamespaces, test.secretNamespace, err, test.expectedErr)
+		}
+	}
+
+	if namespaces := parseSecretNamespaces(" team-a,,team-b "); !reflect.DeepEqual(namespaces, []string{"team-a", "team-b"}) {
+		t.Errorf("parseSecretNamespaces returned %v, expected [team-a team-b]", namespaces)
+	}
+}
+
+func TestValidateRootDirACL(t *testing.T) {
+	tests := []struct {
+		acl         string
+		protocol    string
+		expectedErr error
+	}{
+		{
+			acl:         "",
+			protocol:    nfs,
+			expectedErr: nil,
+		},
+		{
+			acl:         "D:(A;OICI;FA;;;BA)",
+			protocol:    smb,
+			expectedErr: nil,
+		},
+		{
+			acl:         "D:(A;OICI;FA;;;BA)",
+			protocol:    nfs,
+			expectedErr: fmt.Errorf("rootdiracl is only supported with smb protocol"),
+		},
+		{
+			acl:         "0777",
+			protocol:    "",
+			expectedErr: fmt.Errorf("invalid rootdiracl: SDDL(0777) should start with one of O:, G:, D:, S:"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateRootDirACL(test.acl, test.protocol)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("validateRootDirACL(%s, %s) returned with error: %v, expected error: %v", test.acl, test.protocol, err, test.expectedErr)
+		}
+	}
+}
+
+func TestShouldApplyRootDirACL(t *testing.T) {
+	tests := []struct {
+		desc          string
+		acl           string
+		currentACL    string
+		expected      bool
+		expectedError error
+	}{
+		{
+			desc:       "no acl is set",
+			acl:        "",
+			currentACL: "O:BAG:SYD:(A;OICI;FA;;;WD)",
+			expected:   false,
+		},
+		{
+			desc:       "DACL is different",
+			acl:        "D:P(A;OICI;FA;;;BA)",
+			currentACL: "O:BAG:SYD:(A;OICI;FA;;;WD)",
+			expected:   true,
+		},
+		{
+			desc:       "DACL is already set, owner and group are not compared",
+			acl:        "D:p(a;oici;fa;;;ba)",
+			currentACL: "O:BAG:SYD:P(A;OICI;FA;;;BA)",
+			expected:   false,
+		},
+		{
+			desc:       "owner is different",
+			acl:        "O:BAD:P(A;OICI;FA;;;BA)",
+			currentACL: "O:SYG:SYD:P(A;OICI;FA;;;BA)",
+			expected:   true,
+		},
+		{
+			desc:       "SACL is not set on root directory",
+			acl:        "D:P(A;OICI;FA;;;BA)S:(AU;SA;FA;;;WD)",
+			currentACL: "O:BAG:SYD:P(A;OICI;FA;;;BA)",
+			expected:   true,
+		},
+		{
+			desc:       "current ACL could not be parsed",
+			acl:        "D:P(A;OICI;FA;;;BA)",
+			currentACL: "",
+			expected:   true,
+		},
+		{
+			desc:          "invalid acl",
+			acl:           "P(A;OICI;FA;;;BA)",
+			currentACL:    "O:BAG:SYD:P(A;OICI;FA;;;BA)",
+			expected:      false,
+			expectedError: fmt.Errorf("SDDL(P(A;OICI;FA;;;BA)) should start with one of O:, G:, D:, S:"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := shouldApplyRootDirACL(test.acl, test.currentACL)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("test(%s): unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
+		}
+		if result != test.expected {
+			t.Errorf("test(%s): unexpected result: %v, expected: %v", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestValidateDisableAccountKeyAuth(t *testing.T) {
+	tests := []struct {
+		desc             string
+		disabledBy       string
+		dataPlaneAuth    string
+		fsType           string
+		useDataPlaneAPI  bool
+		generateSASToken bool
+		expectedErr      error
+	}{
+		{
+			desc: "no feature requires account key",
+		},
+		{
+			desc:            "useDataPlaneAPI",
+			useDataPlaneAPI: true,
+			expectedErr:     fmt.Errorf("disableaccountkeyauth is not supported with useDataPlaneAPI since data plane API requires account key"),
+		},
+		{
+			desc:          "connection string",
+			dataPlaneAuth: "ConnectionString",
+			expectedErr:   fmt.Errorf("disableaccountkeyauth is not supported with dataPlaneAuth(ConnectionString) since connection string contains account key"),
+		},
+		{
+			desc:             "generateSasToken",
+			generateSASToken: true,
+			expectedErr:      fmt.Errorf("disableaccountkeyauth is not supported with generateSasToken since SAS token is signed by account key"),
+		},
+		{
+			desc:        "vhd disk",
+			fsType:      "ext4",
+			expectedErr: fmt.Errorf("disableaccountkeyauth is not supported with vhd disk fsType(ext4) since vhd disk is created with account key"),
+		},
+		{
+			desc:            "aad with useDataPlaneAPI",
+			disabledBy:      "dataPlaneAuth(aad)",
+			dataPlaneAuth:   dataPlaneAuthAAD,
+			useDataPlaneAPI: true,
+			expectedErr:     fmt.Errorf("dataPlaneAuth(aad) is not supported with useDataPlaneAPI since data plane API requires account key"),
+		},
+	}
+
+	for _, test := range tests {
+		if test.disabledBy == "" {
+			test.disabledBy = disableAccountKeyAuthField
+		}
+		err := validateDisableAccountKeyAuth(test.disabledBy, test.dataPlaneAuth, test.fsType, test.useDataPlaneAPI, test.generateSASToken)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+	}
+}
+
+func TestGetIdentityAuthMethod(t *testing.T) {
+	noIdentityAuthErr := fmt.Errorf("account key auth is disabled by disableaccountkeyauth while no identity based auth is available, use nfs protocol or sec=krb5 mount option with smb protocol")
+	tests := []struct {
+		desc               string
+		protocol           string
+		mountOptions       []string
+		expectedAuthMethod string
+		expectedErr        error
+	}{
+		{
+			desc:               "nfs mount is authorized by network",
+			protocol:           nfs,
+			expectedAuthMethod: identityAuthNetwork,
+		},
+		{
+			desc:               "smb mount with sec=krb5",
+			protocol:           smb,
+			mountOptions:       []string{"dir_mode=0777", "sec=krb5"},
+			expectedAuthMethod: identityAuthKerberos,
+		},
+		{
+			desc:               "smb mount with sec=krb5i in comma separated mount options",
+			protocol:           "",
+			mountOptions:       []string{"vers=3.1.1,sec=krb5i,cruid=0"},
+			expectedAuthMethod: identityAuthKerberos,
+		},
+		{
+			desc:         "smb mount with ntlmssp",
+			protocol:     smb,
+			mountOptions: []string{"sec=ntlmssp"},
+			expectedErr:  noIdentityAuthErr,
+		},
+		{
+			desc:        "smb mount without mount options",
+			protocol:    smb,
+			expectedErr: noIdentityAuthErr,
+		},
+	}
+
+	for _, test := range tests {
+		authMethod, err := getIdentityAuthMethod(disableAccountKeyAuthField, test.protocol, test.mountOptions)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+		if authMethod != test.expectedAuthMethod {
+			t.Errorf("test[%s]: unexpected auth method: %s, expected auth method: %s", test.desc, authMethod, test.expectedAuthMethod)
+		}
+	}
+}
+
+func TestValidateStorageAccountName(t *testing.T) {
+	tests := []struct {
+		name        string
+		expectedErr error
+	}{
+		{
+			name: "account01",
+		},
+		{
+			name:        "ac",
+			expectedErr: fmt.Errorf("storage account name(ac) should be between 3 and 24 characters and only contain numbers and lowercase letters"),
+		},
+		{
+			name:        "Account01",
+			expectedErr: fmt.Errorf("storage account name(Account01) should be between 3 and 24 characters and only contain numbers and lowercase letters"),
+		},
+		{
+			name:        "account-01",
+			expectedErr: fmt.Errorf("storage account name(account-01) should be between 3 and 24 characters and only contain numbers and lowercase letters"),
+		},
+		{
+			name:        "account01account01account",
+			expectedErr: fmt.Errorf("storage account name(account01account01account) should be between 3 and 24 characters and only contain numbers and lowercase letters"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateStorageAccountName(test.name)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("validateStorageAccountName(%s) returned with error: %v, expected error: %v", test.name, err, test.expectedErr)
+		}
+	}
+}
+
+func TestWriteCredentialFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skip test on non-linux platform")
+	}
+	tmpVDir, err := utiltesting.MkTmpdir("WriteCredentialFile")
+	if err != nil {
+		t.Fatalf("can't make a temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpVDir)
+
+	credFile := getCredentialFilePath(filepath.Join(tmpVDir, "globalmount"))
+	if credFile != filepath.Join(tmpVDir, credentialFile) {
+		t.Errorf("unexpected credential file path: %s", credFile)
+	}
+	expectedErr := fmt.Errorf("accountName(account) or accountKey is empty")
+	if err := writeCredentialFile(credFile, "account", ""); !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("unexpected error: %v, expected error: %v", err, expectedErr)
+	}
+
+	// credential file is created with account name and key, existing file is replaced
+	for _, key := range []string{"key", "newkey"} {
+		if err := writeCredentialFile(credFile, "account", key); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		content, err := os.ReadFile(credFile)
+		if err != nil {
+			t.Fatalf("read %s failed with %v", credFile, err)
+		}
+		if expected := fmt.Sprintf("username=account\npassword=%s\n", key); string(content) != expected {
+			t.Errorf("unexpected content of credential file: %q, expected: %q", string(content), expected)
+		}
+		info, err := os.Stat(credFile)
+		if err != nil {
+			t.Fatalf("stat %s failed with %v", credFile, err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("unexpected permissions of credential file: %#o", info.Mode().Perm())
+		}
+	}
+	entries, err := os.ReadDir(tmpVDir)
+	if err != nil {
+		t.Fatalf("read dir %s failed with %v", tmpVDir, err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("unexpected files in %s: %v", tmpVDir, entries)
+	}
+
+	if err := removeCredentialFile(credFile); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(credFile); !os.IsNotExist(err) {
+		t.Errorf("credential file(%s) is not removed: %v", credFile, err)
+	}
+	// removing credential file which does not exist is not an error
+	if err := removeCredentialFile(credFile); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestIsAccountNameUnavailableError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      nil,
+			expected: false,
+		},
+		{
+			err:      errors.New("failed to create storage account f123, error: Code=\"StorageAccountAlreadyTaken\""),
+			expected: true,
+		},
+		{
+			err:      errors.New("failed to create storage account f123, error: Code=\"StorageAccountAlreadyExists\""),
+			expected: true,
+		},
+		{
+			err:      errors.New("failed to create storage account f123, error: Code=\"StorageAccountIsNotProvisioned\""),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isAccountNameUnavailableError(test.err)
+		if result != test.expected {
+			t.Errorf("isAccountNameUnavailableError(%v) returned with %v, not equal to %v", test.err, result, test.expected)
+		}
+	}
+}
+
+func TestIsRegionCapacityError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      nil,
+			expected: false,
+		},
+		{
+			err:      errors.New("failed to create storage account f123, error: Code=\"SkuNotAvailable\""),
+			expected: true,
+		},
+		{
+			err:      errors.New("failed to create storage account f123, error: Code=\"ResourceQuotaExceeded\""),
+			expected: true,
+		},
+		{
+			err:      errors.New("failed to create storage account f123, error: Code=\"AuthorizationFailed\""),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isRegionCapacityError(test.err)
+		if result != test.expected {
+			t.Errorf("isRegionCapacityError(%v) returned with %v, not equal to %v", test.err, result, test.expected)
+		}
+	}
+}
+
+func TestGetVHDDiskFsType(t *testing.T) {
+	tests := []struct {
+		fsType         string
+		expectedFsType string
+		expectedError  error
+	}{
+		{
+			fsType:         "",
+			expectedFsType: "ext4",
+		},
+		{
+			fsType:         "ext4",
+			expectedFsType: "ext4",
+		},
+		{
+			fsType:         "xfs",
+			expectedFsType: "xfs",
+		},
+		{
+			fsType:         "ext3",
+			expectedFsType: "ext3",
+		},
+		{
+			fsType:        "smb",
+			expectedError: fmt.Errorf("fsType(smb) is not supported for vhd disk, supported fsType list: %v", supportedDiskFsTypeList),
+		},
+		{
+			fsType:        "ntfs",
+			expectedError: fmt.Errorf("fsType(ntfs) is not supported for vhd disk, supported fsType list: %v", supportedDiskFsTypeList),
+		},
+	}
+
+	for _, test := range tests {
+		fsType, err := getVHDDiskFsType(test.fsType)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("fsType(%s): unexpected error: %v, expected error: %v", test.fsType, err, test.expectedError)
+		}
+		if fsType != test.expectedFsType {
+			t.Errorf("fsType(%s): unexpected result: %s, expected result: %s", test.fsType, fsType, test.expectedFsType)
+		}
+	}
+}
+
+func TestGetProvenanceTags(t *testing.T) {
+	now := time.Date(2023, 5, 1, 8, 30, 0, 0, time.FixedZone("UTC+8", 8*3600))
+	expected := map[string]string{
+		createdByDriverVersionTag: driverVersion,
+		createdAtTag:              "2023-05-01T00:30:00Z",
+	}
+	if result := getProvenanceTags(now); !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v, expected: %v", result, expected)
+	}
+}
+
+func TestMergeProvenanceTags(t *testing.T) {
+	provenanceTags := map[string]string{createdByDriverVersionTag: "v1.0.0", createdAtTag: "2023-05-01T00:30:00Z"}
+	tests := []struct {
+		desc     string
+		tags     map[string]string
+		expected map[string]string
+	}{
+		{
+			desc:     "no user tags",
+			expected: map[string]string{createdByDriverVersionTag: "v1.0.0", createdAtTag: "2023-05-01T00:30:00Z"},
+		},
+		{
+			desc:     "provenance tags are added to user tags",
+			tags:     map[string]string{"key": "value"},
+			expected: map[string]string{"key": "value", createdByDriverVersionTag: "v1.0.0", createdAtTag: "2023-05-01T00:30:00Z"},
+		},
+		{
+			desc:     "user tags are not overwritten",
+			tags:     map[string]string{"CREATEDAT": "2020-01-01"},
+			expected: map[string]string{"CREATEDAT": "2020-01-01", createdByDriverVersionTag: "v1.0.0"},
+		},
+	}
+
+	for _, test := range tests {
+		if result := mergeProvenanceTags(test.tags, provenanceTags); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("test[%s]: unexpected result: %v, expected: %v", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestMergeProvenanceMetadata(t *testing.T) {
+	provenanceTags := map[string]string{createdByDriverVersionTag: "v1.0.0", createdAtTag: "2023-05-01T00:30:00Z"}
+	tests := []struct {
+		desc     string
+		metadata map[string]*string
+		expected map[string]*string
+	}{
+		{
+			desc:     "no user metadata",
+			expected: map[string]*string{createdByDriverVersionTag: pointer.String("v1.0.0"), createdAtTag: pointer.String("2023-05-01T00:30:00Z")},
+		},
+		{
+			desc:     "user metadata is not overwritten",
+			metadata: map[string]*string{"key": pointer.String("value"), "createdbydriverversion": pointer.String("custom")},
+			expected: map[string]*string{"key": pointer.String("value"), "createdbydriverversion": pointer.String("custom"), createdAtTag: pointer.String("2023-05-01T00:30:00Z")},
+		},
+	}
+
+	for _, test := range tests {
+		if result := mergeProvenanceMetadata(test.metadata, provenanceTags); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("test[%s]: unexpected result: %v, expected: %v", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestRedactMountOptions(t *testing.T) {
+	tests := []struct {
+		desc                  string
+		mountOptions          []string
+		sensitiveMountOptions []string
+		expected              []string
+	}{
+		{
+			desc:     "no mount options",
+			expected: []string{},
+		},
+		{
+			desc:         "mount options without sensitive values",
+			mountOptions: []string{"dir_mode=0777", "vers=3.1.1,actimeo=30", "mfsymlinks"},
+			expected:     []string{"dir_mode=0777", "vers=3.1.1,actimeo=30", "mfsymlinks"},
+		},
+		{
+			desc:         "password in mount options is redacted",
+			mountOptions: []string{"dir_mode=0777,password=secret", "Password=secret"},
+			expected:     []string{"dir_mode=0777,password=***", "Password=***"},
+		},
+		{
+			desc:                  "all values of sensitive mount options are redacted",
+			mountOptions:          []string{"credentials=/etc/smbcredentials/account.cred"},
+			sensitiveMountOptions: []string{"username=account,password=accountkey"},
+			expected:              []string{"credentials=/etc/smbcredentials/account.cred", "username=***,password=***"},
+		},
+		{
+			desc:                  "sensitive mount option without key is redacted entirely",
+			mountOptions:          []string{"AZURE\\account"},
+			sensitiveMountOptions: []string{"accountkey"},
+			expected:              []string{"AZURE\\account", "***"},
+		},
+	}
+
+	for _, test := range tests {
+		result := redactMountOptions(test.mountOptions, test.sensitiveMountOptions)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("test(%s): unexpected result: %v, expected: %v", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestParseShareMetadata(t *testing.T) {
+	value1, value2 := "value1", "value2"
+	tests := []struct {
+		desc             string
+		metadata         string
+		expectedMetadata map[string]*string
+		expectedError    error
+	}{
+		{
+			desc:             "empty metadata",
+			metadata:         "",
+			expectedMetadata: map[string]*string{},
+		},
+		{
+			desc:             "valid metadata",
+			metadata:         "key1=value1, _key_2=value2",
+			expectedMetadata: map[string]*string{"key1": &value1, "_key_2": &value2},
+		},
+		{
+			desc:          "invalid format",
+			metadata:      "key1=value1=value2",
+			expectedError: errors.New("share metadata 'key1=value1=value2' is invalid, the format should like: 'key1=value1,key2=value2'"),
+		},
+		{
+			desc:          "key starts with number",
+			metadata:      "1key=value1",
+			expectedError: errors.New("share metadata key(1key) is invalid, it should start with a letter or underscore and only contain letters, numbers and underscores"),
+		},
+		{
+			desc:          "key contains invalid character",
+			metadata:      "key-1=value1",
+			expectedError: errors.New("share metadata key(key-1) is invalid, it should start with a letter or underscore and only contain letters, numbers and underscores"),
+		},
+	}
+
+	for _, test := range tests {
+		metadata, err := parseShareMetadata(test.metadata)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
+		}
+		if !reflect.DeepEqual(metadata, test.expectedMetadata) {
+			t.Errorf("test[%s]: unexpected result: %v, expected result: %v", test.desc, metadata, test.expectedMetadata)
+		}
+	}
+}
+
+func TestParseAllowedIPs(t *testing.T) {
+	tests := []struct {
+		desc          string
+		allowedIPs    string
+		expectedIPs   []string
+		expectedError error
+	}{
+		{
+			desc:       "empty allowedIPs",
+			allowedIPs: "",
+		},
+		{
+			desc:        "valid IP address and CIDR range",
+			allowedIPs:  "10.0.0.1, 20.0.0.0/24,",
+			expectedIPs: []string{"10.0.0.1", "20.0.0.0/24"},
+		},
+		{
+			desc:          "invalid IP address",
+			allowedIPs:    "10.0.0.1,invalid",
+			expectedError: errors.New("allowedIPs '10.0.0.1,invalid' is invalid, invalid is not a valid IP address or CIDR range"),
+		},
+		{
+			desc:          "invalid CIDR range",
+			allowedIPs:    "20.0.0.0/33",
+			expectedError: errors.New("allowedIPs '20.0.0.0/33' is invalid, 20.0.0.0/33 is not a valid IP address or CIDR range"),
+		},
+	}
+
+	for _, test := range tests {
+		ips, err := parseAllowedIPs(test.allowedIPs)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
+		}
+		if !reflect.DeepEqual(ips, test.expectedIPs) {
+			t.Errorf("test[%s]: unexpected result: %v, expected result: %v", test.desc, ips, test.expectedIPs)
+		}
+	}
+}
+
+func TestChmodIfPermissionMismatch(t *testing.T) {
+	permissionMatchingPath, _ := getWorkDirPath("permissionMatchingPath")
+	_ = makeDir(permissionMatchingPath, 0755)
+	defer os.RemoveAll(permissionMatchingPath)
+
+	permissionMismatchPath, _ := getWorkDirPath("permissionMismatchPath")
+	_ = makeDir(permissionMismatchPath, 0721)
+	defer os.RemoveAll(permissionMismatchPath)
+
+	tests := []struct {
+		desc          string
+		path          string
+		mode          os.FileMode
+		expectedError error
+	}{
+		{
+			desc:          "Invalid path",
+			path:          "invalid-path",
+			mode:          0755,
+			expectedError: fmt.Errorf("CreateFile invalid-path: The system cannot find the file specified"),
+		},
+		{
+			desc:          "permission matching path",
+			path:          permissionMatchingPath,
+			mode:          0755,
+			expectedError: nil,
+		},
+		{
+			desc:          "permission mismatch path",
+			path:          permissionMismatchPath,
+			mode:          0755,
+			expectedError: nil,
+		},
+	}
+
+	for _, test := range tests {
+		err := chmodIfPermissionMismatch(test.path, test.mode)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			if err == nil || test.expectedError == nil && !strings.Contains(err.Error(), test.expectedError.Error()) {
+				t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
+			}
+		}
+	}
+}
+
+// getWorkDirPath returns the path to the current working directory
+func getWorkDirPath(dir string) (string, error) {
+	path, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%c%s", path, os.PathSeparator, dir), nil
+}
+
+func TestSetVolumeOwnership(t *testing.T) {
+	tmpVDir, err := utiltesting.MkTmpdir("SetVolumeOwnership")
+	if err != nil {
+		t.Fatalf("can't make a temp dir: %v", err)
+	}
+	//deferred clean up
+	defer os.RemoveAll(tmpVDir)
+
+	tests := []struct {
+		path                string
+		gid                 string
+		fsGroupChangePolicy string
+		expectedError       error
+	}{
+		{
+			path:          "path",
+			gid:           "",
+			expectedError: fmt.Errorf("convert %s to int failed with %v", "", `strconv.Atoi: parsing "": invalid syntax`),
+		},
+		{
+			path:          "path",
+			gid:           "alpha",
+			expectedError: fmt.Errorf("convert %s to int failed with %v", "alpha", `strconv.Atoi: parsing "alpha": invalid syntax`),
+		},
+		{
+			path:          "not-exists",
+			gid:           "1000",
+			expectedError: fmt.Errorf("lstat not-exists: no such file or directory"),
+		},
+		{
+			path:          tmpVDir,
+			gid:           "1000",
+			expectedError: nil,
+		},
+		{
+			path:                tmpVDir,
+			gid:                 "1000",
+			fsGroupChangePolicy: "Always",
+			expectedError:       nil,
+		},
+		{
+			path:                tmpVDir,
+			gid:                 "1000",
+			fsGroupChangePolicy: "OnRootMismatch",
+			expectedError:       nil,
+		},
+	}
+
+	for _, test := range tests {
+		err := SetVolumeOwnership(test.path, test.gid, test.fsGroupChangePolicy)
+		if err != nil && (err.Error() != test.expectedError.Error()) {
+			t.Errorf("unexpected error: %v, expected error: %v", err, test.expectedError)
+		}
+	}
+}
+
+func TestSetVolumeOwnershipWithFSGroupChangePolicy(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("skip test since it requires root privilege on linux")
+	}
+	gid := os.Getgid()
+
+	tests := []struct {
+		desc                string
+		fsGroupChangePolicy string
+		rootMode            os.FileMode
+		expectedChildMode   os.FileMode
+	}{
+		{
+			desc:                "OnRootMismatch skips recursive change when gid and permissions of root match",
+			fsGroupChangePolicy: "OnRootMismatch",
+			rootMode:            0770 | os.ModeSetgid,
+			expectedChildMode:   0600,
+		},
+		{
+			desc:                "default policy skips recursive change when gid and permissions of root match",
+			fsGroupChangePolicy: "",
+			rootMode:            0770 | os.ModeSetgid,
+			expectedChildMode:   0600,
+		},
+		{
+			desc:                "OnRootMismatch changes ownership recursively when permissions of root mismatch",
+			fsGroupChangePolicy: "OnRootMismatch",
+			rootMode:            0755,
+			expectedChildMode:   0660,
+		},
+		{
+			desc:                "Always changes ownership recursively",
+			fsGroupChangePolicy: "Always",
+			rootMode:            0770 | os.ModeSetgid,
+			expectedChildMode:   0660,
+		},
+	}
+
+	for _, test := range tests {
+		tmpVDir, err := utiltesting.MkTmpdir("SetVolumeOwnership")
+		if err != nil {
+			t.Fatalf("can't make a temp dir: %v", err)
+		}
+		if err := os.Chown(tmpVDir, -1, gid); err != nil {
+			t.Fatalf("chown %s failed with %v", tmpVDir, err)
+		}
+		if err := os.Chmod(tmpVDir, test.rootMode); err != nil {
+			t.Fatalf("chmod %s failed with %v", tmpVDir, err)
+		}
+		childFile := filepath.Join(tmpVDir, "child")
+		if err := ioutil.WriteFile(childFile, []byte{}, 0600); err != nil {
+			t.Fatalf("write %s failed with %v", childFile, err)
+		}
+		if err := os.Chmod(childFile, 0600); err != nil {
+			t.Fatalf("chmod %s failed with %v", childFile, err)
+		}
+
+		if err := SetVolumeOwnership(tmpVDir, strconv.Itoa(gid), test.fsGroupChangePolicy); err != nil {
+			t.Errorf("test[%s]: unexpected error: %v", test.desc, err)
+		}
+		info, err := os.Stat(childFile)
+		if err != nil {
+			t.Errorf("test[%s]: stat %s failed with %v", test.desc, childFile, err)
+		} else if info.Mode().Perm() != test.expectedChildMode {
+			t.Errorf("test[%s]: unexpected mode %#o, expected mode %#o", test.desc, info.Mode().Perm(), test.expectedChildMode)
+		}
+		os.RemoveAll(tmpVDir)
+	}
+}
+
+func TestGetMountPropagation(t *testing.T) {
+	tests := []struct {
+		desc                string
+		mountFlags          []string
+		expectedMountFlags  []string
+		expectedPropagation string
+	}{
+		{
+			desc:                "no mount flags",
+			expectedPropagation: "",
+		},
+		{
+			desc:                "no propagation flag",
+			mountFlags:          []string{"dir_mode=0777", "file_mode=0777"},
+			expectedMountFlags:  []string{"dir_mode=0777", "file_mode=0777"},
+			expectedPropagation: "",
+		},
+		{
+			desc:                "shared is translated to rshared",
+			mountFlags:          []string{"dir_mode=0777", "shared"},
+			expectedMountFlags:  []string{"dir_mode=0777"},
+			expectedPropagation: "rshared",
+		},
+		{
+			desc:                "rshared",
+			mountFlags:          []string{"rshared"},
+			expectedPropagation: "rshared",
+		},
+		{
+			desc:                "slave is translated to rslave",
+			mountFlags:          []string{"dir_mode=0777,SLAVE", "file_mode=0777"},
+			expectedMountFlags:  []string{"dir_mode=0777", "file_mode=0777"},
+			expectedPropagation: "rslave",
+		},
+		{
+			desc:                "private is the default propagation",
+			mountFlags:          []string{"rprivate", "dir_mode=0777"},
+			expectedMountFlags:  []string{"dir_mode=0777"},
+			expectedPropagation: "",
+		},
+		{
+			desc:                "last propagation flag takes effect",
+			mountFlags:          []string{"rshared", "rslave"},
+			expectedPropagation: "rslave",
+		},
+	}
+
+	for _, test := range tests {
+		mountFlags, propagation := getMountPropagation(test.mountFlags)
+		if !reflect.DeepEqual(mountFlags, test.expectedMountFlags) || propagation != test.expectedPropagation {
+			t.Errorf("test[%s]: unexpected result: (%v, %s), expected result: (%v, %s)", test.desc, mountFlags, propagation, test.expectedMountFlags, test.expectedPropagation)
+		}
+	}
+}
+
+func TestGetReadOnlyMountOptions(t *testing.T) {
+	tests := []struct {
+		desc                 string
+		mountOptions         []string
+		readonly             bool
+		expectedMountOptions []string
+	}{
+		{
+			desc:                 "mount options are not changed if readonly is false",
+			mountOptions:         []string{"vers=4,minorversion=1", "rw"},
+			expectedMountOptions: []string{"vers=4,minorversion=1", "rw"},
+		},
+		{
+			desc:                 "ro is appended to empty mount options",
+			readonly:             true,
+			expectedMountOptions: []string{"ro"},
+		},
+		{
+			desc:                 "ro is appended to nfs mount options",
+			mountOptions:         []string{"vers=4,minorversion=1", "sec=sys"},
+			readonly:             true,
+			expectedMountOptions: []string{"vers=4", "minorversion=1", "sec=sys", "ro"},
+		},
+		{
+			desc:                 "rw is replaced by ro",
+			mountOptions:         []string{"nconnect=4,rw", "actimeo=30"},
+			readonly:             true,
+			expectedMountOptions: []string{"nconnect=4", "actimeo=30", "ro"},
+		},
+		{
+			desc:                 "ro is not duplicated",
+			mountOptions:         []string{"bind", "ro"},
+			readonly:             true,
+			expectedMountOptions: []string{"bind", "ro"},
+		},
+	}
+
+	for _, test := range tests {
+		mountOptions := getReadOnlyMountOptions(test.mountOptions, test.readonly)
+		if !reflect.DeepEqual(mountOptions, test.expectedMountOptions) {
+			t.Errorf("test[%s]: unexpected mount options: %v, expected mount options: %v", test.desc, mountOptions, test.expectedMountOptions)
+		}
+	}
+}
+
+func TestGetPremiumFileShareProvisionedPerformance(t *testing.T) {
+	tests := []struct {
+		sizeGiB           int
+		expectedIops      int
+		expectedBandwidth int
+	}{
+		{
+			sizeGiB:           0,
+			expectedIops:      3100,
+			expectedBandwidth: 110,
+		},
+		{
+			sizeGiB:           100,
+			expectedIops:      3100,
+			expectedBandwidth: 110,
+		},
+		{
+			sizeGiB:           101,
+			expectedIops:      3101,
+			expectedBandwidth: 112,
+		},
+		{
+			sizeGiB:           1024,
+			expectedIops:      4024,
+			expectedBandwidth: 203,
+		},
+		{
+			sizeGiB:           102400,
+			expectedIops:      100000,
+			expectedBandwidth: 10340,
+		},
+	}
+
+	for _, test := range tests {
+		iops, bandwidth := getPremiumFileShareProvisionedPerformance(test.sizeGiB)
+		if iops != test.expectedIops || bandwidth != test.expectedBandwidth {
+			t.Errorf("sizeGiB(%d): unexpected result: (%d, %d), expected result: (%d, %d)", test.sizeGiB, iops, bandwidth, test.expectedIops, test.expectedBandwidth)
+		}
+	}
+}
+
+func TestSetKeyValueInMap(t *testing.T) {
+	tests := []struct {
+		desc     string
+		m        map[string]string
+		key      string
+		value    string
+		expected map[string]string
+	}{
+		{
+			desc:  "nil map",
+			key:   "key",
+			value: "value",
+		},
+		{
+			desc:     "empty map",
+			m:        map[string]string{},
+			key:      "key",
+			value:    "value",
+			expected: map[string]string{"key": "value"},
+		},
+		{
+			desc:  "non-empty map",
+			m:     map[string]string{"k": "v"},
+			key:   "key",
+			value: "value",
+			expected: map[string]string{
+				"k":   "v",
+				"key": "value",
+			},
+		},
+		{
+			desc:     "same key already exists",
+			m:        map[string]string{"subDir": "value2"},
+			key:      "subDir",
+			value:    "value",
+			expected: map[string]string{"subDir": "value"},
+		},
+		{
+			desc:     "case insensitive key already exists",
+			m:        map[string]string{"subDir": "value2"},
+			key:      "subdir",
+			value:    "value",
+			expected: map[string]string{"subDir": "value"},
+		},
+	}
+
+	for _, test := range tests {
+		setKeyValueInMap(test.m, test.key, test.value)
+		if !reflect.DeepEqual(test.m, test.expected) {
+			t.Errorf("test[%s]: unexpected output: %v, expected result: %v", test.desc, test.m, test.expected)
+		}
+	}
+}
+
+func TestReplaceWithMap(t *testing.T) {
+	tests := []struct {
+		desc     string
+		str      string
+		m        map[string]string
+		expected string
+	}{
+		{
+			desc:     "empty string",
+			str:      "",
+			expected: "",
+		},
+		{
+			desc:     "empty map",
+			str:      "",
+			m:        map[string]string{},
+			expected: "",
+		},
+		{
+			desc:     "empty key",
+			str:      "prefix-" + pvNameMetadata,
+			m:        map[string]string{"": "pv"},
+			expected: "prefix-" + pvNameMetadata,
+		},
+		{
+			desc:     "empty value",
+			str:      "prefix-" + pvNameMetadata,
+			m:        map[string]string{pvNameMetadata: ""},
+			expected: "prefix-",
+		},
+		{
+			desc:     "one replacement",
+			str:      "prefix-" + pvNameMetadata,
+			m:        map[string]string{pvNameMetadata: "pv"},
+			expected: "prefix-pv",
+		},
+		{
+			desc:     "multiple replacements",
+			str:      pvcNamespaceMetadata + pvcNameMetadata,
+			m:        map[string]string{pvcNamespaceMetadata: "namespace", pvcNameMetadata: "pvcname"},
+			expected: "namespacepvcname",
+		},
+	}
+
+	for _, test := range tests {
+		result := replaceWithMap(test.str, test.m)
+		if result != test.expected {
 			t.Errorf("test[%s]: unexpected output: %v, expected result: %v", test.desc, result, test.expected)
 		}
 	}
 }
+
+func TestParseOperationTimeout(t *testing.T) {
+	tests := []struct {
+		desc          string
+		value         string
+		maxTimeout    time.Duration
+		expected      time.Duration
+		expectedError error
+	}{
+		{
+			desc:          "empty value",
+			value:         "",
+			maxTimeout:    time.Hour,
+			expectedError: fmt.Errorf("invalid operationtimeout: , error: time: invalid duration \"\""),
+		},
+		{
+			desc:          "invalid format",
+			value:         "10",
+			maxTimeout:    time.Hour,
+			expectedError: fmt.Errorf("invalid operationtimeout: 10, error: time: missing unit in duration \"10\""),
+		},
+		{
+			desc:          "zero timeout",
+			value:         "0s",
+			maxTimeout:    time.Hour,
+			expectedError: fmt.Errorf("invalid operationtimeout: 0s, it should be positive"),
+		},
+		{
+			desc:          "negative timeout",
+			value:         "-5m",
+			maxTimeout:    time.Hour,
+			expectedError: fmt.Errorf("invalid operationtimeout: -5m, it should be positive"),
+		},
+		{
+			desc:       "valid timeout",
+			value:      "10m",
+			maxTimeout: time.Hour,
+			expected:   10 * time.Minute,
+		},
+		{
+			desc:       "timeout equals max",
+			value:      "1h",
+			maxTimeout: time.Hour,
+			expected:   time.Hour,
+		},
+		{
+			desc:       "timeout is capped by max",
+			value:      "2h30m",
+			maxTimeout: time.Hour,
+			expected:   time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseOperationTimeout(test.value, test.maxTimeout)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
+		}
+		if result != test.expected {
+			t.Errorf("test[%s]: unexpected result: %v, expected result: %v", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestParseKeyExpirationDays(t *testing.T) {
+	tests := []struct {
+		value         string
+		expected      int32
+		expectedError error
+	}{
+		{
+			value:         "",
+			expectedError: fmt.Errorf("invalid keyexpirationdays: , it should be an integer in range [1, 3650]"),
+		},
+		{
+			value:         "30d",
+			expectedError: fmt.Errorf("invalid keyexpirationdays: 30d, it should be an integer in range [1, 3650]"),
+		},
+		{
+			value:         "0",
+			expectedError: fmt.Errorf("invalid keyexpirationdays: 0, it should be an integer in range [1, 3650]"),
+		},
+		{
+			value:         "3651",
+			expectedError: fmt.Errorf("invalid keyexpirationdays: 3651, it should be an integer in range [1, 3650]"),
+		},
+		{
+			value:    "1",
+			expected: 1,
+		},
+		{
+			value:    "90",
+			expected: 90,
+		},
+		{
+			value:    "3650",
+			expected: 3650,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseKeyExpirationDays(test.value)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.value, err, test.expectedError)
+		}
+		if result != test.expected {
+			t.Errorf("test[%s]: unexpected result: %v, expected result: %v", test.value, result, test.expected)
+		}
+	}
+}
+
+func TestValidateUseKey(t *testing.T) {
+	tests := []struct {
+		useKey          string
+		keyVaultURL     string
+		storeAccountKey bool
+		expectedErr     error
+	}{
+		{},
+		{
+			useKey:          "key1",
+			storeAccountKey: true,
+		},
+		{
+			useKey:          "Key2",
+			storeAccountKey: true,
+		},
+		{
+			useKey:          "key3",
+			storeAccountKey: true,
+			expectedErr:     fmt.Errorf("invalid usekey: key3, supported values: [key1 key2]"),
+		},
+		{
+			useKey:          "key1",
+			keyVaultURL:     "https://vault.vault.azure.net",
+			storeAccountKey: true,
+			expectedErr:     fmt.Errorf("usekey could not be set with keyvaulturl"),
+		},
+		{
+			useKey:      "key1",
+			expectedErr: fmt.Errorf("usekey requires account key stored in k8s secret, could not be set with storeaccountkey(false)"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateUseKey(test.useKey, test.keyVaultURL, test.storeAccountKey)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.useKey, err, test.expectedErr)
+		}
+	}
+}
+
+func TestExtendContextTimeout(t *testing.T) {
+	type contextKey string
+	key := contextKey("key")
+
+	tests := []struct {
+		desc             string
+		parentTimeout    time.Duration
+		timeout          time.Duration
+		expectedDeadline time.Duration
+		expectedNoLimit  bool
+	}{
+		{
+			desc:            "parent context without deadline",
+			timeout:         time.Minute,
+			expectedNoLimit: true,
+		},
+		{
+			desc:             "parent deadline is later than timeout",
+			parentTimeout:    time.Hour,
+			timeout:          time.Minute,
+			expectedDeadline: time.Hour,
+		},
+		{
+			desc:             "parent deadline is extended",
+			parentTimeout:    time.Second,
+			timeout:          time.Hour,
+			expectedDeadline: time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		start := time.Now()
+		parent, parentCancel := context.WithCancel(context.WithValue(context.Background(), key, "value"))
+		if test.parentTimeout > 0 {
+			parent, parentCancel = context.WithTimeout(context.WithValue(context.Background(), key, "value"), test.parentTimeout)
+		}
+		ctx, cancel := extendContextTimeout(parent, test.timeout)
+
+		deadline, ok := ctx.Deadline()
+		if test.expectedNoLimit {
+			if ok {
+				t.Errorf("test[%s]: unexpected deadline: %v", test.desc, deadline)
+			}
+		} else {
+			if !ok {
+				t.Errorf("test[%s]: deadline is not set", test.desc)
+			}
+			if diff := deadline.Sub(start.Add(test.expectedDeadline)); diff < 0 || diff > time.Second {
+				t.Errorf("test[%s]: unexpected deadline: %v, expected deadline around: %v", test.desc, deadline, start.Add(test.expectedDeadline))
+			}
+		}
+		if ctx.Value(key) != "value" {
+			t.Errorf("test[%s]: value of parent context is not kept", test.desc)
+		}
+		cancel()
+		parentCancel()
+	}
+
+	// cancellation of parent context is ignored once deadline is extended
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := extendContextTimeout(parent, time.Hour)
+	defer cancel()
+	parentCancel()
+	if ctx.Err() != nil {
+		t.Errorf("unexpected error of extended context: %v", ctx.Err())
+	}
+}
+
+func TestValidateCapacityRange(t *testing.T) {
+	tests := []struct {
+		desc          string
+		capRange      *csi.CapacityRange
+		expected      int64
+		expectedError error
+	}{
+		{
+			desc:     "nil capacity range",
+			capRange: nil,
+			expected: 0,
+		},
+		{
+			desc:     "empty capacity range",
+			capRange: &csi.CapacityRange{},
+			expected: 0,
+		},
+		{
+			desc:     "only RequiredBytes",
+			capRange: &csi.CapacityRange{RequiredBytes: 100},
+			expected: 100,
+		},
+		{
+			desc:     "only LimitBytes",
+			capRange: &csi.CapacityRange{LimitBytes: 200},
+			expected: 200,
+		},
+		{
+			desc:     "RequiredBytes is preferred",
+			capRange: &csi.CapacityRange{RequiredBytes: 100, LimitBytes: 200},
+			expected: 100,
+		},
+		{
+			desc:     "RequiredBytes equals LimitBytes",
+			capRange: &csi.CapacityRange{RequiredBytes: 200, LimitBytes: 200},
+			expected: 200,
+		},
+		{
+			desc:          "negative RequiredBytes",
+			capRange:      &csi.CapacityRange{RequiredBytes: -1, LimitBytes: 200},
+			expectedError: fmt.Errorf("RequiredBytes(-1) in capacity range must not be negative"),
+		},
+		{
+			desc:          "negative LimitBytes",
+			capRange:      &csi.CapacityRange{RequiredBytes: 100, LimitBytes: -1},
+			expectedError: fmt.Errorf("LimitBytes(-1) in capacity range must not be negative"),
+		},
+		{
+			desc:          "inverted capacity range",
+			capRange:      &csi.CapacityRange{RequiredBytes: 300, LimitBytes: 200},
+			expectedError: fmt.Errorf("RequiredBytes(300) in capacity range must not be larger than LimitBytes(200)"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := validateCapacityRange(test.capRange)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
+		}
+		if result != test.expected {
+			t.Errorf("test[%s]: unexpected result: %d, expected result: %d", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestValidateNFSMountOptions(t *testing.T) {
+	tests := []struct {
+		desc          string
+		mountOptions  []string
+		protocol      string
+		expectedError error
+	}{
+		{
+			desc:     "empty mount options",
+			protocol: smb,
+		},
+		{
+			desc:         "other mount options with smb protocol",
+			mountOptions: []string{"dir_mode=0777", "actimeo=30,mfsymlinks"},
+			protocol:     smb,
+		},
+		{
+			desc:         "valid soft mount options with nfs protocol",
+			mountOptions: []string{"soft", "timeo=600", "retrans=2"},
+			protocol:     nfs,
+		},
+		{
+			desc:         "valid hard mount options with nfs protocol",
+			mountOptions: []string{"nconnect=4,hard,timeo=600,retrans=2"},
+			protocol:     nfs,
+		},
+		{
+			desc:          "soft mount option with smb protocol",
+			mountOptions:  []string{"soft"},
+			protocol:      smb,
+			expectedError: fmt.Errorf("mount option(soft) is only supported with nfs protocol, current protocol: smb"),
+		},
+		{
+			desc:          "hard mount option with empty protocol",
+			mountOptions:  []string{"dir_mode=0777,hard"},
+			protocol:      "",
+			expectedError: fmt.Errorf("mount option(hard) is only supported with nfs protocol, current protocol: "),
+		},
+		{
+			desc:          "timeo mount option with smb protocol",
+			mountOptions:  []string{"timeo=600"},
+			protocol:      smb,
+			expectedError: fmt.Errorf("mount option(timeo) is only supported with nfs protocol, current protocol: smb"),
+		},
+		{
+			desc:          "retrans mount option with smb protocol",
+			mountOptions:  []string{"retrans=2"},
+			protocol:      smb,
+			expectedError: fmt.Errorf("mount option(retrans) is only supported with nfs protocol, current protocol: smb"),
+		},
+		{
+			desc:          "soft and hard mount options",
+			mountOptions:  []string{"soft", "hard"},
+			protocol:      nfs,
+			expectedError: fmt.Errorf("mount options soft and hard could not be set at the same time"),
+		},
+		{
+			desc:          "timeo without value",
+			mountOptions:  []string{"timeo"},
+			protocol:      nfs,
+			expectedError: fmt.Errorf("mount option(timeo) is invalid, timeo should be a positive integer"),
+		},
+		{
+			desc:          "negative timeo",
+			mountOptions:  []string{"timeo=-1"},
+			protocol:      nfs,
+			expectedError: fmt.Errorf("mount option(timeo=-1) is invalid, timeo should be a positive integer"),
+		},
+		{
+			desc:          "invalid retrans",
+			mountOptions:  []string{"hard,retrans=abc"},
+			protocol:      nfs,
+			expectedError: fmt.Errorf("mount option(retrans=abc) is invalid, retrans should be a positive integer"),
+		},
+	}
+
+	for _, test := range tests {
+		err := validateNFSMountOptions(test.mountOptions, test.protocol)
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedError)
+		}
+	}
+}
+
+func TestGetOSMountOptions(t *testing.T) {
+	tests := []struct {
+		desc                 string
+		goos                 string
+		windowsMountOptions  string
+		linuxMountOptions    string
+		expectedMountOptions []string
+		expectedErr          error
+	}{
+		{
+			desc: "no mount options",
+			goos: "linux",
+		},
+		{
+			desc:                 "linuxMountOptions are used on linux",
+			goos:                 "linux",
+			windowsMountOptions:  "RequirePrivacy",
+			linuxMountOptions:    "dir_mode=0777, file_mode=0777,,actimeo=30",
+			expectedMountOptions: []string{"dir_mode=0777", "file_mode=0777", "actimeo=30"},
+		},
+		{
+			desc:                 "windowsMountOptions are used on windows",
+			goos:                 "windows",
+			windowsMountOptions:  "RequirePrivacy,usewritethrough",
+			linuxMountOptions:    "dir_mode=0777",
+			expectedMountOptions: []string{"RequirePrivacy", "usewritethrough"},
+		},
+		{
+			desc:                 "invalid windowsMountOptions are not validated on linux",
+			goos:                 "linux",
+			windowsMountOptions:  "dir_mode=0777",
+			linuxMountOptions:    "vers=3.1.1",
+			expectedMountOptions: []string{"vers=3.1.1"},
+		},
+		{
+			desc:                "cifs mount option is not supported on windows",
+			goos:                "windows",
+			windowsMountOptions: "dir_mode=0777",
+			expectedErr:         fmt.Errorf("mount option(dir_mode=0777) is not supported in windowsmountoptions, supported options: [requireintegrity requireprivacy usewritethrough]"),
+		},
+		{
+			desc:              "credentials are not allowed",
+			goos:              "linux",
+			linuxMountOptions: "vers=3.1.1,Password=key",
+			expectedErr:       fmt.Errorf("mount option(password) is not allowed in linuxmountoptions"),
+		},
+		{
+			desc:              "mount option with space",
+			goos:              "linux",
+			linuxMountOptions: "uid=0 gid=0",
+			expectedErr:       fmt.Errorf("invalid mount option(uid=0 gid=0) in linuxmountoptions"),
+		},
+	}
+
+	for _, test := range tests {
+		mountOptions, err := getOSMountOptions(test.goos, test.windowsMountOptions, test.linuxMountOptions)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+		if !reflect.DeepEqual(mountOptions, test.expectedMountOptions) {
+			t.Errorf("test[%s]: unexpected mount options: %v, expected mount options: %v", test.desc, mountOptions, test.expectedMountOptions)
+		}
+	}
+}
+
+func TestGetProtocolByNodeOS(t *testing.T) {
+	tests := []struct {
+		protocol         string
+		nodeOS           string
+		expectedProtocol string
+	}{
+		{
+			protocol:         auto,
+			nodeOS:           "windows",
+			expectedProtocol: smb,
+		},
+		{
+			protocol:         auto,
+			nodeOS:           "Windows",
+			expectedProtocol: smb,
+		},
+		{
+			protocol:         auto,
+			nodeOS:           "linux",
+			expectedProtocol: nfs,
+		},
+		{
+			protocol:         auto,
+			nodeOS:           "",
+			expectedProtocol: nfs,
+		},
+		{
+			protocol:         smb,
+			nodeOS:           "linux",
+			expectedProtocol: smb,
+		},
+		{
+			protocol:         nfs,
+			nodeOS:           "windows",
+			expectedProtocol: nfs,
+		},
+		{
+			protocol:         "",
+			nodeOS:           "linux",
+			expectedProtocol: "",
+		},
+	}
+
+	for _, test := range tests {
+		protocol := getProtocolByNodeOS(test.protocol, test.nodeOS)
+		if protocol != test.expectedProtocol {
+			t.Errorf("getProtocolByNodeOS(%s, %s) returned %s, expected %s", test.protocol, test.nodeOS, protocol, test.expectedProtocol)
+		}
+	}
+}
+
+func TestIsBusyUnmountError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      nil,
+			expected: false,
+		},
+		{
+			err:      fmt.Errorf("umount: /mnt/target: target is busy"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("umount: /mnt/target: device is busy"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("unmount failed: %w", syscall.EBUSY),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("umount: /mnt/target: not mounted"),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isBusyUnmountError(test.err)
+		if result != test.expected {
+			t.Errorf("isBusyUnmountError(%v) returned %v, expected %v", test.err, result, test.expected)
+		}
+	}
+}
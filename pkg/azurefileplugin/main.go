@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"sigs.k8s.io/azurefile-csi-driver/pkg/azurefile"
 
@@ -33,6 +34,19 @@ import (
 
 func init() {
 	klog.InitFlags(nil)
+	flag.Var(&requiredTags, "require-tag", "tag key which must be set with non-empty value in tags parameter of storage class in CreateVolume, it could be set multiple times, e.g. --require-tag=costCenter")
+}
+
+// stringArrayFlag is a flag which could be set multiple times
+type stringArrayFlag []string
+
+func (f *stringArrayFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringArrayFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
 }
 
 var (
@@ -56,9 +70,68 @@ var (
 	kubeAPIBurst                           = flag.Int("kube-api-burst", 50, "Burst to use while communicating with the kubernetes apiserver.")
 	appendMountErrorHelpLink               = flag.Bool("append-mount-error-help-link", true, "Whether to include a link for help with mount errors when a mount error occurs.")
 	enableWindowsHostProcess               = flag.Bool("enable-windows-host-process", false, "enable windows host process")
+	emitAuditEvents                        = flag.Bool("emit-audit-events", false, "emit kubernetes events for volume create/delete/expand operations as audit trail")
+	snapshotBeforeDelete                   = flag.Bool("snapshot-before-delete", false, "create a snapshot of file share before deleting it (best effort, only if share soft delete is enabled on storage account), the snapshot could be recovered with the soft deleted file share")
+	maxVolumesPerNode                      = flag.Int64("max-volumes-per-node", 0, "maximum number of volumes that could be staged on one node, 0 means unlimited")
+	enableOrphanGC                         = flag.Bool("enable-orphan-gc", false, "periodically retry deleting file shares in storage accounts created by driver in driver resource group which are tagged as pending delete")
+	orphanGCInterval                       = flag.Duration("orphan-gc-interval", time.Hour, "interval of orphaned resource garbage collection")
+	orphanGCThreshold                      = flag.Duration("orphan-gc-threshold", 24*time.Hour, "resources tagged as pending delete for longer than this threshold would be garbage collected")
+	maxOperationTimeout                    = flag.Duration("max-operation-timeout", 30*time.Minute, "max value of operationTimeout parameter in storage class")
+	enableTracing                          = flag.Bool("enable-tracing", false, "export OpenTelemetry traces of CSI operations to OTLP endpoint")
+	otlpEndpoint                           = flag.String("otlp-endpoint", "localhost:4317", "OTLP gRPC endpoint to export traces, only used when enable-tracing is true")
+	smbVersionFallback                     = flag.Bool("smb-version-fallback", false, "retry SMB mount with lower SMB version when server rejects SMB protocol negotiation")
+	nodeCredentialCacheTTL                 = flag.Duration("node-credential-cache-ttl", 0, "TTL of in-memory cache of account key secrets read in NodeStageVolume, 0 means disabled")
+	enableDebugEndpoint                    = flag.Bool("enable-debug-endpoint", false, "serve effective driver config (without secrets) in JSON on /config and volumes staged on node with effective mount options (secrets redacted) on /volumes of debug endpoint address")
+	debugEndpointAddress                   = flag.String("debug-endpoint-address", azurefile.DefaultDebugEndpointAddress, "debug endpoint address, only loopback address is allowed")
+	redactSubscriptionID                   = flag.Bool("redact-subscription-id", true, "redact subscription ID in driver config exported by debug endpoint")
+	armQPS                                 = flag.Float64("arm-qps", 0, "QPS of ARM calls per subscription (token bucket rate limiter), 0 means disabled")
+	armBurst                               = flag.Int("arm-burst", 10, "burst of ARM calls per subscription, only used when arm-qps is larger than 0")
+	defaultShareQuotaGiB                   = flag.Int("default-share-quota-gib", 100, "quota(GiB) of file share created without requested capacity, it would be raised to minimum share size of premium account")
+	applyDefaultMountOptions               = flag.Bool("apply-default-mount-options", true, "apply protocol specific default mount options in NodeStageVolume unless they are set by user (smb: file_mode, dir_mode, actimeo, mfsymlinks; nfs: nconnect, noresvport, actimeo), set to false to disable them for both protocols")
+	pvcAnnotationAllowlist                 = flag.String("pvc-annotation-allowlist", "", "comma separated storage class parameter names which could be overridden by PVC annotations with file.csi.azure.com/ prefix, e.g. skuName,enableLargeFileShares, empty means disabled")
+	checkShareCapacityOnMount              = flag.Bool("check-share-capacity-on-mount", false, "check whether file share usage reaches quota in NodeStageVolume before mount (requires ARM access on node), a warning is logged if share is full")
+	failOnFullShare                        = flag.Bool("fail-on-full-share", false, "fail NodeStageVolume if file share is full, only used when check-share-capacity-on-mount is true")
+	shutdownTimeout                        = flag.Duration("shutdown-timeout", 25*time.Second, "max time to wait for in-flight operations to complete on SIGTERM before exit, it should be less than terminationGracePeriodSeconds of driver pod, 0 means exit immediately")
+	allowedSKUs                            = flag.String("allowed-skus", "", "comma separated skuName values allowed in CreateVolume, e.g. Standard_LRS,Standard_ZRS, empty means all skuName values are allowed")
+	allowMismatchedStagingMount            = flag.Bool("allow-mismatched-staging-mount", false, "treat existing mount on staging target as success in NodeStageVolume even if it's not a mount of the expected file share")
+	enableRegionalAccountAffinity          = flag.Bool("enable-regional-account-affinity", false, "report node region in topology and prefer storage account in the region of requested topology in CreateVolume, storage account in other requested regions is only used when it fails in preferred region")
+	mountHealthCheckInterval               = flag.Duration("mount-health-check-interval", 0, "interval of probing mounts of staged volumes on node, health of every mount is exported in azurefile_csi_driver_mount_healthy metric, 0 means disabled")
+	singleAccountName                      = flag.String("single-account-name", "", "create all file shares in this storage account (created if it does not exist) instead of selecting a matching account, storageAccount parameter in storage class is still respected")
+	waitForShareDelete                     = flag.Bool("wait-for-share-delete", false, "wait until file share is not found in DeleteVolume, polling with exponential backoff")
+	waitForShareDeleteTimeout              = flag.Duration("wait-for-share-delete-timeout", 5*time.Minute, "max time of waiting for file share deletion in DeleteVolume, only used when wait-for-share-delete is true")
+	preferFlagResourceGroup                = flag.Bool("prefer-flag-resource-group", false, "use resourceGroup in cloud config instead of the one in volume handle in controller operations(e.g. DeleteVolume, ControllerExpandVolume), volume handles of other subscriptions are not affected")
+	csiSocketPermissions                   = flag.Uint64("csi-socket-perms", 0, "file mode of CSI unix socket (e.g. 0660), file mode is not changed if 0")
+	maxGRPCMessageSize                     = flag.Int("max-grpc-message-size", 0, "max size(bytes) of gRPC messages received and sent by driver, default limits of gRPC(4MiB received) are used if 0")
+	secretLabelSelector                    = flag.String("secret-label-selector", "", "label selector(e.g. azurefile.csi.azure.com/allowed=true) of secrets which driver reads account key or credential from, secrets not matching the selector are refused, secrets created by driver are labeled if selector is equality based")
+	nodeMountRetries                       = flag.Int("node-mount-retries", 0, "retries of transient mount errors in NodeStageVolume, mount is only tried once if 0")
+	nodeMountRetryInterval                 = flag.Duration("node-mount-retry-interval", time.Second, "interval between mount retries in NodeStageVolume")
+	provisionRetries                       = flag.Int("provision-retries", 0, "retries of retriable errors in file share create, delete and resize operations of controller, backoff settings in cloud config are used if 0")
+	provisionRetryInterval                 = flag.Duration("provision-retry-interval", time.Second, "interval between retries of file share operations of controller, only used when provision-retries is set")
+	enableNodeOSTopology                   = flag.Bool("enable-node-os-topology", false, "report node OS in topology so that protocol(auto) in storage class is resolved by node OS in requested topology in CreateVolume, smb on windows and nfs on linux")
+	accountSelectionStrategy               = flag.String("account-selection-strategy", "first-match", "strategy of selecting matching storage account for new file share, supported values: first-match, round-robin, least-used, bin-packing")
+	lazyUnmountOnBusy                      = flag.Bool("lazy-unmount-on-busy", false, "lazily unmount target in NodeUnpublishVolume if unmount still fails with target busy after retries, only supported on linux")
+	requiredTags                           stringArrayFlag
+	minShareQuotaGiB                       = flag.Int("min-share-quota-gib", 0, "minimum quota(GiB) of file share created by driver, requested quota smaller than it is rounded up, 0 means disabled")
+	maxShareQuotaGiB                       = flag.Int("max-share-quota-gib", 0, "maximum quota(GiB) of file share created or expanded by driver, requested quota larger than it is rejected, 0 means disabled")
+	enableFailoverAwareness                = flag.Bool("enable-failover-awareness", false, "check failover state of geo-redundant storage account on persistent SMB mount failures and retry mount against new primary endpoint")
+	volumeHandleVersion                    = flag.String("volume-handle-version", "v1", "format of volume handle of new volume, v1: segments are joined by #, v2: segments are escaped so that they could contain special characters, volume handles of both versions are always supported")
+	volumeFullThresholdPercent             = flag.Int("volume-full-threshold-percent", 0, "volume condition is reported as abnormal in NodeGetVolumeStats when usage percentage of volume reaches it, 0 means disabled")
+	maxConcurrentFormat                    = flag.Int("max-concurrent-format", 0, "max number of vhd disks formatted concurrently in NodeStageVolume, 0 means unlimited")
+	caBundlePath                           = flag.String("ca-bundle-path", "", "path of PEM encoded CA bundle file whose certificates are trusted in addition to system root CAs in storage data plane and key vault requests")
+	shareNameCollisionStrategy             = flag.String("share-name-collision-strategy", "truncate", "how auto-generated file share name longer than 63 characters is shortened, truncate: truncated directly, hash: truncated with hash suffix of the full name to avoid collision")
+	failOnLockedResourceGroup              = flag.Bool("fail-on-locked-rg", false, "fail CreateVolume instead of logging a warning if storage account would be created in resource group with management locks")
+	accessModeConflictPolicy               = flag.String("access-mode-conflict-policy", "reject", "how contradictory access modes(e.g. SINGLE_NODE_WRITER and MULTI_NODE_MULTI_WRITER) in volume capabilities of CreateVolume are handled, reject: fail with InvalidArgument, allow: accept as before")
+	profileConfigMap                       = flag.String("profile-configmap", "", "configmap(namespace/name) providing custom provisioning profiles used by profile parameter, every key is a profile name and the value is a JSON object of parameters")
+	networkRulePropagationRetries          = flag.Int("network-rule-propagation-retries", 3, "retries with exponential backoff in CreateVolume when file share creation is denied(403 AuthorizationFailure) by network rules of storage account which are not propagated yet, 0 means disabled")
+	networkRulePropagationRetryInterval    = flag.Duration("network-rule-propagation-retry-interval", 5*time.Second, "initial interval between retries of file share creation denied by network rules, doubled on every retry")
+	createSecretNamespace                  = flag.Bool("create-secret-namespace", false, "create secretNamespace if it does not exist when storing account key or SAS token in k8s secret, create permission of namespaces should be granted to controller")
+	minimumTLSVersion                      = flag.String("minimum-tls-version", "TLS1_2", "default minimum TLS version of storage account created by driver, supported values: TLS1_0, TLS1_1, TLS1_2")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == validateStorageClassCommand {
+		os.Exit(validateStorageClass(os.Args[2:], os.Stdout))
+	}
 	flag.Parse()
 	if *version {
 		info, err := azurefile.GetVersionYAML(*driverName)
@@ -97,6 +170,62 @@ func handle() {
 		KubeAPIQPS:                             *kubeAPIQPS,
 		KubeAPIBurst:                           *kubeAPIBurst,
 		EnableWindowsHostProcess:               *enableWindowsHostProcess,
+		MinimumTLSVersion:                      *minimumTLSVersion,
+		EmitAuditEvents:                        *emitAuditEvents,
+		SnapshotBeforeDelete:                   *snapshotBeforeDelete,
+		MaxVolumesPerNode:                      *maxVolumesPerNode,
+		EnableOrphanGC:                         *enableOrphanGC,
+		OrphanGCInterval:                       *orphanGCInterval,
+		OrphanGCThreshold:                      *orphanGCThreshold,
+		MaxOperationTimeout:                    *maxOperationTimeout,
+		EnableTracing:                          *enableTracing,
+		OTLPEndpoint:                           *otlpEndpoint,
+		EnableSMBVersionFallback:               *smbVersionFallback,
+		NodeCredentialCacheTTL:                 *nodeCredentialCacheTTL,
+		EnableDebugEndpoint:                    *enableDebugEndpoint,
+		DebugEndpointAddress:                   *debugEndpointAddress,
+		RedactSubscriptionID:                   *redactSubscriptionID,
+		ARMQPS:                                 *armQPS,
+		ARMBurst:                               *armBurst,
+		DefaultShareQuotaGiB:                   *defaultShareQuotaGiB,
+		ApplyDefaultMountOptions:               *applyDefaultMountOptions,
+		PVCAnnotationAllowlist:                 *pvcAnnotationAllowlist,
+		CheckShareCapacityOnMount:              *checkShareCapacityOnMount,
+		FailOnFullShare:                        *failOnFullShare,
+		ShutdownTimeout:                        *shutdownTimeout,
+		AllowedSKUs:                            *allowedSKUs,
+		AllowMismatchedStagingMount:            *allowMismatchedStagingMount,
+		EnableRegionalAccountAffinity:          *enableRegionalAccountAffinity,
+		MountHealthCheckInterval:               *mountHealthCheckInterval,
+		SingleAccountName:                      *singleAccountName,
+		WaitForShareDelete:                     *waitForShareDelete,
+		WaitForShareDeleteTimeout:              *waitForShareDeleteTimeout,
+		PreferFlagResourceGroup:                *preferFlagResourceGroup,
+		CSISocketPermissions:                   *csiSocketPermissions,
+		SecretLabelSelector:                    *secretLabelSelector,
+		NodeMountRetries:                       *nodeMountRetries,
+		NodeMountRetryInterval:                 *nodeMountRetryInterval,
+		ProvisionRetries:                       *provisionRetries,
+		ProvisionRetryInterval:                 *provisionRetryInterval,
+		EnableNodeOSTopology:                   *enableNodeOSTopology,
+		AccountSelectionStrategy:               *accountSelectionStrategy,
+		LazyUnmountOnBusy:                      *lazyUnmountOnBusy,
+		RequiredTags:                           requiredTags,
+		MinShareQuotaGiB:                       *minShareQuotaGiB,
+		EnableFailoverAwareness:                *enableFailoverAwareness,
+		VolumeHandleVersion:                    *volumeHandleVersion,
+		VolumeFullThresholdPercent:             *volumeFullThresholdPercent,
+		MaxConcurrentFormat:                    *maxConcurrentFormat,
+		CABundlePath:                           *caBundlePath,
+		ShareNameCollisionStrategy:             *shareNameCollisionStrategy,
+		FailOnLockedResourceGroup:              *failOnLockedResourceGroup,
+		AccessModeConflictPolicy:               *accessModeConflictPolicy,
+		ProfileConfigMap:                       *profileConfigMap,
+		NetworkRulePropagationRetries:          *networkRulePropagationRetries,
+		NetworkRulePropagationRetryInterval:    *networkRulePropagationRetryInterval,
+		CreateSecretNamespace:                  *createSecretNamespace,
+		MaxShareQuotaGiB:                       *maxShareQuotaGiB,
+		MaxGRPCMessageSize:                     *maxGRPCMessageSize,
 	}
 	driver := azurefile.NewDriver(&driverOptions)
 	if driver == nil {
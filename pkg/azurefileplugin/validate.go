@@ -0,0 +1,99 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/status"
+	storagev1 "k8s.io/api/storage/v1"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/azurefile-csi-driver/pkg/azurefile"
+)
+
+const (
+	validateStorageClassCommand = "validate-storageclass"
+	// parameters with this prefix are consumed by external-provisioner and never passed to driver
+	reservedParameterPrefix = "csi.storage.k8s.io/"
+)
+
+// validateStorageClass validates parameters of StorageClass manifest file in the same way as CreateVolume
+// without creating any resource, prints errors and warnings to out and returns the exit code
+func validateStorageClass(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet(validateStorageClassCommand, flag.ContinueOnError)
+	fs.SetOutput(out)
+	file := fs.String("f", "", "path of StorageClass manifest file")
+	driverName := fs.String("drivername", azurefile.DefaultDriverName, "name of the driver")
+	enableVHDDiskFeature := fs.Bool("enable-vhd", true, "enable VHD disk feature (experimental)")
+	maxOperationTimeout := fs.Duration("max-operation-timeout", 30*time.Minute, "max value of operationTimeout parameter in storage class")
+	var requiredTags stringArrayFlag
+	fs.Var(&requiredTags, "require-tag", "tag key which must be set with non-empty value in tags parameter, it could be set multiple times")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintf(out, "StorageClass manifest file must be provided by -f\n")
+		return 2
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		fmt.Fprintf(out, "error: failed to read %s: %v\n", *file, err)
+		return 1
+	}
+	sc := storagev1.StorageClass{}
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		fmt.Fprintf(out, "error: failed to parse %s: %v\n", *file, err)
+		return 1
+	}
+	if sc.Kind != "StorageClass" {
+		fmt.Fprintf(out, "error: kind(%s) in %s is not StorageClass\n", sc.Kind, *file)
+		return 1
+	}
+	if sc.Provisioner != *driverName {
+		fmt.Fprintf(out, "warning: provisioner(%s) is not %s\n", sc.Provisioner, *driverName)
+	}
+
+	parameters := map[string]string{}
+	for k, v := range sc.Parameters {
+		if !strings.HasPrefix(strings.ToLower(k), reservedParameterPrefix) {
+			parameters[k] = v
+		}
+	}
+	driver := azurefile.NewDriver(&azurefile.DriverOptions{
+		DriverName:           *driverName,
+		EnableVHDDiskFeature: *enableVHDDiskFeature,
+		MaxOperationTimeout:  *maxOperationTimeout,
+		RequiredTags:         requiredTags,
+	})
+	warnings, err := driver.ValidateCreateVolumeParameters(parameters)
+	for _, warning := range warnings {
+		fmt.Fprintf(out, "warning: %s\n", warning)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "error: %s\n", status.Convert(err).Message())
+		return 1
+	}
+	fmt.Fprintf(out, "StorageClass(%s) is valid\n", sc.Name)
+	return 0
+}
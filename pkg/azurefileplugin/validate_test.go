@@ -0,0 +1,137 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStorageClass(t *testing.T) {
+	tests := []struct {
+		desc             string
+		manifest         string
+		args             []string
+		expectedExitCode int
+		expectedOutput   string
+	}{
+		{
+			desc: "valid StorageClass",
+			manifest: `apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: azurefile-csi
+provisioner: file.csi.azure.com
+parameters:
+  skuName: Premium_LRS
+  csi.storage.k8s.io/provisioner-secret-name: secret
+`,
+			expectedExitCode: 0,
+			expectedOutput:   "StorageClass(azurefile-csi) is valid\n",
+		},
+		{
+			desc: "valid StorageClass with warnings",
+			manifest: `apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: azurefile-csi-nfs
+provisioner: other.csi.azure.com
+parameters:
+  protocol: nfs
+  skuName: Standard_LRS
+`,
+			expectedExitCode: 0,
+			expectedOutput: "warning: provisioner(other.csi.azure.com) is not file.csi.azure.com\n" +
+				"warning: skuName(Standard_LRS) would be replaced by Premium_LRS since nfs protocol only supports premium storage account\n" +
+				"StorageClass(azurefile-csi-nfs) is valid\n",
+		},
+		{
+			desc: "invalid parameter",
+			manifest: `apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: azurefile-csi
+provisioner: file.csi.azure.com
+parameters:
+  protocol: ftp
+`,
+			expectedExitCode: 1,
+			expectedOutput:   "error: protocol(ftp) is not supported, supported protocol list: [smb nfs]\n",
+		},
+		{
+			desc: "vhd disk feature is disabled",
+			manifest: `apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: azurefile-csi
+provisioner: file.csi.azure.com
+parameters:
+  fsType: ext4
+`,
+			args:             []string{"--enable-vhd=false"},
+			expectedExitCode: 1,
+			expectedOutput:   "error: fsType storage class parameter enables experimental VDH disk feature which is currently disabled, use --enable-vhd driver option to enable it\n",
+		},
+		{
+			desc: "required tag is missing",
+			manifest: `apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: azurefile-csi
+provisioner: file.csi.azure.com
+parameters:
+  tags: costCenter=1234
+`,
+			args:             []string{"--require-tag=costCenter", "--require-tag=owner"},
+			expectedExitCode: 1,
+			expectedOutput:   "error: required tags[owner] are not set in tags parameter, the format should like: 'key1=value1,key2=value2'\n",
+		},
+		{
+			desc: "not a StorageClass",
+			manifest: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: azurefile-csi
+`,
+			expectedExitCode: 1,
+		},
+		{
+			desc:             "invalid manifest",
+			manifest:         "invalid",
+			expectedExitCode: 1,
+		},
+	}
+
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "sc.yaml")
+		assert.NoError(t, os.WriteFile(file, []byte(test.manifest), 0600), test.desc)
+		out := &bytes.Buffer{}
+		exitCode := validateStorageClass(append(test.args, "-f", file), out)
+		assert.Equal(t, test.expectedExitCode, exitCode, test.desc)
+		if test.expectedOutput != "" {
+			assert.Equal(t, test.expectedOutput, out.String(), test.desc)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	assert.Equal(t, 2, validateStorageClass([]string{}, out))
+	assert.Equal(t, 1, validateStorageClass([]string{"-f", filepath.Join(t.TempDir(), "notexists.yaml")}, out))
+}
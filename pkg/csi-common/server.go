@@ -17,6 +17,7 @@ limitations under the License.
 package csicommon
 
 import (
+	"fmt"
 	"net"
 	"os"
 	"runtime"
@@ -39,71 +40,117 @@ type NonBlockingGRPCServer interface {
 	Stop()
 	// Stops the service forcefully
 	ForceStop()
+	// Stops the service gracefully, the service is stopped forcefully if in-flight calls do not complete within timeout
+	GracefulStopWithTimeout(timeout time.Duration) bool
 }
 
 func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
 	return &nonBlockingGRPCServer{}
 }
 
+// NewNonBlockingGRPCServerWithSocketPermissions returns server which sets file mode of unix socket to socketPermissions,
+// file mode is not changed if socketPermissions is 0
+func NewNonBlockingGRPCServerWithSocketPermissions(socketPermissions os.FileMode) NonBlockingGRPCServer {
+	return &nonBlockingGRPCServer{socketPermissions: socketPermissions}
+}
+
+// NewNonBlockingGRPCServerWithOptions returns server which sets file mode of unix socket to socketPermissions and
+// limits size of received and sent gRPC messages to maxMessageSize bytes, default limits of gRPC are used if maxMessageSize is 0
+func NewNonBlockingGRPCServerWithOptions(socketPermissions os.FileMode, maxMessageSize int) NonBlockingGRPCServer {
+	return &nonBlockingGRPCServer{socketPermissions: socketPermissions, maxMessageSize: maxMessageSize}
+}
+
 // NonBlocking server
 type nonBlockingGRPCServer struct {
-	wg     sync.WaitGroup
-	server *grpc.Server
+	wg sync.WaitGroup
+	// mu protects server which is stopped by other goroutines, e.g. signal handler
+	mu                sync.Mutex
+	server            *grpc.Server
+	socketPermissions os.FileMode
+	maxMessageSize    int
 }
 
+// Start creates gRPC server before returning and serves on endpoint in background,
+// so that the server could be stopped by Stop, ForceStop and GracefulStopWithTimeout once Start returns
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) {
+	server := grpc.NewServer(s.serverOptions()...)
+	if ids != nil {
+		csi.RegisterIdentityServer(server, ids)
+	}
+	if cs != nil {
+		csi.RegisterControllerServer(server, cs)
+	}
+	if ns != nil {
+		csi.RegisterNodeServer(server, ns)
+	}
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+
 	s.wg.Add(1)
-	go s.serve(endpoint, ids, cs, ns, testMode)
+	go s.serve(endpoint, server, testMode)
 }
 
 func (s *nonBlockingGRPCServer) Wait() {
 	s.wg.Wait()
 }
 
+// getServer returns gRPC server, it's nil if the server is not started
+func (s *nonBlockingGRPCServer) getServer() *grpc.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.server
+}
+
 func (s *nonBlockingGRPCServer) Stop() {
-	s.server.GracefulStop()
+	if server := s.getServer(); server != nil {
+		server.GracefulStop()
+	}
 }
 
 func (s *nonBlockingGRPCServer) ForceStop() {
-	s.server.Stop()
+	if server := s.getServer(); server != nil {
+		server.Stop()
+	}
+}
+
+// GracefulStopWithTimeout stops accepting new calls and waits for in-flight calls to complete,
+// returns false if the server is stopped forcefully after timeout
+func (s *nonBlockingGRPCServer) GracefulStopWithTimeout(timeout time.Duration) bool {
+	server := s.getServer()
+	if server == nil {
+		return true
+	}
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return true
+	case <-time.After(timeout):
+		server.Stop()
+		return false
+	}
 }
 
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) {
+func (s *nonBlockingGRPCServer) serve(endpoint string, server *grpc.Server, testMode bool) {
 
 	proto, addr, err := ParseEndpoint(endpoint)
 	if err != nil {
 		klog.Fatal(err.Error())
 	}
 
-	if proto == "unix" {
-		if runtime.GOOS != "windows" {
-			addr = "/" + addr
-		}
-		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
-			klog.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
-		}
+	if proto == "unix" && runtime.GOOS != "windows" {
+		addr = "/" + addr
 	}
 
-	listener, err := net.Listen(proto, addr)
+	listener, err := listen(proto, addr, s.socketPermissions)
 	if err != nil {
 		klog.Fatalf("Failed to listen: %v", err)
 	}
 
-	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
-	}
-	server := grpc.NewServer(opts...)
-	s.server = server
-
-	if ids != nil {
-		csi.RegisterIdentityServer(server, ids)
-	}
-	if cs != nil {
-		csi.RegisterControllerServer(server, cs)
-	}
-	if ns != nil {
-		csi.RegisterNodeServer(server, ns)
-	}
 	// Used to stop the server while running tests
 	if testMode {
 		s.wg.Done()
@@ -111,7 +158,7 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 			// make sure Serve() is called
 			s.wg.Wait()
 			time.Sleep(time.Millisecond * 1000)
-			s.server.GracefulStop()
+			server.GracefulStop()
 		}()
 	}
 
@@ -119,4 +166,67 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	if err := server.Serve(listener); err != nil {
 		klog.Errorf("Listening for connections on address: %#v, error: %v", listener.Addr(), err)
 	}
+	if !testMode {
+		// Serve returns after server is stopped
+		s.wg.Done()
+	}
+}
+
+// serverOptions returns options of gRPC server, message size limits are only set if maxMessageSize is not 0
+func (s *nonBlockingGRPCServer) serverOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(traceGRPC, logGRPC),
+	}
+	if s.maxMessageSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(s.maxMessageSize), grpc.MaxSendMsgSize(s.maxMessageSize))
+	}
+	return opts
+}
+
+// listen listens on addr, stale unix socket is removed before listening and file mode of unix socket is set
+// to socketPermissions if it's not 0
+func listen(proto, addr string, socketPermissions os.FileMode) (net.Listener, error) {
+	if proto != "unix" {
+		return net.Listen(proto, addr)
+	}
+	if err := removeStaleSocket(addr); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		return nil, err
+	}
+	if socketPermissions != 0 {
+		if err := os.Chmod(addr, socketPermissions); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set permissions(%#o) of socket %s: %v", socketPermissions, addr, err)
+		}
+		klog.V(2).Infof("set permissions(%#o) of socket %s", socketPermissions, addr)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes unix socket left by previous process, it fails if the socket is still in use
+// or the path is not a socket, so that a file or a running driver would not be removed by mistake
+func removeStaleSocket(addr string) error {
+	fi, err := os.Lstat(addr)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// socket file on windows is a reparse point which is not reported as socket
+	if runtime.GOOS != "windows" && fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", addr)
+	}
+	if conn, err := net.DialTimeout("unix", addr, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use by another process", addr)
+	}
+	klog.V(2).Infof("remove stale socket %s", addr)
+	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s, error: %v", addr, err)
+	}
+	return nil
 }
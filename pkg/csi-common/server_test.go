@@ -17,12 +17,22 @@ limitations under the License.
 package csicommon
 
 import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func TestNewNonBlockingGRPCServer(t *testing.T) {
@@ -44,7 +54,7 @@ func TestServe(t *testing.T) {
 	s.wg = sync.WaitGroup{}
 	//need to add one here as the actual also requires one.
 	s.wg.Add(1)
-	s.serve("tcp://127.0.0.1:0", nil, nil, nil, true)
+	s.serve("tcp://127.0.0.1:0", s.server, true)
 }
 
 func TestWait(t *testing.T) {
@@ -65,3 +75,225 @@ func TestForceStop(t *testing.T) {
 	s.server = grpc.NewServer()
 	s.ForceStop()
 }
+
+func TestStopBeforeStart(t *testing.T) {
+	s := nonBlockingGRPCServer{}
+	s.Stop()
+	s.ForceStop()
+	assert.True(t, s.GracefulStopWithTimeout(time.Second))
+}
+
+// blockingIdentityServer blocks Probe calls for a while to simulate in-flight operations
+type blockingIdentityServer struct {
+	csi.UnimplementedIdentityServer
+	started  chan struct{}
+	duration time.Duration
+	mutex    sync.Mutex
+	finished bool
+}
+
+func (s *blockingIdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	close(s.started)
+	time.Sleep(s.duration)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.finished = true
+	return &csi.ProbeResponse{}, nil
+}
+
+func (s *blockingIdentityServer) isFinished() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.finished
+}
+
+func TestGracefulStopWithTimeout(t *testing.T) {
+	tests := []struct {
+		desc             string
+		duration         time.Duration
+		timeout          time.Duration
+		expectedStopped  bool
+		expectedFinished bool
+	}{
+		{
+			desc:             "in-flight call completes within timeout",
+			duration:         500 * time.Millisecond,
+			timeout:          10 * time.Second,
+			expectedStopped:  true,
+			expectedFinished: true,
+		},
+		{
+			desc:             "in-flight call does not complete within timeout",
+			duration:         5 * time.Second,
+			timeout:          100 * time.Millisecond,
+			expectedStopped:  false,
+			expectedFinished: false,
+		},
+	}
+
+	for _, test := range tests {
+		ids := &blockingIdentityServer{started: make(chan struct{}), duration: test.duration}
+		s := nonBlockingGRPCServer{server: grpc.NewServer()}
+		csi.RegisterIdentityServer(s.server, ids)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err, test.desc)
+		go func() {
+			_ = s.server.Serve(listener)
+		}()
+
+		conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		assert.NoError(t, err, test.desc)
+		go func() {
+			_, _ = csi.NewIdentityClient(conn).Probe(context.Background(), &csi.ProbeRequest{})
+		}()
+		<-ids.started
+
+		start := time.Now()
+		stopped := s.GracefulStopWithTimeout(test.timeout)
+		assert.Equal(t, test.expectedStopped, stopped, test.desc)
+		assert.Equal(t, test.expectedFinished, ids.isFinished(), test.desc)
+		assert.Less(t, time.Since(start), test.timeout+time.Second, test.desc)
+		conn.Close()
+	}
+}
+
+// largePluginInfoIdentityServer returns plugin info whose manifest is larger than manifestSize bytes
+type largePluginInfoIdentityServer struct {
+	csi.UnimplementedIdentityServer
+	manifestSize int
+}
+
+func (s *largePluginInfoIdentityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{
+		Name:     "file.csi.azure.com",
+		Manifest: map[string]string{"data": strings.Repeat("a", s.manifestSize)},
+	}, nil
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		desc           string
+		maxMessageSize int
+		manifestSize   int
+		expectedCode   codes.Code
+	}{
+		{
+			desc:         "default limit of gRPC",
+			manifestSize: 1024 * 1024,
+			expectedCode: codes.OK,
+		},
+		{
+			desc:           "response within max message size",
+			maxMessageSize: 64 * 1024,
+			manifestSize:   1024,
+			expectedCode:   codes.OK,
+		},
+		{
+			desc:           "response exceeds max message size",
+			maxMessageSize: 64 * 1024,
+			manifestSize:   128 * 1024,
+			expectedCode:   codes.ResourceExhausted,
+		},
+	}
+
+	for _, test := range tests {
+		s := NewNonBlockingGRPCServerWithOptions(0, test.maxMessageSize).(*nonBlockingGRPCServer)
+		assert.Equal(t, test.maxMessageSize, s.maxMessageSize, test.desc)
+		s.server = grpc.NewServer(s.serverOptions()...)
+		csi.RegisterIdentityServer(s.server, &largePluginInfoIdentityServer{manifestSize: test.manifestSize})
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err, test.desc)
+		go func() {
+			_ = s.server.Serve(listener)
+		}()
+
+		conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		assert.NoError(t, err, test.desc)
+		_, err = csi.NewIdentityClient(conn).GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+		conn.Close()
+		s.ForceStop()
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip unix socket test on windows")
+	}
+	dir := t.TempDir()
+
+	// not existing socket
+	assert.NoError(t, removeStaleSocket(filepath.Join(dir, "notexist.sock")))
+
+	// stale socket which is not listened by any process
+	staleSocket := filepath.Join(dir, "stale.sock")
+	listener, err := net.Listen("unix", staleSocket)
+	assert.NoError(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	_, err = os.Lstat(staleSocket)
+	assert.NoError(t, err)
+	assert.NoError(t, removeStaleSocket(staleSocket))
+	_, err = os.Lstat(staleSocket)
+	assert.True(t, os.IsNotExist(err))
+
+	// socket in use
+	inUseSocket := filepath.Join(dir, "inuse.sock")
+	listener, err = net.Listen("unix", inUseSocket)
+	assert.NoError(t, err)
+	defer listener.Close()
+	assert.EqualError(t, removeStaleSocket(inUseSocket), "socket "+inUseSocket+" is in use by another process")
+
+	// regular file
+	file := filepath.Join(dir, "file")
+	assert.NoError(t, os.WriteFile(file, []byte("data"), 0600))
+	assert.EqualError(t, removeStaleSocket(file), file+" exists and is not a socket")
+	_, err = os.Lstat(file)
+	assert.NoError(t, err)
+}
+
+func TestListenWithSocketPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip unix socket test on windows")
+	}
+	tests := []struct {
+		desc              string
+		socketPermissions os.FileMode
+		staleSocket       bool
+	}{
+		{
+			desc: "default permissions",
+		},
+		{
+			desc:              "socket permissions are applied",
+			socketPermissions: 0660,
+		},
+		{
+			desc:              "stale socket is removed before listening",
+			socketPermissions: 0600,
+			staleSocket:       true,
+		},
+	}
+
+	for _, test := range tests {
+		socket := filepath.Join(t.TempDir(), "csi.sock")
+		if test.staleSocket {
+			stale, err := net.Listen("unix", socket)
+			assert.NoError(t, err, test.desc)
+			stale.(*net.UnixListener).SetUnlinkOnClose(false)
+			stale.Close()
+		}
+		listener, err := listen("unix", socket, test.socketPermissions)
+		assert.NoError(t, err, test.desc)
+		fi, err := os.Stat(socket)
+		assert.NoError(t, err, test.desc)
+		if test.socketPermissions != 0 {
+			assert.Equal(t, test.socketPermissions, fi.Mode().Perm(), test.desc)
+		}
+		listener.Close()
+	}
+
+	listener, err := listen("tcp", "127.0.0.1:0", 0660)
+	assert.NoError(t, err)
+	listener.Close()
+}
@@ -20,8 +20,13 @@ import (
 	"bytes"
 	"context"
 	"flag"
+	"fmt"
+	"sync"
 	"testing"
 
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
 	"k8s.io/klog/v2"
 
@@ -267,3 +272,64 @@ func TestGetLogLevel(t *testing.T) {
 		}
 	}
 }
+
+// inMemoryExporter stores exported spans in memory
+type inMemoryExporter struct {
+	sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (e *inMemoryExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *inMemoryExporter) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func TestTraceGRPC(t *testing.T) {
+	exporter := &inMemoryExporter{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	originalTP := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(originalTP)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId: "vol_1",
+		Secrets:  map[string]string{"accountkey": "testkey"},
+	}
+
+	// handler creates a child span
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, span := otel.Tracer("test").Start(ctx, "mount")
+		span.End()
+		return nil, fmt.Errorf("mount error")
+	}
+	_, err := traceGRPC(context.Background(), req, info, handler)
+	assert.Equal(t, fmt.Errorf("mount error"), err)
+
+	assert.Equal(t, 2, len(exporter.spans))
+	child, parent := exporter.spans[0], exporter.spans[1]
+	assert.Equal(t, "mount", child.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), child.Parent().SpanID())
+	assert.Equal(t, parent.SpanContext().TraceID(), child.SpanContext().TraceID())
+
+	assert.Equal(t, info.FullMethod, parent.Name())
+	assert.Equal(t, otelcodes.Error, parent.Status().Code)
+	assert.Equal(t, "mount error", parent.Status().Description)
+	assert.Equal(t, 1, len(parent.Attributes()))
+	assert.Equal(t, VolumeIDAttribute.String("vol_1"), parent.Attributes()[0])
+
+	// request without volume ID
+	exporter.spans = nil
+	handler = func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	_, err = traceGRPC(context.Background(), &csi.ListSnapshotsRequest{}, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/ListSnapshots"}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(exporter.spans))
+	assert.Equal(t, 0, len(exporter.spans[0].Attributes()))
+	assert.Equal(t, otelcodes.Unset, exporter.spans[0].Status().Code)
+}
@@ -74,6 +74,8 @@ func (mounter *winMounter) SMBMount(source, target, fsType string, mountOptions,
 		return fmt.Errorf("remote path is empty")
 	}
 
+	username := mountOptions[0]
+	password := sensitiveMountOptions[0]
 	isMapped, err := smb.IsSmbMapped(remotePath)
 	if err != nil {
 		isMapped = false
@@ -98,9 +100,7 @@ func (mounter *winMounter) SMBMount(source, target, fsType string, mountOptions,
 
 	if !isMapped {
 		klog.V(4).Infof("Remote %s not mapped. Mapping now!", remotePath)
-		username := mountOptions[0]
-		password := sensitiveMountOptions[0]
-		err := smb.NewSmbGlobalMapping(remotePath, username, password)
+		err := smb.NewSmbGlobalMapping(remotePath, username, password, mountOptions[1:])
 		if err != nil {
 			klog.Errorf("failed NewSmbGlobalMapping %v", err)
 			return err
@@ -114,6 +114,19 @@ func (mounter *winMounter) SMBMount(source, target, fsType string, mountOptions,
 			return err
 		}
 		err = smb.NewSmbLink(remotePath, localPath)
+		if err != nil && isMapped && isSMBAuthError(err) {
+			// existing mapping could be created with stale credentials, e.g. account key is rotated
+			klog.Warningf("NewSmbLink(%s, %s) failed with auth error(%v), re-mapping remote path with current credentials", remotePath, localPath, err)
+			if err = smb.RemoveSmbGlobalMapping(remotePath); err != nil {
+				klog.Errorf("RemoveSmbGlobalMapping(%s) failed with %v", remotePath, err)
+				return err
+			}
+			if err = smb.NewSmbGlobalMapping(remotePath, username, password, mountOptions[1:]); err != nil {
+				klog.Errorf("failed NewSmbGlobalMapping %v", err)
+				return err
+			}
+			err = smb.NewSmbLink(remotePath, localPath)
+		}
 		if err != nil {
 			klog.Errorf("failed NewSmbLink %v", err)
 			return fmt.Errorf("creating link %s to %s failed with error: %v", localPath, remotePath, err)
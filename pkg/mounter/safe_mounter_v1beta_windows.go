@@ -83,6 +83,9 @@ func (mounter *csiProxyMounterV1Beta) SMBMount(source, target, fsType string, mo
 		Username:   mountOptions[0],
 		Password:   sensitiveMountOptions[0],
 	}
+	if len(mountOptions) > 1 {
+		klog.Warningf("mount options(%v) are ignored since they are not supported by csi proxy, use host process mode instead", mountOptions[1:])
+	}
 	klog.V(2).Infof("begin to mount %s on %s", source, normalizedTarget)
 	if _, err := mounter.SMBClient.NewSmbGlobalMapping(context.Background(), smbMountRequest); err != nil {
 		return fmt.Errorf("smb mapping failed with error: %v", err)
@@ -93,8 +93,20 @@ func (mounter *csiProxyMounter) SMBMount(source, target, fsType string, mountOpt
 		Username:   mountOptions[0],
 		Password:   sensitiveMountOptions[0],
 	}
+	if len(mountOptions) > 1 {
+		klog.Warningf("mount options(%v) are ignored since they are not supported by csi proxy, use host process mode instead", mountOptions[1:])
+	}
 	klog.V(2).Infof("begin to mount %s on %s", source, normalizedTarget)
-	if _, err := mounter.SMBClient.NewSmbGlobalMapping(context.Background(), smbMountRequest); err != nil {
+	_, err = mounter.SMBClient.NewSmbGlobalMapping(context.Background(), smbMountRequest)
+	if err != nil && isSMBAuthError(err) {
+		// existing SMB global mapping of remote path is reused by csi proxy, it could be created with stale credentials
+		klog.Warningf("smb mapping of %s failed with auth error(%v), removing smb mapping and mapping again", source, err)
+		if _, err := mounter.SMBClient.RemoveSmbGlobalMapping(context.Background(), &smb.RemoveSmbGlobalMappingRequest{RemotePath: source}); err != nil {
+			return fmt.Errorf("smb unmapping of %s failed with error: %v", source, err)
+		}
+		_, err = mounter.SMBClient.NewSmbGlobalMapping(context.Background(), smbMountRequest)
+	}
+	if err != nil {
 		return fmt.Errorf("smb mapping failed with error: %v", err)
 	}
 	klog.V(2).Infof("mount %s on %s successfully", source, normalizedTarget)
@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mounter
+
+import (
+	"strings"
+)
+
+// smbAuthErrors are error messages returned when SMB server rejects credentials of SMB global mapping,
+// e.g. the mapping was created with an account key which has been rotated
+var smbAuthErrors = []string{
+	"access is denied",
+	"logon failure",
+	"user name or password is incorrect",
+}
+
+// isSMBAuthError returns true if SMB mount failed since credentials are rejected,
+// SMB global mapping of the remote path should be re-created with current credentials then
+func isSMBAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, authErr := range smbAuthErrors {
+		if strings.Contains(msg, authErr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mounter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSMBAuthError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      nil,
+			expected: false,
+		},
+		{
+			err:      fmt.Errorf("NewSmbLink failed. output: \"New-Item : Access is denied\", err: exit status 1"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("smb mapping failed with error: rpc error: code = Unknown desc = The user name or password is incorrect."),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("New-SmbGlobalMapping : Logon failure: unknown user name or bad password"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("The network path was not found"),
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isSMBAuthError(test.err), fmt.Sprintf("%v", test.err))
+	}
+}
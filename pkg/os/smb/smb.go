@@ -20,9 +20,33 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 )
 
+// globalMappingOptions maps supported mount options(lower case) to parameters of New-SmbGlobalMapping
+var globalMappingOptions = map[string]string{
+	"requireprivacy":   "-RequirePrivacy $true",
+	"requireintegrity": "-RequireIntegrity $true",
+	"usewritethrough":  "-UseWriteThrough $true",
+}
+
+// IsSupportedGlobalMappingOption checks whether mount option could be applied in New-SmbGlobalMapping, it's case insensitive
+func IsSupportedGlobalMappingOption(option string) bool {
+	_, ok := globalMappingOptions[strings.ToLower(option)]
+	return ok
+}
+
+// SupportedGlobalMappingOptions returns sorted mount options which could be applied in New-SmbGlobalMapping
+func SupportedGlobalMappingOptions() []string {
+	options := make([]string, 0, len(globalMappingOptions))
+	for option := range globalMappingOptions {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+	return options
+}
+
 func IsSmbMapped(remotePath string) (bool, error) {
 	cmdLine := `$(Get-SmbGlobalMapping -RemotePath $Env:smbremotepath -ErrorAction Stop).Status`
 	cmd := exec.Command("powershell", "/c", cmdLine)
@@ -63,13 +87,21 @@ func NewSmbLink(remotePath, localPath string) error {
 	return nil
 }
 
-func NewSmbGlobalMapping(remotePath, username, password string) error {
+func NewSmbGlobalMapping(remotePath, username, password string, mountOptions []string) error {
 
 	// use PowerShell Environment Variables to store user input string to prevent command line injection
 	// https://docs.microsoft.com/en-us/powershell/module/microsoft.powershell.core/about/about_environment_variables?view=powershell-5.1
 	cmdLine := fmt.Sprintf(`$PWord = ConvertTo-SecureString -String $Env:smbpassword -AsPlainText -Force` +
 		`;$Credential = New-Object -TypeName System.Management.Automation.PSCredential -ArgumentList $Env:smbuser, $PWord` +
 		`;New-SmbGlobalMapping -RemotePath $Env:smbremotepath -Credential $Credential`)
+	// only parameters in globalMappingOptions are appended to command line, so mount options could not inject commands
+	for _, option := range mountOptions {
+		param, ok := globalMappingOptions[strings.ToLower(option)]
+		if !ok {
+			return fmt.Errorf("mount option(%s) is not supported, supported options: %v", option, SupportedGlobalMappingOptions())
+		}
+		cmdLine += " " + param
+	}
 
 	cmd := exec.Command("powershell", "/c", cmdLine)
 	cmd.Env = append(os.Environ(),
@@ -78,7 +110,8 @@ func NewSmbGlobalMapping(remotePath, username, password string) error {
 		fmt.Sprintf("smbremotepath=%s", remotePath))
 
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("NewSmbGlobalMapping failed. output: %q, err: %v\n[debug]smbuser=%s,smbpassword=%s,smbremotepath=%s", string(output), err, username, password, remotePath)
+		// password must not be logged
+		return fmt.Errorf("NewSmbGlobalMapping failed. output: %q, err: %v\n[debug]smbuser=%s,smbremotepath=%s", string(output), err, username, remotePath)
 	}
 
 	return nil